@@ -38,6 +38,7 @@ func GetRootCmd(ctx *appCtx.AgentContext) *cobra.Command {
 
 	cmd.AddCommand(
 		GetStartCmd(ctx),
+		GetStateCmd(ctx),
 		GetVersionCmd(),
 	)
 
@@ -83,6 +84,11 @@ func GetRootPreRunEFn(ctx *appCtx.AgentContext, validateCfg bool) func(*cobra.Co
 			return fmt.Errorf("failed to create state storage: %v", err)
 		}
 
+		ctx.TrustedRoots, err = ctx.Config.Validation.TrustedRoots(ctx.Fs)
+		if err != nil {
+			return fmt.Errorf("failed to load validation ca bundle: %v", err)
+		}
+
 		ctx.MetricsRegister = appProm.NewRegistry(types.NameAgentMetrics, prometheus.NewRegistry())
 
 		logLevelFlagStr, _ := cmd.Flags().GetString(LogLevel)
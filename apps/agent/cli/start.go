@@ -2,7 +2,11 @@ package cli
 
 import (
 	stdContext "context"
+	"errors"
 	"fmt"
+	"net/http"
+	"time"
+
 	"github.com/alexandreh2ag/lets-go-tls/apps/agent/context"
 	appAgentHttp "github.com/alexandreh2ag/lets-go-tls/apps/agent/http"
 	"github.com/alexandreh2ag/lets-go-tls/apps/agent/service"
@@ -24,29 +28,52 @@ func GetStartRunFn(ctx *context.AgentContext) func(*cobra.Command, []string) err
 		e := appAgentHttp.CreateServerHTTP(ctx)
 
 		httpConfig := ctx.Config.HTTP
-		go appHttp.StartServerHTTP(e, httpConfig.Listen, nil)
-
+		servers := []*http.Server{appHttp.NewServerHTTP(e, httpConfig.Listen, nil)}
 		if httpConfig.TLS.Enable {
 			tlsConfig := appHttp.CreateTLSConfig(httpConfig.TLS)
-			go appHttp.StartServerHTTP(e, httpConfig.TLS.Listen, tlsConfig)
+			servers = append(servers, appHttp.NewServerHTTP(e, httpConfig.TLS.Listen, tlsConfig))
+		}
+
+		errServers := make(chan error, len(servers))
+		for _, server := range servers {
+			go func() {
+				if errStart := appHttp.StartServerHTTP(server); errStart != nil {
+					errServers <- errStart
+				}
+			}()
 		}
 
 		srv := service.NewService(ctx)
+		srvDone := make(chan error, 1)
 		go func() {
-			errStart := srv.Start(ctx)
-			if errStart != nil {
-				panic(errStart)
-			}
+			srvDone <- srv.Start(ctx)
 		}()
 
-		for {
+		var errRun, errStop error
+		srvRunning := true
+		select {
+		case sig := <-ctx.Signal():
+			ctx.Logger.Info(fmt.Sprintf("%s signal received, exiting...", sig.String()))
+		case errRun = <-errServers:
+			ctx.Logger.Error(errRun.Error())
+		case errRun = <-srvDone:
+			srvRunning = false
+		}
+
+		// a run in progress finishes to save certificates, run hooks and save state
+		if srvRunning {
+			ctx.Cancel()
 			select {
-			case sig := <-ctx.Signal():
-				ctx.Cancel()
-				ctx.Logger.Info(fmt.Sprintf("%s signal received, exiting...", sig.String()))
-				return e.Shutdown(stdContext.Background())
+			case errStop = <-srvDone:
+			case <-time.After(ctx.Config.ShutdownGracePeriod):
+				ctx.Logger.Warn(fmt.Sprintf("service did not stop in %s, run in progress is abandoned", ctx.Config.ShutdownGracePeriod))
 			}
 		}
 
+		// http servers have their own grace period, in-flight requests are drained even when service used the whole one
+		shutdownCtx, cancel := stdContext.WithTimeout(stdContext.Background(), ctx.Config.ShutdownGracePeriod)
+		defer cancel()
+		ctx.Logger.Info("stop http servers")
+		return errors.Join(errRun, errStop, appHttp.ShutdownServersHTTP(shutdownCtx, servers...))
 	}
 }
@@ -1,6 +1,7 @@
 package cli
 
 import (
+	"errors"
 	"github.com/alexandreh2ag/lets-go-tls/apps/agent/context"
 	mockTypesStorageState "github.com/alexandreh2ag/lets-go-tls/mocks/types/storage/state"
 	appProm "github.com/alexandreh2ag/lets-go-tls/prometheus"
@@ -63,3 +64,22 @@ func TestGetStartRunFn_SuccessListenHTTPS(t *testing.T) {
 	time.Sleep(time.Millisecond * 100)
 	ctx.Signal() <- syscall.SIGINT
 }
+
+func TestGetStartRunFn_FailListen(t *testing.T) {
+	ctx := context.TestContext(nil)
+	ctx.Config.HTTP.Listen = "127.0.0.1:-1"
+	ctx.Config.ShutdownGracePeriod = time.Second * 5
+	viper.Reset()
+	viper.SetFs(ctx.Fs)
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	storage := mockTypesStorageState.NewMockStorage(ctrl)
+	storage.EXPECT().Load().AnyTimes().Return(nil, errors.New("error"))
+	ctx.StateStorage = storage
+	ctx.MetricsRegister = appProm.NewRegistry(types.NameServerMetrics, prometheus.NewRegistry())
+
+	cmd := GetStartCmd(ctx)
+	err := GetStartRunFn(ctx)(cmd, []string{})
+	assert.ErrorContains(t, err, "fail to start http server with")
+}
@@ -2,6 +2,7 @@ package config
 
 import (
 	"github.com/alexandreh2ag/lets-go-tls/config"
+	"github.com/alexandreh2ag/lets-go-tls/types"
 	"time"
 )
 
@@ -12,6 +13,11 @@ type Config struct {
 	HTTP       config.HTTPConfig        `mapstructure:"http" validate:"required"`
 	Interval   time.Duration            `mapstructure:"interval" validate:"required"`
 	Manager    ManagerConfig            `mapstructure:"manager" validate:"required"`
+	Validation config.ValidationConfig  `mapstructure:"validation"`
+
+	PrivateSuffixes types.Suffixes `mapstructure:"private_suffixes" validate:"omitempty,dive,required"`
+
+	ShutdownGracePeriod time.Duration `mapstructure:"shutdown_grace_period" validate:"required"`
 }
 type ManagerConfig struct {
 	Address  string `mapstructure:"address" validate:"required,http_url"`
@@ -25,6 +31,7 @@ func NewConfig() Config {
 func DefaultConfig() Config {
 	cfg := NewConfig()
 	cfg.Interval = time.Minute * 5
+	cfg.ShutdownGracePeriod = time.Second * 30
 	cfg.HTTP = config.HTTPConfig{Listen: "0.0.0.0:8080"}
 	return cfg
 }
@@ -14,5 +14,5 @@ func TestNewConfig(t *testing.T) {
 
 func TestDefaultConfig(t *testing.T) {
 	got := DefaultConfig()
-	assert.Equal(t, Config{HTTP: config.HTTPConfig{Listen: "0.0.0.0:8080"}, Interval: time.Minute * 5}, got)
+	assert.Equal(t, Config{HTTP: config.HTTPConfig{Listen: "0.0.0.0:8080"}, Interval: time.Minute * 5, ShutdownGracePeriod: time.Second * 30}, got)
 }
@@ -30,6 +30,10 @@ func FetchRequests(requesters types.Requesters) ([]*types.DomainRequest, error)
 					err,
 				))
 			}
+			// invalid requests are sent as is, the server reports why they are not found
+			for _, request := range domainsRequest {
+				_ = request.Normalize()
+			}
 			mtx.Lock()
 			defer mtx.Unlock()
 			domainsRequests = append(domainsRequests, domainsRequest...)
@@ -6,7 +6,6 @@ import (
 	"fmt"
 	"log/slog"
 	"net/http"
-	"slices"
 	"strconv"
 	"sync"
 	"time"
@@ -89,13 +88,6 @@ func (t *traefik) Fetch() ([]*types.DomainRequest, error) {
 	}
 	wg.Wait()
 
-	domains = slices.DeleteFunc(domains, func(item *types.DomainRequest) bool {
-		if item.IsIP() {
-			return true
-		}
-		return false
-	})
-
 	for _, domain := range domains {
 		domain.Requester = t
 	}
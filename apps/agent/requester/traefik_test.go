@@ -260,6 +260,7 @@ func Test_traefik_Fetch(t *testing.T) {
 		{Requester: a, Domains: types.Domains{types.Domain("bar.com")}},
 	}
 	want := append(want1, want2...)
+	want = append(want, types.DomainRequest{Requester: a, Domains: types.Domains{types.Domain("127.0.0.1")}})
 	tests := []struct {
 		name     string
 		funcMock func(clientHttp *mockHttp.MockClient)
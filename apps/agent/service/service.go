@@ -127,6 +127,12 @@ func (as *AgentService) Run(ctx *appCtx.AgentContext) error {
 		}
 
 		for _, responseManagerCert := range managerResponse.Certificates {
+			errValidate := responseManagerCert.ValidateMaterial(ctx.TrustedRoots, as.clock.Now())
+			if errValidate != nil {
+				// last known-good material is kept in state and storages
+				ctx.Logger.Error(fmt.Sprintf("certificate %s rejected, invalid material: %v", responseManagerCert.Identifier, errValidate))
+				continue
+			}
 			certificateState := state.Certificates.GetCertificate(responseManagerCert.Identifier)
 			if certificateState != nil {
 				certificateState.Main = responseManagerCert.Main
@@ -134,6 +140,7 @@ func (as *AgentService) Run(ctx *appCtx.AgentContext) error {
 				certificateState.Key = responseManagerCert.Key
 				certificateState.Certificate = responseManagerCert.Certificate
 				certificateState.ExpirationDate = responseManagerCert.ExpirationDate
+				certificateState.NotBefore = responseManagerCert.NotBefore
 			} else {
 				state.Certificates = append(state.Certificates, responseManagerCert)
 				ctx.GetMetricsRegister().RegisterNewCertificateMetrics(responseManagerCert)
@@ -144,7 +151,8 @@ func (as *AgentService) Run(ctx *appCtx.AgentContext) error {
 	// find unused certificates only if fetch succeeded
 	unusedCertificates := types.Certificates{}
 	if errFetch == nil {
-		unusedCertificates = state.Certificates.UnusedCertificates(domainsRequests)
+		// certificates matched alone for a domain serve requests no certificate covers together
+		unusedCertificates = state.Certificates.UnusedCertificates(domainsRequests, false, ctx.Config.PrivateSuffixes)
 		ctx.Logger.Debug(fmt.Sprintf("found %d unused certificates", len(unusedCertificates)))
 	}
 
@@ -9,6 +9,7 @@ import (
 	appCtx "github.com/alexandreh2ag/lets-go-tls/apps/agent/context"
 	"github.com/alexandreh2ag/lets-go-tls/hook"
 	appHttp "github.com/alexandreh2ag/lets-go-tls/http"
+	"github.com/alexandreh2ag/lets-go-tls/internal/testutil"
 	mockHttp "github.com/alexandreh2ag/lets-go-tls/mocks/http"
 	mockPrometheus "github.com/alexandreh2ag/lets-go-tls/mocks/prometheus"
 	mockTypes "github.com/alexandreh2ag/lets-go-tls/mocks/types"
@@ -97,6 +98,68 @@ func TestAgentService_Run_Success(t *testing.T) {
 		httpClient:   clientHttp,
 		storages:     certificate.Storages{"foo": storage},
 		hookManager:  hook.NewManagerHook(ctx.Logger),
+		clock:        clockwork.NewRealClock(),
+	}
+	go as.hookManager.Start()
+
+	err := as.Run(ctx)
+	assert.NoError(t, err)
+}
+
+func TestAgentService_Run_SuccessRejectInvalidMaterial(t *testing.T) {
+	ctx := appCtx.TestContext(nil)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	now := time.Now()
+	ca := testutil.NewCertificateAuthority(t, now.Add(-time.Hour), now.Add(time.Hour*24))
+	ctx.TrustedRoots = ca.Pool
+	fooCert, fooKey := ca.Issue(t, []string{"foo.com"}, now.Add(-time.Hour), now.Add(time.Hour))
+	untrustedCA := testutil.NewCertificateAuthority(t, now.Add(-time.Hour), now.Add(time.Hour*24))
+	barCert, barKey := untrustedCA.Issue(t, []string{"bar.com"}, now.Add(-time.Hour), now.Add(time.Hour))
+
+	ctx.MetricsRegister = appProm.NewRegistry(types.NameAgentMetrics, prometheus.NewRegistry())
+	state := &types.State{Certificates: types.Certificates{
+		{Identifier: "bar.com-0", Domains: types.Domains{"bar.com"}, Certificate: []byte("goodCert"), Key: []byte("goodKey")},
+	}}
+	storageState := mockTypesStorageState.NewMockStorage(ctrl)
+	storageState.EXPECT().Load().Times(1).Return(state, nil)
+	storageState.EXPECT().Save(gomock.Any()).Times(1).Return(nil)
+
+	requester := mockTypes.NewMockRequester(ctrl)
+	requester.EXPECT().Fetch().Times(1).Return([]*types.DomainRequest{domainRequestFoo, domainRequestBar}, nil)
+	ctx.Requesters = types.Requesters{"foo": requester}
+
+	clientHttp := mockHttp.NewMockClient(ctrl)
+	resp := fasthttp.Response{}
+	resp.SetStatusCode(http.StatusOK)
+	responseCertificate := appHttp.ResponseCertificatesFromRequests{
+		Certificates: types.Certificates{
+			{Identifier: "foo.com-0", Domains: types.Domains{"foo.com"}, Certificate: fooCert, Key: fooKey},
+			{Identifier: "bar.com-0", Domains: types.Domains{"bar.com"}, Certificate: barCert, Key: barKey},
+		},
+		Requests: appHttp.ResponseRequests{Found: []*types.DomainRequest{domainRequestFoo, domainRequestBar}},
+	}
+	body, _ := json.Marshal(responseCertificate)
+	resp.SetBody(body)
+	clientHttp.EXPECT().DoTimeout(gomock.Any(), gomock.Any(), gomock.Any()).Times(1).SetArg(1, resp).Return(nil)
+
+	storage := mockTypesStorageCertificate.NewMockStorage(ctrl)
+	storage.EXPECT().Save(gomock.Any(), gomock.Any()).Times(1).DoAndReturn(func(certificates types.Certificates, _ chan<- *hook.Hook) []error {
+		assert.Len(t, certificates, 2)
+		assert.Equal(t, []byte("goodCert"), certificates.GetCertificate("bar.com-0").Certificate)
+		assert.Equal(t, fooCert, certificates.GetCertificate("foo.com-0").Certificate)
+		return nil
+	})
+	storage.EXPECT().Delete(gomock.Any(), gomock.Any()).Times(1).Return(nil)
+
+	as := &AgentService{
+		logger:       ctx.Logger,
+		stateStorage: storageState,
+		httpClient:   clientHttp,
+		storages:     certificate.Storages{"foo": storage},
+		hookManager:  hook.NewManagerHook(ctx.Logger),
+		clock:        clockwork.NewRealClock(),
 	}
 	go as.hookManager.Start()
 
@@ -119,6 +182,7 @@ func TestAgentService_Run_FailLoadState(t *testing.T) {
 		logger:       ctx.Logger,
 		stateStorage: storageState,
 		hookManager:  hook.NewManagerHook(ctx.Logger),
+		clock:        clockwork.NewRealClock(),
 	}
 	go as.hookManager.Start()
 	err := as.Run(ctx)
@@ -172,6 +236,7 @@ func TestAgentService_Run_SuccessWithErrorFetchRequest(t *testing.T) {
 		httpClient:   clientHttp,
 		storages:     certificate.Storages{"foo": storage},
 		hookManager:  hook.NewManagerHook(ctx.Logger),
+		clock:        clockwork.NewRealClock(),
 	}
 	go as.hookManager.Start()
 
@@ -205,6 +270,7 @@ func TestAgentService_Run_SuccessWithErrorGetRequestManager(t *testing.T) {
 		stateStorage: storageState,
 		httpClient:   clientHttp,
 		hookManager:  hook.NewManagerHook(ctx.Logger),
+		clock:        clockwork.NewRealClock(),
 	}
 	go as.hookManager.Start()
 
@@ -253,6 +319,7 @@ func TestAgentService_Run_SuccessStorageSaveError(t *testing.T) {
 		httpClient:   clientHttp,
 		storages:     certificate.Storages{"foo": storage},
 		hookManager:  hook.NewManagerHook(ctx.Logger),
+		clock:        clockwork.NewRealClock(),
 	}
 	go as.hookManager.Start()
 
@@ -300,6 +367,7 @@ func TestAgentService_Run_SuccessStorageDeleteError(t *testing.T) {
 		httpClient:   clientHttp,
 		storages:     certificate.Storages{"foo": storage},
 		hookManager:  hook.NewManagerHook(ctx.Logger),
+		clock:        clockwork.NewRealClock(),
 	}
 	go as.hookManager.Start()
 
@@ -328,15 +396,22 @@ func TestAgentService_Start_Success(t *testing.T) {
 		hookManager:  hook.NewManagerHook(ctx.Logger),
 	}
 
+	stopped := make(chan struct{})
 	go func() {
+		defer close(stopped)
 		err := as.Start(ctx)
 		assert.NoError(t, err)
 	}()
 	fakeClock.BlockUntil(1)
 	fakeClock.Advance(ctx.Config.Interval)
 	time.Sleep(200 * time.Millisecond)
+	// cancel does not wait for the run in progress, wait for start to return
 	ctx.Cancel()
-	time.Sleep(200 * time.Millisecond)
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("start did not return after cancel")
+	}
 	assert.Contains(t, b.String(), "tick received")
 	assert.Contains(t, b.String(), "stop asked by app, exiting")
 }
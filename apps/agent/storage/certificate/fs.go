@@ -51,6 +51,8 @@ type fs struct {
 	fs       afero.Fs
 	checksum *appFs.Checksum
 	cfg      ConfigFs
+	// suffixes are used to match specific domains with wildcard certificates
+	suffixes types.Suffixes
 
 	uid int
 	gid int
@@ -219,7 +221,7 @@ func (f fs) delete(certificates types.Certificates) (bool, []error) {
 
 func (f fs) GetSpecificDomainConfig(cert *types.Certificate) *ConfigSpecificDomain {
 	for _, specificDomainCfg := range f.cfg.SpecificDomains {
-		if cert.Match(specificDomainCfg.Domains) {
+		if cert.Match(specificDomainCfg.Domains, f.suffixes) {
 			return &specificDomainCfg
 		}
 	}
@@ -244,7 +246,15 @@ func createFsStorage(ctx *context.AgentContext, cfg config.StorageConfig) (certi
 	uid := os.GetUserUID(instanceConfig.Owner)
 	gid := os.GetGroupUID(instanceConfig.Group)
 
-	instance := &fs{id: cfg.Id, fs: ctx.Fs, cfg: instanceConfig, checksum: appFs.NewChecksum(ctx.Fs), uid: uid, gid: gid}
+	instance := &fs{
+		id:       cfg.Id,
+		fs:       ctx.Fs,
+		cfg:      instanceConfig,
+		checksum: appFs.NewChecksum(ctx.Fs),
+		suffixes: ctx.Config.PrivateSuffixes,
+		uid:      uid,
+		gid:      gid,
+	}
 
 	return instance, nil
 }
@@ -54,7 +54,7 @@ func (n nginx) Save(certificates types.Certificates, hookChan chan<- *hook.Hook)
 
 	certsChanged := false
 	for _, vhostConfig := range vhostConfigs {
-		cert := certificates.Match(vhostConfig.ServerName, true)
+		cert := certificates.Match(vhostConfig.ServerName, true, n.fsStorage.suffixes)
 		if cert != nil {
 			keyChanged, errWriteKey := n.fsStorage.WriteFile(cert.Key, vhostConfig.KeyPath)
 			if errWriteKey != nil {
@@ -103,7 +103,15 @@ func createNginxStorage(ctx *context.AgentContext, cfg config.StorageConfig) (ce
 	gid := os.GetGroupUID(instanceConfig.Group)
 
 	checksum := appFs.NewChecksum(ctx.Fs)
-	instanceFs := &fs{id: cfg.Id, fs: ctx.Fs, cfg: instanceConfig.ConfigFs, checksum: checksum, uid: uid, gid: gid}
+	instanceFs := &fs{
+		id:       cfg.Id,
+		fs:       ctx.Fs,
+		cfg:      instanceConfig.ConfigFs,
+		checksum: checksum,
+		suffixes: ctx.Config.PrivateSuffixes,
+		uid:      uid,
+		gid:      gid,
+	}
 	instance := &nginx{id: cfg.Id, logger: ctx.Logger, cfg: instanceConfig, fsStorage: instanceFs, uid: uid, gid: gid}
 
 	return instance, nil
@@ -0,0 +1,126 @@
+package acme
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/alexandreh2ag/lets-go-tls/apps/server/acme/dns"
+	"github.com/alexandreh2ag/lets-go-tls/apps/server/cache"
+	"github.com/alexandreh2ag/lets-go-tls/apps/server/config"
+	"github.com/alexandreh2ag/lets-go-tls/apps/server/context"
+	"github.com/alexandreh2ag/lets-go-tls/types"
+	"github.com/alexandreh2ag/lets-go-tls/types/acme"
+	"github.com/go-acme/lego/v4/challenge"
+	"github.com/go-acme/lego/v4/challenge/dns01"
+	"github.com/go-acme/lego/v4/platform/wait"
+	"github.com/google/uuid"
+)
+
+const checkKeyAuthSuffix = "lets-go-tls-check"
+
+// CheckResolver presents a test challenge for domain with resolver id, checks it is reachable like the CA would do
+// and cleans it up. No ACME order is placed.
+func CheckResolver(ctx *context.ServerContext, id string, domain string, timeout time.Duration) error {
+	cfg, ok := ctx.Config.Acme.Resolvers[id]
+	if !ok && id != types.DefaultKey {
+		return fmt.Errorf("resolver %s does not exist", id)
+	}
+
+	token := uuid.NewString()
+	keyAuth := fmt.Sprintf("%s.%s", token, checkKeyAuthSuffix)
+
+	if id == types.DefaultKey || cfg.Type == acme.TypeHTTP01 {
+		return checkHTTPResolver(ctx, domain, token, keyAuth, timeout)
+	}
+	if cfg.Type == acme.TypeTLSALPN01 {
+		return fmt.Errorf("resolver %s: check is not supported for %s challenge", id, acme.TypeTLSALPN01)
+	}
+	return checkDNSResolver(ctx, id, cfg, domain, token, keyAuth, timeout)
+}
+
+func checkHTTPResolver(ctx *context.ServerContext, domain, token, keyAuth string, timeout time.Duration) (err error) {
+	// the token is served by running servers, they can not read it from the memory cache of this command
+	if !cache.IsShared(ctx.Config.Cache) {
+		return fmt.Errorf(
+			"http challenge check requires a cache shared with running servers (redis or redis-cluster), %s cache is local to this command",
+			ctx.Config.Cache.Type,
+		)
+	}
+	provider := GetHTTPProvider(ctx)
+	err = provider.Present(domain, token, keyAuth)
+	if err != nil {
+		return fmt.Errorf("failed to present http challenge: %v", err)
+	}
+	defer func() {
+		errCleanUp := provider.CleanUp(domain, token, keyAuth)
+		if errCleanUp != nil {
+			err = errors.Join(err, fmt.Errorf("failed to clean up http challenge: %v", errCleanUp))
+		}
+	}()
+
+	host := domain
+	if types.Domain(domain).IsIP() && strings.Contains(domain, ":") {
+		host = fmt.Sprintf("[%s]", domain)
+	}
+	url := fmt.Sprintf("http://%s/.well-known/acme-challenge/%s", host, token)
+	ctx.Logger.Info(fmt.Sprintf("check http challenge on %s", url))
+	client := &http.Client{Timeout: timeout}
+	resp, err := client.Get(url)
+	if err != nil {
+		return fmt.Errorf("failed to reach http challenge: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("http challenge %s returned status %d", url, resp.StatusCode)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read http challenge response: %v", err)
+	}
+	if strings.TrimSpace(string(body)) != keyAuth {
+		return fmt.Errorf("http challenge %s returned an unexpected keyAuth", url)
+	}
+	return nil
+}
+
+func checkDNSResolver(ctx *context.ServerContext, id string, cfg config.ResolverConfig, domain, token, keyAuth string, timeout time.Duration) (err error) {
+	provider, err := dns.CreateDnsChallenge(ctx, id, cfg)
+	if err != nil {
+		return err
+	}
+	propagation, err := dns.DecodePropagationConfig(cfg.Config)
+	if err != nil {
+		return fmt.Errorf("invalid propagation config for resolver %s: %v", id, err)
+	}
+
+	err = provider.Present(domain, token, keyAuth)
+	if err != nil {
+		return fmt.Errorf("failed to present dns challenge: %v", err)
+	}
+	defer func() {
+		errCleanUp := provider.CleanUp(domain, token, keyAuth)
+		if errCleanUp != nil {
+			err = errors.Join(err, fmt.Errorf("failed to clean up dns challenge: %v", errCleanUp))
+		}
+	}()
+
+	interval := dns01.DefaultPollingInterval
+	if providerTimeout, ok := provider.(challenge.ProviderTimeout); ok {
+		timeout, interval = providerTimeout.Timeout()
+	}
+
+	info := dns01.GetChallengeInfo(domain, keyAuth)
+	ctx.Logger.Info(fmt.Sprintf("check dns challenge record %s", info.EffectiveFQDN))
+	err = wait.For("propagation", timeout, interval, func() (bool, error) {
+		return propagation.Check(info.EffectiveFQDN, info.Value)
+	})
+	if err != nil {
+		return fmt.Errorf("dns challenge record %s is not visible: %v", info.EffectiveFQDN, err)
+	}
+	return nil
+}
@@ -0,0 +1,197 @@
+package acme
+
+import (
+	"errors"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alexandreh2ag/lets-go-tls/apps/server/acme/dns"
+	"github.com/alexandreh2ag/lets-go-tls/apps/server/config"
+	"github.com/alexandreh2ag/lets-go-tls/apps/server/context"
+	"github.com/alexandreh2ag/lets-go-tls/types"
+	"github.com/alexandreh2ag/lets-go-tls/types/acme"
+	"github.com/go-acme/lego/v4/challenge/dns01"
+	"github.com/labstack/echo/v4"
+	miekgDns "github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+)
+
+// checkDnsChallenge records presented keyAuth, its propagation timeout is short to keep tests fast.
+type checkDnsChallenge struct {
+	mu         sync.Mutex
+	keyAuth    string
+	presentErr error
+	cleanedUp  bool
+}
+
+func (c *checkDnsChallenge) ID() string   { return "check" }
+func (c *checkDnsChallenge) Type() string { return acme.TypeDNS01 }
+func (c *checkDnsChallenge) Timeout() (time.Duration, time.Duration) {
+	return 200 * time.Millisecond, 10 * time.Millisecond
+}
+func (c *checkDnsChallenge) Present(_, _, keyAuth string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.keyAuth = keyAuth
+	return c.presentErr
+}
+func (c *checkDnsChallenge) CleanUp(_, _, _ string) error {
+	c.cleanedUp = true
+	return nil
+}
+
+// startCheckDnsServer starts an UDP dns server on localhost, it answers TXT record of presented keyAuth when serve is true.
+func startCheckDnsServer(t *testing.T, provider *checkDnsChallenge, domain string, serve bool) string {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	started := make(chan struct{})
+	server := &miekgDns.Server{
+		PacketConn:        conn,
+		NotifyStartedFunc: func() { close(started) },
+		Handler: miekgDns.HandlerFunc(func(w miekgDns.ResponseWriter, r *miekgDns.Msg) {
+			m := new(miekgDns.Msg)
+			m.SetReply(r)
+			q := r.Question[0]
+			provider.mu.Lock()
+			info := dns01.GetChallengeInfo(domain, provider.keyAuth)
+			provider.mu.Unlock()
+			if serve && q.Qtype == miekgDns.TypeTXT && q.Name == info.EffectiveFQDN {
+				header := miekgDns.RR_Header{Name: q.Name, Rrtype: q.Qtype, Class: miekgDns.ClassINET, Ttl: 60}
+				m.Answer = []miekgDns.RR{&miekgDns.TXT{Hdr: header, Txt: []string{info.Value}}}
+			}
+			_ = w.WriteMsg(m)
+		}),
+	}
+	go func() { _ = server.ActivateAndServe() }()
+	<-started
+	t.Cleanup(func() { _ = server.Shutdown() })
+	return conn.LocalAddr().String()
+}
+
+func startCheckHTTPServer(t *testing.T, ctx *context.ServerContext) string {
+	t.Helper()
+	httpProvider = nil
+	t.Cleanup(func() { httpProvider = nil })
+	// the test server runs in process, it shares the cache of the check
+	ctx.Config.Cache.Type = "redis"
+	e := echo.New()
+	e.GET("/.well-known/acme-challenge/:token", GetHTTPProvider(ctx).Handler)
+	server := httptest.NewServer(e)
+	t.Cleanup(server.Close)
+	return strings.TrimPrefix(server.URL, "http://")
+}
+
+func TestCheckResolver_FailResolverNotExist(t *testing.T) {
+	ctx := context.TestContext(nil)
+	err := CheckResolver(ctx, "foo", "example.com", time.Second)
+	assert.EqualError(t, err, "resolver foo does not exist")
+}
+
+func TestCheckResolver_SuccessHTTP(t *testing.T) {
+	ctx := context.TestContext(nil)
+	domain := startCheckHTTPServer(t, ctx)
+
+	err := CheckResolver(ctx, types.DefaultKey, domain, time.Second)
+	assert.NoError(t, err)
+}
+
+func TestCheckResolver_FailHTTPNotServed(t *testing.T) {
+	ctx := context.TestContext(nil)
+	domain := startCheckHTTPServer(t, ctx)
+	// the server does not share the cache of the command
+	httpProvider = nil
+	ctx.Cache = context.TestContext(nil).Cache
+
+	err := CheckResolver(ctx, types.DefaultKey, domain, time.Second)
+	assert.ErrorContains(t, err, "returned status 404")
+}
+
+func TestCheckResolver_FailHTTPUnreachable(t *testing.T) {
+	ctx := context.TestContext(nil)
+	httpProvider = nil
+	t.Cleanup(func() { httpProvider = nil })
+	ctx.Config.Acme.Resolvers["http"] = config.ResolverConfig{Type: acme.TypeHTTP01}
+	ctx.Config.Cache.Type = "redis"
+
+	err := CheckResolver(ctx, "http", "127.0.0.1:1", time.Second)
+	assert.ErrorContains(t, err, "failed to reach http challenge")
+}
+
+func TestCheckResolver_FailHTTPCacheNotShared(t *testing.T) {
+	ctx := context.TestContext(nil)
+	httpProvider = nil
+	t.Cleanup(func() { httpProvider = nil })
+
+	err := CheckResolver(ctx, types.DefaultKey, "127.0.0.1:1", time.Second)
+	assert.EqualError(t, err, "http challenge check requires a cache shared with running servers (redis or redis-cluster), memory cache is local to this command")
+}
+
+func TestCheckResolver_FailTLSALPNUnsupported(t *testing.T) {
+	ctx := context.TestContext(nil)
+	ctx.Config.Acme.Resolvers["alpn"] = config.ResolverConfig{Type: acme.TypeTLSALPN01}
+
+	err := CheckResolver(ctx, "alpn", "192.0.2.1", time.Second)
+	assert.EqualError(t, err, "resolver alpn: check is not supported for tls-alpn-01 challenge")
+}
+
+func TestCheckResolver_DNS(t *testing.T) {
+	tests := []struct {
+		name       string
+		serve      bool
+		presentErr error
+		cfg        map[string]interface{}
+		wantErr    string
+	}{
+		{
+			name:  "Success",
+			serve: true,
+		},
+		{
+			name:    "FailNotVisible",
+			wantErr: "dns challenge record _acme-challenge.example.com. is not visible: propagation: time limit exceeded",
+		},
+		{
+			name:       "FailPresent",
+			presentErr: errors.New("api error"),
+			wantErr:    "failed to present dns challenge: api error",
+		},
+		{
+			name:    "FailPropagationConfig",
+			cfg:     map[string]interface{}{"nameservers": []string{""}},
+			wantErr: "invalid propagation config for resolver check",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.TestContext(nil)
+			provider := &checkDnsChallenge{presentErr: tt.presentErr}
+			key := "check"
+			dns.TypeDnsProviderMapping[key] = func(ctx *context.ServerContext, id string, config map[string]interface{}) (acme.Challenge, error) {
+				return provider, nil
+			}
+			t.Cleanup(func() { delete(dns.TypeDnsProviderMapping, key) })
+
+			cfg := tt.cfg
+			if cfg == nil {
+				nameserver := startCheckDnsServer(t, provider, "example.com", tt.serve)
+				cfg = map[string]interface{}{"nameservers": []string{nameserver}, "disable_complete_propagation": true}
+			}
+			ctx.Config.Acme.Resolvers[key] = config.ResolverConfig{Type: key, Config: cfg}
+
+			err := CheckResolver(ctx, key, "example.com", time.Second)
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tt.presentErr == nil && tt.cfg == nil, provider.cleanedUp)
+		})
+	}
+}
@@ -2,6 +2,7 @@ package gandiv5
 
 import (
 	"fmt"
+	appConfig "github.com/alexandreh2ag/lets-go-tls/apps/server/config"
 	"github.com/alexandreh2ag/lets-go-tls/apps/server/context"
 	"github.com/alexandreh2ag/lets-go-tls/mapstructure"
 	"github.com/alexandreh2ag/lets-go-tls/types/acme"
@@ -18,6 +19,8 @@ type ConfigGandiV5 struct {
 	PropagationTimeout time.Duration `mapstructure:"propagation_timeout" validate:"required"`
 	PollingInterval    time.Duration `mapstructure:"polling_interval" validate:"required"`
 	HttpTimeout        time.Duration `mapstructure:"http_timeout" validate:"required"`
+
+	appConfig.HTTPClientConfig `mapstructure:",squash"`
 }
 
 type gandiV5 struct {
@@ -33,7 +36,7 @@ func (g *gandiV5) Type() string {
 	return acme.TypeDNS01
 }
 
-func CreateGandiV5(_ *context.ServerContext, id string, cfg map[string]interface{}) (acme.Challenge, error) {
+func CreateGandiV5(ctx *context.ServerContext, id string, cfg map[string]interface{}) (acme.Challenge, error) {
 	config := gandiv5.NewDefaultConfig()
 	instanceConfig := ConfigGandiV5{
 		PropagationTimeout: config.PropagationTimeout,
@@ -52,6 +55,12 @@ func CreateGandiV5(_ *context.ServerContext, id string, cfg map[string]interface
 	}
 
 	config.PersonalAccessToken = instanceConfig.APIKey
+	if instanceConfig.HTTPClientConfig.IsDefined() {
+		config.HTTPClient, err = instanceConfig.CreateHTTPClient(ctx.Fs, instanceConfig.HttpTimeout)
+		if err != nil {
+			return nil, err
+		}
+	}
 	provider, err := gandiv5.NewDNSProviderConfig(config)
 	return &gandiV5{id: id, DNSProvider: provider}, err
 }
@@ -30,6 +30,22 @@ func Test_CreateGandiV5(t *testing.T) {
 			name: "Success",
 			cfg:  map[string]interface{}{"api_key": "api_key"},
 		},
+		{
+			name: "SuccessWithHTTPClient",
+			cfg:  map[string]interface{}{"api_key": "api_key", "proxy": "http://proxy.internal:3128", "tls": map[string]interface{}{"insecure_skip_verify": true}},
+		},
+		{
+			name:        "FailCreateHTTPClient",
+			cfg:         map[string]interface{}{"api_key": "api_key", "ca_bundle": "/missing.pem"},
+			wantErr:     true,
+			errContains: "failed to read ca bundle",
+		},
+		{
+			name:        "FailValidateProxy",
+			cfg:         map[string]interface{}{"api_key": "api_key", "proxy": "wrong"},
+			wantErr:     true,
+			errContains: "Key: 'ConfigGandiV5.HTTPClientConfig.Proxy' Error:Field validation for 'Proxy' failed on the 'url' tag",
+		},
 		{
 			name:        "FailDecodeCfg",
 			cfg:         map[string]interface{}{"api_key": []string{}},
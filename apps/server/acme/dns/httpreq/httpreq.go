@@ -5,6 +5,7 @@ import (
 	"net/url"
 	"time"
 
+	appConfig "github.com/alexandreh2ag/lets-go-tls/apps/server/config"
 	"github.com/alexandreh2ag/lets-go-tls/apps/server/context"
 	"github.com/alexandreh2ag/lets-go-tls/mapstructure"
 	"github.com/alexandreh2ag/lets-go-tls/types/acme"
@@ -22,6 +23,8 @@ type ConfigHttpReq struct {
 	PropagationTimeout time.Duration `mapstructure:"propagation_timeout" validate:"required"`
 	PollingInterval    time.Duration `mapstructure:"polling_interval" validate:"required"`
 	HttpTimeout        time.Duration `mapstructure:"http_timeout" validate:"required"`
+
+	appConfig.HTTPClientConfig `mapstructure:",squash"`
 }
 
 type httpReq struct {
@@ -37,7 +40,7 @@ func (g *httpReq) Type() string {
 	return acme.TypeDNS01
 }
 
-func CreateHttpReq(_ *context.ServerContext, id string, cfg map[string]interface{}) (acme.Challenge, error) {
+func CreateHttpReq(ctx *context.ServerContext, id string, cfg map[string]interface{}) (acme.Challenge, error) {
 	config := httpreq.NewDefaultConfig()
 	instanceConfig := ConfigHttpReq{
 		PropagationTimeout: config.PropagationTimeout,
@@ -67,6 +70,12 @@ func CreateHttpReq(_ *context.ServerContext, id string, cfg map[string]interface
 	config.PollingInterval = instanceConfig.PollingInterval
 	config.PropagationTimeout = instanceConfig.PropagationTimeout
 	config.HTTPClient.Timeout = instanceConfig.HttpTimeout
+	if instanceConfig.HTTPClientConfig.IsDefined() {
+		config.HTTPClient, err = instanceConfig.CreateHTTPClient(ctx.Fs, instanceConfig.HttpTimeout)
+		if err != nil {
+			return nil, err
+		}
+	}
 
 	provider, err := httpreq.NewDNSProviderConfig(config)
 	return &httpReq{id: id, DNSProvider: provider}, err
@@ -35,6 +35,22 @@ func Test_CreatehttpReq(t *testing.T) {
 			name: "SuccessWithDuration",
 			cfg:  map[string]interface{}{"endpoint": "http://127.0.0.1/", "mode": "", "http_timeout": "10s"},
 		},
+		{
+			name: "SuccessWithHTTPClient",
+			cfg:  map[string]interface{}{"endpoint": "http://127.0.0.1/", "proxy": "http://proxy.internal:3128", "tls": map[string]interface{}{"insecure_skip_verify": true}},
+		},
+		{
+			name:        "FailCreateHTTPClient",
+			cfg:         map[string]interface{}{"endpoint": "http://127.0.0.1/", "ca_bundle": "/missing.pem"},
+			wantErr:     true,
+			errContains: "failed to read ca bundle",
+		},
+		{
+			name:        "FailValidateProxy",
+			cfg:         map[string]interface{}{"endpoint": "http://127.0.0.1/", "proxy": "wrong"},
+			wantErr:     true,
+			errContains: "Key: 'ConfigHttpReq.HTTPClientConfig.Proxy' Error:Field validation for 'Proxy' failed on the 'url' tag",
+		},
 		{
 			name:        "FailDecodeCfg",
 			cfg:         map[string]interface{}{"endpoint": []string{}},
@@ -2,6 +2,7 @@ package ovh
 
 import (
 	"fmt"
+	appConfig "github.com/alexandreh2ag/lets-go-tls/apps/server/config"
 	"github.com/alexandreh2ag/lets-go-tls/apps/server/context"
 	"github.com/alexandreh2ag/lets-go-tls/mapstructure"
 	"github.com/alexandreh2ag/lets-go-tls/types/acme"
@@ -23,6 +24,8 @@ type ConfigOvh struct {
 	PropagationTimeout time.Duration `mapstructure:"propagation_timeout" validate:"required"`
 	PollingInterval    time.Duration `mapstructure:"polling_interval" validate:"required"`
 	HttpTimeout        time.Duration `mapstructure:"http_timeout" validate:"required"`
+
+	appConfig.HTTPClientConfig `mapstructure:",squash"`
 }
 
 type ovhChallenge struct {
@@ -38,7 +41,7 @@ func (g *ovhChallenge) Type() string {
 	return acme.TypeDNS01
 }
 
-func CreateOvh(_ *context.ServerContext, id string, cfg map[string]interface{}) (acme.Challenge, error) {
+func CreateOvh(ctx *context.ServerContext, id string, cfg map[string]interface{}) (acme.Challenge, error) {
 	config := legoOVH.NewDefaultConfig()
 	instanceConfig := ConfigOvh{
 		Endpoint:           "ovh-eu",
@@ -69,6 +72,12 @@ func CreateOvh(_ *context.ServerContext, id string, cfg map[string]interface{})
 	}
 
 	config.ConsumerKey = instanceConfig.ConsumerKey
+	if instanceConfig.HTTPClientConfig.IsDefined() {
+		config.HTTPClient, err = instanceConfig.CreateHTTPClient(ctx.Fs, instanceConfig.HttpTimeout)
+		if err != nil {
+			return nil, err
+		}
+	}
 	config.APIEndpoint = instanceConfig.Endpoint
 	provider, err := legoOVH.NewDNSProviderConfig(config)
 	return &ovhChallenge{id: id, DNSProvider: provider}, err
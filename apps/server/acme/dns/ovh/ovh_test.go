@@ -47,6 +47,16 @@ func Test_CreateOVH(t *testing.T) {
 				"client_secret": "client_secret",
 			},
 		},
+		{
+			name: "SuccessWithHTTPClient",
+			cfg:  map[string]interface{}{"access_token": "access_token", "proxy": "http://proxy.internal:3128", "tls": map[string]interface{}{"insecure_skip_verify": true}},
+		},
+		{
+			name:        "FailCreateHTTPClient",
+			cfg:         map[string]interface{}{"access_token": "access_token", "ca_bundle": "/missing.pem"},
+			wantErr:     true,
+			errContains: "failed to read ca bundle",
+		},
 		{
 			name: "FailDecodeCfg",
 			cfg: map[string]interface{}{
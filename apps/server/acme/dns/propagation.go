@@ -0,0 +1,193 @@
+package dns
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/alexandreh2ag/lets-go-tls/mapstructure"
+	"github.com/go-acme/lego/v4/challenge/dns01"
+	"github.com/go-playground/validator/v10"
+	"github.com/miekg/dns"
+)
+
+// PropagationConfig defines how propagation of a DNS-01 record is checked before asking the CA to validate it.
+// It is decoded from the resolver config next to propagation_timeout and polling_interval.
+type PropagationConfig struct {
+	Nameservers                []string      `mapstructure:"nameservers" validate:"omitempty,dive,required"`
+	DisableCompletePropagation bool          `mapstructure:"disable_complete_propagation"`
+	PreCheckDelay              time.Duration `mapstructure:"pre_check_delay"`
+	AuthoritativeOnly          bool          `mapstructure:"authoritative_only"`
+}
+
+// defaultNameservers are used when neither nameservers nor system nameservers are defined, like lego.
+var defaultNameservers = []string{
+	"google-public-dns-a.google.com:53",
+	"google-public-dns-b.google.com:53",
+}
+
+func DecodePropagationConfig(cfg map[string]interface{}) (PropagationConfig, error) {
+	propagation := PropagationConfig{}
+	err := mapstructure.Decode(cfg, &propagation)
+	if err != nil {
+		return propagation, err
+	}
+
+	validate := validator.New()
+	err = validate.Struct(propagation)
+	return propagation, err
+}
+
+func CreateChallengeOptions(cfg map[string]interface{}) ([]dns01.ChallengeOption, error) {
+	propagation, err := DecodePropagationConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return propagation.ChallengeOptions(), nil
+}
+
+// ChallengeOptions returns lego DNS-01 options, the lego checker is replaced when nameservers are defined
+// because lego nameservers option is shared by all resolvers.
+func (c PropagationConfig) ChallengeOptions() []dns01.ChallengeOption {
+	if len(c.Nameservers) == 0 {
+		opts := []dns01.ChallengeOption{}
+		if c.DisableCompletePropagation {
+			opts = append(opts, dns01.DisableAuthoritativeNssPropagationRequirement())
+		}
+		if c.PreCheckDelay > 0 {
+			opts = append(opts, dns01.PropagationWait(c.PreCheckDelay, false))
+		}
+		return opts
+	}
+
+	checker := newPropagationChecker(c)
+	return []dns01.ChallengeOption{
+		dns01.WrapPreCheck(func(_, fqdn, value string, _ dns01.PreCheckFunc) (bool, error) {
+			time.Sleep(c.PreCheckDelay)
+			return checker.check(fqdn, value)
+		}),
+	}
+}
+
+// Check returns true when the record is propagated, system nameservers are used when nameservers are not defined.
+func (c PropagationConfig) Check(fqdn, value string) (bool, error) {
+	if len(c.Nameservers) == 0 {
+		c.Nameservers = systemNameservers()
+	}
+	time.Sleep(c.PreCheckDelay)
+	return newPropagationChecker(c).check(fqdn, value)
+}
+
+func systemNameservers() []string {
+	clientConfig, err := dns.ClientConfigFromFile("/etc/resolv.conf")
+	if err != nil || len(clientConfig.Servers) == 0 {
+		return defaultNameservers
+	}
+	return clientConfig.Servers
+}
+
+type propagationChecker struct {
+	nameservers       []string
+	complete          bool
+	authoritativeOnly bool
+
+	// nsPort is the port of authoritative nameservers, it is only changed by tests.
+	nsPort string
+	client *dns.Client
+}
+
+func newPropagationChecker(cfg PropagationConfig) *propagationChecker {
+	return &propagationChecker{
+		nameservers:       dns01.ParseNameservers(cfg.Nameservers),
+		complete:          !cfg.DisableCompletePropagation,
+		authoritativeOnly: cfg.AuthoritativeOnly,
+		nsPort:            "53",
+		client:            &dns.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// check returns true when the record is served by recursive nameservers (unless authoritativeOnly)
+// and by all authoritative nameservers of the zone (unless complete propagation is disabled).
+func (p *propagationChecker) check(fqdn, value string) (bool, error) {
+	if !p.authoritativeOnly {
+		err := p.checkNameservers(fqdn, value, p.nameservers, true)
+		if err != nil {
+			return false, fmt.Errorf("recursive nameservers: %w", err)
+		}
+	}
+
+	if !p.complete {
+		return true, nil
+	}
+
+	authoritativeNss, err := p.lookupAuthoritativeNameservers(fqdn)
+	if err != nil {
+		return false, err
+	}
+	err = p.checkNameservers(fqdn, value, authoritativeNss, false)
+	if err != nil {
+		return false, fmt.Errorf("authoritative nameservers: %w", err)
+	}
+	return true, nil
+}
+
+func (p *propagationChecker) lookupAuthoritativeNameservers(fqdn string) ([]string, error) {
+	zone, err := dns01.FindZoneByFqdnCustom(fqdn, p.nameservers)
+	if err != nil {
+		return nil, fmt.Errorf("could not find zone: %w", err)
+	}
+
+	r, err := p.query(zone, dns.TypeNS, p.nameservers[0], true)
+	if err != nil {
+		return nil, fmt.Errorf("NS call failed: %w", err)
+	}
+
+	nameservers := []string{}
+	for _, rr := range r.Answer {
+		if ns, ok := rr.(*dns.NS); ok {
+			nameservers = append(nameservers, net.JoinHostPort(strings.TrimSuffix(strings.ToLower(ns.Ns), "."), p.nsPort))
+		}
+	}
+	if len(nameservers) == 0 {
+		return nil, fmt.Errorf("[zone=%s] could not determine authoritative nameservers", zone)
+	}
+	return nameservers, nil
+}
+
+func (p *propagationChecker) checkNameservers(fqdn, value string, nameservers []string, recursive bool) error {
+	for _, ns := range nameservers {
+		r, err := p.query(fqdn, dns.TypeTXT, ns, recursive)
+		if err != nil {
+			return err
+		}
+		if r.Rcode != dns.RcodeSuccess {
+			return fmt.Errorf("NS %s returned %s for %s", ns, dns.RcodeToString[r.Rcode], fqdn)
+		}
+
+		found := false
+		for _, rr := range r.Answer {
+			if txt, ok := rr.(*dns.TXT); ok && strings.Join(txt.Txt, "") == value {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("NS %s did not return the expected TXT record [fqdn: %s, value: %s]", ns, fqdn, value)
+		}
+	}
+	return nil
+}
+
+func (p *propagationChecker) query(fqdn string, rtype uint16, ns string, recursive bool) (*dns.Msg, error) {
+	m := new(dns.Msg)
+	m.SetQuestion(fqdn, rtype)
+	m.SetEdns0(4096, false)
+	m.RecursionDesired = recursive
+
+	r, _, err := p.client.Exchange(m, ns)
+	if err != nil {
+		return nil, fmt.Errorf("DNS call to %s failed: %w", ns, err)
+	}
+	return r, nil
+}
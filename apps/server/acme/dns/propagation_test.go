@@ -0,0 +1,157 @@
+package dns
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	testFqdn  = "_acme-challenge.example.com."
+	testValue = "token"
+)
+
+// startDnsServer starts an UDP dns server on localhost, it answers zone example.com with records returned by answer.
+func startDnsServer(t *testing.T, answer func(q dns.Question) []dns.RR) string {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	started := make(chan struct{})
+	server := &dns.Server{
+		PacketConn:        conn,
+		NotifyStartedFunc: func() { close(started) },
+		Handler: dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+			m := new(dns.Msg)
+			m.SetReply(r)
+			m.Answer = answer(r.Question[0])
+			_ = w.WriteMsg(m)
+		}),
+	}
+	go func() { _ = server.ActivateAndServe() }()
+	<-started
+	t.Cleanup(func() { _ = server.Shutdown() })
+	return conn.LocalAddr().String()
+}
+
+func zoneAnswer(withTXT bool) func(q dns.Question) []dns.RR {
+	return func(q dns.Question) []dns.RR {
+		header := dns.RR_Header{Name: q.Name, Rrtype: q.Qtype, Class: dns.ClassINET, Ttl: 60}
+		switch {
+		case q.Qtype == dns.TypeSOA && q.Name == "example.com.":
+			return []dns.RR{&dns.SOA{Hdr: header, Ns: "localhost.", Mbox: "admin.example.com.", Minttl: 60}}
+		case q.Qtype == dns.TypeNS && q.Name == "example.com.":
+			return []dns.RR{&dns.NS{Hdr: header, Ns: "localhost."}}
+		case q.Qtype == dns.TypeTXT && q.Name == testFqdn && withTXT:
+			return []dns.RR{&dns.TXT{Hdr: header, Txt: []string{testValue}}}
+		}
+		return nil
+	}
+}
+
+func Test_propagationChecker_check(t *testing.T) {
+	tests := []struct {
+		name             string
+		cfg              PropagationConfig
+		recursiveTXT     bool
+		authoritativeTXT bool
+		wantErr          string
+	}{
+		{
+			name:             "Success",
+			recursiveTXT:     true,
+			authoritativeTXT: true,
+		},
+		{
+			name:             "SuccessAuthoritativeOnly",
+			cfg:              PropagationConfig{AuthoritativeOnly: true},
+			authoritativeTXT: true,
+		},
+		{
+			name:         "SuccessDisableCompletePropagation",
+			cfg:          PropagationConfig{DisableCompletePropagation: true},
+			recursiveTXT: true,
+		},
+		{
+			name:             "FailRecursiveNotPropagated",
+			authoritativeTXT: true,
+			wantErr:          "recursive nameservers: NS 127.0.0.1",
+		},
+		{
+			name:         "FailAuthoritativeNotPropagated",
+			recursiveTXT: true,
+			wantErr:      "authoritative nameservers: NS localhost:",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recursive := startDnsServer(t, zoneAnswer(tt.recursiveTXT))
+			authoritative := startDnsServer(t, zoneAnswer(tt.authoritativeTXT))
+			_, authoritativePort, _ := net.SplitHostPort(authoritative)
+
+			tt.cfg.Nameservers = []string{recursive}
+			checker := newPropagationChecker(tt.cfg)
+			checker.nsPort = authoritativePort
+			checker.client.Timeout = time.Second
+
+			got, err := checker.check(testFqdn, testValue)
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				assert.False(t, got)
+				return
+			}
+			assert.NoError(t, err)
+			assert.True(t, got)
+		})
+	}
+}
+
+func TestCreateChallengeOptions(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     map[string]interface{}
+		wantLen int
+		wantErr string
+	}{
+		{
+			name:    "SuccessDefault",
+			cfg:     map[string]interface{}{"propagation_timeout": "1m"},
+			wantLen: 0,
+		},
+		{
+			name:    "SuccessLegoChecker",
+			cfg:     map[string]interface{}{"disable_complete_propagation": true, "pre_check_delay": "10s"},
+			wantLen: 2,
+		},
+		{
+			name:    "SuccessCustomNameservers",
+			cfg:     map[string]interface{}{"nameservers": []string{"1.1.1.1", "8.8.8.8:53"}, "authoritative_only": true},
+			wantLen: 1,
+		},
+		{
+			name:    "FailDecodeCfg",
+			cfg:     map[string]interface{}{"pre_check_delay": []string{}},
+			wantErr: "'pre_check_delay' expected type 'time.Duration'",
+		},
+		{
+			name:    "FailValidateCfg",
+			cfg:     map[string]interface{}{"nameservers": []string{""}},
+			wantErr: "Key: 'PropagationConfig.Nameservers[0]' Error:Field validation for 'Nameservers[0]' failed on the 'required' tag",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := CreateChallengeOptions(tt.cfg)
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Len(t, got, tt.wantLen)
+		})
+	}
+}
@@ -4,8 +4,10 @@ import (
 	"context"
 	"fmt"
 	"log/slog"
+	"net"
 	"net/http"
 	"path/filepath"
+	"strings"
 
 	"github.com/alexandreh2ag/lets-go-tls/apps/server/config"
 	"github.com/alexandreh2ag/lets-go-tls/types"
@@ -36,7 +38,24 @@ func (ch *ChallengeHTTP) Type() string {
 }
 
 func (ch *ChallengeHTTP) GetCacheKey(token, domain string) string {
-	return fmt.Sprintf("acme_http_%s_%s", token, domain)
+	return fmt.Sprintf("acme_http_%s_%s", token, challengeDomain(domain))
+}
+
+// challengeDomain returns the domain used in cache key, an IPv6 host (ex: [2001:db8::1]:80) is reduced to its canonical address
+// so it matches the IP identifier given to Present.
+func challengeDomain(domain string) string {
+	host := domain
+	if strings.HasPrefix(domain, "[") {
+		if h, _, err := net.SplitHostPort(domain); err == nil {
+			host = h
+		} else {
+			host = strings.Trim(domain, "[]")
+		}
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		return ip.String()
+	}
+	return domain
 }
 
 func (ch *ChallengeHTTP) Present(domain, token, keyAuth string) error {
@@ -43,6 +43,14 @@ func TestChallenge_GetCacheKey(t *testing.T) {
 	assert.Equal(t, want, challenge.GetCacheKey("token", "example.com"))
 }
 
+func TestChallenge_GetCacheKey_IP(t *testing.T) {
+	challenge := &ChallengeHTTP{}
+	assert.Equal(t, "acme_http_token_192.0.2.1", challenge.GetCacheKey("token", "192.0.2.1"))
+	assert.Equal(t, "acme_http_token_2001:db8::1", challenge.GetCacheKey("token", "2001:DB8:0::1"))
+	assert.Equal(t, "acme_http_token_2001:db8::1", challenge.GetCacheKey("token", "[2001:db8::1]"))
+	assert.Equal(t, "acme_http_token_2001:db8::1", challenge.GetCacheKey("token", "[2001:db8::1]:80"))
+}
+
 func TestChallenge_Present_Success(t *testing.T) {
 	ctx := appCtx.TestContext(nil)
 	ctrl := gomock.NewController(t)
@@ -58,3 +58,17 @@ func TestRegisterAccount_FailSave(t *testing.T) {
 	assert.Error(t, err)
 	assert.Contains(t, err.Error(), "error save")
 }
+
+func TestRegisterStagingAccount_SuccessRegister(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	state := &types.State{Account: &acme.Account{Registration: nil}, StagingAccount: &acme.Account{Registration: nil}}
+	resolver := mockTypes.NewMockResolver(ctrl)
+	resolver.EXPECT().Register(gomock.Any()).Times(1).Return(&registration.Resource{URI: "staging"}, nil)
+	stateStorage := mockTypesStorageState.NewMockStorage(ctrl)
+	stateStorage.EXPECT().Save(gomock.Any()).Times(1).Return(nil)
+
+	err := RegisterStagingAccount(state, stateStorage, resolver)
+	assert.NoError(t, err)
+	assert.Equal(t, "staging", state.StagingAccount.Registration.URI)
+	assert.Nil(t, state.Account.Registration)
+}
@@ -7,6 +7,7 @@ import (
 
 	"github.com/alexandreh2ag/lets-go-tls/apps/server/acme/dns"
 	"github.com/alexandreh2ag/lets-go-tls/apps/server/acme/http"
+	"github.com/alexandreh2ag/lets-go-tls/apps/server/acme/tlsalpn"
 	"github.com/alexandreh2ag/lets-go-tls/apps/server/config"
 	"github.com/alexandreh2ag/lets-go-tls/apps/server/context"
 	"github.com/alexandreh2ag/lets-go-tls/types"
@@ -21,15 +22,29 @@ var (
 )
 
 func CreateResolvers(ctx *context.ServerContext, account *acme.Account) (types.Resolvers, error) {
+	return createResolvers(ctx, account, ctx.Config.Acme.CAServer)
+}
+
+// CreateStagingResolvers creates the same resolvers as CreateResolvers on the staging CA.
+func CreateStagingResolvers(ctx *context.ServerContext, account *acme.Account) (types.Resolvers, error) {
+	return createResolvers(ctx, account, ctx.Config.Acme.Staging.CAServer)
+}
+
+func createResolvers(ctx *context.ServerContext, account *acme.Account, caServer string) (types.Resolvers, error) {
 	var err error = nil
 	instances := types.Resolvers{}
 
 	legoLog.Logger = log.New(io.Discard, "", log.LstdFlags)
 	configAcme := lego.NewConfig(account)
-	configAcme.CADirURL = ctx.Config.Acme.CAServer
+	configAcme.CADirURL = caServer
 	configAcme.Certificate.KeyType = certcrypto.RSA4096
 	if ctx.Config.Acme.HTTPClient != nil {
 		configAcme.HTTPClient = ctx.Config.Acme.HTTPClient
+	} else if ctx.Config.Acme.HTTPClientConfig.IsDefined() {
+		configAcme.HTTPClient, err = ctx.Config.Acme.CreateHTTPClient(ctx.Fs, configAcme.HTTPClient.Timeout)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create acme http client: %v", err)
+		}
 	}
 
 	ctx.Config.Acme.Resolvers[types.DefaultKey] = config.ResolverConfig{
@@ -50,6 +65,21 @@ func CreateResolvers(ctx *context.ServerContext, account *acme.Account) (types.R
 	return instances, err
 }
 
+// CreateOfflineResolvers creates resolvers with the same matching rules as CreateResolvers without any ACME client.
+func CreateOfflineResolvers(ctx *context.ServerContext) types.Resolvers {
+	instances := types.Resolvers{}
+
+	ctx.Config.Acme.Resolvers[types.DefaultKey] = config.ResolverConfig{
+		Type:    acme.TypeHTTP01,
+		Filters: []string{"*"},
+	}
+
+	for id, cfgResolver := range ctx.Config.Acme.Resolvers {
+		instances[id] = &ResolverOffline{Id: id, Filters: cfgResolver.Filters, Type: cfgResolver.Type}
+	}
+	return instances
+}
+
 func createResolver(ctx *context.ServerContext, id string, cfg config.ResolverConfig, cfgAcme *lego.Config) (types.Resolver, error) {
 	var provider acme.Challenge
 	client, err := lego.NewClient(cfgAcme)
@@ -58,15 +88,27 @@ func createResolver(ctx *context.ServerContext, id string, cfg config.ResolverCo
 	}
 	resolver := &ResolverAcme{Id: id, Filters: cfg.Filters, Client: client}
 
-	if cfg.Type == acme.TypeHTTP01 {
+	switch cfg.Type {
+	case acme.TypeHTTP01:
 		provider = GetHTTPProvider(ctx)
 		err = client.Challenge.SetHTTP01Provider(provider)
-	} else {
+	case acme.TypeTLSALPN01:
+		providerTLSALPN, errCreate := tlsalpn.CreateChallenge(id, cfg.Config)
+		if errCreate != nil {
+			return nil, fmt.Errorf("invalid config for resolver %s: %v", id, errCreate)
+		}
+		provider = providerTLSALPN
+		err = client.Challenge.SetTLSALPN01Provider(provider)
+	default:
 		provider, err = dns.CreateDnsChallenge(ctx, id, cfg)
 		if err != nil {
 			return nil, err
 		}
-		err = client.Challenge.SetDNS01Provider(provider)
+		opts, errOpts := dns.CreateChallengeOptions(cfg.Config)
+		if errOpts != nil {
+			return nil, fmt.Errorf("invalid propagation config for resolver %s: %v", id, errOpts)
+		}
+		err = client.Challenge.SetDNS01Provider(provider, opts...)
 	}
 
 	if err != nil {
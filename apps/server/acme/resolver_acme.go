@@ -1,12 +1,15 @@
 package acme
 
 import (
+	"net/netip"
+	"strings"
+
 	"github.com/alexandreh2ag/lets-go-tls/types"
 	"github.com/alexandreh2ag/lets-go-tls/types/acme"
 	"github.com/go-acme/lego/v4/certificate"
 	"github.com/go-acme/lego/v4/lego"
 	"github.com/go-acme/lego/v4/registration"
-	"strings"
+	"golang.org/x/net/publicsuffix"
 )
 
 var _ types.Resolver = &ResolverAcme{}
@@ -39,11 +42,15 @@ func (r ResolverAcme) RenewWithOptions(certRes certificate.Resource, options *ce
 }
 
 func (r ResolverAcme) Match(certificate *types.Certificate) bool {
-	if len(r.Filters) > 0 && len(certificate.Domains) > 0 {
+	return matchFilters(r.Filters, certificate)
+}
+
+func matchFilters(filters []string, certificate *types.Certificate) bool {
+	if len(filters) > 0 && len(certificate.Domains) > 0 {
 		for _, domain := range certificate.Domains {
 			match := false
-			for _, domainFilter := range r.Filters {
-				if strings.Contains(string(domain), domainFilter) {
+			for _, domainFilter := range filters {
+				if matchFilter(domainFilter, domain) {
 					match = true
 					continue
 				}
@@ -56,3 +63,36 @@ func (r ResolverAcme) Match(certificate *types.Certificate) bool {
 	}
 	return false
 }
+
+// matchFilter returns true when domain matches filter: an IP prefix (ex: 192.0.2.0/24) matches addresses it contains,
+// a registrable domain (ex: example.com) or an IP address matches the zone and its subdomains or the same address,
+// any other filter (ex: example, 192.0.2. or *) matches domains containing it like filters did before zone matching.
+func matchFilter(filter string, domain types.Domain) bool {
+	if prefix, err := netip.ParsePrefix(filter); err == nil {
+		addr, errAddr := netip.ParseAddr(string(domain))
+		return errAddr == nil && prefix.Contains(addr.Unmap())
+	}
+	if isZoneFilter(filter) {
+		return domain.InZone(filter)
+	}
+	return strings.Contains(string(domain), filter)
+}
+
+// isZoneFilter returns true when filter is an IP address or a hostname under a suffix of the public suffix list
+// which is not a suffix itself, so single labels (ex: internal) and names under unlisted TLDs keep substring matching.
+func isZoneFilter(filter string) bool {
+	normalized, err := types.Domain(filter).Normalize()
+	if err != nil || normalized.IsWildcard() {
+		return false
+	}
+	if normalized.IsIP() {
+		return true
+	}
+	suffix, icann := publicsuffix.PublicSuffix(string(normalized))
+	// suffixes of the list managed by ICANN or private ones (ex: github.io) have a dot, a TLD outside the list does not
+	if !icann && !strings.Contains(suffix, ".") {
+		return false
+	}
+	_, errRegistered := publicsuffix.EffectiveTLDPlusOne(string(normalized))
+	return errRegistered == nil
+}
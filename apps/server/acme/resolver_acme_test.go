@@ -128,6 +128,18 @@ func TestResolverAcme_Match(t *testing.T) {
 			certificate: &types.Certificate{Domains: types.Domains{"example.com", "example2.com"}},
 			want:        false,
 		},
+		{
+			name:        "NoMatchSubstring",
+			filters:     []string{"example.com"},
+			certificate: &types.Certificate{Domains: types.Domains{"notexample.com"}},
+			want:        false,
+		},
+		{
+			name:        "NoMatchParentDomain",
+			filters:     []string{"sub.example.com"},
+			certificate: &types.Certificate{Domains: types.Domains{"example.com"}},
+			want:        false,
+		},
 		{
 			name:        "OneMatch",
 			filters:     []string{"example.com"},
@@ -146,6 +158,66 @@ func TestResolverAcme_Match(t *testing.T) {
 			certificate: &types.Certificate{Domains: types.Domains{"example.com", "*.example.com"}},
 			want:        true,
 		},
+		{
+			name:        "IPMatch",
+			filters:     []string{"2001:DB8::1"},
+			certificate: &types.Certificate{Domains: types.Domains{"2001:db8::1"}},
+			want:        true,
+		},
+		{
+			name:        "IPPrefixMatch",
+			filters:     []string{"192.0.2.0/24", "2001:db8::/32"},
+			certificate: &types.Certificate{Domains: types.Domains{"192.0.2.10", "2001:db8::1"}},
+			want:        true,
+		},
+		{
+			name:        "NoMatchIPPrefix",
+			filters:     []string{"192.0.2.0/24"},
+			certificate: &types.Certificate{Domains: types.Domains{"198.51.100.1", "example.com"}},
+			want:        false,
+		},
+		{
+			name:        "PartialIPSubstringMatch",
+			filters:     []string{"192.0.2."},
+			certificate: &types.Certificate{Domains: types.Domains{"192.0.2.1"}},
+			want:        true,
+		},
+		{
+			name:        "WildcardSubstringMatch",
+			filters:     []string{"*"},
+			certificate: &types.Certificate{Domains: types.Domains{"*.example.com"}},
+			want:        true,
+		},
+		{
+			name:        "SingleLabelSubstringMatch",
+			filters:     []string{"internal"},
+			certificate: &types.Certificate{Domains: types.Domains{"app.internal.example.com", "internal-app.example.com"}},
+			want:        true,
+		},
+		{
+			name:        "UnlistedTLDSubstringMatch",
+			filters:     []string{"corp.lan"},
+			certificate: &types.Certificate{Domains: types.Domains{"app.mycorp.lan"}},
+			want:        true,
+		},
+		{
+			name:        "PublicSuffixSubstringMatch",
+			filters:     []string{"co.uk"},
+			certificate: &types.Certificate{Domains: types.Domains{"example.co.uk"}},
+			want:        true,
+		},
+		{
+			name:        "PrivateSuffixListRegistrableMatch",
+			filters:     []string{"example.github.io"},
+			certificate: &types.Certificate{Domains: types.Domains{"app.example.github.io"}},
+			want:        true,
+		},
+		{
+			name:        "NoMatchPrivateSuffixListRegistrableSubstring",
+			filters:     []string{"example.github.io"},
+			certificate: &types.Certificate{Domains: types.Domains{"myexample.github.io"}},
+			want:        false,
+		},
 		{
 			name:        "DifferentDomainsCertificateMatch",
 			filters:     []string{"example.com", "example.dev"},
@@ -0,0 +1,49 @@
+package acme
+
+import (
+	"errors"
+
+	"github.com/alexandreh2ag/lets-go-tls/types"
+	"github.com/alexandreh2ag/lets-go-tls/types/acme"
+	"github.com/go-acme/lego/v4/certificate"
+	"github.com/go-acme/lego/v4/registration"
+)
+
+var _ types.Resolver = &ResolverOffline{}
+
+var ErrResolverOffline = errors.New("resolver is offline")
+
+// ResolverOffline has the same filters and challenge type as ResolverAcme but never contacts the CA.
+type ResolverOffline struct {
+	Id      string
+	Filters []string
+	Type    string
+}
+
+func (r ResolverOffline) ID() string {
+	return r.Id
+}
+
+func (r ResolverOffline) TypeChallenge() string {
+	switch r.Type {
+	case acme.TypeHTTP01, acme.TypeTLSALPN01:
+		return r.Type
+	}
+	return acme.TypeDNS01
+}
+
+func (r ResolverOffline) Register(_ registration.RegisterOptions) (*registration.Resource, error) {
+	return nil, ErrResolverOffline
+}
+
+func (r ResolverOffline) Obtain(_ certificate.ObtainRequest) (*certificate.Resource, error) {
+	return nil, ErrResolverOffline
+}
+
+func (r ResolverOffline) RenewWithOptions(_ certificate.Resource, _ *certificate.RenewOptions) (*certificate.Resource, error) {
+	return nil, ErrResolverOffline
+}
+
+func (r ResolverOffline) Match(certificate *types.Certificate) bool {
+	return matchFilters(r.Filters, certificate)
+}
@@ -0,0 +1,50 @@
+package acme
+
+import (
+	"testing"
+
+	"github.com/alexandreh2ag/lets-go-tls/types"
+	"github.com/alexandreh2ag/lets-go-tls/types/acme"
+	"github.com/go-acme/lego/v4/certificate"
+	"github.com/go-acme/lego/v4/registration"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResolverOffline_ID(t *testing.T) {
+	r := &ResolverOffline{Id: "foo"}
+	assert.Equal(t, "foo", r.ID())
+}
+
+func TestResolverOffline_TypeChallenge(t *testing.T) {
+	tests := []struct {
+		name string
+		typ  string
+		want string
+	}{
+		{name: "http", typ: acme.TypeHTTP01, want: acme.TypeHTTP01},
+		{name: "tls-alpn", typ: acme.TypeTLSALPN01, want: acme.TypeTLSALPN01},
+		{name: "dns", typ: "ovh", want: acme.TypeDNS01},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &ResolverOffline{Type: tt.typ}
+			assert.Equal(t, tt.want, r.TypeChallenge())
+		})
+	}
+}
+
+func TestResolverOffline_NeverContactCA(t *testing.T) {
+	r := &ResolverOffline{}
+	_, err := r.Register(registration.RegisterOptions{})
+	assert.ErrorIs(t, err, ErrResolverOffline)
+	_, err = r.Obtain(certificate.ObtainRequest{})
+	assert.ErrorIs(t, err, ErrResolverOffline)
+	_, err = r.RenewWithOptions(certificate.Resource{}, nil)
+	assert.ErrorIs(t, err, ErrResolverOffline)
+}
+
+func TestResolverOffline_Match(t *testing.T) {
+	r := &ResolverOffline{Filters: []string{"example.com"}}
+	assert.True(t, r.Match(&types.Certificate{Domains: types.Domains{"sub.example.com"}}))
+	assert.False(t, r.Match(&types.Certificate{Domains: types.Domains{"sub.example.com", "foo.com"}}))
+}
@@ -1,6 +1,9 @@
 package acme
 
 import (
+	"crypto/tls"
+	"encoding/pem"
+	"net/url"
 	"testing"
 
 	"github.com/alexandreh2ag/lets-go-tls/apps/server/acme/dns"
@@ -8,8 +11,10 @@ import (
 	"github.com/alexandreh2ag/lets-go-tls/apps/server/context"
 	"github.com/alexandreh2ag/lets-go-tls/internal/testutil"
 	mockTypesAcme "github.com/alexandreh2ag/lets-go-tls/mocks/types/acme"
+	"github.com/alexandreh2ag/lets-go-tls/types"
 	"github.com/alexandreh2ag/lets-go-tls/types/acme"
 	"github.com/go-acme/lego/v4/lego"
+	"github.com/spf13/afero"
 	"github.com/stretchr/testify/assert"
 	"go.uber.org/mock/gomock"
 )
@@ -25,6 +30,47 @@ func TestCreateResolvers_Success(t *testing.T) {
 	assert.Len(t, got, 1)
 }
 
+func TestCreateStagingResolvers_Success(t *testing.T) {
+	ctx := context.TestContext(nil)
+	_, apiURL, httpClient := testutil.SetupFakeAPI(t)
+	ctx.Config.Acme.CAServer = "http://127.0.0.1:1/wrong"
+	ctx.Config.Acme.Staging.CAServer = apiURL + "/dir"
+	ctx.Config.Acme.HTTPClient = httpClient
+	account, _ := acme.NewAccount("dev@example.com")
+	got, err := CreateStagingResolvers(ctx, account)
+	assert.NoError(t, err)
+	assert.Len(t, got, 1)
+}
+
+func TestCreateResolvers_SuccessWithCABundle(t *testing.T) {
+	ctx := context.TestContext(nil)
+	ctx.Fs = afero.NewMemMapFs()
+	_, apiURL, _ := testutil.SetupFakeAPI(t)
+	serverURL, _ := url.Parse(apiURL)
+	conn, errDial := tls.Dial("tcp", serverURL.Host, &tls.Config{InsecureSkipVerify: true})
+	assert.NoError(t, errDial)
+	bundle := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: conn.ConnectionState().PeerCertificates[0].Raw})
+	_ = conn.Close()
+	_ = afero.WriteFile(ctx.Fs, "/ca.pem", bundle, 0644)
+
+	ctx.Config.Acme.CAServer = apiURL + "/dir"
+	ctx.Config.Acme.CABundle = "/ca.pem"
+	account, _ := acme.NewAccount("dev@example.com")
+	got, err := CreateResolvers(ctx, account)
+	assert.NoError(t, err)
+	assert.Len(t, got, 1)
+}
+
+func TestCreateResolvers_FailCreateHTTPClient(t *testing.T) {
+	ctx := context.TestContext(nil)
+	ctx.Fs = afero.NewMemMapFs()
+	ctx.Config.Acme.CABundle = "/ca.pem"
+	account, _ := acme.NewAccount("dev@example.com")
+	got, err := CreateResolvers(ctx, account)
+	assert.ErrorContains(t, err, "failed to create acme http client: failed to read ca bundle")
+	assert.Nil(t, got)
+}
+
 func TestCreateResolvers_Fail(t *testing.T) {
 	ctx := context.TestContext(nil)
 	_, apiURL, httpClient := testutil.SetupFakeAPI(t)
@@ -38,6 +84,16 @@ func TestCreateResolvers_Fail(t *testing.T) {
 	assert.Len(t, got, 0)
 }
 
+func TestCreateOfflineResolvers(t *testing.T) {
+	ctx := context.TestContext(nil)
+	ctx.Config.Acme.Resolvers = map[string]config.ResolverConfig{"ovh": {Type: "ovh", Filters: []string{"foo.com"}}}
+	got := CreateOfflineResolvers(ctx)
+	assert.Len(t, got, 2)
+	assert.Equal(t, &ResolverOffline{Id: "ovh", Filters: []string{"foo.com"}, Type: "ovh"}, got["ovh"])
+	assert.Equal(t, acme.TypeDNS01, got["ovh"].TypeChallenge())
+	assert.Equal(t, acme.TypeHTTP01, got[types.DefaultKey].TypeChallenge())
+}
+
 func Test_createResolver_Success(t *testing.T) {
 	ctx := context.TestContext(nil)
 	_, apiURL, httpClient := testutil.SetupFakeAPI(t)
@@ -55,6 +111,42 @@ func Test_createResolver_Success(t *testing.T) {
 	assert.NotNil(t, got)
 }
 
+func Test_createResolver_SuccessWithTLSALPN(t *testing.T) {
+	ctx := context.TestContext(nil)
+	_, apiURL, httpClient := testutil.SetupFakeAPI(t)
+
+	account, _ := acme.NewAccount("dev@example.com")
+	configAcme := lego.NewConfig(account)
+	configAcme.CADirURL = apiURL + "/dir"
+	configAcme.HTTPClient = httpClient
+	cfg := config.ResolverConfig{
+		Type:    acme.TypeTLSALPN01,
+		Config:  map[string]interface{}{"port": "8443"},
+		Filters: []string{"*"},
+	}
+	got, err := createResolver(ctx, "foo", cfg, configAcme)
+	assert.NoError(t, err)
+	assert.Equal(t, acme.TypeTLSALPN01, got.TypeChallenge())
+}
+
+func Test_createResolver_FailTLSALPNConfig(t *testing.T) {
+	ctx := context.TestContext(nil)
+	_, apiURL, httpClient := testutil.SetupFakeAPI(t)
+
+	account, _ := acme.NewAccount("dev@example.com")
+	configAcme := lego.NewConfig(account)
+	configAcme.CADirURL = apiURL + "/dir"
+	configAcme.HTTPClient = httpClient
+	cfg := config.ResolverConfig{
+		Type:    acme.TypeTLSALPN01,
+		Config:  map[string]interface{}{"port": "wrong"},
+		Filters: []string{"*"},
+	}
+	got, err := createResolver(ctx, "foo", cfg, configAcme)
+	assert.ErrorContains(t, err, "invalid config for resolver foo")
+	assert.Nil(t, got)
+}
+
 func Test_createResolver_SuccessWithDns(t *testing.T) {
 	ctx := context.TestContext(nil)
 	_, apiURL, httpClient := testutil.SetupFakeAPI(t)
@@ -0,0 +1,48 @@
+package tlsalpn
+
+import (
+	"fmt"
+
+	"github.com/alexandreh2ag/lets-go-tls/mapstructure"
+	"github.com/alexandreh2ag/lets-go-tls/types/acme"
+	"github.com/go-acme/lego/v4/challenge/tlsalpn01"
+	"github.com/go-playground/validator/v10"
+)
+
+// ConfigTLSALPN defines where the tls-alpn-01 challenge server listens, the CA always connects on port 443
+// so another port must be forwarded from 443.
+type ConfigTLSALPN struct {
+	Interface string `mapstructure:"interface"`
+	Port      string `mapstructure:"port" validate:"required,numeric"`
+}
+
+var _ acme.Challenge = &ChallengeTLSALPN{}
+
+type ChallengeTLSALPN struct {
+	*tlsalpn01.ProviderServer
+	id string
+}
+
+func (c *ChallengeTLSALPN) ID() string {
+	return fmt.Sprintf("%s-%s", acme.TypeTLSALPN01, c.id)
+}
+
+func (c *ChallengeTLSALPN) Type() string {
+	return acme.TypeTLSALPN01
+}
+
+func CreateChallenge(id string, cfg map[string]interface{}) (*ChallengeTLSALPN, error) {
+	instanceConfig := ConfigTLSALPN{Port: "443"}
+	err := mapstructure.Decode(cfg, &instanceConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	validate := validator.New()
+	err = validate.Struct(instanceConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ChallengeTLSALPN{ProviderServer: tlsalpn01.NewProviderServer(instanceConfig.Interface, instanceConfig.Port), id: id}, nil
+}
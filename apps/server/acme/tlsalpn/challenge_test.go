@@ -0,0 +1,84 @@
+package tlsalpn
+
+import (
+	"crypto/tls"
+	"net"
+	"testing"
+
+	"github.com/alexandreh2ag/lets-go-tls/types/acme"
+	"github.com/go-acme/lego/v4/challenge/tlsalpn01"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChallengeTLSALPN_ID(t *testing.T) {
+	c := ChallengeTLSALPN{id: "foo"}
+	assert.Equal(t, "tls-alpn-01-foo", c.ID())
+}
+
+func TestChallengeTLSALPN_Type(t *testing.T) {
+	c := ChallengeTLSALPN{}
+	assert.Equal(t, acme.TypeTLSALPN01, c.Type())
+}
+
+func TestCreateChallenge(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     map[string]interface{}
+		address string
+		wantErr string
+	}{
+		{
+			name:    "SuccessDefault",
+			address: ":443",
+		},
+		{
+			name:    "SuccessCustom",
+			cfg:     map[string]interface{}{"interface": "127.0.0.1", "port": "8443"},
+			address: "127.0.0.1:8443",
+		},
+		{
+			name:    "FailDecodeCfg",
+			cfg:     map[string]interface{}{"port": []string{}},
+			wantErr: "'port' expected type 'string'",
+		},
+		{
+			name:    "FailValidateCfg",
+			cfg:     map[string]interface{}{"port": "wrong"},
+			wantErr: "Key: 'ConfigTLSALPN.Port' Error:Field validation for 'Port' failed on the 'numeric' tag",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := CreateChallenge("foo", tt.cfg)
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				assert.Nil(t, got)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.address, got.GetAddress())
+		})
+	}
+}
+
+func TestChallengeTLSALPN_PresentIP(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+	_ = listener.Close()
+
+	c, err := CreateChallenge("foo", map[string]interface{}{"interface": "127.0.0.1", "port": port})
+	assert.NoError(t, err)
+	assert.NoError(t, c.Present("127.0.0.1", "token", "keyAuth"))
+	defer func() { _ = c.CleanUp("127.0.0.1", "token", "keyAuth") }()
+
+	conn, err := tls.Dial("tcp", c.GetAddress(), &tls.Config{
+		NextProtos:         []string{tlsalpn01.ACMETLS1Protocol},
+		InsecureSkipVerify: true,
+	})
+	assert.NoError(t, err)
+	defer conn.Close()
+	leaf := conn.ConnectionState().PeerCertificates[0]
+	assert.Len(t, leaf.IPAddresses, 1)
+	assert.Equal(t, "127.0.0.1", leaf.IPAddresses[0].String())
+}
@@ -16,3 +16,8 @@ func CreateCache(cfg config.CacheConfig) (types.Cache, error) {
 	}
 	return nil, fmt.Errorf("config cache type '%s' does not exist", cfg.Type)
 }
+
+// IsShared returns true when the cache is shared between processes, a memory cache is local to its process.
+func IsShared(cfg config.CacheConfig) bool {
+	return cfg.Type != memoryKey
+}
@@ -20,3 +20,8 @@ func TestCreateCache_Fail(t *testing.T) {
 	assert.Error(t, err)
 	assert.Contains(t, err.Error(), "config cache type 'wrong' does not exist")
 }
+
+func TestIsShared(t *testing.T) {
+	assert.False(t, IsShared(config.CacheConfig{Type: memoryKey}))
+	assert.True(t, IsShared(config.CacheConfig{Type: redisKey}))
+}
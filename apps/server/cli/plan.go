@@ -0,0 +1,99 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/alexandreh2ag/lets-go-tls/apps/server/context"
+	"github.com/alexandreh2ag/lets-go-tls/apps/server/manager"
+	"github.com/spf13/cobra"
+)
+
+const (
+	PlanFormatText = "text"
+	PlanFormatJson = "json"
+)
+
+func GetPlanCmd(ctx *context.ServerContext) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "plan",
+		Short: "Show what the manager would do without contacting the CA or saving the state",
+		RunE:  GetPlanRunFn(ctx),
+	}
+	cmd.Flags().StringP("format", "f", PlanFormatText, "Define output format (text, json)")
+	return cmd
+}
+
+func GetPlanRunFn(ctx *context.ServerContext) func(*cobra.Command, []string) error {
+	return func(cmd *cobra.Command, args []string) error {
+		format, _ := cmd.Flags().GetString("format")
+		if format != PlanFormatText && format != PlanFormatJson {
+			return fmt.Errorf("format %s is not supported", format)
+		}
+
+		plan, err := manager.NewManager(ctx.StateStorage).Plan(ctx)
+		if err != nil {
+			return err
+		}
+
+		if format == PlanFormatJson {
+			encoder := json.NewEncoder(cmd.OutOrStdout())
+			encoder.SetIndent("", "  ")
+			return encoder.Encode(plan)
+		}
+		writePlanText(cmd.OutOrStdout(), plan)
+		return nil
+	}
+}
+
+func writePlanText(w io.Writer, plan *manager.Plan) {
+	sections := []struct {
+		title        string
+		symbol       string
+		certificates []manager.PlanCertificate
+	}{
+		{"Certificates to create", "+", plan.Created},
+		{"Certificates to obtain", "+", plan.Obtained},
+		{"Certificates to renew", "~", plan.Renewed},
+		{"Certificates skipped", "!", plan.Skipped},
+		{"Certificates to mark as unused", "?", plan.Unused},
+		{"Certificates to delete", "-", plan.Deleted},
+	}
+
+	for _, section := range sections {
+		if len(section.certificates) == 0 {
+			continue
+		}
+		_, _ = fmt.Fprintf(w, "%s:\n", section.title)
+		for _, certificate := range section.certificates {
+			line := fmt.Sprintf("  %s %s %v", section.symbol, certificate.Identifier, certificate.Domains.ToStringSlice())
+			if certificate.Resolver != "" {
+				line += fmt.Sprintf(" (resolver: %s)", certificate.Resolver)
+			}
+			if certificate.Reason != "" {
+				line += fmt.Sprintf(": %s", certificate.Reason)
+			}
+			_, _ = fmt.Fprintln(w, line)
+		}
+	}
+
+	if len(plan.FetchErrors) > 0 {
+		ids := make([]string, 0, len(plan.FetchErrors))
+		for id := range plan.FetchErrors {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+		_, _ = fmt.Fprintln(w, "Fetch errors (unused certificates are not evaluated):")
+		for _, id := range ids {
+			_, _ = fmt.Fprintf(w, "  %s: %s\n", id, plan.FetchErrors[id])
+		}
+	}
+
+	_, _ = fmt.Fprintf(
+		w,
+		"Plan: %d to create, %d to obtain, %d to renew, %d skipped, %d to mark as unused, %d to delete.\n",
+		len(plan.Created), len(plan.Obtained), len(plan.Renewed), len(plan.Skipped), len(plan.Unused), len(plan.Deleted),
+	)
+}
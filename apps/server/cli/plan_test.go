@@ -0,0 +1,107 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/alexandreh2ag/lets-go-tls/apps/server/context"
+	"github.com/alexandreh2ag/lets-go-tls/apps/server/manager"
+	mockTypes "github.com/alexandreh2ag/lets-go-tls/mocks/types"
+	mockTypesStorageState "github.com/alexandreh2ag/lets-go-tls/mocks/types/storage/state"
+	appProm "github.com/alexandreh2ag/lets-go-tls/prometheus"
+	"github.com/alexandreh2ag/lets-go-tls/types"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/mock/gomock"
+)
+
+func preparePlanContext(t *testing.T) *context.ServerContext {
+	ctx := context.TestContext(nil)
+	ctx.MetricsRegister = appProm.NewRegistry(types.NameServerMetrics, prometheus.NewRegistry())
+	ctrl := gomock.NewController(t)
+	storage := mockTypesStorageState.NewMockStorage(ctrl)
+	storage.EXPECT().Load().Times(1).Return(&types.State{Certificates: types.Certificates{}}, nil)
+	ctx.StateStorage = storage
+	requester := mockTypes.NewMockRequester(ctrl)
+	requester.EXPECT().Fetch().Times(1).Return([]*types.DomainRequest{{Domains: types.Domains{"example.com"}}}, nil)
+	ctx.Requesters = types.Requesters{"foo": requester}
+	return ctx
+}
+
+func TestGetPlanRunFn_SuccessText(t *testing.T) {
+	ctx := preparePlanContext(t)
+	b := bytes.NewBufferString("")
+	cmd := GetPlanCmd(ctx)
+	cmd.SetArgs([]string{})
+	cmd.SetOut(b)
+	cmd.SetErr(io.Discard)
+	err := cmd.Execute()
+	assert.NoError(t, err)
+	assert.Equal(
+		t,
+		"Certificates to create:\n  + example.com-0 [example.com] (resolver: default)\nPlan: 1 to create, 0 to obtain, 0 to renew, 0 skipped, 0 to mark as unused, 0 to delete.\n",
+		b.String(),
+	)
+}
+
+func TestGetPlanRunFn_SuccessJson(t *testing.T) {
+	ctx := preparePlanContext(t)
+	b := bytes.NewBufferString("")
+	cmd := GetPlanCmd(ctx)
+	cmd.SetArgs([]string{"--format", "json"})
+	cmd.SetOut(b)
+	cmd.SetErr(io.Discard)
+	err := cmd.Execute()
+	assert.NoError(t, err)
+	got := &manager.Plan{}
+	assert.NoError(t, json.Unmarshal(b.Bytes(), got))
+	assert.Equal(t, []manager.PlanCertificate{{Identifier: "example.com-0", Domains: types.Domains{"example.com"}, Resolver: types.DefaultKey}}, got.Created)
+}
+
+func TestGetPlanRunFn_FailWrongFormat(t *testing.T) {
+	ctx := context.TestContext(nil)
+	cmd := GetPlanCmd(ctx)
+	cmd.SetArgs([]string{"--format", "yaml"})
+	cmd.SetOut(io.Discard)
+	cmd.SetErr(io.Discard)
+	err := cmd.Execute()
+	assert.ErrorContains(t, err, "format yaml is not supported")
+}
+
+func TestGetPlanRunFn_FailLoadState(t *testing.T) {
+	ctx := context.TestContext(nil)
+	ctrl := gomock.NewController(t)
+	storage := mockTypesStorageState.NewMockStorage(ctrl)
+	storage.EXPECT().Load().Times(1).Return(nil, errors.New("error"))
+	ctx.StateStorage = storage
+	cmd := GetPlanCmd(ctx)
+	cmd.SetArgs([]string{})
+	cmd.SetOut(io.Discard)
+	cmd.SetErr(io.Discard)
+	err := cmd.Execute()
+	assert.ErrorContains(t, err, "failed to load state: error")
+}
+
+func Test_writePlanText(t *testing.T) {
+	plan := manager.NewPlan()
+	plan.Renewed = []manager.PlanCertificate{{Identifier: "foo", Domains: types.Domains{"foo.com"}, Resolver: "ovh"}}
+	plan.Skipped = []manager.PlanCertificate{{Identifier: "bar", Domains: types.Domains{"*.bar.com"}, Resolver: "default", Reason: "reason"}}
+	plan.Deleted = []manager.PlanCertificate{{Identifier: "old", Domains: types.Domains{"old.com"}}}
+	plan.FetchErrors = map[string]string{"b": "error b", "a": "error a"}
+	b := bytes.NewBufferString("")
+	writePlanText(b, plan)
+	want := "Certificates to renew:\n" +
+		"  ~ foo [foo.com] (resolver: ovh)\n" +
+		"Certificates skipped:\n" +
+		"  ! bar [*.bar.com] (resolver: default): reason\n" +
+		"Certificates to delete:\n" +
+		"  - old [old.com]\n" +
+		"Fetch errors (unused certificates are not evaluated):\n" +
+		"  a: error a\n" +
+		"  b: error b\n" +
+		"Plan: 0 to create, 0 to obtain, 1 to renew, 1 skipped, 0 to mark as unused, 1 to delete.\n"
+	assert.Equal(t, want, b.String())
+}
@@ -0,0 +1,48 @@
+package cli
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/alexandreh2ag/lets-go-tls/apps/server/acme"
+	"github.com/alexandreh2ag/lets-go-tls/apps/server/context"
+	"github.com/spf13/cobra"
+)
+
+func GetResolverCmd(ctx *context.ServerContext) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "resolver",
+		Short: "Manage ACME resolvers",
+	}
+	cmd.AddCommand(GetResolverTestCmd(ctx))
+	return cmd
+}
+
+func GetResolverTestCmd(ctx *context.ServerContext) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "test <id>",
+		Short: "Check a resolver can solve its challenge without placing an ACME order",
+		Args:  cobra.ExactArgs(1),
+		RunE:  GetResolverTestRunFn(ctx),
+	}
+	cmd.Flags().StringP("domain", "d", "", "Define domain used to present the test challenge")
+	cmd.Flags().Duration("timeout", 30*time.Second, "Define http challenge request timeout (dns resolvers use propagation_timeout)")
+	return cmd
+}
+
+func GetResolverTestRunFn(ctx *context.ServerContext) func(*cobra.Command, []string) error {
+	return func(cmd *cobra.Command, args []string) error {
+		domain, _ := cmd.Flags().GetString("domain")
+		timeout, _ := cmd.Flags().GetDuration("timeout")
+		if domain == "" {
+			return fmt.Errorf("domain is required")
+		}
+
+		err := acme.CheckResolver(ctx, args[0], domain, timeout)
+		if err != nil {
+			return fmt.Errorf("resolver %s check failed for %s: %v", args[0], domain, err)
+		}
+		_, _ = fmt.Fprintf(cmd.OutOrStdout(), "resolver %s check succeeded for %s\n", args[0], domain)
+		return nil
+	}
+}
@@ -0,0 +1,44 @@
+package cli
+
+import (
+	"io"
+	"testing"
+
+	"github.com/alexandreh2ag/lets-go-tls/apps/server/context"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetResolverTestRunFn(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		wantErr string
+	}{
+		{
+			name:    "FailMissingId",
+			args:    []string{"test", "-d", "example.com"},
+			wantErr: "accepts 1 arg(s), received 0",
+		},
+		{
+			name:    "FailMissingDomain",
+			args:    []string{"test", "foo"},
+			wantErr: "domain is required",
+		},
+		{
+			name:    "FailResolverNotExist",
+			args:    []string{"test", "foo", "-d", "example.com"},
+			wantErr: "resolver foo check failed for example.com: resolver foo does not exist",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.TestContext(nil)
+			cmd := GetResolverCmd(ctx)
+			cmd.SetArgs(tt.args)
+			cmd.SetOut(io.Discard)
+			cmd.SetErr(io.Discard)
+			err := cmd.Execute()
+			assert.EqualError(t, err, tt.wantErr)
+		})
+	}
+}
@@ -4,7 +4,9 @@ import (
 	"errors"
 	"fmt"
 	"github.com/alexandreh2ag/lets-go-tls/apps/server/cache"
+	serverConfig "github.com/alexandreh2ag/lets-go-tls/apps/server/config"
 	appCtx "github.com/alexandreh2ag/lets-go-tls/apps/server/context"
+	"github.com/alexandreh2ag/lets-go-tls/apps/server/lock"
 	serverRequester "github.com/alexandreh2ag/lets-go-tls/apps/server/requester"
 	appProm "github.com/alexandreh2ag/lets-go-tls/prometheus"
 	"github.com/alexandreh2ag/lets-go-tls/requester"
@@ -39,6 +41,9 @@ func GetRootCmd(ctx *appCtx.ServerContext) *cobra.Command {
 	cmd.AddCommand(
 		GetStartCmd(ctx),
 		GetMigrateCmd(ctx),
+		GetPlanCmd(ctx),
+		GetResolverCmd(ctx),
+		GetStateCmd(ctx),
 		GetVersionCmd(),
 	)
 
@@ -52,6 +57,10 @@ func GetRootPreRunEFn(ctx *appCtx.ServerContext, validateCfg bool) func(*cobra.C
 
 		if validateCfg {
 			validate := validator.New()
+			err = serverConfig.RegisterValidations(validate)
+			if err != nil {
+				return err
+			}
 			err = validate.Struct(ctx.Config)
 			if err != nil {
 
@@ -78,12 +87,23 @@ func GetRootPreRunEFn(ctx *appCtx.ServerContext, validateCfg bool) func(*cobra.C
 			return fmt.Errorf("failed to create cache: %v", err)
 		}
 
+		ctx.Locker, err = lock.CreateLocker(ctx.Config.GetLockConfig())
+		if err != nil {
+			return fmt.Errorf("failed to create lock: %v", err)
+		}
+
+		ctx.TrustedRoots, err = ctx.Config.Validation.TrustedRoots(ctx.Fs)
+		if err != nil {
+			return fmt.Errorf("failed to load validation ca bundle: %v", err)
+		}
+
 		ctx.MetricsRegister = appProm.NewRegistry(types.NameServerMetrics, prometheus.NewRegistry())
 
 		ctx.StateStorage, err = storageState.CreateStorage(ctx, ctx.Config.State)
 		if err != nil {
 			return fmt.Errorf("failed to create state storage: %v", err)
 		}
+		ctx.StateStorage = storageState.WithSnapshot(ctx.StateStorage, ctx.Config.StateRefreshInterval)
 
 		logLevelFlagStr, _ := cmd.Flags().GetString(LogLevel)
 		if logLevelFlagStr != "" {
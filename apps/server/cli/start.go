@@ -2,7 +2,11 @@ package cli
 
 import (
 	stdContext "context"
+	"errors"
 	"fmt"
+	"net/http"
+	"time"
+
 	"github.com/alexandreh2ag/lets-go-tls/apps/server/acme"
 	"github.com/alexandreh2ag/lets-go-tls/apps/server/context"
 	appSrvHttp "github.com/alexandreh2ag/lets-go-tls/apps/server/http"
@@ -25,30 +29,53 @@ func GetStartRunFn(ctx *context.ServerContext) func(*cobra.Command, []string) er
 		e := appSrvHttp.CreateServerHTTP(ctx, acme.GetHTTPProvider(ctx))
 
 		httpConfig := ctx.Config.HTTP
-		go appHttp.StartServerHTTP(e, httpConfig.Listen, nil)
-
+		servers := []*http.Server{appHttp.NewServerHTTP(e, httpConfig.Listen, nil)}
 		if httpConfig.TLS.Enable {
 			tlsConfig := appHttp.CreateTLSConfig(httpConfig.TLS)
-			go appHttp.StartServerHTTP(e, httpConfig.TLS.Listen, tlsConfig)
+			servers = append(servers, appHttp.NewServerHTTP(e, httpConfig.TLS.Listen, tlsConfig))
 		}
 
-		mgr, _ := manager.CreateManager(ctx)
+		errServers := make(chan error, len(servers))
+		for _, server := range servers {
+			go func() {
+				if errStart := appHttp.StartServerHTTP(server); errStart != nil {
+					errServers <- errStart
+				}
+			}()
+		}
 
+		mgr, _ := manager.CreateManager(ctx)
+		mgrDone := make(chan error, 1)
 		go func() {
-			errStart := mgr.Start(ctx)
-			if errStart != nil {
-				panic(errStart)
-			}
+			mgrDone <- mgr.Start(ctx)
 		}()
 
-		for {
+		var errRun, errStop error
+		mgrRunning := true
+		select {
+		case sig := <-ctx.Signal():
+			ctx.Logger.Info(fmt.Sprintf("%s signal received, exiting...", sig.String()))
+		case errRun = <-errServers:
+			ctx.Logger.Error(errRun.Error())
+		case errRun = <-mgrDone:
+			mgrRunning = false
+		}
+
+		// manager is stopped before http servers to keep serving http challenges of an order in progress
+		if mgrRunning {
+			ctx.Cancel()
 			select {
-			case sig := <-ctx.Signal():
-				ctx.Cancel()
-				ctx.Logger.Info(fmt.Sprintf("%s signal received, exiting...", sig.String()))
-				return e.Shutdown(stdContext.Background())
+			case errStop = <-mgrDone:
+			case <-time.After(ctx.Config.ShutdownGracePeriod):
+				ctx.Logger.Warn(fmt.Sprintf("manager did not stop in %s", ctx.Config.ShutdownGracePeriod))
+				errStop = mgr.Abandon(ctx)
 			}
 		}
 
+		// http servers have their own grace period, in-flight requests are drained even when manager used the whole one
+		shutdownCtx, cancel := stdContext.WithTimeout(stdContext.Background(), ctx.Config.ShutdownGracePeriod)
+		defer cancel()
+		ctx.Logger.Info("stop http servers")
+		return errors.Join(errRun, errStop, appHttp.ShutdownServersHTTP(shutdownCtx, servers...))
 	}
 }
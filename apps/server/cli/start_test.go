@@ -1,6 +1,8 @@
 package cli
 
 import (
+	"errors"
+	"fmt"
 	"github.com/alexandreh2ag/lets-go-tls/apps/server/context"
 	mockTypesStorageState "github.com/alexandreh2ag/lets-go-tls/mocks/types/storage/state"
 	appProm "github.com/alexandreh2ag/lets-go-tls/prometheus"
@@ -11,6 +13,8 @@ import (
 	"github.com/spf13/viper"
 	"github.com/stretchr/testify/assert"
 	"go.uber.org/mock/gomock"
+	"net"
+	"net/http"
 	"syscall"
 	"testing"
 	"time"
@@ -69,3 +73,91 @@ func TestGetStartRunFn_SuccessListenHTTPS(t *testing.T) {
 	time.Sleep(time.Millisecond * 100)
 	ctx.Signal() <- syscall.SIGINT
 }
+
+func TestGetStartRunFn_FailListen(t *testing.T) {
+	ctx := context.TestContext(nil)
+	ctx.Config.HTTP.Listen = "127.0.0.1:-1"
+	ctx.Config.ShutdownGracePeriod = time.Second * 5
+	viper.Reset()
+	viper.SetFs(ctx.Fs)
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	storage := mockTypesStorageState.NewMockStorage(ctrl)
+	storage.EXPECT().Load().AnyTimes().Return(nil, errors.New("error"))
+	ctx.StateStorage = storage
+	ctx.MetricsRegister = appProm.NewRegistry(types.NameServerMetrics, prometheus.NewRegistry())
+
+	cmd := GetStartCmd(ctx)
+	err := GetStartRunFn(ctx)(cmd, []string{})
+	assert.ErrorContains(t, err, "fail to start http server with")
+}
+
+// slowCollector delays metrics responses to keep a request in flight during shutdown.
+type slowCollector struct {
+	started chan bool
+	delay   time.Duration
+}
+
+func (c *slowCollector) Describe(chan<- *prometheus.Desc) {}
+
+func (c *slowCollector) Collect(chan<- prometheus.Metric) {
+	close(c.started)
+	time.Sleep(c.delay)
+}
+
+func TestGetStartRunFn_SuccessDrainHTTPWhenManagerOverrunGracePeriod(t *testing.T) {
+	ctx := context.TestContext(nil)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	address := listener.Addr().String()
+	_ = listener.Close()
+	ctx.Config.HTTP.Listen = address
+	ctx.Config.HTTP.MetricsEnable = true
+	ctx.Config.ShutdownGracePeriod = time.Millisecond * 300
+	viper.Reset()
+	viper.SetFs(ctx.Fs)
+
+	ctrl := gomock.NewController(t)
+	storage := mockTypesStorageState.NewMockStorage(ctrl)
+	// manager run is still in progress when the grace period expires
+	storage.EXPECT().Load().AnyTimes().DoAndReturn(func() (*types.State, error) {
+		time.Sleep(time.Second)
+		return nil, errors.New("error")
+	})
+	ctx.StateStorage = storage
+
+	registry := prometheus.NewRegistry()
+	collector := &slowCollector{started: make(chan bool), delay: time.Millisecond * 500}
+	registry.MustRegister(collector)
+	ctx.MetricsRegister = appProm.NewRegistry(types.NameServerMetrics, registry)
+
+	errRun := make(chan error, 1)
+	cmd := GetStartCmd(ctx)
+	go func() {
+		errRun <- GetStartRunFn(ctx)(cmd, []string{})
+	}()
+
+	statusCode := make(chan int, 1)
+	assert.Eventually(t, func() bool {
+		conn, errDial := net.Dial("tcp", address)
+		if errDial == nil {
+			_ = conn.Close()
+		}
+		return errDial == nil
+	}, time.Second, time.Millisecond*10)
+	go func() {
+		resp, errGet := http.Get(fmt.Sprintf("http://%s/metrics", address))
+		if errGet != nil {
+			statusCode <- 0
+			return
+		}
+		_ = resp.Body.Close()
+		statusCode <- resp.StatusCode
+	}()
+	<-collector.started
+	ctx.Signal() <- syscall.SIGINT
+
+	assert.NoError(t, <-errRun)
+	assert.Equal(t, http.StatusOK, <-statusCode)
+}
@@ -0,0 +1,20 @@
+package cli
+
+import (
+	"crypto/x509"
+
+	"github.com/alexandreh2ag/lets-go-tls/apps/server/context"
+	"github.com/alexandreh2ag/lets-go-tls/config"
+	stateCli "github.com/alexandreh2ag/lets-go-tls/storage/state/cli"
+	"github.com/alexandreh2ag/lets-go-tls/types/storage/state"
+	"github.com/spf13/cobra"
+)
+
+func GetStateCmd(ctx *context.ServerContext) *cobra.Command {
+	return stateCli.GetStateCmd(stateCli.Options{
+		Ctx:          ctx,
+		Config:       func() config.StateConfig { return ctx.Config.State },
+		Storage:      func() state.Storage { return ctx.StateStorage },
+		TrustedRoots: func() *x509.CertPool { return ctx.TrustedRoots },
+	})
+}
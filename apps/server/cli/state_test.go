@@ -0,0 +1,19 @@
+package cli
+
+import (
+	"testing"
+
+	"github.com/alexandreh2ag/lets-go-tls/apps/server/context"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetStateCmd(t *testing.T) {
+	ctx := context.TestContext(nil)
+	cmd := GetStateCmd(ctx)
+	assert.Equal(t, "state", cmd.Name())
+	for _, name := range []string{"list", "show", "export", "delete", "import", "history", "diff", "restore", "migrate"} {
+		sub, _, err := cmd.Find([]string{name})
+		assert.NoError(t, err)
+		assert.Equal(t, name, sub.Name())
+	}
+}
@@ -0,0 +1,41 @@
+package config
+
+import (
+	"fmt"
+
+	"github.com/alexandreh2ag/lets-go-tls/types"
+	"github.com/go-acme/lego/v4/certcrypto"
+	"github.com/go-playground/validator/v10"
+)
+
+// KeyTypes maps key_type values of certificates config to lego key types.
+var KeyTypes = map[string]certcrypto.KeyType{
+	"rsa2048": certcrypto.RSA2048,
+	"rsa3072": certcrypto.RSA3072,
+	"rsa4096": certcrypto.RSA4096,
+	"rsa8192": certcrypto.RSA8192,
+	"ec256":   certcrypto.EC256,
+	"ec384":   certcrypto.EC384,
+}
+
+// CertificateConfig declares a certificate managed by the server even if no requester asks for it.
+type CertificateConfig struct {
+	Identifier string        `mapstructure:"identifier" validate:"required,excludesall=/\\ "`
+	Domains    types.Domains `mapstructure:"domains" validate:"required,min=1,max=100,dive,required"`
+	Resolver   string        `mapstructure:"resolver,omitempty" yaml:"resolver,omitempty"`
+	KeyType    string        `mapstructure:"key_type,omitempty" yaml:"key_type,omitempty" validate:"omitempty,oneof=rsa2048 rsa3072 rsa4096 rsa8192 ec256 ec384"`
+	Retention  string        `mapstructure:"retention,omitempty" yaml:"retention,omitempty" validate:"omitempty,oneof=unused never"`
+}
+
+// validateCertificatesResolver checks resolver of declared certificates is defined in acme resolvers.
+func validateCertificatesResolver(sl validator.StructLevel) {
+	cfg := sl.Current().Interface().(Config)
+	for i, certificate := range cfg.Certificates {
+		if certificate.Resolver == "" || certificate.Resolver == types.DefaultKey {
+			continue
+		}
+		if _, ok := cfg.Acme.Resolvers[certificate.Resolver]; !ok {
+			sl.ReportError(certificate.Resolver, fmt.Sprintf("Certificates[%d].Resolver", i), "Resolver", "resolver_exists", "")
+		}
+	}
+}
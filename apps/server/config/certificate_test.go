@@ -0,0 +1,69 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/alexandreh2ag/lets-go-tls/config"
+	"github.com/alexandreh2ag/lets-go-tls/types"
+	"github.com/go-playground/validator/v10"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_validateCertificatesResolver(t *testing.T) {
+	tests := []struct {
+		name         string
+		certificates []CertificateConfig
+		wantErr      string
+	}{
+		{
+			name: "Success",
+			certificates: []CertificateConfig{
+				{Identifier: "foo", Domains: types.Domains{"foo.com"}, Resolver: "ovh", KeyType: "ec256", Retention: "never"},
+				{Identifier: "bar", Domains: types.Domains{"bar.com"}, Resolver: types.DefaultKey},
+				{Identifier: "baz", Domains: types.Domains{"baz.com"}},
+			},
+		},
+		{
+			name:         "FailResolverNotExist",
+			certificates: []CertificateConfig{{Identifier: "foo", Domains: types.Domains{"foo.com"}, Resolver: "gandi"}},
+			wantErr:      "Key: 'Config.Certificates[0].Resolver' Error:Field validation for 'Certificates[0].Resolver' failed on the 'resolver_exists' tag",
+		},
+		{
+			name:         "FailKeyType",
+			certificates: []CertificateConfig{{Identifier: "foo", Domains: types.Domains{"foo.com"}, KeyType: "dsa"}},
+			wantErr:      "Key: 'Config.Certificates[0].KeyType' Error:Field validation for 'KeyType' failed on the 'oneof' tag",
+		},
+		{
+			name: "FailIdentifierNotUnique",
+			certificates: []CertificateConfig{
+				{Identifier: "foo", Domains: types.Domains{"foo.com"}},
+				{Identifier: "foo", Domains: types.Domains{"bar.com"}},
+			},
+			wantErr: "Key: 'Config.Certificates' Error:Field validation for 'Certificates' failed on the 'unique' tag",
+		},
+		{
+			name:         "FailDomainsRequired",
+			certificates: []CertificateConfig{{Identifier: "foo"}},
+			wantErr:      "Key: 'Config.Certificates[0].Domains' Error:Field validation for 'Domains' failed on the 'required' tag",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			validate := validator.New()
+			assert.NoError(t, RegisterValidations(validate))
+			cfg := DefaultConfig()
+			cfg.Acme.Email = "foo@bar.com"
+			cfg.Acme.Resolvers = map[string]ResolverConfig{"ovh": {Type: "ovh", Filters: []string{"foo.com"}}}
+			cfg.Requesters = []config.RequesterConfig{{Id: "static", Type: "static"}}
+			cfg.State = config.StateConfig{Type: "fs"}
+			cfg.JWT.Key = "secret"
+			cfg.Certificates = tt.certificates
+			err := validate.Struct(cfg)
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
@@ -1,11 +1,16 @@
 package config
 
 import (
+	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/alexandreh2ag/lets-go-tls/config"
+	"github.com/alexandreh2ag/lets-go-tls/types"
 	"github.com/go-acme/lego/v4/lego"
+	"github.com/go-playground/validator/v10"
 )
 
 type Config struct {
@@ -13,24 +18,43 @@ type Config struct {
 	Acme                    AcmeConfig               `mapstructure:"acme" validate:"required"`
 	State                   config.StateConfig       `mapstructure:"state" validate:"required"`
 	Cache                   CacheConfig              `mapstructure:"cache" validate:"required"`
+	Lock                    LockConfig               `mapstructure:"lock"`
+	Partition               PartitionConfig          `mapstructure:"partition"`
+	SANMerge                bool                     `mapstructure:"san_merge"`
+	Grouping                string                   `mapstructure:"grouping" validate:"required,oneof=per_request per_domain per_registered_domain"`
+	Certificates            []CertificateConfig      `mapstructure:"certificates" validate:"omitempty,unique=Identifier,dive"`
+	PrivateSuffixes         types.Suffixes           `mapstructure:"private_suffixes" validate:"omitempty,dive,required"`
+	Validation              config.ValidationConfig  `mapstructure:"validation"`
 	HTTP                    config.HTTPConfig        `mapstructure:"http" validate:"required"`
 	JWT                     JWTConfig                `mapstructure:"jwt" validate:"required"`
 	Interval                time.Duration            `mapstructure:"interval" validate:"required"`
 	LockDuration            time.Duration            `mapstructure:"lock_duration" validate:"required"`
 	UnusedRetentionDuration time.Duration            `mapstructure:"unused_retention" validate:"required"`
+	ShutdownGracePeriod     time.Duration            `mapstructure:"shutdown_grace_period" validate:"required"`
+	StateRefreshInterval    time.Duration            `mapstructure:"state_refresh_interval" validate:"required"`
 }
 
+const (
+	GroupingPerRequest          = "per_request"
+	GroupingPerDomain           = "per_domain"
+	GroupingPerRegisteredDomain = "per_registered_domain"
+)
+
 type AcmeConfig struct {
 	CAServer    string                    `mapstructure:"ca_server" validate:"required"`
 	Email       string                    `mapstructure:"email" validate:"required,email"`
-	Resolvers   map[string]ResolverConfig `mapstructure:"resolvers,omitempty"`
+	Resolvers   map[string]ResolverConfig `mapstructure:"resolvers,omitempty" validate:"omitempty,dive"`
 	RenewPeriod time.Duration             `mapstructure:"renew_period" validate:"required"`
+	RenewAt     string                    `mapstructure:"renew_at,omitempty" validate:"omitempty,renew_at"`
 	MaxAttempt  int                       `mapstructure:"max_attempt" validate:"required,min=1"`
 	DelayFailed time.Duration             `mapstructure:"delay_failed" validate:"required"`
 
 	HttpChallengeConfig HttpChallengeConfig `mapstructure:"http_challenge"`
+	Staging             StagingConfig       `mapstructure:"staging"`
 
-	// HTTPClient is an optional HTTP client used for ACME requests (useful for testing with TLS test servers).
+	HTTPClientConfig `mapstructure:",squash" yaml:",inline"`
+
+	// HTTPClient is an optional HTTP client used for ACME requests, it takes precedence over HTTPClientConfig (useful for testing with TLS test servers).
 	HTTPClient *http.Client `mapstructure:"-"`
 }
 
@@ -39,15 +63,33 @@ type HttpChallengeConfig struct {
 	DocumentRoot       string `mapstructure:"document_root" validate:"required_if=EnableDocumentRoot true"`
 }
 
+// StagingConfig enables a full order on a staging CA before issuing a new certificate from CAServer.
+type StagingConfig struct {
+	Enable   bool   `mapstructure:"enable"`
+	CAServer string `mapstructure:"ca_server" validate:"required_if=Enable true"`
+}
+
 type CacheConfig struct {
 	Type   string                 `mapstructure:"type" validate:"required,excludesall=!@#$ "`
 	Config map[string]interface{} `mapstructure:"config,omitempty"`
 }
 
+type LockConfig struct {
+	Type   string                 `mapstructure:"type" validate:"omitempty,excludesall=!@#$ "`
+	Config map[string]interface{} `mapstructure:"config,omitempty"`
+}
+
+// PartitionConfig enables replicas to obtain different certificates concurrently with a lease per certificate.
+type PartitionConfig struct {
+	Enable bool `mapstructure:"enable"`
+}
+
 type ResolverConfig struct {
-	Type    string                 `mapstructure:"type" validate:"required,excludesall=!@#$ "`
-	Config  map[string]interface{} `mapstructure:"config"`
-	Filters []string               `mapstructure:"filters" validate:"required,min=1"`
+	Type        string                 `mapstructure:"type" validate:"required,excludesall=!@#$ "`
+	Config      map[string]interface{} `mapstructure:"config"`
+	Filters     []string               `mapstructure:"filters" validate:"required,min=1"`
+	RenewPeriod time.Duration          `mapstructure:"renew_period,omitempty" yaml:"renew_period,omitempty"`
+	RenewAt     string                 `mapstructure:"renew_at,omitempty" yaml:"renew_at,omitempty" validate:"omitempty,renew_at"`
 }
 
 type JWTConfig struct {
@@ -55,6 +97,71 @@ type JWTConfig struct {
 	Method string `mapstructure:"method" validate:"required"`
 }
 
+// GetLockConfig returns the lock config, it falls back on cache config when lock type is not defined.
+func (c Config) GetLockConfig() LockConfig {
+	if c.Lock.Type == "" {
+		return LockConfig{Type: c.Cache.Type, Config: c.Cache.Config}
+	}
+	return c.Lock
+}
+
+// GetRenewPolicy returns the lifetime fraction and the fixed period used to renew certificates of a resolver.
+// Resolver values take precedence over global ones, a zero fraction means the fixed period is used.
+func (c AcmeConfig) GetRenewPolicy(resolverID string) (float64, time.Duration) {
+	renewAt, renewPeriod := c.RenewAt, c.RenewPeriod
+	if resolver, ok := c.Resolvers[resolverID]; ok {
+		if resolver.RenewAt != "" {
+			renewAt = resolver.RenewAt
+		} else if resolver.RenewPeriod > 0 {
+			renewAt, renewPeriod = "", resolver.RenewPeriod
+		}
+	}
+
+	fraction, err := ParseRenewAt(renewAt)
+	if err != nil {
+		return 0, renewPeriod
+	}
+	return fraction, renewPeriod
+}
+
+// ParseRenewAt parses a fraction of certificate lifetime like "2/3" or "0.66", an empty value returns 0.
+func ParseRenewAt(value string) (float64, error) {
+	if value == "" {
+		return 0, nil
+	}
+
+	var fraction float64
+	numerator, denominator, isRatio := strings.Cut(value, "/")
+	if isRatio {
+		num, errNum := strconv.ParseFloat(strings.TrimSpace(numerator), 64)
+		den, errDen := strconv.ParseFloat(strings.TrimSpace(denominator), 64)
+		if errNum != nil || errDen != nil || den == 0 {
+			return 0, fmt.Errorf("renew_at %s is not a valid fraction", value)
+		}
+		fraction = num / den
+	} else {
+		var err error
+		fraction, err = strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil {
+			return 0, fmt.Errorf("renew_at %s is not a valid fraction", value)
+		}
+	}
+
+	if fraction <= 0 || fraction >= 1 {
+		return 0, fmt.Errorf("renew_at %s must be between 0 and 1 excluded", value)
+	}
+	return fraction, nil
+}
+
+// RegisterValidations adds custom validations used by the config.
+func RegisterValidations(validate *validator.Validate) error {
+	validate.RegisterStructValidation(validateCertificatesResolver, Config{})
+	return validate.RegisterValidation("renew_at", func(fl validator.FieldLevel) bool {
+		_, err := ParseRenewAt(fl.Field().String())
+		return err == nil
+	})
+}
+
 func NewConfig() Config {
 	return Config{}
 }
@@ -64,6 +171,9 @@ func DefaultConfig() Config {
 	cfg.Interval = time.Minute * 5
 	cfg.LockDuration = time.Minute * 25
 	cfg.UnusedRetentionDuration = time.Hour * 24 * 14
+	cfg.ShutdownGracePeriod = time.Second * 30
+	cfg.StateRefreshInterval = time.Second * 5
+	cfg.Grouping = GroupingPerRequest
 	cfg.HTTP = config.HTTPConfig{Listen: "0.0.0.0:8080"}
 	cfg.Cache = CacheConfig{Type: "memory"}
 	cfg.Acme = AcmeConfig{
@@ -72,6 +182,7 @@ func DefaultConfig() Config {
 		RenewPeriod: time.Hour * 24 * 10,
 		MaxAttempt:  3,
 		DelayFailed: time.Hour * 24,
+		Staging:     StagingConfig{CAServer: lego.LEDirectoryStaging},
 	}
 	cfg.JWT = JWTConfig{Method: "HS256"}
 	return cfg
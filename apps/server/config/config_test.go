@@ -3,6 +3,7 @@ package config
 import (
 	"github.com/alexandreh2ag/lets-go-tls/config"
 	"github.com/go-acme/lego/v4/lego"
+	"github.com/go-playground/validator/v10"
 	"github.com/stretchr/testify/assert"
 	"testing"
 	"time"
@@ -21,6 +22,9 @@ func TestDefaultConfig(t *testing.T) {
 			Interval:                time.Minute * 5,
 			LockDuration:            time.Minute * 25,
 			UnusedRetentionDuration: time.Hour * 24 * 14,
+			ShutdownGracePeriod:     time.Second * 30,
+			StateRefreshInterval:    time.Second * 5,
+			Grouping:                GroupingPerRequest,
 			Cache:                   CacheConfig{Type: "memory"},
 			Acme: AcmeConfig{
 				CAServer:    lego.LEDirectoryProduction,
@@ -28,9 +32,113 @@ func TestDefaultConfig(t *testing.T) {
 				Resolvers:   map[string]ResolverConfig{},
 				MaxAttempt:  3,
 				DelayFailed: time.Hour * 24,
+				Staging:     StagingConfig{CAServer: lego.LEDirectoryStaging},
 			},
 			JWT: JWTConfig{Method: "HS256"},
 		},
 		got,
 	)
 }
+
+func TestConfig_GetLockConfig(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  Config
+		want LockConfig
+	}{
+		{
+			name: "FallbackCache",
+			cfg:  Config{Cache: CacheConfig{Type: "redis", Config: map[string]interface{}{"address": "127.0.0.1:6379"}}},
+			want: LockConfig{Type: "redis", Config: map[string]interface{}{"address": "127.0.0.1:6379"}},
+		},
+		{
+			name: "DefinedLock",
+			cfg: Config{
+				Cache: CacheConfig{Type: "memory"},
+				Lock:  LockConfig{Type: "redis", Config: map[string]interface{}{"address": "127.0.0.1:6379"}},
+			},
+			want: LockConfig{Type: "redis", Config: map[string]interface{}{"address": "127.0.0.1:6379"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.cfg.GetLockConfig())
+		})
+	}
+}
+
+func TestParseRenewAt(t *testing.T) {
+	tests := []struct {
+		name        string
+		value       string
+		want        float64
+		wantErr     bool
+		errContains string
+	}{
+		{name: "Empty", value: "", want: 0},
+		{name: "Ratio", value: "2/3", want: 2.0 / 3.0},
+		{name: "RatioWithSpaces", value: " 1 / 2 ", want: 0.5},
+		{name: "Decimal", value: "0.75", want: 0.75},
+		{name: "FailDivideByZero", value: "2/0", wantErr: true, errContains: "renew_at 2/0 is not a valid fraction"},
+		{name: "FailNotNumber", value: "foo", wantErr: true, errContains: "renew_at foo is not a valid fraction"},
+		{name: "FailGreaterThanOne", value: "3/2", wantErr: true, errContains: "renew_at 3/2 must be between 0 and 1 excluded"},
+		{name: "FailZero", value: "0", wantErr: true, errContains: "renew_at 0 must be between 0 and 1 excluded"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseRenewAt(tt.value)
+			if tt.wantErr {
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), tt.errContains)
+				return
+			}
+			assert.NoError(t, err)
+			assert.InDelta(t, tt.want, got, 0.0001)
+		})
+	}
+}
+
+func TestAcmeConfig_GetRenewPolicy(t *testing.T) {
+	cfg := AcmeConfig{
+		RenewPeriod: time.Hour * 24 * 10,
+		RenewAt:     "2/3",
+		Resolvers: map[string]ResolverConfig{
+			"fraction": {RenewAt: "1/2"},
+			"period":   {RenewPeriod: time.Hour * 24},
+			"global":   {},
+		},
+	}
+	tests := []struct {
+		name       string
+		resolverID string
+		wantAt     float64
+		wantPeriod time.Duration
+	}{
+		{name: "ResolverFraction", resolverID: "fraction", wantAt: 0.5, wantPeriod: time.Hour * 24 * 10},
+		{name: "ResolverPeriod", resolverID: "period", wantAt: 0, wantPeriod: time.Hour * 24},
+		{name: "Global", resolverID: "global", wantAt: 2.0 / 3.0, wantPeriod: time.Hour * 24 * 10},
+		{name: "UnknownResolver", resolverID: "unknown", wantAt: 2.0 / 3.0, wantPeriod: time.Hour * 24 * 10},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotAt, gotPeriod := cfg.GetRenewPolicy(tt.resolverID)
+			assert.InDelta(t, tt.wantAt, gotAt, 0.0001)
+			assert.Equal(t, tt.wantPeriod, gotPeriod)
+		})
+	}
+}
+
+func TestRegisterValidations(t *testing.T) {
+	validate := validator.New()
+	assert.NoError(t, RegisterValidations(validate))
+
+	cfg := DefaultConfig()
+	cfg.Acme.Email = "foo@bar.com"
+	cfg.Acme.RenewAt = "2/3"
+	cfg.Acme.Resolvers = map[string]ResolverConfig{"ovh": {Type: "ovh", Filters: []string{"foo.com"}, RenewAt: "1/2"}}
+	assert.NoError(t, validate.Struct(cfg.Acme))
+
+	cfg.Acme.Resolvers["ovh"] = ResolverConfig{Type: "ovh", Filters: []string{"foo.com"}, RenewAt: "3/2"}
+	err := validate.Struct(cfg.Acme)
+	assert.ErrorContains(t, err, "'RenewAt' failed on the 'renew_at' tag")
+}
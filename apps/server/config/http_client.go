@@ -0,0 +1,74 @@
+package config
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/alexandreh2ag/lets-go-tls/types"
+	"github.com/spf13/afero"
+)
+
+// HTTPClientConfig defines how outbound traffic reaches a CA or a DNS provider API (private CA, egress proxy, mTLS).
+type HTTPClientConfig struct {
+	CABundle string          `mapstructure:"ca_bundle" yaml:"ca_bundle"`
+	Proxy    string          `mapstructure:"proxy" yaml:"proxy" validate:"omitempty,url"`
+	TLS      ClientTLSConfig `mapstructure:"tls" yaml:"tls"`
+}
+
+type ClientTLSConfig struct {
+	InsecureSkipVerify bool   `mapstructure:"insecure_skip_verify" yaml:"insecure_skip_verify"`
+	CertPath           string `mapstructure:"cert_path" yaml:"cert_path" validate:"required_with=KeyPath"`
+	KeyPath            string `mapstructure:"key_path" yaml:"key_path" validate:"required_with=CertPath"`
+}
+
+// IsDefined returns true when at least one option differs from the default http client.
+func (c HTTPClientConfig) IsDefined() bool {
+	return c != HTTPClientConfig{}
+}
+
+// CreateHTTPClient creates a http client, ca_bundle roots are trusted in addition to system roots.
+func (c HTTPClientConfig) CreateHTTPClient(fs afero.Fs, timeout time.Duration) (*http.Client, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: c.TLS.InsecureSkipVerify}
+
+	if c.CABundle != "" {
+		bundle, err := afero.ReadFile(fs, c.CABundle)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read ca bundle: %v", err)
+		}
+		tlsConfig.RootCAs, err = types.NewCertPool(bundle)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load ca bundle %s: %v", c.CABundle, err)
+		}
+	}
+
+	if c.TLS.CertPath != "" {
+		certPEM, err := afero.ReadFile(fs, c.TLS.CertPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read client certificate: %v", err)
+		}
+		keyPEM, err := afero.ReadFile(fs, c.TLS.KeyPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read client key: %v", err)
+		}
+		clientCert, err := tls.X509KeyPair(certPEM, keyPEM)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %v", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{clientCert}
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	if c.Proxy != "" {
+		proxyURL, err := url.Parse(c.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse proxy: %v", err)
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	return &http.Client{Transport: transport, Timeout: timeout}, nil
+}
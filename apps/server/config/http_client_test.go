@@ -0,0 +1,120 @@
+package config
+
+import (
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/alexandreh2ag/lets-go-tls/internal/testutil"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHTTPClientConfig_IsDefined(t *testing.T) {
+	assert.False(t, HTTPClientConfig{}.IsDefined())
+	assert.True(t, HTTPClientConfig{Proxy: "http://proxy:3128"}.IsDefined())
+	assert.True(t, HTTPClientConfig{TLS: ClientTLSConfig{InsecureSkipVerify: true}}.IsDefined())
+}
+
+func TestHTTPClientConfig_CreateHTTPClient_SuccessWithCABundle(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	fs := afero.NewMemMapFs()
+	bundle := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	_ = afero.WriteFile(fs, "/ca.pem", bundle, 0644)
+
+	client, err := HTTPClientConfig{CABundle: "/ca.pem"}.CreateHTTPClient(fs, time.Second)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Second, client.Timeout)
+	resp, errGet := client.Get(server.URL)
+	assert.NoError(t, errGet)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestHTTPClientConfig_CreateHTTPClient(t *testing.T) {
+	now := time.Now()
+	ca := testutil.NewCertificateAuthority(t, now.Add(-time.Hour), now.Add(time.Hour))
+	cert, key := ca.Issue(t, []string{"client.example.com"}, now.Add(-time.Hour), now.Add(time.Hour))
+	fs := afero.NewMemMapFs()
+	_ = afero.WriteFile(fs, "/ca.pem", ca.PEM, 0644)
+	_ = afero.WriteFile(fs, "/wrong.pem", []byte("wrong"), 0644)
+	_ = afero.WriteFile(fs, "/client.crt", cert, 0644)
+	_ = afero.WriteFile(fs, "/client.key", key, 0644)
+
+	tests := []struct {
+		name      string
+		cfg       HTTPClientConfig
+		checkFunc func(t *testing.T, transport *http.Transport)
+		wantErr   string
+	}{
+		{
+			name: "SuccessDefault",
+			checkFunc: func(t *testing.T, transport *http.Transport) {
+				assert.Nil(t, transport.TLSClientConfig.RootCAs)
+				assert.False(t, transport.TLSClientConfig.InsecureSkipVerify)
+			},
+		},
+		{
+			name: "SuccessFull",
+			cfg: HTTPClientConfig{
+				CABundle: "/ca.pem",
+				Proxy:    "http://proxy.internal:3128",
+				TLS:      ClientTLSConfig{InsecureSkipVerify: true, CertPath: "/client.crt", KeyPath: "/client.key"},
+			},
+			checkFunc: func(t *testing.T, transport *http.Transport) {
+				assert.NotNil(t, transport.TLSClientConfig.RootCAs)
+				assert.True(t, transport.TLSClientConfig.InsecureSkipVerify)
+				assert.Len(t, transport.TLSClientConfig.Certificates, 1)
+				proxyURL, err := transport.Proxy(&http.Request{URL: &url.URL{Scheme: "https", Host: "acme.example.com"}})
+				assert.NoError(t, err)
+				assert.Equal(t, "proxy.internal:3128", proxyURL.Host)
+			},
+		},
+		{
+			name:    "FailReadCABundle",
+			cfg:     HTTPClientConfig{CABundle: "/missing.pem"},
+			wantErr: "failed to read ca bundle",
+		},
+		{
+			name:    "FailLoadCABundle",
+			cfg:     HTTPClientConfig{CABundle: "/wrong.pem"},
+			wantErr: "failed to load ca bundle /wrong.pem: failed to find any certificate in bundle",
+		},
+		{
+			name:    "FailReadClientCertificate",
+			cfg:     HTTPClientConfig{TLS: ClientTLSConfig{CertPath: "/missing.crt", KeyPath: "/client.key"}},
+			wantErr: "failed to read client certificate",
+		},
+		{
+			name:    "FailReadClientKey",
+			cfg:     HTTPClientConfig{TLS: ClientTLSConfig{CertPath: "/client.crt", KeyPath: "/missing.key"}},
+			wantErr: "failed to read client key",
+		},
+		{
+			name:    "FailLoadClientCertificate",
+			cfg:     HTTPClientConfig{TLS: ClientTLSConfig{CertPath: "/client.crt", KeyPath: "/wrong.pem"}},
+			wantErr: "failed to load client certificate",
+		},
+		{
+			name:    "FailParseProxy",
+			cfg:     HTTPClientConfig{Proxy: "http://wrong:wrong"},
+			wantErr: "failed to parse proxy",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, err := tt.cfg.CreateHTTPClient(fs, time.Minute)
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				assert.Nil(t, client)
+				return
+			}
+			assert.NoError(t, err)
+			tt.checkFunc(t, client.Transport.(*http.Transport))
+		})
+	}
+}
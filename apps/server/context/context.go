@@ -2,11 +2,13 @@ package context
 
 import (
 	"github.com/alexandreh2ag/lets-go-tls/apps/server/config"
+	"github.com/alexandreh2ag/lets-go-tls/apps/server/lock"
 	"github.com/alexandreh2ag/lets-go-tls/context"
 	"github.com/alexandreh2ag/lets-go-tls/types"
 	"github.com/alexandreh2ag/lets-go-tls/types/storage/state"
 	goCacheLib "github.com/eko/gocache/lib/v4/cache"
 	gocacheStore "github.com/eko/gocache/store/go_cache/v4"
+	"github.com/jonboulle/clockwork"
 	go_cache "github.com/patrickmn/go-cache"
 	"io"
 	"time"
@@ -21,6 +23,7 @@ type ServerContext struct {
 	Requesters   types.Requesters
 	StateStorage state.Storage
 	Cache        types.Cache
+	Locker       types.Locker
 }
 
 func DefaultContext() *ServerContext {
@@ -45,5 +48,6 @@ func TestContext(logBuffer io.Writer) *ServerContext {
 		BaseContext: context.TestContext(logBuffer),
 		Config:      &cfg,
 		Cache:       cacheManager,
+		Locker:      lock.NewMemory(clockwork.NewRealClock()),
 	}
 }
@@ -28,6 +28,7 @@ func TestTestContext(t *testing.T) {
 	got := TestContext(nil)
 	got.BaseContext = want.BaseContext
 	got.Cache = nil
+	got.Locker = nil
 	assert.Equal(t, want, got)
 }
 
@@ -40,5 +41,6 @@ func TestTestContext_WithLogBuffer(t *testing.T) {
 	got := TestContext(io.Discard)
 	got.BaseContext = want.BaseContext
 	got.Cache = nil
+	got.Locker = nil
 	assert.Equal(t, want, got)
 }
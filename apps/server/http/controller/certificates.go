@@ -2,10 +2,14 @@ package controller
 
 import (
 	"fmt"
+	"slices"
+
+	"github.com/alexandreh2ag/lets-go-tls/apps/server/config"
 	"github.com/alexandreh2ag/lets-go-tls/apps/server/context"
 	"github.com/alexandreh2ag/lets-go-tls/apps/server/http/middleware"
 	appHttp "github.com/alexandreh2ag/lets-go-tls/http"
 	"github.com/alexandreh2ag/lets-go-tls/types"
+	"github.com/alexandreh2ag/lets-go-tls/types/storage/state"
 	"github.com/labstack/echo/v4"
 	"net/http"
 )
@@ -29,28 +33,75 @@ func GetCertificatesFromRequests(c echo.Context) error {
 		return c.JSON(http.StatusInternalServerError, response)
 	}
 
-	state, errLoad := ctx.StateStorage.Load()
-	if errLoad != nil {
-		ctx.Logger.Error(
-			fmt.Sprintf(
-				"http request (%s): failed to load state: %v",
-				appHttp.GetApiPrefix(appHttp.ServerApiGetCertificates),
-				errLoad,
-			),
-		)
-		return c.JSON(http.StatusInternalServerError, response)
+	// storages with a domain index find certificates of each request, others load the whole state once
+	// or read it from memory when storage keeps a snapshot
+	finder, _ := ctx.StateStorage.(state.CertificateFinder)
+	var certificates types.Certificates
+	if finder == nil {
+		loadState := ctx.StateStorage.Load
+		if reader, ok := ctx.StateStorage.(state.SnapshotReader); ok {
+			loadState = reader.Snapshot
+		}
+		currentState, errLoad := loadState()
+		if errLoad != nil {
+			ctx.Logger.Error(
+				fmt.Sprintf(
+					"http request (%s): failed to load state: %v",
+					appHttp.GetApiPrefix(appHttp.ServerApiGetCertificates),
+					errLoad,
+				),
+			)
+			return c.JSON(http.StatusInternalServerError, response)
+		}
+		certificates = currentState.Certificates
 	}
 
 	for _, request := range domainsRequests {
+		errNormalize := request.Normalize()
+		if errNormalize != nil {
+			request.Reason = errNormalize.Error()
+			response.Requests.NotFound = append(response.Requests.NotFound, request)
+			ctx.Logger.Warn(
+				fmt.Sprintf(
+					"http request (%s): invalid request %v: %v",
+					appHttp.GetApiPrefix(appHttp.ServerApiGetCertificates),
+					request.Domains,
+					errNormalize,
+				),
+			)
+			continue
+		}
+
 		ctx.Logger.Debug(fmt.Sprintf(
 			"http request (%s): search matching certificate for %v",
 			appHttp.GetApiPrefix(appHttp.ServerApiGetCertificates),
 			request,
 		))
 
-		cert := state.Certificates.Match(request.Domains, true)
-		if cert != nil {
-			response.Certificates = append(response.Certificates, cert)
+		if finder != nil {
+			var errFind error
+			certificates, errFind = finder.FindCertificates(request.Domains)
+			if errFind != nil {
+				ctx.Logger.Error(
+					fmt.Sprintf(
+						"http request (%s): failed to find certificates for %v: %v",
+						appHttp.GetApiPrefix(appHttp.ServerApiGetCertificates),
+						request.Domains,
+						errFind,
+					),
+				)
+				return c.JSON(http.StatusInternalServerError, response)
+			}
+		}
+
+		// with per_domain grouping, a request is served by the certificate of each domain
+		matched := certificates.MatchAll(request.Domains, true, ctx.Config.Grouping == config.GroupingPerDomain, ctx.Config.PrivateSuffixes)
+		if matched != nil {
+			for _, cert := range matched {
+				if !slices.Contains(response.Certificates, cert) {
+					response.Certificates = append(response.Certificates, cert)
+				}
+			}
 			response.Requests.Found = append(response.Requests.Found, request)
 		} else {
 			response.Requests.NotFound = append(response.Requests.NotFound, request)
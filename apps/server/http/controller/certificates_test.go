@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"encoding/json"
 	"errors"
+	"github.com/alexandreh2ag/lets-go-tls/apps/server/config"
 	appCtx "github.com/alexandreh2ag/lets-go-tls/apps/server/context"
 	"github.com/alexandreh2ag/lets-go-tls/apps/server/http/middleware"
 	appHttp "github.com/alexandreh2ag/lets-go-tls/http"
@@ -56,6 +57,42 @@ func TestGetCertificatesFromRequests_Success(t *testing.T) {
 	assert.Equal(t, string(wantJson)+"\n", rec.Body.String())
 }
 
+func TestGetCertificatesFromRequests_SuccessPerDomain(t *testing.T) {
+	ctx := appCtx.TestContext(nil)
+	ctx.Config.Grouping = config.GroupingPerDomain
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	response := appHttp.ResponseCertificatesFromRequests{}
+	both := &types.Certificate{Identifier: "both", Main: "foo.com", Domains: types.Domains{"foo.com", "bar.com"}, ExpirationDate: time.Now(), Certificate: []byte("cert"), Key: []byte("key")}
+	cert1 := &types.Certificate{Identifier: "foo", Main: "foo.com", Domains: types.Domains{"foo.com"}, ExpirationDate: time.Now(), Certificate: []byte("cert"), Key: []byte("key")}
+	cert2 := &types.Certificate{Identifier: "bar", Main: "bar.com", Domains: types.Domains{"bar.com"}, ExpirationDate: time.Now(), Certificate: []byte("cert"), Key: []byte("key")}
+	state := &types.State{Certificates: types.Certificates{both, cert1, cert2}}
+
+	request1 := types.DomainRequest{Domains: types.Domains{"foo.com", "bar.com"}}
+	request2 := types.DomainRequest{Domains: types.Domains{"foo.com"}}
+
+	response.Certificates = types.Certificates{cert1, cert2}
+	response.Requests.Found = []*types.DomainRequest{&request1, &request2}
+	response.Requests.NotFound = []*types.DomainRequest{}
+	wantJson, _ := json.Marshal(response)
+	stateStorage := mockTypesStorageState.NewMockStorage(ctrl)
+	stateStorage.EXPECT().Load().Times(1).Return(state, nil)
+	ctx.StateStorage = stateStorage
+	e := echo.New()
+	jsonBody, _ := json.Marshal([]types.DomainRequest{request1, request2})
+	req := httptest.NewRequest(http.MethodGet, "/", bytes.NewReader(jsonBody))
+	req.Header.Add("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	c.Set(middleware.ContextKey, ctx)
+
+	err := GetCertificatesFromRequests(c)
+
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, string(wantJson)+"\n", rec.Body.String())
+}
+
 func TestGetCertificatesFromRequests_SuccessWithInvalidCertificate(t *testing.T) {
 	ctx := appCtx.TestContext(nil)
 	ctrl := gomock.NewController(t)
@@ -91,6 +128,39 @@ func TestGetCertificatesFromRequests_SuccessWithInvalidCertificate(t *testing.T)
 	assert.Equal(t, string(wantJson)+"\n", rec.Body.String())
 }
 
+func TestGetCertificatesFromRequests_SuccessNormalize(t *testing.T) {
+	ctx := appCtx.TestContext(nil)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	cert1 := &types.Certificate{Identifier: "foo", Main: "foo.com", Domains: types.Domains{types.Domain("foo.com"), types.Domain("xn--bcher-kva.foo.com")}, ExpirationDate: time.Now(), Certificate: []byte("cert"), Key: []byte("key")}
+	state := &types.State{Certificates: types.Certificates{cert1}}
+
+	request1 := types.DomainRequest{Domains: types.Domains{"FOO.com", "Bücher.foo.com"}}
+	request2 := types.DomainRequest{Domains: types.Domains{"foo..com"}}
+
+	response := appHttp.ResponseCertificatesFromRequests{}
+	response.Certificates = types.Certificates{cert1}
+	response.Requests.Found = []*types.DomainRequest{{Domains: types.Domains{"foo.com", "xn--bcher-kva.foo.com"}}}
+	response.Requests.NotFound = []*types.DomainRequest{{Domains: types.Domains{"foo..com"}, Reason: "invalid hostname foo..com: idna: invalid label \"foo..com\""}}
+	wantJson, _ := json.Marshal(response)
+	stateStorage := mockTypesStorageState.NewMockStorage(ctrl)
+	stateStorage.EXPECT().Load().Times(1).Return(state, nil)
+	ctx.StateStorage = stateStorage
+	e := echo.New()
+	jsonBody, _ := json.Marshal([]types.DomainRequest{request1, request2})
+	req := httptest.NewRequest(http.MethodGet, "/", bytes.NewReader(jsonBody))
+	req.Header.Add("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	c.Set(middleware.ContextKey, ctx)
+
+	err := GetCertificatesFromRequests(c)
+
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, string(wantJson)+"\n", rec.Body.String())
+}
+
 func TestGetCertificatesFromRequests_Fail_ParseBody(t *testing.T) {
 	ctx := appCtx.TestContext(nil)
 
@@ -129,3 +199,124 @@ func TestGetCertificatesFromRequests_Fail_LoadState(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, http.StatusInternalServerError, rec.Code)
 }
+
+type finderStorage struct {
+	*mockTypesStorageState.MockStorage
+	*mockTypesStorageState.MockCertificateFinder
+}
+
+func TestGetCertificatesFromRequests_SuccessWithFinder(t *testing.T) {
+	ctx := appCtx.TestContext(nil)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	response := appHttp.ResponseCertificatesFromRequests{}
+	cert1 := &types.Certificate{Identifier: "foo", Main: "foo.com", Domains: types.Domains{types.Domain("foo.com")}, ExpirationDate: time.Now(), Certificate: []byte("cert"), Key: []byte("key")}
+
+	request1 := types.DomainRequest{Domains: types.Domains{"foo.com"}}
+	request2 := types.DomainRequest{Domains: types.Domains{"bar.foo.com"}}
+
+	response.Certificates = types.Certificates{cert1}
+	response.Requests.Found = []*types.DomainRequest{&request1}
+	response.Requests.NotFound = []*types.DomainRequest{&request2}
+	wantJson, _ := json.Marshal(response)
+	stateStorage := finderStorage{mockTypesStorageState.NewMockStorage(ctrl), mockTypesStorageState.NewMockCertificateFinder(ctrl)}
+	stateStorage.MockStorage.EXPECT().Load().Times(0)
+	stateStorage.MockCertificateFinder.EXPECT().FindCertificates(types.Domains{"foo.com"}).Times(1).Return(types.Certificates{cert1}, nil)
+	stateStorage.MockCertificateFinder.EXPECT().FindCertificates(types.Domains{"bar.foo.com"}).Times(1).Return(types.Certificates{}, nil)
+	ctx.StateStorage = stateStorage
+	e := echo.New()
+	jsonBody, _ := json.Marshal([]types.DomainRequest{request1, request2})
+	req := httptest.NewRequest(http.MethodGet, "/", bytes.NewReader(jsonBody))
+	req.Header.Add("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	c.Set(middleware.ContextKey, ctx)
+
+	err := GetCertificatesFromRequests(c)
+
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, string(wantJson)+"\n", rec.Body.String())
+}
+
+func TestGetCertificatesFromRequests_Fail_FindCertificates(t *testing.T) {
+	ctx := appCtx.TestContext(nil)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	stateStorage := finderStorage{mockTypesStorageState.NewMockStorage(ctrl), mockTypesStorageState.NewMockCertificateFinder(ctrl)}
+	stateStorage.MockCertificateFinder.EXPECT().FindCertificates(gomock.Any()).Times(1).Return(nil, errors.New("fail"))
+	ctx.StateStorage = stateStorage
+	e := echo.New()
+	jsonBody, _ := json.Marshal([]types.DomainRequest{{Domains: types.Domains{"foo.com"}}})
+	req := httptest.NewRequest(http.MethodGet, "/", bytes.NewReader(jsonBody))
+	req.Header.Add("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	c.Set(middleware.ContextKey, ctx)
+
+	err := GetCertificatesFromRequests(c)
+
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+}
+
+type snapshotStorage struct {
+	*mockTypesStorageState.MockStorage
+	*mockTypesStorageState.MockSnapshotReader
+}
+
+func TestGetCertificatesFromRequests_SuccessWithSnapshot(t *testing.T) {
+	ctx := appCtx.TestContext(nil)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	response := appHttp.ResponseCertificatesFromRequests{}
+	cert1 := &types.Certificate{Identifier: "foo", Main: "foo.com", Domains: types.Domains{types.Domain("foo.com")}, ExpirationDate: time.Now(), Certificate: []byte("cert"), Key: []byte("key")}
+
+	request1 := types.DomainRequest{Domains: types.Domains{"foo.com"}}
+	request2 := types.DomainRequest{Domains: types.Domains{"bar.foo.com"}}
+
+	response.Certificates = types.Certificates{cert1}
+	response.Requests.Found = []*types.DomainRequest{&request1}
+	response.Requests.NotFound = []*types.DomainRequest{&request2}
+	wantJson, _ := json.Marshal(response)
+	stateStorage := snapshotStorage{mockTypesStorageState.NewMockStorage(ctrl), mockTypesStorageState.NewMockSnapshotReader(ctrl)}
+	stateStorage.MockStorage.EXPECT().Load().Times(0)
+	stateStorage.MockSnapshotReader.EXPECT().Snapshot().Times(1).Return(&types.State{Certificates: types.Certificates{cert1}}, nil)
+	ctx.StateStorage = stateStorage
+	e := echo.New()
+	jsonBody, _ := json.Marshal([]types.DomainRequest{request1, request2})
+	req := httptest.NewRequest(http.MethodGet, "/", bytes.NewReader(jsonBody))
+	req.Header.Add("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	c.Set(middleware.ContextKey, ctx)
+
+	err := GetCertificatesFromRequests(c)
+
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, string(wantJson)+"\n", rec.Body.String())
+}
+
+func TestGetCertificatesFromRequests_Fail_Snapshot(t *testing.T) {
+	ctx := appCtx.TestContext(nil)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	stateStorage := snapshotStorage{mockTypesStorageState.NewMockStorage(ctrl), mockTypesStorageState.NewMockSnapshotReader(ctrl)}
+	stateStorage.MockSnapshotReader.EXPECT().Snapshot().Times(1).Return(nil, errors.New("fail"))
+	ctx.StateStorage = stateStorage
+	e := echo.New()
+	jsonBody, _ := json.Marshal([]types.DomainRequest{{Domains: types.Domains{"foo.com"}}})
+	req := httptest.NewRequest(http.MethodGet, "/", bytes.NewReader(jsonBody))
+	req.Header.Add("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	c.Set(middleware.ContextKey, ctx)
+
+	err := GetCertificatesFromRequests(c)
+
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+}
@@ -0,0 +1,18 @@
+package lock
+
+import (
+	"fmt"
+	"github.com/alexandreh2ag/lets-go-tls/apps/server/config"
+	"github.com/alexandreh2ag/lets-go-tls/types"
+)
+
+var TypeLock = map[string]CreateLockFn{}
+
+type CreateLockFn func(cfg config.LockConfig) (types.Locker, error)
+
+func CreateLocker(cfg config.LockConfig) (types.Locker, error) {
+	if fn, ok := TypeLock[cfg.Type]; ok {
+		return fn(cfg)
+	}
+	return nil, fmt.Errorf("config lock type '%s' does not exist", cfg.Type)
+}
@@ -0,0 +1,22 @@
+package lock
+
+import (
+	"github.com/alexandreh2ag/lets-go-tls/apps/server/config"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestCreateLocker_Success(t *testing.T) {
+	cfg := config.LockConfig{Type: memoryKey, Config: map[string]interface{}{}}
+	got, err := CreateLocker(cfg)
+	assert.NoError(t, err)
+	assert.NotNil(t, got)
+}
+
+func TestCreateLocker_Fail(t *testing.T) {
+	cfg := config.LockConfig{Type: "wrong", Config: map[string]interface{}{}}
+	got, err := CreateLocker(cfg)
+	assert.Nil(t, got)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "config lock type 'wrong' does not exist")
+}
@@ -0,0 +1,95 @@
+package lock
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/alexandreh2ag/lets-go-tls/apps/server/config"
+	"github.com/alexandreh2ag/lets-go-tls/types"
+	"github.com/jonboulle/clockwork"
+)
+
+const (
+	memoryKey = "memory"
+)
+
+func init() {
+	TypeLock[memoryKey] = createMemoryLocker
+}
+
+var _ types.Locker = &Memory{}
+
+type memoryLock struct {
+	owner     string
+	token     int64
+	expiresAt time.Time
+}
+
+// Memory is a locker only shared inside the current process.
+type Memory struct {
+	mutex  sync.Mutex
+	locks  map[string]memoryLock
+	tokens map[string]int64
+	clock  clockwork.Clock
+}
+
+func NewMemory(clock clockwork.Clock) *Memory {
+	return &Memory{locks: map[string]memoryLock{}, tokens: map[string]int64{}, clock: clock}
+}
+
+func (m *Memory) Acquire(_ context.Context, key, owner string, ttl time.Duration) (*types.Lease, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	now := m.clock.Now()
+	if current, ok := m.locks[key]; ok && now.Before(current.expiresAt) {
+		if current.owner != owner {
+			return nil, nil
+		}
+		current.expiresAt = now.Add(ttl)
+		m.locks[key] = current
+		return &types.Lease{Key: key, Owner: owner, Token: current.token}, nil
+	}
+
+	token := nextToken(m.tokens[key], now)
+	m.tokens[key] = token
+	m.locks[key] = memoryLock{owner: owner, token: token, expiresAt: now.Add(ttl)}
+	return &types.Lease{Key: key, Owner: owner, Token: token}, nil
+}
+
+func (m *Memory) Renew(_ context.Context, lease *types.Lease, ttl time.Duration) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	now := m.clock.Now()
+	current, ok := m.locks[lease.Key]
+	if !ok || current.owner != lease.Owner || !now.Before(current.expiresAt) {
+		return types.ErrLockNotHeld
+	}
+	current.expiresAt = now.Add(ttl)
+	m.locks[lease.Key] = current
+	return nil
+}
+
+func (m *Memory) Release(_ context.Context, lease *types.Lease) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	current, ok := m.locks[lease.Key]
+	if !ok || current.owner != lease.Owner || !m.clock.Now().Before(current.expiresAt) {
+		return types.ErrLockNotHeld
+	}
+	delete(m.locks, lease.Key)
+	return nil
+}
+
+func createMemoryLocker(_ config.LockConfig) (types.Locker, error) {
+	return NewMemory(clockwork.NewRealClock()), nil
+}
+
+// nextToken keeps fencing tokens increasing even when the lock backend is reset (restart, flush),
+// because a state may already be saved with a previous token.
+func nextToken(previous int64, now time.Time) int64 {
+	return max(previous+1, now.UnixMilli())
+}
@@ -0,0 +1,80 @@
+package lock
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alexandreh2ag/lets-go-tls/apps/server/config"
+	"github.com/alexandreh2ag/lets-go-tls/types"
+	"github.com/jonboulle/clockwork"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemory_Acquire(t *testing.T) {
+	fakeNow := time.Date(1970, time.January, 1, 0, 0, 59, 0, time.UTC)
+	clock := clockwork.NewFakeClockAt(fakeNow)
+	locker := NewMemory(clock)
+
+	lease, err := locker.Acquire(context.Background(), "foo", "owner1", time.Minute)
+	assert.NoError(t, err)
+	assert.Equal(t, &types.Lease{Key: "foo", Owner: "owner1", Token: fakeNow.UnixMilli()}, lease)
+
+	got, err := locker.Acquire(context.Background(), "foo", "owner2", time.Minute)
+	assert.NoError(t, err)
+	assert.Nil(t, got)
+
+	got, err = locker.Acquire(context.Background(), "foo", "owner1", time.Minute)
+	assert.NoError(t, err)
+	assert.Equal(t, lease, got)
+
+	clock.Advance(time.Minute * 2)
+	got, err = locker.Acquire(context.Background(), "foo", "owner2", time.Minute)
+	assert.NoError(t, err)
+	assert.Equal(t, "owner2", got.Owner)
+	assert.Greater(t, got.Token, lease.Token)
+}
+
+func TestMemory_Renew(t *testing.T) {
+	clock := clockwork.NewFakeClock()
+	locker := NewMemory(clock)
+	lease, _ := locker.Acquire(context.Background(), "foo", "owner1", time.Minute)
+
+	clock.Advance(time.Second * 50)
+	assert.NoError(t, locker.Renew(context.Background(), lease, time.Minute))
+	clock.Advance(time.Second * 50)
+	got, _ := locker.Acquire(context.Background(), "foo", "owner2", time.Minute)
+	assert.Nil(t, got)
+
+	assert.ErrorIs(t, locker.Renew(context.Background(), &types.Lease{Key: "foo", Owner: "owner2"}, time.Minute), types.ErrLockNotHeld)
+
+	clock.Advance(time.Minute * 2)
+	assert.ErrorIs(t, locker.Renew(context.Background(), lease, time.Minute), types.ErrLockNotHeld)
+}
+
+func TestMemory_Release(t *testing.T) {
+	clock := clockwork.NewFakeClock()
+	locker := NewMemory(clock)
+	lease, _ := locker.Acquire(context.Background(), "foo", "owner1", time.Minute)
+
+	assert.ErrorIs(t, locker.Release(context.Background(), &types.Lease{Key: "foo", Owner: "owner2"}), types.ErrLockNotHeld)
+	assert.NoError(t, locker.Release(context.Background(), lease))
+	assert.ErrorIs(t, locker.Release(context.Background(), lease), types.ErrLockNotHeld)
+
+	got, err := locker.Acquire(context.Background(), "foo", "owner2", time.Minute)
+	assert.NoError(t, err)
+	assert.NotNil(t, got)
+	assert.Greater(t, got.Token, lease.Token)
+}
+
+func Test_createMemoryLocker(t *testing.T) {
+	got, err := createMemoryLocker(config.LockConfig{Type: memoryKey})
+	assert.NoError(t, err)
+	assert.NotNil(t, got)
+}
+
+func Test_nextToken(t *testing.T) {
+	now := time.Date(1970, time.January, 1, 0, 0, 59, 0, time.UTC)
+	assert.Equal(t, now.UnixMilli(), nextToken(0, now))
+	assert.Equal(t, now.UnixMilli()+11, nextToken(now.UnixMilli()+10, now))
+}
@@ -0,0 +1,142 @@
+package lock
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/alexandreh2ag/lets-go-tls/apps/server/config"
+	"github.com/alexandreh2ag/lets-go-tls/mapstructure"
+	"github.com/alexandreh2ag/lets-go-tls/types"
+	"github.com/go-playground/validator/v10"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	redisKey = "redis"
+)
+
+func init() {
+	TypeLock[redisKey] = createRedisLocker
+}
+
+var (
+	_ types.Locker = &redisLocker{}
+
+	// KEYS[1]: lock key, KEYS[2]: fencing key, ARGV[1]: owner, ARGV[2]: ttl (ms), ARGV[3]: now (unix ms)
+	acquireScript = redis.NewScript(`
+local current = redis.call('GET', KEYS[1])
+if current == ARGV[1] then
+	redis.call('PEXPIRE', KEYS[1], ARGV[2])
+	return tonumber(redis.call('GET', KEYS[2]) or '0')
+end
+if redis.call('SET', KEYS[1], ARGV[1], 'NX', 'PX', ARGV[2]) then
+	local token = math.max(tonumber(redis.call('GET', KEYS[2]) or '0') + 1, tonumber(ARGV[3]))
+	redis.call('SET', KEYS[2], string.format('%d', token))
+	return token
+end
+return 0
+`)
+
+	// KEYS[1]: lock key, ARGV[1]: owner, ARGV[2]: ttl (ms)
+	renewScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('PEXPIRE', KEYS[1], ARGV[2])
+end
+return 0
+`)
+
+	// KEYS[1]: lock key, ARGV[1]: owner
+	releaseScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0
+`)
+)
+
+type redisConfig struct {
+	Address  string `mapstructure:"address" validate:"required"`
+	DB       int    `mapstructure:"db"`
+	Username string `mapstructure:"username"`
+	Password string `mapstructure:"password"`
+}
+
+type redisLocker struct {
+	client  redis.UniversalClient
+	hashTag bool
+}
+
+// lockKeys use a hash tag with redis cluster so both keys are in the same slot,
+// the lock key is kept as is with redis so replicas of previous versions still exclude each other.
+func (r *redisLocker) lockKeys(key string) []string {
+	if r.hashTag {
+		return []string{fmt.Sprintf("{%s}", key), fmt.Sprintf("{%s}:fencing", key)}
+	}
+	return []string{key, fmt.Sprintf("%s:fencing", key)}
+}
+
+func (r *redisLocker) Acquire(ctx context.Context, key, owner string, ttl time.Duration) (*types.Lease, error) {
+	token, err := acquireScript.Run(ctx, r.client, r.lockKeys(key), owner, ttl.Milliseconds(), time.Now().UnixMilli()).Int64()
+	if err != nil {
+		return nil, fmt.Errorf("failed to acquire lock %s: %v", key, err)
+	}
+	if token == 0 {
+		return nil, nil
+	}
+	return &types.Lease{Key: key, Owner: owner, Token: token}, nil
+}
+
+func (r *redisLocker) Renew(ctx context.Context, lease *types.Lease, ttl time.Duration) error {
+	result, err := renewScript.Run(ctx, r.client, r.lockKeys(lease.Key)[:1], lease.Owner, ttl.Milliseconds()).Int64()
+	if err != nil {
+		return fmt.Errorf("failed to renew lock %s: %v", lease.Key, err)
+	}
+	if result == 0 {
+		return types.ErrLockNotHeld
+	}
+	return nil
+}
+
+func (r *redisLocker) Release(ctx context.Context, lease *types.Lease) error {
+	result, err := releaseScript.Run(ctx, r.client, r.lockKeys(lease.Key)[:1], lease.Owner).Int64()
+	if err != nil {
+		return fmt.Errorf("failed to release lock %s: %v", lease.Key, err)
+	}
+	if result == 0 {
+		return types.ErrLockNotHeld
+	}
+	return nil
+}
+
+func createRedisLocker(cfg config.LockConfig) (types.Locker, error) {
+	instanceConfig := redisConfig{}
+	err := mapstructure.Decode(cfg.Config, &instanceConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	validate := validator.New()
+	err = validate.Struct(instanceConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	clientConfig := &redis.Options{
+		Addr: instanceConfig.Address,
+	}
+
+	if instanceConfig.DB != 0 {
+		clientConfig.DB = instanceConfig.DB
+	}
+
+	if instanceConfig.Username != "" {
+		clientConfig.Username = instanceConfig.Username
+	}
+
+	if instanceConfig.Password != "" {
+		clientConfig.Password = instanceConfig.Password
+	}
+
+	return &redisLocker{client: redis.NewClient(clientConfig)}, nil
+}
@@ -0,0 +1,51 @@
+package lock
+
+import (
+	"github.com/alexandreh2ag/lets-go-tls/apps/server/config"
+	"github.com/alexandreh2ag/lets-go-tls/mapstructure"
+	"github.com/alexandreh2ag/lets-go-tls/types"
+	"github.com/go-playground/validator/v10"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	redisClusterKey = "redis-cluster"
+)
+
+func init() {
+	TypeLock[redisClusterKey] = createRedisClusterLocker
+}
+
+type redisClusterConfig struct {
+	Address  []string `mapstructure:"address" validate:"required,min=1"`
+	Username string   `mapstructure:"username"`
+	Password string   `mapstructure:"password"`
+}
+
+func createRedisClusterLocker(cfg config.LockConfig) (types.Locker, error) {
+	instanceConfig := redisClusterConfig{}
+	err := mapstructure.Decode(cfg.Config, &instanceConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	validate := validator.New()
+	err = validate.Struct(instanceConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	clientConfig := &redis.ClusterOptions{
+		Addrs: instanceConfig.Address,
+	}
+
+	if instanceConfig.Username != "" {
+		clientConfig.Username = instanceConfig.Username
+	}
+
+	if instanceConfig.Password != "" {
+		clientConfig.Password = instanceConfig.Password
+	}
+
+	return &redisLocker{client: redis.NewClusterClient(clientConfig), hashTag: true}, nil
+}
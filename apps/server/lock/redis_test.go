@@ -0,0 +1,147 @@
+package lock
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alexandreh2ag/lets-go-tls/apps/server/config"
+	"github.com/alexandreh2ag/lets-go-tls/types"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+func createTestRedisLocker(t *testing.T) (*miniredis.Miniredis, *redisLocker) {
+	server := miniredis.RunT(t)
+	return server, &redisLocker{client: redis.NewClient(&redis.Options{Addr: server.Addr()})}
+}
+
+func Test_redisLocker_Acquire(t *testing.T) {
+	server, locker := createTestRedisLocker(t)
+
+	lease, err := locker.Acquire(context.Background(), "foo", "owner1", time.Minute)
+	assert.NoError(t, err)
+	assert.Equal(t, "foo", lease.Key)
+	assert.Equal(t, "owner1", lease.Owner)
+	assert.Greater(t, lease.Token, int64(0))
+
+	got, err := locker.Acquire(context.Background(), "foo", "owner2", time.Minute)
+	assert.NoError(t, err)
+	assert.Nil(t, got)
+
+	got, err = locker.Acquire(context.Background(), "foo", "owner1", time.Minute)
+	assert.NoError(t, err)
+	assert.Equal(t, lease, got)
+
+	server.FastForward(time.Minute * 2)
+	got, err = locker.Acquire(context.Background(), "foo", "owner2", time.Minute)
+	assert.NoError(t, err)
+	assert.Equal(t, "owner2", got.Owner)
+	assert.Greater(t, got.Token, lease.Token)
+}
+
+func Test_redisLocker_Acquire_Keys(t *testing.T) {
+	server, locker := createTestRedisLocker(t)
+
+	// a replica of a previous version holds the lock with the same key
+	_ = server.Set("foo", "legacy")
+	got, err := locker.Acquire(context.Background(), "foo", "owner1", time.Minute)
+	assert.NoError(t, err)
+	assert.Nil(t, got)
+
+	server.Del("foo")
+	_, err = locker.Acquire(context.Background(), "foo", "owner1", time.Minute)
+	assert.NoError(t, err)
+	assert.True(t, server.Exists("foo"))
+	assert.True(t, server.Exists("foo:fencing"))
+
+	locker.hashTag = true
+	_, err = locker.Acquire(context.Background(), "bar", "owner1", time.Minute)
+	assert.NoError(t, err)
+	assert.True(t, server.Exists("{bar}"))
+	assert.True(t, server.Exists("{bar}:fencing"))
+}
+
+func Test_redisLocker_AcquireFail(t *testing.T) {
+	server, locker := createTestRedisLocker(t)
+	server.Close()
+
+	got, err := locker.Acquire(context.Background(), "foo", "owner1", time.Minute)
+	assert.Nil(t, got)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to acquire lock foo")
+}
+
+func Test_redisLocker_Renew(t *testing.T) {
+	server, locker := createTestRedisLocker(t)
+	lease, _ := locker.Acquire(context.Background(), "foo", "owner1", time.Minute)
+
+	server.FastForward(time.Second * 50)
+	assert.NoError(t, locker.Renew(context.Background(), lease, time.Minute))
+	server.FastForward(time.Second * 50)
+	got, _ := locker.Acquire(context.Background(), "foo", "owner2", time.Minute)
+	assert.Nil(t, got)
+
+	assert.ErrorIs(t, locker.Renew(context.Background(), &types.Lease{Key: "foo", Owner: "owner2"}, time.Minute), types.ErrLockNotHeld)
+
+	server.FastForward(time.Minute * 2)
+	assert.ErrorIs(t, locker.Renew(context.Background(), lease, time.Minute), types.ErrLockNotHeld)
+}
+
+func Test_redisLocker_Release(t *testing.T) {
+	_, locker := createTestRedisLocker(t)
+	lease, _ := locker.Acquire(context.Background(), "foo", "owner1", time.Minute)
+
+	assert.ErrorIs(t, locker.Release(context.Background(), &types.Lease{Key: "foo", Owner: "owner2"}), types.ErrLockNotHeld)
+	assert.NoError(t, locker.Release(context.Background(), lease))
+	assert.ErrorIs(t, locker.Release(context.Background(), lease), types.ErrLockNotHeld)
+
+	got, err := locker.Acquire(context.Background(), "foo", "owner2", time.Minute)
+	assert.NoError(t, err)
+	assert.Greater(t, got.Token, lease.Token)
+}
+
+func Test_createRedisLocker(t *testing.T) {
+	tests := []struct {
+		name        string
+		cfg         config.LockConfig
+		wantErr     bool
+		errContains string
+	}{
+		{
+			name: "Success",
+			cfg:  config.LockConfig{Type: "redis", Config: map[string]interface{}{"address": "127.0.0.1:6379"}},
+		},
+		{
+			name: "SuccessWithConfig",
+			cfg:  config.LockConfig{Type: "redis", Config: map[string]interface{}{"address": "127.0.0.1:6379", "db": 2, "username": "user", "password": "pass"}},
+		},
+		{
+			name:        "FailDecodeCfg",
+			cfg:         config.LockConfig{Type: "redis", Config: map[string]interface{}{"address": []string{}}},
+			wantErr:     true,
+			errContains: "'address' expected type 'string', got unconvertible type '[]string",
+		},
+		{
+			name:        "FailValidateCfg",
+			cfg:         config.LockConfig{Type: "redis", Config: map[string]interface{}{"address": ""}},
+			wantErr:     true,
+			errContains: "Key: 'redisConfig.Address' Error:Field validation for 'Address' failed on the 'required' tag",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := createRedisLocker(tt.cfg)
+
+			if tt.wantErr {
+				assert.Nil(t, got)
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), tt.errContains)
+			} else {
+				assert.NoError(t, err)
+				assert.NotNil(t, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,78 @@
+package manager
+
+import (
+	"fmt"
+	"slices"
+	"time"
+
+	appCtx "github.com/alexandreh2ag/lets-go-tls/apps/server/context"
+	"github.com/alexandreh2ag/lets-go-tls/types"
+)
+
+// syncDeclaredCertificates creates or updates certificates declared in config,
+// certificates removed from config are managed like certificates created from requests.
+func (cm *CertifierManager) syncDeclaredCertificates(ctx *appCtx.ServerContext, state *types.State) {
+	declared := map[string]bool{}
+	for _, cfg := range ctx.Config.Certificates {
+		declared[cfg.Identifier] = true
+		domains := slices.Clone(cfg.Domains)
+		cert := state.Certificates.GetCertificate(cfg.Identifier)
+		if cert == nil {
+			cert = &types.Certificate{Identifier: cfg.Identifier, Domains: domains}
+			ctx.Logger.Info(fmt.Sprintf("create declared certificate %s (%v)", cert.Identifier, cert.Domains))
+			state.Certificates = append(state.Certificates, cert)
+			ctx.GetMetricsRegister().RegisterNewCertificateMetrics(cert)
+		} else {
+			updateDeclaredDomains(ctx, cert, domains)
+		}
+		cert.Main = string(domains[0])
+		cert.Declared = true
+		cert.Resolver = cfg.Resolver
+		cert.KeyType = cfg.KeyType
+		cert.Retention = cfg.Retention
+		cert.UnusedAt = time.Time{}
+	}
+
+	for _, cert := range state.Certificates {
+		if cert.Declared && !declared[cert.Identifier] {
+			ctx.Logger.Info(fmt.Sprintf("certificate %s is no longer declared", cert.Identifier))
+			cert.Declared = false
+			cert.Resolver = ""
+			cert.KeyType = ""
+		}
+	}
+}
+
+// updateDeclaredDomains applies domains of config on a declared certificate. Added domains are pending and removed
+// domains are kept until reissue, so the current certificate is still distributed.
+func updateDeclaredDomains(ctx *appCtx.ServerContext, cert *types.Certificate, domains types.Domains) {
+	// parked domains are retried after delay_failed unless they are removed from config
+	cert.ParkedDomains = slices.DeleteFunc(cert.ParkedDomains, func(domain types.Domain) bool {
+		return !domains.Contains(domain)
+	})
+	if len(cert.ParkedDomains) == 0 {
+		cert.ParkedDomains = nil
+		cert.ParkedDate = time.Time{}
+	}
+
+	var added, removed types.Domains
+	for _, domain := range domains {
+		if !cert.Domains.Contains(domain) && !cert.ParkedDomains.Contains(domain) {
+			added = append(added, domain)
+		}
+	}
+	for _, domain := range cert.Domains {
+		if !domains.Contains(domain) {
+			removed = append(removed, domain)
+		}
+	}
+
+	if len(added) > 0 && !slices.Equal(cert.PendingDomains, added) {
+		ctx.Logger.Info(fmt.Sprintf("extend declared certificate %s with %v", cert.Identifier, added))
+	}
+	if len(removed) > 0 && !slices.Equal(cert.RemovedDomains, removed) {
+		ctx.Logger.Info(fmt.Sprintf("remove %v from declared certificate %s, it will be reissued", removed, cert.Identifier))
+	}
+	cert.PendingDomains = added
+	cert.RemovedDomains = removed
+}
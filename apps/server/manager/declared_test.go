@@ -0,0 +1,161 @@
+package manager
+
+import (
+	"crypto/ecdsa"
+	"testing"
+	"time"
+
+	"github.com/alexandreh2ag/lets-go-tls/apps/server/config"
+	appCtx "github.com/alexandreh2ag/lets-go-tls/apps/server/context"
+	mockTypes "github.com/alexandreh2ag/lets-go-tls/mocks/types"
+	appProm "github.com/alexandreh2ag/lets-go-tls/prometheus"
+	"github.com/alexandreh2ag/lets-go-tls/types"
+	typesAcme "github.com/alexandreh2ag/lets-go-tls/types/acme"
+	"github.com/go-acme/lego/v4/certcrypto"
+	"github.com/go-acme/lego/v4/certificate"
+	"github.com/jonboulle/clockwork"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/mock/gomock"
+)
+
+func TestCertifierManager_syncDeclaredCertificates(t *testing.T) {
+	now := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name         string
+		declared     []config.CertificateConfig
+		certificates types.Certificates
+		want         types.Certificates
+	}{
+		{
+			name:         "SuccessCreate",
+			declared:     []config.CertificateConfig{{Identifier: "www", Domains: types.Domains{"example.com", "www.example.com"}, Resolver: "ovh", KeyType: "ec256", Retention: types.RetentionNever}},
+			certificates: types.Certificates{},
+			want: types.Certificates{
+				{Identifier: "www", Main: "example.com", Domains: types.Domains{"example.com", "www.example.com"}, Declared: true, Resolver: "ovh", KeyType: "ec256", Retention: types.RetentionNever},
+			},
+		},
+		{
+			name:         "SuccessUpdateExisting",
+			declared:     []config.CertificateConfig{{Identifier: "www", Domains: types.Domains{"example.com"}, Resolver: "ovh"}},
+			certificates: types.Certificates{{Identifier: "www", Main: "example.com", Domains: types.Domains{"example.com"}, Key: []byte("key"), Certificate: []byte("cert"), UnusedAt: now}},
+			want: types.Certificates{
+				{Identifier: "www", Main: "example.com", Domains: types.Domains{"example.com"}, Key: []byte("key"), Certificate: []byte("cert"), Declared: true, Resolver: "ovh"},
+			},
+		},
+		{
+			name:         "SuccessAddDomains",
+			declared:     []config.CertificateConfig{{Identifier: "www", Domains: types.Domains{"example.com", "www.example.com"}}},
+			certificates: types.Certificates{{Identifier: "www", Main: "example.com", Domains: types.Domains{"example.com"}, Key: []byte("key"), Certificate: []byte("cert"), Declared: true}},
+			want: types.Certificates{
+				{Identifier: "www", Main: "example.com", Domains: types.Domains{"example.com"}, PendingDomains: types.Domains{"www.example.com"}, Key: []byte("key"), Certificate: []byte("cert"), Declared: true},
+			},
+		},
+		{
+			name:     "SuccessKeepParkedDomains",
+			declared: []config.CertificateConfig{{Identifier: "www", Domains: types.Domains{"example.com", "www.example.com", "api.example.com"}}},
+			certificates: types.Certificates{{
+				Identifier: "www", Main: "example.com", Domains: types.Domains{"example.com"}, ParkedDomains: types.Domains{"www.example.com", "old.example.com"}, ParkedDate: now,
+				Key: []byte("key"), Certificate: []byte("cert"), Declared: true,
+			}},
+			want: types.Certificates{{
+				Identifier: "www", Main: "example.com", Domains: types.Domains{"example.com"}, PendingDomains: types.Domains{"api.example.com"}, ParkedDomains: types.Domains{"www.example.com"}, ParkedDate: now,
+				Key: []byte("key"), Certificate: []byte("cert"), Declared: true,
+			}},
+		},
+		{
+			name:         "SuccessRemoveDomains",
+			declared:     []config.CertificateConfig{{Identifier: "www", Domains: types.Domains{"www.example.com"}}},
+			certificates: types.Certificates{{Identifier: "www", Main: "example.com", Domains: types.Domains{"example.com", "www.example.com"}, Key: []byte("key"), Certificate: []byte("cert"), Declared: true}},
+			want: types.Certificates{
+				{Identifier: "www", Main: "www.example.com", Domains: types.Domains{"example.com", "www.example.com"}, RemovedDomains: types.Domains{"example.com"}, Key: []byte("key"), Certificate: []byte("cert"), Declared: true},
+			},
+		},
+		{
+			name:         "SuccessReplaceDomains",
+			declared:     []config.CertificateConfig{{Identifier: "www", Domains: types.Domains{"www.example.com", "shop.example.com"}}},
+			certificates: types.Certificates{{Identifier: "www", Main: "example.com", Domains: types.Domains{"example.com", "www.example.com"}, Key: []byte("key"), Certificate: []byte("cert"), Declared: true}},
+			want: types.Certificates{
+				{Identifier: "www", Main: "www.example.com", Domains: types.Domains{"example.com", "www.example.com"}, PendingDomains: types.Domains{"shop.example.com"}, RemovedDomains: types.Domains{"example.com"}, Key: []byte("key"), Certificate: []byte("cert"), Declared: true},
+			},
+		},
+		{
+			name:         "SuccessNoLongerDeclared",
+			certificates: types.Certificates{{Identifier: "www", Main: "example.com", Domains: types.Domains{"example.com"}, Declared: true, Resolver: "ovh", KeyType: "ec256", Retention: types.RetentionNever}},
+			want: types.Certificates{
+				{Identifier: "www", Main: "example.com", Domains: types.Domains{"example.com"}, Retention: types.RetentionNever},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := appCtx.TestContext(nil)
+			ctx.MetricsRegister = appProm.NewRegistry(types.NameServerMetrics, prometheus.NewRegistry())
+			ctx.Config.Certificates = tt.declared
+			cm := &CertifierManager{}
+			state := &types.State{Certificates: tt.certificates}
+			cm.syncDeclaredCertificates(ctx, state)
+			assert.Equal(t, tt.want, state.Certificates)
+		})
+	}
+}
+
+func TestCertifierManager_MatchingRequests_SuccessDeclaredInPreference(t *testing.T) {
+	ctx := appCtx.TestContext(nil)
+	ctx.MetricsRegister = appProm.NewRegistry(types.NameServerMetrics, prometheus.NewRegistry())
+	ctx.Config.SANMerge = true
+	ctx.Config.Certificates = []config.CertificateConfig{{Identifier: "www", Domains: types.Domains{"example.com", "www.example.com"}}}
+	cm := &CertifierManager{}
+	state := &types.State{Certificates: types.Certificates{}}
+
+	cm.MatchingRequests(ctx, state, []*types.DomainRequest{
+		{Domains: types.Domains{"www.example.com"}},
+		{Domains: types.Domains{"example.com", "foo.example.com"}},
+	})
+	assert.Equal(
+		t,
+		types.Certificates{
+			{Identifier: "www", Main: "example.com", Domains: types.Domains{"example.com", "www.example.com"}, Declared: true},
+			{Identifier: "example.com-0", Main: "example.com", Domains: types.Domains{"example.com", "foo.example.com"}},
+		},
+		state.Certificates,
+	)
+}
+
+func TestCertifierManager_ObtainCertificate_SuccessRenewWithDeclaredKeyType(t *testing.T) {
+	ctx := appCtx.TestContext(nil)
+	now := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+	resource := newCertificateResource(t, ctx, now, "example.com")
+	ctrl := gomock.NewController(t)
+	resolver := mockTypes.NewMockResolver(ctrl)
+	resolver.EXPECT().ID().AnyTimes().Return(types.DefaultKey)
+	resolver.EXPECT().TypeChallenge().AnyTimes().Return(typesAcme.TypeHTTP01)
+	resolver.EXPECT().RenewWithOptions(gomock.Any(), gomock.Any()).Times(1).DoAndReturn(func(certRes certificate.Resource, _ *certificate.RenewOptions) (*certificate.Resource, error) {
+		privateKey, err := certcrypto.ParsePEMPrivateKey(certRes.PrivateKey)
+		assert.NoError(t, err)
+		assert.IsType(t, &ecdsa.PrivateKey{}, privateKey)
+		return resource, nil
+	})
+	// renewal is not validated on staging
+	ctx.Config.Acme.Staging.Enable = true
+	stagingResolver := mockTypes.NewMockResolver(ctrl)
+	cm := &CertifierManager{
+		resolvers:        types.Resolvers{types.DefaultKey: resolver},
+		stagingResolvers: types.Resolvers{types.DefaultKey: stagingResolver},
+		clock:            clockwork.NewFakeClockAt(now),
+	}
+	cert := &types.Certificate{
+		Identifier:     "www",
+		Main:           "example.com",
+		Domains:        types.Domains{"example.com"},
+		Key:            []byte("old"),
+		Certificate:    []byte("old"),
+		ExpirationDate: time.Now().Add(time.Hour),
+		Declared:       true,
+		KeyType:        "ec256",
+	}
+
+	err := cm.ObtainCertificate(ctx, cert)
+	assert.NoError(t, err)
+	assert.Equal(t, resource.Certificate, cert.Certificate)
+}
@@ -0,0 +1,139 @@
+package manager
+
+import (
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/alexandreh2ag/lets-go-tls/apps/server/config"
+	appCtx "github.com/alexandreh2ag/lets-go-tls/apps/server/context"
+	"github.com/alexandreh2ag/lets-go-tls/types"
+)
+
+// uncoveredDomains returns domains of request without their own certificate with per_domain grouping,
+// a domain is covered by a declared certificate or a certificate with exactly this domain.
+func (cm *CertifierManager) uncoveredDomains(ctx *appCtx.ServerContext, state *types.State, request *types.DomainRequest) types.Domains {
+	uncovered := types.Domains{}
+	for _, domain := range request.Domains {
+		cert := state.Certificates.Match(types.Domains{domain}, false, ctx.Config.PrivateSuffixes)
+		if cert == nil || !(cert.Declared || cert.Domains.Equal(types.Domains{domain})) {
+			uncovered = append(uncovered, domain)
+		}
+	}
+	return uncovered
+}
+
+// extendCertificate adds missing domains of request as pending domains of an existing certificate,
+// the certificate keeps its identifier and is reissued with all domains. It returns false when no certificate can be extended.
+func (cm *CertifierManager) extendCertificate(ctx *appCtx.ServerContext, state *types.State, request *types.DomainRequest) bool {
+	if ctx.Config.SANMerge && cm.extendCertificateWith(ctx, state, request, sharesDomain) {
+		return true
+	}
+	if ctx.Config.Grouping == config.GroupingPerRegisteredDomain && cm.extendCertificateWith(ctx, state, request, sameRegisteredDomain) {
+		return true
+	}
+	return false
+}
+
+func (cm *CertifierManager) extendCertificateWith(ctx *appCtx.ServerContext, state *types.State, request *types.DomainRequest, candidate func(cert *types.Certificate, domains types.Domains, suffixes types.Suffixes) bool) bool {
+	suffixes := ctx.Config.PrivateSuffixes
+	for _, cert := range state.Certificates {
+		// domains of declared certificates come only from config
+		if cert.Declared || !candidate(cert, request.Domains, suffixes) {
+			continue
+		}
+
+		missing := types.Domains{}
+		for _, domain := range request.Domains {
+			if !cert.Match(types.Domains{domain}, suffixes) && !cert.PendingDomains.Contains(domain) && !cert.ParkedDomains.Contains(domain) {
+				missing = append(missing, domain)
+			}
+		}
+		if len(missing) == 0 {
+			return true
+		}
+
+		merged := &types.Certificate{Domains: append(cert.RequestedDomains(), missing...)}
+		if len(merged.Domains) > types.MaxDomainsPerCertificate {
+			continue
+		}
+		// domains of a certificate must be solved by the resolver they would use alone
+		if cm.resolvers != nil {
+			resolverID := cm.resolvers.FindResolver(cert).ID()
+			if cm.resolvers.FindResolver(merged).ID() != resolverID ||
+				cm.resolvers.FindResolver(&types.Certificate{Domains: request.Domains}).ID() != resolverID {
+				continue
+			}
+		}
+
+		ctx.Logger.Info(fmt.Sprintf("extend certificate %s with %v", cert.Identifier, missing))
+		cert.PendingDomains = append(cert.PendingDomains, missing...)
+		return true
+	}
+	return false
+}
+
+// resumeParkedDomains makes parked domains pending again once delay_failed is expired.
+func (cm *CertifierManager) resumeParkedDomains(ctx *appCtx.ServerContext, state *types.State) {
+	for _, cert := range state.Certificates {
+		if len(cert.ParkedDomains) == 0 || cm.clock.Now().Before(cert.ParkedDate.Add(ctx.Config.Acme.DelayFailed)) {
+			continue
+		}
+		ctx.Logger.Info(fmt.Sprintf("retry to extend certificate %s with %v", cert.Identifier, cert.ParkedDomains))
+		for _, domain := range cert.ParkedDomains {
+			if !cert.PendingDomains.Contains(domain) {
+				cert.PendingDomains = append(cert.PendingDomains, domain)
+			}
+		}
+		cert.ParkedDomains = nil
+		cert.ParkedDate = time.Time{}
+	}
+}
+
+// PrunePendingDomains removes pending and parked domains which are no longer requested,
+// those of declared certificates come from config.
+func (cm *CertifierManager) PrunePendingDomains(ctx *appCtx.ServerContext, certificates types.Certificates, domainsRequests []*types.DomainRequest) {
+	unrequested := func(domain types.Domain) bool {
+		return !slices.ContainsFunc(domainsRequests, func(request *types.DomainRequest) bool {
+			return request.Domains.Contains(domain)
+		})
+	}
+	for _, cert := range certificates {
+		if cert.Declared {
+			continue
+		}
+		pending := slices.DeleteFunc(slices.Clone(cert.PendingDomains), unrequested)
+		parked := slices.DeleteFunc(slices.Clone(cert.ParkedDomains), unrequested)
+		if len(pending) == len(cert.PendingDomains) && len(parked) == len(cert.ParkedDomains) {
+			continue
+		}
+		ctx.Logger.Info(fmt.Sprintf("remove domains no longer requested from pending domains of certificate %s", cert.Identifier))
+		cert.PendingDomains, cert.ParkedDomains = nil, nil
+		if len(pending) > 0 {
+			cert.PendingDomains = pending
+		}
+		if len(parked) > 0 {
+			cert.ParkedDomains = parked
+		} else {
+			cert.ParkedDate = time.Time{}
+		}
+	}
+}
+
+// sharesDomain returns true when the certificate covers at least one of domains.
+func sharesDomain(cert *types.Certificate, domains types.Domains, suffixes types.Suffixes) bool {
+	return slices.ContainsFunc(domains, func(domain types.Domain) bool {
+		return cert.Match(types.Domains{domain}, suffixes)
+	})
+}
+
+// sameRegisteredDomain returns true when the certificate and domains belong to a single registered domain.
+func sameRegisteredDomain(cert *types.Certificate, domains types.Domains, suffixes types.Suffixes) bool {
+	registered := domains[0].RegisteredDomain(suffixes)
+	for _, domain := range slices.Concat(cert.RequestedDomains(), domains) {
+		if domain.RegisteredDomain(suffixes) != registered {
+			return false
+		}
+	}
+	return true
+}
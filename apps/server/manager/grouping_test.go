@@ -0,0 +1,185 @@
+package manager
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	appAcme "github.com/alexandreh2ag/lets-go-tls/apps/server/acme"
+	"github.com/alexandreh2ag/lets-go-tls/apps/server/config"
+	appCtx "github.com/alexandreh2ag/lets-go-tls/apps/server/context"
+	appProm "github.com/alexandreh2ag/lets-go-tls/prometheus"
+	"github.com/alexandreh2ag/lets-go-tls/types"
+	typesAcme "github.com/alexandreh2ag/lets-go-tls/types/acme"
+	"github.com/jonboulle/clockwork"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCertifierManager_MatchingRequests_Grouping(t *testing.T) {
+	resolvers := types.Resolvers{
+		types.DefaultKey: &appAcme.ResolverOffline{Id: types.DefaultKey, Filters: []string{"*"}, Type: typesAcme.TypeHTTP01},
+		"ovh":            &appAcme.ResolverOffline{Id: "ovh", Filters: []string{"api.example.com"}, Type: "ovh"},
+	}
+	parkedDate := time.Now()
+	manyDomains := types.Domains{}
+	for i := 0; i < types.MaxDomainsPerCertificate; i++ {
+		manyDomains = append(manyDomains, types.Domain(fmt.Sprintf("%d.example.org", i)))
+	}
+
+	tests := []struct {
+		name         string
+		grouping     string
+		sanMerge     bool
+		suffixes     types.Suffixes
+		declared     []config.CertificateConfig
+		certificates types.Certificates
+		requests     []types.Domains
+		want         types.Certificates
+	}{
+		{
+			name:         "PerRequestReuseCoveringCertificate",
+			grouping:     config.GroupingPerRequest,
+			certificates: types.Certificates{{Identifier: "example.com-0", Main: "example.com", Domains: types.Domains{"example.com", "www.example.com"}}},
+			requests:     []types.Domains{{"www.example.com"}},
+			want:         types.Certificates{{Identifier: "example.com-0", Main: "example.com", Domains: types.Domains{"example.com", "www.example.com"}}},
+		},
+		{
+			name:         "PerDomainCreateCertificatePerHostname",
+			grouping:     config.GroupingPerDomain,
+			certificates: types.Certificates{{Identifier: "example.com-0", Main: "example.com", Domains: types.Domains{"example.com", "www.example.com"}}},
+			requests:     []types.Domains{{"www.example.com"}, {"example.com", "www.example.com"}},
+			want: types.Certificates{
+				{Identifier: "example.com-0", Main: "example.com", Domains: types.Domains{"example.com", "www.example.com"}},
+				{Identifier: "www.example.com-0", Main: "www.example.com", Domains: types.Domains{"www.example.com"}},
+				{Identifier: "example.com-1", Main: "example.com", Domains: types.Domains{"example.com"}},
+			},
+		},
+		{
+			name:     "PerDomainDeclaredCertificate",
+			grouping: config.GroupingPerDomain,
+			sanMerge: true,
+			declared: []config.CertificateConfig{{Identifier: "declared", Domains: types.Domains{"example.com", "www.example.com"}}},
+			requests: []types.Domains{{"example.com", "www.example.com", "api.example.com"}},
+			want: types.Certificates{
+				{Identifier: "declared", Main: "example.com", Domains: types.Domains{"example.com", "www.example.com"}, Declared: true},
+				{Identifier: "api.example.com-0", Main: "api.example.com", Domains: types.Domains{"api.example.com"}},
+			},
+		},
+		{
+			name:     "PerRegisteredDomainBundle",
+			grouping: config.GroupingPerRegisteredDomain,
+			requests: []types.Domains{{"example.com"}, {"www.example.com"}, {"*.shop.example.com"}, {"example.co.uk"}, {"www.example.co.uk"}},
+			want: types.Certificates{
+				{Identifier: "example.com-0", Main: "example.com", Domains: types.Domains{"example.com"}, PendingDomains: types.Domains{"www.example.com", "*.shop.example.com"}},
+				{Identifier: "example.co.uk-0", Main: "example.co.uk", Domains: types.Domains{"example.co.uk"}, PendingDomains: types.Domains{"www.example.co.uk"}},
+			},
+		},
+		{
+			name:     "PerRegisteredDomainPrivateSuffix",
+			grouping: config.GroupingPerRegisteredDomain,
+			suffixes: types.Suffixes{"customers.example.org"},
+			requests: []types.Domains{{"team1.customers.example.org"}, {"team2.customers.example.org"}, {"www.team1.customers.example.org"}},
+			want: types.Certificates{
+				{Identifier: "team1.customers.example.org-0", Main: "team1.customers.example.org", Domains: types.Domains{"team1.customers.example.org"}, PendingDomains: types.Domains{"www.team1.customers.example.org"}},
+				{Identifier: "team2.customers.example.org-0", Main: "team2.customers.example.org", Domains: types.Domains{"team2.customers.example.org"}},
+			},
+		},
+		{
+			name:     "PerRegisteredDomainResolverBoundary",
+			grouping: config.GroupingPerRegisteredDomain,
+			requests: []types.Domains{{"example.com"}, {"api.example.com"}},
+			want: types.Certificates{
+				{Identifier: "example.com-0", Main: "example.com", Domains: types.Domains{"example.com"}},
+				{Identifier: "api.example.com-0", Main: "api.example.com", Domains: types.Domains{"api.example.com"}},
+			},
+		},
+		{
+			name:         "SANMergeParkedDomainNotExtendedAgain",
+			grouping:     config.GroupingPerRequest,
+			sanMerge:     true,
+			certificates: types.Certificates{{Identifier: "example.com-0", Main: "example.com", Domains: types.Domains{"example.com"}, ParkedDomains: types.Domains{"www.example.com"}, ParkedDate: parkedDate}},
+			requests:     []types.Domains{{"example.com", "www.example.com"}},
+			want:         types.Certificates{{Identifier: "example.com-0", Main: "example.com", Domains: types.Domains{"example.com"}, ParkedDomains: types.Domains{"www.example.com"}, ParkedDate: parkedDate}},
+		},
+		{
+			name:         "PerRegisteredDomainSANLimit",
+			grouping:     config.GroupingPerRegisteredDomain,
+			certificates: types.Certificates{{Identifier: "0.example.org-0", Main: "0.example.org", Domains: manyDomains}},
+			requests:     []types.Domains{{"example.org"}},
+			want: types.Certificates{
+				{Identifier: "0.example.org-0", Main: "0.example.org", Domains: manyDomains},
+				{Identifier: "example.org-0", Main: "example.org", Domains: types.Domains{"example.org"}},
+			},
+		},
+		{
+			name:         "PerRegisteredDomainNotMixed",
+			grouping:     config.GroupingPerRegisteredDomain,
+			certificates: types.Certificates{{Identifier: "example.com-0", Main: "example.com", Domains: types.Domains{"example.com", "foo.com"}}},
+			requests:     []types.Domains{{"www.example.com"}},
+			want: types.Certificates{
+				{Identifier: "example.com-0", Main: "example.com", Domains: types.Domains{"example.com", "foo.com"}},
+				{Identifier: "www.example.com-0", Main: "www.example.com", Domains: types.Domains{"www.example.com"}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := appCtx.TestContext(nil)
+			ctx.MetricsRegister = appProm.NewRegistry(types.NameServerMetrics, prometheus.NewRegistry())
+			ctx.Config.Grouping = tt.grouping
+			ctx.Config.SANMerge = tt.sanMerge
+			ctx.Config.PrivateSuffixes = tt.suffixes
+			ctx.Config.Certificates = tt.declared
+			ctx.Config.Acme.DelayFailed = time.Hour
+			cm := &CertifierManager{resolvers: resolvers, clock: clockwork.NewRealClock()}
+			state := &types.State{Certificates: tt.certificates}
+			domainsRequests := []*types.DomainRequest{}
+			for _, domains := range tt.requests {
+				domainsRequests = append(domainsRequests, &types.DomainRequest{Domains: domains})
+			}
+			cm.MatchingRequests(ctx, state, domainsRequests)
+			assert.Equal(t, tt.want, state.Certificates)
+		})
+	}
+}
+
+func TestCertifierManager_resumeParkedDomains(t *testing.T) {
+	now := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+	ctx := appCtx.TestContext(nil)
+	ctx.Config.Acme.DelayFailed = time.Hour
+	cm := &CertifierManager{clock: clockwork.NewFakeClockAt(now)}
+	state := &types.State{Certificates: types.Certificates{
+		{Identifier: "parked", Domains: types.Domains{"foo.com"}, ParkedDomains: types.Domains{"www.foo.com"}, ParkedDate: now.Add(-time.Minute)},
+		{Identifier: "expired", Domains: types.Domains{"bar.com"}, PendingDomains: types.Domains{"api.bar.com"}, ParkedDomains: types.Domains{"www.bar.com", "api.bar.com"}, ParkedDate: now.Add(-time.Hour)},
+	}}
+
+	cm.resumeParkedDomains(ctx, state)
+	assert.Equal(t, types.Certificates{
+		{Identifier: "parked", Domains: types.Domains{"foo.com"}, ParkedDomains: types.Domains{"www.foo.com"}, ParkedDate: now.Add(-time.Minute)},
+		{Identifier: "expired", Domains: types.Domains{"bar.com"}, PendingDomains: types.Domains{"api.bar.com", "www.bar.com"}},
+	}, state.Certificates)
+}
+
+func TestCertifierManager_PrunePendingDomains(t *testing.T) {
+	now := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+	ctx := appCtx.TestContext(nil)
+	cm := &CertifierManager{}
+	certificates := types.Certificates{
+		{Identifier: "requested", Domains: types.Domains{"foo.com"}, PendingDomains: types.Domains{"www.foo.com"}, ParkedDomains: types.Domains{"api.foo.com"}, ParkedDate: now},
+		{Identifier: "unrequested", Domains: types.Domains{"bar.com"}, PendingDomains: types.Domains{"www.bar.com", "api.bar.com"}, ParkedDomains: types.Domains{"old.bar.com"}, ParkedDate: now},
+		{Identifier: "declared", Domains: types.Domains{"baz.com"}, PendingDomains: types.Domains{"www.baz.com"}, Declared: true},
+	}
+	domainsRequests := []*types.DomainRequest{
+		{Domains: types.Domains{"foo.com", "www.foo.com"}},
+		{Domains: types.Domains{"api.foo.com"}},
+		{Domains: types.Domains{"api.bar.com"}},
+	}
+
+	cm.PrunePendingDomains(ctx, certificates, domainsRequests)
+	assert.Equal(t, types.Certificates{
+		{Identifier: "requested", Domains: types.Domains{"foo.com"}, PendingDomains: types.Domains{"www.foo.com"}, ParkedDomains: types.Domains{"api.foo.com"}, ParkedDate: now},
+		{Identifier: "unrequested", Domains: types.Domains{"bar.com"}, PendingDomains: types.Domains{"api.bar.com"}},
+		{Identifier: "declared", Domains: types.Domains{"baz.com"}, PendingDomains: types.Domains{"www.baz.com"}, Declared: true},
+	}, certificates)
+}
@@ -4,17 +4,20 @@ import (
 	"context"
 	"crypto/x509"
 	"encoding/pem"
+	"errors"
 	"fmt"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/alexandreh2ag/lets-go-tls/apps/server/acme"
+	"github.com/alexandreh2ag/lets-go-tls/apps/server/config"
 	appCtx "github.com/alexandreh2ag/lets-go-tls/apps/server/context"
+	storageState "github.com/alexandreh2ag/lets-go-tls/storage/state"
 	"github.com/alexandreh2ag/lets-go-tls/types"
 	typesAcme "github.com/alexandreh2ag/lets-go-tls/types/acme"
 	typesStorageState "github.com/alexandreh2ag/lets-go-tls/types/storage/state"
-	"github.com/eko/gocache/lib/v4/store"
+	"github.com/go-acme/lego/v4/certcrypto"
 	legoCertificate "github.com/go-acme/lego/v4/certificate"
 	"github.com/google/uuid"
 	"github.com/hashicorp/go-multierror"
@@ -23,27 +26,63 @@ import (
 )
 
 const (
-	CacheProcessLockKey = "manager_run_process_lock"
+	ProcessLockKey = "manager_run_process_lock"
 
 	runCountMetric        = "run_count"
 	fetchErrorMetric      = "fetch_error_number"
 	obtainCertErrorMetric = "obtain_certificate_error_number"
+
+	// maxStateConflictRetries is the number of times changes are applied on last saved state when it was modified concurrently.
+	maxStateConflictRetries = 3
 )
 
 var _ Manager = &CertifierManager{}
 
+var errRunAbandoned = errors.New("run abandoned")
+
+// identifierReplacer removes characters of wildcard and IPv6 domains which are not usable in file names.
+var identifierReplacer = strings.NewReplacer("*", "wildcard", ":", "_")
+
+type CertificateAction string
+
+const (
+	CertificateActionNone                CertificateAction = "none"
+	CertificateActionObtain              CertificateAction = "obtain"
+	CertificateActionRenew               CertificateAction = "renew"
+	CertificateActionSkipMaxAttempt      CertificateAction = "skip_max_attempt"
+	CertificateActionSkipStagingFailed   CertificateAction = "skip_staging_failed"
+	CertificateActionWildcardUnsupported CertificateAction = "wildcard_unsupported"
+	CertificateActionIPUnsupported       CertificateAction = "ip_unsupported"
+)
+
 type Manager interface {
 	Start(ctx *appCtx.ServerContext) error
+	// Abandon stops a run still in progress and releases its lock when shutdown grace period is expired,
+	// the run is stopped first so it does not save its state without lock.
+	Abandon(ctx *appCtx.ServerContext) error
 }
 
 type CertifierManager struct {
 	ephemeralID  string
 	stateStorage typesStorageState.Storage
 	resolvers    types.Resolvers
+	// stagingResolvers are only created when staging is enabled
+	stagingResolvers types.Resolvers
 
 	clock clockwork.Clock
 
 	metricsInit bool
+
+	// stopAsked is only used by the goroutine running Start
+	stopAsked bool
+	// lease is the lock held by the run in progress
+	lease *types.Lease
+	// certificateLease is the lease of the certificate ordered by the run in progress with partition
+	certificateLease *types.Lease
+	leaseMutex       sync.Mutex
+	// runCtx is canceled when the run in progress must stop, ex: its lock is lost
+	runCtx    context.Context
+	cancelRun context.CancelCauseFunc
 }
 
 func NewManager(stateStorage typesStorageState.Storage) *CertifierManager {
@@ -70,55 +109,125 @@ func (cm *CertifierManager) Start(ctx *appCtx.ServerContext) error {
 	defer ticker.Stop()
 	ctx.Logger.Debug("wait for tick")
 	for {
+		if cm.stopAsked {
+			ctx.Logger.Info(fmt.Sprintf("stop asked by app, exiting..."))
+			return nil
+		}
 		select {
 		case <-ticker.Chan():
 			ctx.Logger.Debug("tick received")
 			tickFunc()
 		case <-ctx.Done():
-			ctx.Logger.Info(fmt.Sprintf("stop asked by app, exiting..."))
-			return nil
+			cm.stopAsked = true
 		}
+	}
+}
 
+// runStopped returns the reason why the run in progress must stop, or nil.
+func (cm *CertifierManager) runStopped() error {
+	if cm.runCtx == nil {
+		return nil
+	}
+	return context.Cause(cm.runCtx)
+}
+
+// isStopAsked checks without blocking if app asked to stop during a run.
+func (cm *CertifierManager) isStopAsked(ctx *appCtx.ServerContext) bool {
+	if !cm.stopAsked {
+		select {
+		case <-ctx.Done():
+			cm.stopAsked = true
+		default:
+		}
 	}
+	return cm.stopAsked
+}
+
+func (cm *CertifierManager) Abandon(ctx *appCtx.ServerContext) error {
+	cm.leaseMutex.Lock()
+	lease, certificateLease := cm.lease, cm.certificateLease
+	cm.lease, cm.certificateLease = nil, nil
+	if cm.cancelRun != nil {
+		cm.cancelRun(errRunAbandoned)
+	}
+	cm.leaseMutex.Unlock()
+
+	var errs []error
+	if certificateLease != nil {
+		ctx.Logger.Warn(fmt.Sprintf("run in progress is abandoned, release lock %s", certificateLease.Key))
+		errs = append(errs, ctx.Locker.Release(context.Background(), certificateLease))
+	}
+	if lease != nil {
+		ctx.Logger.Warn("run in progress is abandoned, release manager process lock")
+		errs = append(errs, ctx.Locker.Release(context.Background(), lease))
+	}
+	return errors.Join(errs...)
 }
 
 func (cm *CertifierManager) Run(ctx *appCtx.ServerContext) error {
-	var errCreateAccountError, errCreateResolvers error
-	state, errLoad := cm.stateStorage.Load()
-	if errLoad != nil {
-		return fmt.Errorf("failed to load state: %v", errLoad)
+	runCtx, cancelRun := context.WithCancelCause(context.Background())
+	defer cancelRun(nil)
+	cm.leaseMutex.Lock()
+	cm.runCtx, cm.cancelRun = runCtx, cancelRun
+	cm.leaseMutex.Unlock()
+
+	state, err := cm.runProcess(ctx)
+	if err != nil || !ctx.Config.Partition.Enable {
+		return err
 	}
+	return cm.runPartition(ctx, state)
+}
 
-	cm.initMetrics(ctx, state)
+// runProcess runs the manager process while holding process lock, it returns the saved state or nil when the lock is held by another replica.
+func (cm *CertifierManager) runProcess(ctx *appCtx.ServerContext) (*types.State, error) {
+	var errCreateAccountError, errCreateResolvers error
 
-	hasLock, errLock := cm.obtainLock(ctx)
+	lease, errLock := cm.obtainLock(ctx)
 	if errLock != nil {
-		return fmt.Errorf("unable to lock manager process with: %v", errLock)
+		return nil, fmt.Errorf("unable to lock manager process with: %v", errLock)
 	}
-	if !hasLock {
+	if lease == nil {
 		ctx.Logger.Info("tick skipped due process is already running")
-		return nil
+		return nil, nil
 	}
+	cm.setLease(lease)
+	stopRenewLock := cm.renewLock(ctx, lease, cm.cancelRun)
 	defer func() {
-		errLock = cm.releaseLock(ctx)
+		stopRenewLock()
+		if !cm.setLease(nil) {
+			// lock already released by Abandon
+			return
+		}
+		errLock = cm.releaseLock(ctx, lease)
 		if errLock != nil {
 			ctx.Logger.Error(fmt.Sprintf("unable to unlock manager process with: %v", errLock))
 		}
 	}()
 
+	// state is loaded only once lock is held to not override changes of another replica
+	state, errLoad := cm.stateStorage.Load()
+	if errLoad != nil {
+		return nil, fmt.Errorf("failed to load state: %v", errLoad)
+	}
+	state.FencingToken = lease.Token
+	// base is kept to apply changes of the run on last saved state when another process saved it during the run
+	base := state.Clone()
+
+	cm.initMetrics(ctx, state)
+
 	ctx.MetricsRegister.MustGetCounter(runCountMetric).Inc()
 	// Create new account
 	if state.Account == nil || state.Account.Key == nil {
 		state.Account, errCreateAccountError = typesAcme.NewAccount(ctx.Config.Acme.Email)
 		if errCreateAccountError != nil {
-			return fmt.Errorf("failed to create account: %s", errCreateAccountError)
+			return nil, fmt.Errorf("failed to create account: %s", errCreateAccountError)
 		}
 	}
 
 	if cm.resolvers == nil {
 		cm.resolvers, errCreateResolvers = acme.CreateResolvers(ctx, state.Account)
 		if errCreateResolvers != nil {
-			return errCreateResolvers
+			return nil, errCreateResolvers
 		}
 	}
 
@@ -126,7 +235,14 @@ func (cm *CertifierManager) Run(ctx *appCtx.ServerContext) error {
 	// Init account typesAcme
 	errRegisterAccountError := acme.RegisterAccount(state, cm.stateStorage, defaultResolver)
 	if errRegisterAccountError != nil {
-		return errRegisterAccountError
+		return nil, errRegisterAccountError
+	}
+
+	if ctx.Config.Acme.Staging.Enable {
+		errInitStaging := cm.initStaging(ctx, state)
+		if errInitStaging != nil {
+			return nil, errInitStaging
+		}
 	}
 
 	domainsRequests, errFetch := cm.FetchRequests(ctx)
@@ -139,24 +255,21 @@ func (cm *CertifierManager) Run(ctx *appCtx.ServerContext) error {
 	}
 
 	cm.MatchingRequests(ctx, state, domainsRequests)
+	if len(errFetch) == 0 {
+		cm.PrunePendingDomains(ctx, state.Certificates, domainsRequests)
+	}
 
 	// Run acme challenge for new cert or renew cert need
-	errObtainCerts := cm.ObtainCertificates(ctx, state)
-	if errObtainCerts.ErrorOrNil() != nil {
-		ctx.MetricsRegister.MustGetGauge(obtainCertErrorMetric).Set(1)
-		for _, errObtainCert := range errObtainCerts.WrappedErrors() {
-			ctx.Logger.Error(errObtainCert.Error())
-		}
-		ctx.Logger.Error("failed to obtain certificates")
-	} else {
-		ctx.MetricsRegister.MustGetGauge(obtainCertErrorMetric).Set(0)
+	// with partitioning, certificates are obtained once process lock is released
+	if !ctx.Config.Partition.Enable {
+		cm.reportObtainErrors(ctx, cm.ObtainCertificates(ctx, state))
 	}
 
 	// remove UnusedAt when a certificate is reuse again
 	// remove unused certificates when retention expired or mark for retention and only if errFetch is nil
 	if len(errFetch) == 0 {
 		ctx.Logger.Info(fmt.Sprintf("clean unused flag when certificates have been reuse again"))
-		cm.MarkCertificatesAsReused(state.Certificates, domainsRequests)
+		cm.MarkCertificatesAsReused(ctx, state.Certificates, domainsRequests)
 
 		ctx.Logger.Info(fmt.Sprintf("clean up unused certificates"))
 		state.Certificates = cm.CleanUnusedCertificates(ctx, state.Certificates, domainsRequests)
@@ -164,13 +277,54 @@ func (cm *CertifierManager) Run(ctx *appCtx.ServerContext) error {
 
 	ctx.GetMetricsRegister().UpdateCertificatesMetrics(state.Certificates)
 
-	return cm.stateStorage.Save(state)
+	if errStopped := cm.runStopped(); errStopped != nil {
+		return nil, fmt.Errorf("run stopped, state is not saved: %v", errStopped)
+	}
+	return cm.saveState(ctx, base, state)
+}
+
+// saveState saves state changed since base, when state was saved by another process in the meantime,
+// changes are applied by certificate on last saved state instead of overriding it.
+func (cm *CertifierManager) saveState(ctx *appCtx.ServerContext, base *types.State, state *types.State) (*types.State, error) {
+	errSave := cm.stateStorage.Save(state)
+	for retry := 0; errors.Is(errSave, types.ErrStateConflict) && retry < maxStateConflictRetries; retry++ {
+		ctx.Logger.Warn(fmt.Sprintf("state was modified during run, apply changes on last saved state: %v", errSave))
+		latest, errLoad := cm.stateStorage.Load()
+		if errLoad != nil {
+			return nil, fmt.Errorf("failed to load state: %v", errLoad)
+		}
+		rebased := storageState.Rebase(base, state, latest)
+		ctx.GetMetricsRegister().UpdateCertificatesMetrics(rebased.Certificates)
+		errSave = cm.stateStorage.Save(rebased)
+		if errSave == nil {
+			return rebased, nil
+		}
+	}
+	if errSave != nil {
+		return nil, errSave
+	}
+	return state, nil
+}
+
+func (cm *CertifierManager) reportObtainErrors(ctx *appCtx.ServerContext, errObtainCerts *multierror.Error) {
+	if errObtainCerts.ErrorOrNil() != nil {
+		ctx.MetricsRegister.MustGetGauge(obtainCertErrorMetric).Set(1)
+		for _, errObtainCert := range errObtainCerts.WrappedErrors() {
+			ctx.Logger.Error(errObtainCert.Error())
+		}
+		ctx.Logger.Error("failed to obtain certificates")
+	} else {
+		ctx.MetricsRegister.MustGetGauge(obtainCertErrorMetric).Set(0)
+	}
 }
 
 func (cm *CertifierManager) CleanUnusedCertificates(ctx *appCtx.ServerContext, certificates types.Certificates, domainsRequests []*types.DomainRequest) types.Certificates {
 	toDeleteCertificates := types.Certificates{}
-	unusedCertificates := certificates.UnusedCertificates(domainsRequests)
+	unusedCertificates := certificates.UnusedCertificates(domainsRequests, ctx.Config.Grouping == config.GroupingPerDomain, ctx.Config.PrivateSuffixes)
 	for _, certificate := range unusedCertificates {
+		if certificate.Declared || certificate.Retention == types.RetentionNever {
+			continue
+		}
 		if certificate.UnusedAt.IsZero() {
 			ctx.Logger.Info(fmt.Sprintf("certificate %s is detected unused", certificate.Identifier))
 			certificate.UnusedAt = cm.clock.Now()
@@ -182,98 +336,257 @@ func (cm *CertifierManager) CleanUnusedCertificates(ctx *appCtx.ServerContext, c
 	return certificates.Deletes(toDeleteCertificates)
 }
 
-func (cm *CertifierManager) MarkCertificatesAsReused(certificates types.Certificates, domainsRequests []*types.DomainRequest) {
-	for _, certificate := range certificates.UsedCertificates(domainsRequests) {
+func (cm *CertifierManager) MarkCertificatesAsReused(ctx *appCtx.ServerContext, certificates types.Certificates, domainsRequests []*types.DomainRequest) {
+	for _, certificate := range certificates.UsedCertificates(domainsRequests, ctx.Config.Grouping == config.GroupingPerDomain, ctx.Config.PrivateSuffixes) {
 		certificate.UnusedAt = time.Time{}
 	}
 }
 
-func (cm *CertifierManager) ObtainCertificates(ctx *appCtx.ServerContext, state *types.State) *multierror.Error {
+// GetCertificateAction returns what must be done for a certificate, it does not contact the CA.
+// Pending domains are obtained with current domains, once it failed current domains are renewed alone when renew date is reached.
+func (cm *CertifierManager) GetCertificateAction(ctx *appCtx.ServerContext, resolver types.Resolver, certificate *types.Certificate) CertificateAction {
 	cfgAcme := ctx.Config.Acme
-	var err error
+	renewDue := func() bool {
+		renewAt, renewPeriod := cfgAcme.GetRenewPolicy(resolver.ID())
+		return time.Now().After(certificate.RenewDate(renewAt, renewPeriod))
+	}
+
+	domains := certificate.RequestedDomains()
+	extend := certificate.IsValid() && certificate.NeedReissue()
+	if extend && certificate.ObtainFailCount > 0 && renewDue() {
+		// a failing reissue must not let current domains expire
+		domains, extend = certificate.Domains, false
+	}
+
+	typeChallenge := resolver.TypeChallenge()
+	if typeChallenge != typesAcme.TypeDNS01 && domains.ContainsWildcard() {
+		return CertificateActionWildcardUnsupported
+	}
+	if typeChallenge == typesAcme.TypeDNS01 && domains.ContainsIP() {
+		return CertificateActionIPUnsupported
+	}
+
+	if !certificate.ObtainFailDate.IsZero() && certificate.ObtainFailCount >= cfgAcme.MaxAttempt &&
+		cm.clock.Now().Before(certificate.ObtainFailDate.Add(cfgAcme.DelayFailed)) {
+		return CertificateActionSkipMaxAttempt
+	}
+
+	if cm.needStaging(ctx, certificate) && !certificate.StagingFailDate.IsZero() &&
+		cm.clock.Now().Before(certificate.StagingFailDate.Add(cfgAcme.DelayFailed)) {
+		return CertificateActionSkipStagingFailed
+	}
+
+	if !certificate.IsValid() || extend {
+		return CertificateActionObtain
+	}
+
+	if renewDue() {
+		return CertificateActionRenew
+	}
+
+	return CertificateActionNone
+}
+
+// obtainFailed counts a failed attempt. When pending domains of a valid certificate reach max attempt, they are parked
+// until delay_failed is expired, so current domains are renewed meanwhile.
+func (cm *CertifierManager) obtainFailed(ctx *appCtx.ServerContext, certificate *types.Certificate, action CertificateAction) {
+	certificate.ObtainFailCount++
+	certificate.ObtainFailDate = cm.clock.Now()
+	if action == CertificateActionRenew || !certificate.IsValid() || len(certificate.PendingDomains) == 0 ||
+		certificate.ObtainFailCount < ctx.Config.Acme.MaxAttempt {
+		return
+	}
+	ctx.Logger.Warn(fmt.Sprintf(
+		"max obtain attempts reached to extend certificate %s with %v, they are retried after %s",
+		certificate.Identifier,
+		certificate.PendingDomains,
+		ctx.Config.Acme.DelayFailed,
+	))
+	certificate.ParkedDomains = append(certificate.ParkedDomains, certificate.PendingDomains...)
+	certificate.ParkedDate = certificate.ObtainFailDate
+	certificate.PendingDomains = nil
+	certificate.ObtainFailCount = 0
+	certificate.ObtainFailDate = time.Time{}
+}
+
+func (cm *CertifierManager) ObtainCertificates(ctx *appCtx.ServerContext, state *types.State) *multierror.Error {
 	merr := &multierror.Error{}
 	for _, certificate := range state.Certificates {
-		var certAcme *legoCertificate.Resource
-		resolver := cm.resolvers.FindResolver(certificate)
-
-		if resolver.TypeChallenge() == typesAcme.TypeHTTP01 && certificate.Domains.ContainsWildcard() {
-			certificate.ObtainFailCount++
-			certificate.ObtainFailDate = cm.clock.Now()
-			merr = multierror.Append(
-				merr,
-				fmt.Errorf(
-					"unable to obtain wildcard certificate without ACME DNS challange %s",
-					certificate.Identifier,
-				),
-			)
-			continue
+		if cm.isStopAsked(ctx) {
+			ctx.Logger.Info("stop asked by app, remaining certificates will be processed on next start")
+			break
+		}
+		if errStopped := cm.runStopped(); errStopped != nil {
+			merr = multierror.Append(merr, fmt.Errorf("run stopped, remaining certificates will be processed on next run: %v", errStopped))
+			break
 		}
+		err := cm.ObtainCertificate(ctx, certificate)
+		if err != nil {
+			merr = multierror.Append(merr, err)
+		}
+	}
+	return merr
+}
 
-		if !certificate.ObtainFailDate.IsZero() && certificate.ObtainFailCount >= cfgAcme.MaxAttempt &&
-			cm.clock.Now().Before(certificate.ObtainFailDate.Add(cfgAcme.DelayFailed)) {
-			ctx.Logger.Warn(fmt.Sprintf("skip certificate %s due to max obtain fail reach", certificate.Identifier))
-			continue
+// ObtainCertificate obtains or renews a certificate when needed and updates it with the result.
+func (cm *CertifierManager) ObtainCertificate(ctx *appCtx.ServerContext, certificate *types.Certificate) error {
+	var err error
+	var certAcme *legoCertificate.Resource
+	resolver := cm.resolvers.FindResolver(certificate)
+
+	action := cm.GetCertificateAction(ctx, resolver, certificate)
+
+	switch action {
+	case CertificateActionWildcardUnsupported:
+		cm.obtainFailed(ctx, certificate, action)
+		return fmt.Errorf(
+			"unable to obtain wildcard certificate without ACME DNS challange %s",
+			certificate.Identifier,
+		)
+	case CertificateActionIPUnsupported:
+		cm.obtainFailed(ctx, certificate, action)
+		return fmt.Errorf(
+			"unable to obtain IP address certificate with ACME DNS challenge %s",
+			certificate.Identifier,
+		)
+	case CertificateActionSkipMaxAttempt:
+		ctx.Logger.Warn(fmt.Sprintf("skip certificate %s due to max obtain fail reach", certificate.Identifier))
+		return nil
+	case CertificateActionSkipStagingFailed:
+		ctx.Logger.Warn(fmt.Sprintf("skip certificate %s due to staging validation failure", certificate.Identifier))
+		return nil
+	case CertificateActionObtain:
+		request := legoCertificate.ObtainRequest{
+			Domains:    certificate.RequestedDomains().ToStringSlice(),
+			Bundle:     true,
+			MustStaple: false,
+		}
+		if certificate.KeyType != "" {
+			request.PrivateKey, err = certcrypto.GeneratePrivateKey(config.KeyTypes[certificate.KeyType])
+			if err != nil {
+				return fmt.Errorf("failed to generate private key for %s: %v", certificate.Identifier, err)
+			}
 		}
 
-		if certificate.Key == nil || certificate.Certificate == nil {
-			request := legoCertificate.ObtainRequest{
-				Domains:    certificate.Domains.ToStringSlice(),
-				Bundle:     true,
-				MustStaple: false,
+		if cm.needStaging(ctx, certificate) {
+			errStaging := cm.validateOnStaging(ctx, certificate, request)
+			if errStaging != nil {
+				// staging failure does not count against production max attempt
+				certificate.StagingFailDate = cm.clock.Now()
+				return fmt.Errorf("unable to validate certificate %s on staging: %v", certificate.Identifier, errStaging)
 			}
+			certificate.StagingFailDate = time.Time{}
+		}
 
-			ctx.Logger.Info(fmt.Sprintf(
-				"(resolver: %s) obtain certificate %s (%v)",
-				resolver.ID(),
-				certificate.Identifier,
-				certificate.Domains.ToStringSlice(),
-			))
-			certAcme, err = resolver.Obtain(request)
-		} else if time.Now().Add(cfgAcme.RenewPeriod).After(certificate.ExpirationDate) {
-			certRes := legoCertificate.Resource{
-				Domain:      string(certificate.Domains[0]),
-				PrivateKey:  certificate.Key,
-				Certificate: certificate.Certificate,
+		ctx.Logger.Info(fmt.Sprintf(
+			"(resolver: %s) obtain certificate %s (%v)",
+			resolver.ID(),
+			certificate.Identifier,
+			request.Domains,
+		))
+		certAcme, err = resolver.Obtain(request)
+	case CertificateActionRenew:
+		certRes := legoCertificate.Resource{
+			Domain:      string(certificate.Domains[0]),
+			PrivateKey:  certificate.Key,
+			Certificate: certificate.Certificate,
+		}
+		if certificate.KeyType != "" {
+			// renewal reuses the current key, a new key of the declared type is used instead
+			privateKey, errKey := certcrypto.GeneratePrivateKey(config.KeyTypes[certificate.KeyType])
+			if errKey != nil {
+				return fmt.Errorf("failed to generate private key for %s: %v", certificate.Identifier, errKey)
 			}
-			options := &legoCertificate.RenewOptions{Bundle: true, MustStaple: false}
-			ctx.Logger.Info(fmt.Sprintf(
-				"(resolver: %s) renew certificate %s (%v)",
-				resolver.ID(),
-				certificate.Identifier,
-				certificate.Domains.ToStringSlice(),
-			))
-			certAcme, err = resolver.RenewWithOptions(certRes, options)
-		} else {
-			ctx.Logger.Debug(fmt.Sprintf("nothing to do for certificate %s", certificate.Identifier))
-			continue
+			certRes.PrivateKey = certcrypto.PEMEncode(privateKey)
 		}
+		options := &legoCertificate.RenewOptions{Bundle: true, MustStaple: false}
+		ctx.Logger.Info(fmt.Sprintf(
+			"(resolver: %s) renew certificate %s (%v)",
+			resolver.ID(),
+			certificate.Identifier,
+			certificate.Domains.ToStringSlice(),
+		))
+		certAcme, err = resolver.RenewWithOptions(certRes, options)
+	default:
+		ctx.Logger.Debug(fmt.Sprintf("nothing to do for certificate %s", certificate.Identifier))
+		return nil
+	}
+	if err != nil {
+		cm.obtainFailed(ctx, certificate, action)
+		return fmt.Errorf("unable to obtain/renew certificate %s : %v", certificate.Identifier, err)
+	}
+	block, _ := pem.Decode(certAcme.Certificate)
+	if block == nil {
+		cm.obtainFailed(ctx, certificate, action)
+		return fmt.Errorf("failed to decode certificate for: %s", certificate.Identifier)
+	}
+	cert, errParse := x509.ParseCertificate(block.Bytes)
+	if errParse != nil {
+		cm.obtainFailed(ctx, certificate, action)
+		return fmt.Errorf("failed to parse certificate for %s: %v", certificate.Identifier, errParse)
+	}
+	// last known-good material is kept when the new one is rejected
+	issued := &types.Certificate{Domains: certificate.RequestedDomains(), Key: certAcme.PrivateKey, Certificate: certAcme.Certificate}
+	if action == CertificateActionRenew {
+		// pending domains are kept for the next extension attempt
+		issued.Domains = certificate.Domains
+	}
+	errValidate := issued.ValidateMaterial(ctx.TrustedRoots, cm.clock.Now())
+	if errValidate != nil {
+		cm.obtainFailed(ctx, certificate, action)
+		return fmt.Errorf("invalid certificate material for %s: %v", certificate.Identifier, errValidate)
+	}
+	if action == CertificateActionObtain {
+		certificate.PendingDomains = nil
+		certificate.RemovedDomains = nil
+	}
+	certificate.Domains = issued.Domains
+	certificate.Key = certAcme.PrivateKey
+	certificate.Certificate = certAcme.Certificate
+	certificate.ExpirationDate = cert.NotAfter
+	certificate.NotBefore = cert.NotBefore
+	certificate.ObtainFailCount = 0
+	certificate.ObtainFailDate = time.Time{}
+	return nil
+}
+
+// initStaging creates and registers the staging account and resolvers used to validate new certificates.
+func (cm *CertifierManager) initStaging(ctx *appCtx.ServerContext, state *types.State) error {
+	var err error
+	if state.StagingAccount == nil || state.StagingAccount.Key == nil {
+		state.StagingAccount, err = typesAcme.NewAccount(ctx.Config.Acme.Email)
 		if err != nil {
-			certificate.ObtainFailCount++
-			certificate.ObtainFailDate = cm.clock.Now()
-			merr = multierror.Append(merr, fmt.Errorf("unable to obtain/renew certificate %s : %v", certificate.Identifier, err))
-			continue
-		}
-		certificate.Key = certAcme.PrivateKey
-		certificate.Certificate = certAcme.Certificate
-		block, _ := pem.Decode(certificate.Certificate)
-		if block == nil {
-			certificate.ObtainFailCount++
-			certificate.ObtainFailDate = cm.clock.Now()
-			merr = multierror.Append(merr, fmt.Errorf("failed to decode certificate for: %s", certificate.Identifier))
-			continue
+			return fmt.Errorf("failed to create staging account: %s", err)
 		}
-		cert, errParse := x509.ParseCertificate(block.Bytes)
-		if errParse != nil {
-			certificate.ObtainFailCount++
-			certificate.ObtainFailDate = cm.clock.Now()
-			merr = multierror.Append(merr, fmt.Errorf("failed to parse certificate for %s: %v", certificate.Identifier, errParse))
-			continue
+	}
+
+	if cm.stagingResolvers == nil {
+		cm.stagingResolvers, err = acme.CreateStagingResolvers(ctx, state.StagingAccount)
+		if err != nil {
+			return fmt.Errorf("failed to create staging resolvers: %v", err)
 		}
-		certificate.ExpirationDate = cert.NotAfter
-		certificate.ObtainFailCount = 0
-		certificate.ObtainFailDate = time.Time{}
 	}
-	return merr
+
+	return acme.RegisterStagingAccount(state, cm.stateStorage, cm.stagingResolvers[types.DefaultKey])
+}
+
+// needStaging returns true when a certificate must be validated on staging CA before production, only a certificate
+// never issued is validated so renewals and reissues do not cost a staging order.
+func (cm *CertifierManager) needStaging(ctx *appCtx.ServerContext, certificate *types.Certificate) bool {
+	return ctx.Config.Acme.Staging.Enable && !certificate.IsIssued()
+}
+
+// validateOnStaging runs a full order on staging CA, the obtained certificate is dropped.
+func (cm *CertifierManager) validateOnStaging(ctx *appCtx.ServerContext, certificate *types.Certificate, request legoCertificate.ObtainRequest) error {
+	resolver := cm.stagingResolvers.FindResolver(certificate)
+	ctx.Logger.Info(fmt.Sprintf(
+		"(resolver: %s) validate certificate %s (%v) on staging",
+		resolver.ID(),
+		certificate.Identifier,
+		certificate.Domains.ToStringSlice(),
+	))
+	_, err := resolver.Obtain(request)
+	return err
 }
 
 func (cm *CertifierManager) FetchRequests(ctx *appCtx.ServerContext) ([]*types.DomainRequest, map[string]error) {
@@ -286,13 +599,20 @@ func (cm *CertifierManager) FetchRequests(ctx *appCtx.ServerContext) ([]*types.D
 		go func() {
 			defer wg.Done()
 			requests, err := requester.Fetch()
+			lock.Lock()
+			defer lock.Unlock()
 			if err != nil {
 				errs[requester.ID()] = err
 				return
 			}
-			lock.Lock()
-			defer lock.Unlock()
-			domainsRequests = append(domainsRequests, requests...)
+			for _, request := range requests {
+				errNormalize := request.Normalize()
+				if errNormalize != nil {
+					ctx.Logger.Warn(fmt.Sprintf("requester %s: ignore request %v: %v", requester.ID(), request.Domains, errNormalize))
+					continue
+				}
+				domainsRequests = append(domainsRequests, request)
+			}
 		}()
 	}
 	wg.Wait()
@@ -301,60 +621,97 @@ func (cm *CertifierManager) FetchRequests(ctx *appCtx.ServerContext) ([]*types.D
 }
 
 func (cm *CertifierManager) MatchingRequests(ctx *appCtx.ServerContext, state *types.State, domainsRequests []*types.DomainRequest) {
+	cm.resumeParkedDomains(ctx, state)
+	cm.syncDeclaredCertificates(ctx, state)
+
 	// check domainRequest is already in typesStorageState.Certificates or add it
 	for _, request := range domainsRequests {
-		cert := state.Certificates.Match(request.Domains, false)
-		if cert == nil {
-			cert = &types.Certificate{Domains: request.Domains, Main: string(request.Domains[0])}
-			// generate name
-			baseIdentifier := strings.ReplaceAll(cert.Main, "*", "wildcard")
-			i := 0
-			cert.Identifier = fmt.Sprintf("%s-%v", baseIdentifier, i)
-			for !state.Certificates.CheckIdentifierUnique(cert.Identifier) {
-				cert.Identifier = fmt.Sprintf("%s-%v", baseIdentifier, i)
-				i++
+		if ctx.Config.Grouping == config.GroupingPerDomain {
+			for _, domain := range cm.uncoveredDomains(ctx, state, request) {
+				cm.createCertificate(ctx, state, types.Domains{domain})
 			}
-			ctx.Logger.Info(fmt.Sprintf("create new certificate %s (%v)", cert.Identifier, cert.Domains))
-			state.Certificates = append(state.Certificates, cert)
-			ctx.GetMetricsRegister().RegisterNewCertificateMetrics(cert)
+			continue
 		}
-
+		if state.Certificates.Match(request.Domains, false, ctx.Config.PrivateSuffixes) != nil || cm.extendCertificate(ctx, state, request) {
+			continue
+		}
+		cm.createCertificate(ctx, state, request.Domains)
 	}
 }
 
-func (cm *CertifierManager) obtainLock(ctx *appCtx.ServerContext) (bool, error) {
-	id, err := ctx.Cache.Get(context.Background(), CacheProcessLockKey)
-	if err != nil {
-		if _, ok := err.(*store.NotFound); !ok {
-			return false, err
-		}
-	}
-	if id != "" && id != cm.ephemeralID {
-		return false, nil
+func (cm *CertifierManager) createCertificate(ctx *appCtx.ServerContext, state *types.State, domains types.Domains) {
+	cert := &types.Certificate{Domains: domains, Main: string(domains[0])}
+	// generate name
+	baseIdentifier := identifierReplacer.Replace(cert.Main)
+	i := 0
+	cert.Identifier = fmt.Sprintf("%s-%v", baseIdentifier, i)
+	for !state.Certificates.CheckIdentifierUnique(cert.Identifier) {
+		cert.Identifier = fmt.Sprintf("%s-%v", baseIdentifier, i)
+		i++
 	}
-	err = ctx.Cache.Set(context.Background(), CacheProcessLockKey, cm.ephemeralID, func(o *store.Options) {
-		o.Expiration = ctx.Config.LockDuration
-	})
-	if err != nil {
-		return false, err
-	}
-	time.Sleep(time.Millisecond * 500)
+	ctx.Logger.Info(fmt.Sprintf("create new certificate %s (%v)", cert.Identifier, cert.Domains))
+	state.Certificates = append(state.Certificates, cert)
+	ctx.GetMetricsRegister().RegisterNewCertificateMetrics(cert)
+}
 
-	id, err = ctx.Cache.Get(context.Background(), CacheProcessLockKey)
-	if err != nil {
-		if _, ok := err.(*store.NotFound); !ok {
-			return false, err
+func (cm *CertifierManager) obtainLock(ctx *appCtx.ServerContext) (*types.Lease, error) {
+	return ctx.Locker.Acquire(context.Background(), ProcessLockKey, cm.ephemeralID, ctx.Config.LockDuration)
+}
+
+// renewLock extends the lease while a run is in progress, the returned func stops the renewal.
+// When the lease is lost, cancel is called so the work done under the lease stops.
+func (cm *CertifierManager) renewLock(ctx *appCtx.ServerContext, lease *types.Lease, cancel context.CancelCauseFunc) func() {
+	done := make(chan bool)
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(ctx.Config.LockDuration / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				err := ctx.Locker.Renew(context.Background(), lease, ctx.Config.LockDuration)
+				if errors.Is(err, types.ErrLockNotHeld) {
+					ctx.Logger.Error(fmt.Sprintf("lock %s lost, stop work in progress: %v", lease.Key, err))
+					cancel(fmt.Errorf("lock %s lost: %w", lease.Key, err))
+					return
+				}
+				if err != nil {
+					ctx.Logger.Error(fmt.Sprintf("unable to renew lock %s: %v", lease.Key, err))
+				}
+			case <-done:
+				return
+			}
 		}
+	}()
+
+	return func() {
+		close(done)
+		wg.Wait()
 	}
-	if id != "" && id != cm.ephemeralID {
-		return false, nil
-	}
+}
+
+// setLease replaces the lease of the run in progress, it returns false when the previous lease was already removed.
+func (cm *CertifierManager) setLease(lease *types.Lease) bool {
+	cm.leaseMutex.Lock()
+	defer cm.leaseMutex.Unlock()
+	held := cm.lease != nil
+	cm.lease = lease
+	return held
+}
 
-	return true, nil
+// setCertificateLease replaces the certificate lease of the run in progress, it returns false when the previous lease was already removed.
+func (cm *CertifierManager) setCertificateLease(lease *types.Lease) bool {
+	cm.leaseMutex.Lock()
+	defer cm.leaseMutex.Unlock()
+	held := cm.certificateLease != nil
+	cm.certificateLease = lease
+	return held
 }
 
-func (cm *CertifierManager) releaseLock(ctx *appCtx.ServerContext) error {
-	return ctx.Cache.Delete(context.Background(), CacheProcessLockKey)
+func (cm *CertifierManager) releaseLock(ctx *appCtx.ServerContext, lease *types.Lease) error {
+	return ctx.Locker.Release(context.Background(), lease)
 }
 
 func (cm *CertifierManager) initMetrics(ctx *appCtx.ServerContext, state *types.State) {
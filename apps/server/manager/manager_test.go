@@ -7,21 +7,25 @@ import (
 	"fmt"
 	"log/slog"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 
+	appAcme "github.com/alexandreh2ag/lets-go-tls/apps/server/acme"
 	"github.com/alexandreh2ag/lets-go-tls/apps/server/acme/dns"
 	"github.com/alexandreh2ag/lets-go-tls/apps/server/config"
 	appCtx "github.com/alexandreh2ag/lets-go-tls/apps/server/context"
+	"github.com/alexandreh2ag/lets-go-tls/internal/testutil"
 	mockPrometheus "github.com/alexandreh2ag/lets-go-tls/mocks/prometheus"
 	mockTypes "github.com/alexandreh2ag/lets-go-tls/mocks/types"
 	mockTypesStorageState "github.com/alexandreh2ag/lets-go-tls/mocks/types/storage/state"
 	appProm "github.com/alexandreh2ag/lets-go-tls/prometheus"
+	storageState "github.com/alexandreh2ag/lets-go-tls/storage/state"
 	"github.com/alexandreh2ag/lets-go-tls/types"
 	"github.com/alexandreh2ag/lets-go-tls/types/acme"
 	typesAcme "github.com/alexandreh2ag/lets-go-tls/types/acme"
-	"github.com/eko/gocache/lib/v4/store"
 	"github.com/go-acme/lego/v4/certificate"
+	legoCertificate "github.com/go-acme/lego/v4/certificate"
 	"github.com/go-acme/lego/v4/registration"
 	"github.com/jonboulle/clockwork"
 	"github.com/prometheus/client_golang/prometheus"
@@ -29,45 +33,13 @@ import (
 	"go.uber.org/mock/gomock"
 )
 
-const certPemResponseMock = `-----BEGIN CERTIFICATE-----
-MIIDEDCCAfigAwIBAgIHPhckqW5fPDANBgkqhkiG9w0BAQsFADAoMSYwJAYDVQQD
-Ex1QZWJibGUgSW50ZXJtZWRpYXRlIENBIDM5NWU2MTAeFw0xODExMDcxNzQ2NTZa
-Fw0yMzExMDcxNzQ2NTZaMBMxETAPBgNVBAMTCGFjbWUud3RmMIIBIjANBgkqhkiG
-9w0BAQEFAAOCAQ8AMIIBCgKCAQEAwtLNKvZXD20XPUQCWYSK9rUSKxD9Eb0c9fag
-bxOxOkLRTgL8LH6yln+bxc3MrHDou4PpDUdeo2CyOQu3CKsTS5mrH3NXYHu0H7p5
-y3riOJTHnfkGKLT9LciGz7GkXd62nvNP57bOf5Sk4P2M+Qbxd0hPTSfu52740LSy
-144cnxe2P1aDYehrEp6nYCESuyD/CtUHTo0qwJmzIy163Sp3rSs15BuCPyhySnE3
-BJ8Ggv+qC6D5I1932DfSqyQJ79iq/HRm0Fn84am3KwvRlUfWxabmsUGARXoqCgnE
-zcbJVOZKewv0zlQJpfac+b+Imj6Lvt1TGjIz2mVyefYgLx8gwwIDAQABo1QwUjAO
-BgNVHQ8BAf8EBAMCBaAwHQYDVR0lBBYwFAYIKwYBBQUHAwEGCCsGAQUFBwMCMAwG
-A1UdEwEB/wQCMAAwEwYDVR0RBAwwCoIIYWNtZS53dGYwDQYJKoZIhvcNAQELBQAD
-ggEBABB/0iYhmfPSQot5RaeeovQnsqYjI5ryQK2cwzW6qcTJfv8N6+p6XkqF1+W4
-jXZjrQP8MvgO9KNWlvx12vhINE6wubk88L+2piAi5uS2QejmZbXpyYB9s+oPqlk9
-IDvfdlVYOqvYAhSx7ggGi+j73mjZVtjAavP6dKuu475ZCeq+NIC15RpbbikWKtYE
-HBJ7BW8XQKx67iHGx8ygHTDLbREL80Bck3oUm7wIYGMoNijD6RBl25p4gYl9dzOd
-TqGl5hW/1P5hMbgEzHbr4O3BfWqU2g7tV36TASy3jbC3ONFRNNYrpEZ1AL3+cUri
-OPPkKtAKAbQkKbUIfsHpBZjKZMU=
------END CERTIFICATE-----
------BEGIN CERTIFICATE-----
-MIIDDDCCAfSgAwIBAgIIOV5hkYJx0JwwDQYJKoZIhvcNAQELBQAwIDEeMBwGA1UE
-AxMVUGViYmxlIFJvb3QgQ0EgNTBmZmJkMB4XDTE4MTEwNzE3NDY0N1oXDTQ4MTEw
-NzE3NDY0N1owKDEmMCQGA1UEAxMdUGViYmxlIEludGVybWVkaWF0ZSBDQSAzOTVl
-NjEwggEiMA0GCSqGSIb3DQEBAQUAA4IBDwAwggEKAoIBAQCacwXN4LmyRTgYS8TT
-SZYgz758npHiPTBDKgeN5WVmkkwW0TuN4W2zXhEmcM82uxOEjWS2drvK0+iJKneh
-0fQR8ZF35dIYFe8WXTg3kEmqcizSgh4LxlOntsXvatfX/6GU/ADo3xAFoBKCijen
-SRBIY65yq5m00cWx3RMIcQq1B0X8nJS0O1P7MYE/Vvidz5St/36RXVu1oWLeS5Fx
-HAezW0lqxEUzvC+uLTFWC6f/CilzmI7SsPAkZBk7dO5Qs0d7m/zWF588vlGS+0pt
-D1on+lU85Ma2zuAd0qmB6LY66N8pEKKtMk93wF/o4Z5i58ahbwNvTKAzz4JSRWSu
-mB9LAgMBAAGjQjBAMA4GA1UdDwEB/wQEAwIChDAdBgNVHSUEFjAUBggrBgEFBQcD
-AQYIKwYBBQUHAwIwDwYDVR0TAQH/BAUwAwEB/zANBgkqhkiG9w0BAQsFAAOCAQEA
-upU0DjzvIvoCOYKbq1RRN7rPdYad39mfjxgkeV0iOF5JoIdO6y1C7XAm9lT69Wjm
-iUPvnCTMDYft40N2SvmXuuMaPOm4zjNwn4K33jw5XBnpwxC7By/Y0oV+Sl10fBsd
-QqXC6H7LcSGkv+4eJbgY33P4uH5ZAy+2TkHUuZDkpufkAshzBust7nDAjfv3AIuQ
-wlPoyZfI11eqyiOqRzOq+B5dIBr1JzKnEzSL6n0JLNQiPO7iN03rud/wYD3gbmcv
-rzFL1KZfz+HZdnFwFW2T2gVW8L3ii1l9AJDuKzlvjUH3p6bgihVq02sjT8mx+GM2
-7R4IbHGnj0BJA2vMYC4hSw==
------END CERTIFICATE-----
-`
+// newCertificateResource issues a certificate for domains valid at now, its CA is trusted by ctx.
+func newCertificateResource(t *testing.T, ctx *appCtx.ServerContext, now time.Time, domains ...string) *certificate.Resource {
+	ca := testutil.NewCertificateAuthority(t, now.Add(-time.Hour), now.Add(time.Hour*24*365))
+	ctx.TrustedRoots = ca.Pool
+	cert, key := ca.Issue(t, domains, now.Add(-time.Minute), now.Add(time.Hour*24*90))
+	return &certificate.Resource{PrivateKey: key, Certificate: cert}
+}
 
 func TestNewManager(t *testing.T) {
 
@@ -90,98 +62,71 @@ func TestCreateManager_Success(t *testing.T) {
 
 func TestCertifierManager_releaseLock_Success(t *testing.T) {
 	ctx := appCtx.TestContext(nil)
-	cm := &CertifierManager{}
-	err := cm.releaseLock(ctx)
+	cm := &CertifierManager{ephemeralID: "test"}
+	lease, _ := cm.obtainLock(ctx)
+	err := cm.releaseLock(ctx, lease)
 	assert.NoError(t, err)
 }
 
-func TestCertifierManager_obtainLock_WithMemoryCache(t *testing.T) {
+func TestCertifierManager_releaseLock_FailNotHeld(t *testing.T) {
+	ctx := appCtx.TestContext(nil)
+	cm := &CertifierManager{ephemeralID: "test"}
+	err := cm.releaseLock(ctx, &types.Lease{Key: ProcessLockKey, Owner: "test"})
+	assert.ErrorIs(t, err, types.ErrLockNotHeld)
+}
+
+func TestCertifierManager_obtainLock_WithMemoryLocker(t *testing.T) {
 	ctx := appCtx.TestContext(nil)
 	cm := &CertifierManager{
 		ephemeralID: "test",
 	}
 	got, err := cm.obtainLock(ctx)
 	assert.NoError(t, err)
-	assert.True(t, got)
+	assert.Equal(t, ProcessLockKey, got.Key)
+	assert.Equal(t, "test", got.Owner)
+
+	other := &CertifierManager{
+		ephemeralID: "other",
+	}
+	got, err = other.obtainLock(ctx)
+	assert.NoError(t, err)
+	assert.Nil(t, got)
 }
 
 func TestCertifierManager_obtainLock(t *testing.T) {
 	ctx := appCtx.TestContext(nil)
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
-	cacheManager := mockTypes.NewMockCache[string](ctrl)
-	ctx.Cache = cacheManager
+	locker := mockTypes.NewMockLocker(ctrl)
+	ctx.Locker = locker
 	tests := []struct {
 		name     string
-		mockFunc func(cache *mockTypes.MockCache[string])
-		want     bool
+		mockFunc func(locker *mockTypes.MockLocker)
+		want     *types.Lease
 		wantErr  assert.ErrorAssertionFunc
 	}{
 		{
 			name: "Success",
-			mockFunc: func(cache *mockTypes.MockCache[string]) {
-				gomock.InOrder(
-					cacheManager.EXPECT().Get(gomock.Any(), gomock.Any()).Times(1).Return("", &store.NotFound{}),
-					cacheManager.EXPECT().Set(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Times(1).Return(nil),
-					cacheManager.EXPECT().Get(gomock.Any(), gomock.Any()).Times(1).Return("test", nil),
-				)
+			mockFunc: func(locker *mockTypes.MockLocker) {
+				locker.EXPECT().Acquire(gomock.Any(), ProcessLockKey, "test", ctx.Config.LockDuration).Times(1).Return(&types.Lease{Key: ProcessLockKey, Owner: "test", Token: 1}, nil)
 			},
-			want:    true,
+			want:    &types.Lease{Key: ProcessLockKey, Owner: "test", Token: 1},
 			wantErr: assert.NoError,
 		},
 		{
 			name: "SuccessAlreadyLock",
-			mockFunc: func(cache *mockTypes.MockCache[string]) {
-				gomock.InOrder(
-					cacheManager.EXPECT().Get(gomock.Any(), gomock.Any()).Times(1).Return("other", nil),
-				)
-			},
-			want:    false,
-			wantErr: assert.NoError,
-		},
-		{
-			name: "SuccessLockConcurrency",
-			mockFunc: func(cache *mockTypes.MockCache[string]) {
-				gomock.InOrder(
-					cacheManager.EXPECT().Get(gomock.Any(), gomock.Any()).Times(1).Return("", &store.NotFound{}),
-					cacheManager.EXPECT().Set(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Times(1).Return(nil),
-					cacheManager.EXPECT().Get(gomock.Any(), gomock.Any()).Times(1).Return("other", nil),
-				)
+			mockFunc: func(locker *mockTypes.MockLocker) {
+				locker.EXPECT().Acquire(gomock.Any(), ProcessLockKey, "test", ctx.Config.LockDuration).Times(1).Return(nil, nil)
 			},
-			want:    false,
+			want:    nil,
 			wantErr: assert.NoError,
 		},
 		{
-			name: "FailedGetError",
-			mockFunc: func(cache *mockTypes.MockCache[string]) {
-				gomock.InOrder(
-					cacheManager.EXPECT().Get(gomock.Any(), gomock.Any()).Times(1).Return("", errors.New("fail")),
-				)
+			name: "FailedAcquireError",
+			mockFunc: func(locker *mockTypes.MockLocker) {
+				locker.EXPECT().Acquire(gomock.Any(), ProcessLockKey, "test", ctx.Config.LockDuration).Times(1).Return(nil, errors.New("fail"))
 			},
-			want:    false,
-			wantErr: assert.Error,
-		},
-		{
-			name: "FailedSetError",
-			mockFunc: func(cache *mockTypes.MockCache[string]) {
-				gomock.InOrder(
-					cacheManager.EXPECT().Get(gomock.Any(), gomock.Any()).Times(1).Return("", &store.NotFound{}),
-					cacheManager.EXPECT().Set(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Times(1).Return(errors.New("fail")),
-				)
-			},
-			want:    false,
-			wantErr: assert.Error,
-		},
-		{
-			name: "FailedSecondGetError",
-			mockFunc: func(cache *mockTypes.MockCache[string]) {
-				gomock.InOrder(
-					cacheManager.EXPECT().Get(gomock.Any(), gomock.Any()).Times(1).Return("", &store.NotFound{}),
-					cacheManager.EXPECT().Set(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Times(1).Return(nil),
-					cacheManager.EXPECT().Get(gomock.Any(), gomock.Any()).Times(1).Return("", errors.New("fail")),
-				)
-			},
-			want:    false,
+			want:    nil,
 			wantErr: assert.Error,
 		},
 	}
@@ -190,7 +135,7 @@ func TestCertifierManager_obtainLock(t *testing.T) {
 			cm := &CertifierManager{
 				ephemeralID: "test",
 			}
-			tt.mockFunc(cacheManager)
+			tt.mockFunc(locker)
 			got, err := cm.obtainLock(ctx)
 			if !tt.wantErr(t, err, fmt.Sprintf("obtainLock(%v)", ctx)) {
 				return
@@ -200,6 +145,46 @@ func TestCertifierManager_obtainLock(t *testing.T) {
 	}
 }
 
+func TestCertifierManager_renewLock(t *testing.T) {
+	b := bytes.NewBufferString("")
+	ctx := appCtx.TestContext(b)
+	ctx.Config.LockDuration = time.Millisecond * 30
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	locker := mockTypes.NewMockLocker(ctrl)
+	ctx.Locker = locker
+	lease := &types.Lease{Key: ProcessLockKey, Owner: "test", Token: 1}
+	locker.EXPECT().Renew(gomock.Any(), lease, ctx.Config.LockDuration).MinTimes(1).Return(types.ErrLockNotHeld)
+
+	cm := &CertifierManager{ephemeralID: "test"}
+	runCtx, cancel := context.WithCancelCause(context.Background())
+	stop := cm.renewLock(ctx, lease, cancel)
+	time.Sleep(ctx.Config.LockDuration)
+	stop()
+	assert.Contains(t, b.String(), "lock manager_run_process_lock lost")
+	assert.ErrorIs(t, context.Cause(runCtx), types.ErrLockNotHeld)
+}
+
+func TestCertifierManager_renewLock_KeepOnError(t *testing.T) {
+	b := bytes.NewBufferString("")
+	ctx := appCtx.TestContext(b)
+	ctx.Config.LockDuration = time.Millisecond * 30
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	locker := mockTypes.NewMockLocker(ctrl)
+	ctx.Locker = locker
+	lease := &types.Lease{Key: ProcessLockKey, Owner: "test", Token: 1}
+	locker.EXPECT().Renew(gomock.Any(), lease, ctx.Config.LockDuration).MinTimes(1).Return(errors.New("error"))
+
+	cm := &CertifierManager{ephemeralID: "test"}
+	runCtx, cancel := context.WithCancelCause(context.Background())
+	stop := cm.renewLock(ctx, lease, cancel)
+	time.Sleep(ctx.Config.LockDuration)
+	stop()
+	assert.Contains(t, b.String(), "unable to renew lock manager_run_process_lock")
+	assert.NoError(t, context.Cause(runCtx))
+}
+
 func TestCertifierManager_FetchRequests_Success(t *testing.T) {
 	ctx := appCtx.TestContext(nil)
 	ctrl := gomock.NewController(t)
@@ -220,6 +205,22 @@ func TestCertifierManager_FetchRequests_Success(t *testing.T) {
 	assert.Equal(t, want, got)
 }
 
+func TestCertifierManager_FetchRequests_SuccessNormalize(t *testing.T) {
+	ctx := appCtx.TestContext(nil)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	r := mockTypes.NewMockRequester(ctrl)
+	request1 := &types.DomainRequest{Domains: types.Domains{"Example.COM", "bücher.example.com."}, Requester: r}
+	request2 := &types.DomainRequest{Domains: types.Domains{"foo_bar.example.com"}, Requester: r}
+	r.EXPECT().Fetch().Times(1).Return([]*types.DomainRequest{request1, request2}, nil)
+	r.EXPECT().ID().AnyTimes().Return("test")
+	ctx.Requesters = map[string]types.Requester{"test": r}
+	mgr := &CertifierManager{}
+	got, err := mgr.FetchRequests(ctx)
+	assert.Len(t, err, 0)
+	assert.Equal(t, []*types.DomainRequest{{Domains: types.Domains{"example.com", "xn--bcher-kva.example.com"}, Requester: r}}, got)
+}
+
 func TestCertifierManager_MatchingRequests(t *testing.T) {
 	ctx := appCtx.TestContext(nil)
 	ctrl := gomock.NewController(t)
@@ -295,6 +296,20 @@ func TestCertifierManager_MatchingRequests(t *testing.T) {
 
 			wantErr: assert.NoError,
 		},
+		{
+			name:  "SuccessNewCertIPv6",
+			state: &types.State{Certificates: types.Certificates{}},
+			wantFunc: func() types.Certificates {
+				cert := &types.Certificate{
+					Identifier: "2001_db8__1-0",
+					Main:       "2001:db8::1",
+					Domains:    types.Domains{types.Domain("2001:db8::1")},
+				}
+				return types.Certificates{cert}
+			},
+			domainsRequests: []*types.DomainRequest{{Requester: r, Domains: types.Domains{types.Domain("2001:db8::1")}}},
+			wantErr:         assert.NoError,
+		},
 		{
 			name:  "SuccessNewCertWithMultipleSameRequest",
 			state: &types.State{Certificates: types.Certificates{}},
@@ -330,6 +345,195 @@ func TestCertifierManager_MatchingRequests(t *testing.T) {
 	}
 }
 
+func TestCertifierManager_MatchingRequests_SANMerge(t *testing.T) {
+	ctx := appCtx.TestContext(nil)
+	ctx.Config.SANMerge = true
+	ctx.MetricsRegister = appProm.NewRegistry(types.NameServerMetrics, prometheus.NewRegistry())
+	resolvers := types.Resolvers{
+		types.DefaultKey: &appAcme.ResolverOffline{Id: types.DefaultKey, Filters: []string{"*"}, Type: typesAcme.TypeHTTP01},
+		"ovh":            &appAcme.ResolverOffline{Id: "ovh", Filters: []string{"foo.com"}, Type: "ovh"},
+	}
+	manyDomains := types.Domains{}
+	for i := 0; i < types.MaxDomainsPerCertificate; i++ {
+		manyDomains = append(manyDomains, types.Domain(fmt.Sprintf("%d.example.com", i)))
+	}
+
+	tests := []struct {
+		name         string
+		certificates types.Certificates
+		request      types.Domains
+		want         types.Certificates
+	}{
+		{
+			name:         "SuccessExtendExistingCertificate",
+			certificates: types.Certificates{{Identifier: "example.com-0", Main: "example.com", Domains: types.Domains{"example.com"}}},
+			request:      types.Domains{"example.com", "example2.com"},
+			want:         types.Certificates{{Identifier: "example.com-0", Main: "example.com", Domains: types.Domains{"example.com"}, PendingDomains: types.Domains{"example2.com"}}},
+		},
+		{
+			name:         "SuccessAlreadyPending",
+			certificates: types.Certificates{{Identifier: "example.com-0", Main: "example.com", Domains: types.Domains{"example.com"}, PendingDomains: types.Domains{"example2.com"}}},
+			request:      types.Domains{"example.com", "example2.com"},
+			want:         types.Certificates{{Identifier: "example.com-0", Main: "example.com", Domains: types.Domains{"example.com"}, PendingDomains: types.Domains{"example2.com"}}},
+		},
+		{
+			name:         "SuccessNewCertWithoutSharedDomain",
+			certificates: types.Certificates{{Identifier: "example.com-0", Main: "example.com", Domains: types.Domains{"example.com"}}},
+			request:      types.Domains{"example2.com"},
+			want: types.Certificates{
+				{Identifier: "example.com-0", Main: "example.com", Domains: types.Domains{"example.com"}},
+				{Identifier: "example2.com-0", Main: "example2.com", Domains: types.Domains{"example2.com"}},
+			},
+		},
+		{
+			name:         "SuccessNewCertWithOtherResolver",
+			certificates: types.Certificates{{Identifier: "foo.com-0", Main: "foo.com", Domains: types.Domains{"foo.com"}}},
+			request:      types.Domains{"example.com", "foo.com"},
+			want: types.Certificates{
+				{Identifier: "foo.com-0", Main: "foo.com", Domains: types.Domains{"foo.com"}},
+				{Identifier: "example.com-0", Main: "example.com", Domains: types.Domains{"example.com", "foo.com"}},
+			},
+		},
+		{
+			name:         "SuccessNewCertWithSANLimit",
+			certificates: types.Certificates{{Identifier: "0.example.com-0", Main: "0.example.com", Domains: manyDomains}},
+			request:      types.Domains{"0.example.com", "example.com"},
+			want: types.Certificates{
+				{Identifier: "0.example.com-0", Main: "0.example.com", Domains: manyDomains},
+				{Identifier: "0.example.com-1", Main: "0.example.com", Domains: types.Domains{"0.example.com", "example.com"}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cm := &CertifierManager{resolvers: resolvers}
+			state := &types.State{Certificates: tt.certificates}
+			cm.MatchingRequests(ctx, state, []*types.DomainRequest{{Domains: tt.request}})
+			assert.Equal(t, tt.want, state.Certificates)
+		})
+	}
+}
+
+func TestCertifierManager_ObtainCertificate_SuccessWithPendingDomains(t *testing.T) {
+	ctx := appCtx.TestContext(nil)
+	now := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+	resource := newCertificateResource(t, ctx, now, "example.com", "example2.com")
+	ctrl := gomock.NewController(t)
+	resolver := mockTypes.NewMockResolver(ctrl)
+	resolver.EXPECT().ID().AnyTimes().Return(types.DefaultKey)
+	resolver.EXPECT().TypeChallenge().AnyTimes().Return(typesAcme.TypeHTTP01)
+	resolver.EXPECT().Obtain(certificate.ObtainRequest{Domains: []string{"example.com", "example2.com"}, Bundle: true}).Times(1).Return(resource, nil)
+	cm := &CertifierManager{resolvers: types.Resolvers{types.DefaultKey: resolver}, clock: clockwork.NewFakeClockAt(now)}
+	cert := &types.Certificate{
+		Identifier:     "example.com-0",
+		Main:           "example.com",
+		Domains:        types.Domains{"example.com"},
+		PendingDomains: types.Domains{"example2.com"},
+		Key:            []byte("old"),
+		Certificate:    []byte("old"),
+	}
+
+	err := cm.ObtainCertificate(ctx, cert)
+	assert.NoError(t, err)
+	assert.Equal(t, "example.com-0", cert.Identifier)
+	assert.Equal(t, types.Domains{"example.com", "example2.com"}, cert.Domains)
+	assert.Empty(t, cert.PendingDomains)
+	assert.Equal(t, resource.Certificate, cert.Certificate)
+}
+
+func TestCertifierManager_ObtainCertificate_SuccessRenewWithPendingDomains(t *testing.T) {
+	ctx := appCtx.TestContext(nil)
+	ctx.Config.Acme.RenewPeriod = time.Hour * 24 * 30
+	now := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+	resource := newCertificateResource(t, ctx, now, "example.com")
+	ctrl := gomock.NewController(t)
+	resolver := mockTypes.NewMockResolver(ctrl)
+	resolver.EXPECT().ID().AnyTimes().Return(types.DefaultKey)
+	resolver.EXPECT().TypeChallenge().AnyTimes().Return(typesAcme.TypeHTTP01)
+	resolver.EXPECT().RenewWithOptions(gomock.Any(), gomock.Any()).Times(1).Return(resource, nil)
+	cm := &CertifierManager{resolvers: types.Resolvers{types.DefaultKey: resolver}, clock: clockwork.NewFakeClockAt(now)}
+	cert := &types.Certificate{
+		Identifier:      "example.com-0",
+		Domains:         types.Domains{"example.com"},
+		PendingDomains:  types.Domains{"example2.com"},
+		Key:             []byte("old"),
+		Certificate:     []byte("old"),
+		ExpirationDate:  time.Now().Add(time.Hour),
+		ObtainFailCount: 1,
+		ObtainFailDate:  now,
+	}
+
+	err := cm.ObtainCertificate(ctx, cert)
+	assert.NoError(t, err)
+	assert.Equal(t, types.Domains{"example.com"}, cert.Domains)
+	assert.Equal(t, types.Domains{"example2.com"}, cert.PendingDomains)
+	assert.Equal(t, resource.Certificate, cert.Certificate)
+	assert.Equal(t, 0, cert.ObtainFailCount)
+}
+
+func TestCertifierManager_ObtainCertificate_SuccessRemovedDomains(t *testing.T) {
+	ctx := appCtx.TestContext(nil)
+	now := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+	resource := newCertificateResource(t, ctx, now, "example.com")
+	ctrl := gomock.NewController(t)
+	resolver := mockTypes.NewMockResolver(ctrl)
+	resolver.EXPECT().ID().AnyTimes().Return(types.DefaultKey)
+	resolver.EXPECT().TypeChallenge().AnyTimes().Return(typesAcme.TypeHTTP01)
+	resolver.EXPECT().Obtain(gomock.Any()).Times(1).DoAndReturn(func(request legoCertificate.ObtainRequest) (*legoCertificate.Resource, error) {
+		assert.Equal(t, []string{"example.com"}, request.Domains)
+		return resource, nil
+	})
+	cm := &CertifierManager{resolvers: types.Resolvers{types.DefaultKey: resolver}, clock: clockwork.NewFakeClockAt(now)}
+	cert := &types.Certificate{
+		Identifier:     "example.com-0",
+		Domains:        types.Domains{"example.com", "example2.com"},
+		RemovedDomains: types.Domains{"example2.com"},
+		Key:            []byte("old"),
+		Certificate:    []byte("old"),
+		ExpirationDate: time.Now().Add(time.Hour * 24 * 60),
+	}
+
+	err := cm.ObtainCertificate(ctx, cert)
+	assert.NoError(t, err)
+	assert.Equal(t, types.Domains{"example.com"}, cert.Domains)
+	assert.Nil(t, cert.RemovedDomains)
+	assert.Equal(t, resource.Certificate, cert.Certificate)
+}
+
+func TestCertifierManager_ObtainCertificate_FailParkPendingDomains(t *testing.T) {
+	ctx := appCtx.TestContext(nil)
+	ctx.Config.Acme.MaxAttempt = 3
+	now := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+	ctrl := gomock.NewController(t)
+	resolver := mockTypes.NewMockResolver(ctrl)
+	resolver.EXPECT().ID().AnyTimes().Return(types.DefaultKey)
+	resolver.EXPECT().TypeChallenge().AnyTimes().Return(typesAcme.TypeHTTP01)
+	resolver.EXPECT().Obtain(gomock.Any()).Times(2).Return(nil, errors.New("fail"))
+	cm := &CertifierManager{resolvers: types.Resolvers{types.DefaultKey: resolver}, clock: clockwork.NewFakeClockAt(now)}
+	cert := &types.Certificate{
+		Identifier:      "example.com-0",
+		Domains:         types.Domains{"example.com"},
+		PendingDomains:  types.Domains{"example2.com"},
+		Key:             []byte("key"),
+		Certificate:     []byte("cert"),
+		ExpirationDate:  time.Now().Add(time.Hour * 24 * 60),
+		ObtainFailCount: 1,
+		ObtainFailDate:  now,
+	}
+
+	assert.Error(t, cm.ObtainCertificate(ctx, cert))
+	assert.Equal(t, 2, cert.ObtainFailCount)
+	assert.Equal(t, types.Domains{"example2.com"}, cert.PendingDomains)
+
+	assert.Error(t, cm.ObtainCertificate(ctx, cert))
+	assert.Empty(t, cert.PendingDomains)
+	assert.Equal(t, types.Domains{"example2.com"}, cert.ParkedDomains)
+	assert.Equal(t, now, cert.ParkedDate)
+	assert.Equal(t, 0, cert.ObtainFailCount)
+	assert.Equal(t, time.Time{}, cert.ObtainFailDate)
+	assert.Equal(t, "cert", string(cert.Certificate))
+}
+
 func TestCertifierManager_ObtainCertificates(t *testing.T) {
 	ctx := appCtx.TestContext(nil)
 	ctx.Config.Acme.RenewPeriod = time.Hour
@@ -367,7 +571,7 @@ func TestCertifierManager_ObtainCertificates(t *testing.T) {
 				},
 			},
 			mockFunc: func(resolver *mockTypes.MockResolver) {
-				resource := &certificate.Resource{PrivateKey: []byte("key"), Certificate: []byte(certPemResponseMock)}
+				resource := newCertificateResource(t, ctx, fakeNow, "example.com")
 				resolver.EXPECT().ID().AnyTimes().Return(types.DefaultKey)
 				resolver.EXPECT().TypeChallenge().Times(1).Return(typesAcme.TypeHTTP01)
 				resolver.EXPECT().Obtain(gomock.Any()).Times(1).Return(resource, nil)
@@ -378,6 +582,7 @@ func TestCertifierManager_ObtainCertificates(t *testing.T) {
 				assert.NotEmpty(t, cert.Key)
 				assert.NotEmpty(t, cert.Certificate)
 				assert.NotEmpty(t, cert.ExpirationDate)
+				assert.NotEmpty(t, cert.NotBefore)
 			},
 			wantErr: assert.NoError,
 		},
@@ -389,7 +594,7 @@ func TestCertifierManager_ObtainCertificates(t *testing.T) {
 				},
 			},
 			mockFunc: func(resolver *mockTypes.MockResolver) {
-				resource := &certificate.Resource{PrivateKey: []byte("key"), Certificate: []byte(certPemResponseMock)}
+				resource := newCertificateResource(t, ctx, fakeNow, "example.com")
 				resolver.EXPECT().ID().AnyTimes().Return(types.DefaultKey)
 				resolver.EXPECT().TypeChallenge().Times(1).Return(typesAcme.TypeHTTP01)
 				resolver.EXPECT().RenewWithOptions(gomock.Any(), gomock.Any()).Times(1).Return(resource, nil)
@@ -420,14 +625,14 @@ func TestCertifierManager_ObtainCertificates(t *testing.T) {
 				},
 			},
 			mockFunc: func(resolver *mockTypes.MockResolver) {
-				resource := &certificate.Resource{PrivateKey: []byte("newKey"), Certificate: []byte(certPemResponseMock)}
+				resource := newCertificateResource(t, ctx, fakeNow, "example.com")
 				resolver.EXPECT().TypeChallenge().Times(1).Return(typesAcme.TypeHTTP01)
 				resolver.EXPECT().RenewWithOptions(gomock.Any(), gomock.Any()).Times(1).Return(resource, nil)
 			},
 			checkFunc: func(t *testing.T, state *types.State) {
 				assert.Len(t, state.Certificates, 1)
 				cert := state.Certificates[0]
-				assert.Equal(t, "newKey", string(cert.Key))
+				assert.NotEqual(t, "key", string(cert.Key))
 				assert.NotEmpty(t, cert.Certificate)
 				assert.NotEmpty(t, cert.ExpirationDate)
 				assert.Equal(t, 0, cert.ObtainFailCount)
@@ -534,6 +739,27 @@ func TestCertifierManager_ObtainCertificates(t *testing.T) {
 			checkFunc: func(t *testing.T, state *types.State) {},
 			wantErr:   assert.Error,
 		},
+		{
+			name: "FailedInvalidMaterialKeepLastKnownGood",
+			state: &types.State{
+				Certificates: types.Certificates{
+					{Identifier: "foo", Main: "example.com", Domains: types.Domains{types.Domain("example.com")}, Certificate: []byte("cert"), Key: []byte("key"), ExpirationDate: time.Now().Add(time.Hour * -1)},
+				},
+			},
+			mockFunc: func(resolver *mockTypes.MockResolver) {
+				resource := newCertificateResource(t, ctx, fakeNow, "other.com")
+				resolver.EXPECT().TypeChallenge().Times(1).Return(typesAcme.TypeHTTP01)
+				resolver.EXPECT().RenewWithOptions(gomock.Any(), gomock.Any()).Times(1).Return(resource, nil)
+			},
+			checkFunc: func(t *testing.T, state *types.State) {
+				cert := state.Certificates[0]
+				assert.Equal(t, "cert", string(cert.Certificate))
+				assert.Equal(t, "key", string(cert.Key))
+				assert.Equal(t, 1, cert.ObtainFailCount)
+				assert.Equal(t, fakeNow, cert.ObtainFailDate)
+			},
+			wantErr: assert.Error,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -603,9 +829,9 @@ func TestCertifierManager_Run_SuccessHasLock(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
 	storage := mockTypesStorageState.NewMockStorage(ctrl)
-	storage.EXPECT().Load().Times(1).Return(&types.State{}, nil)
-	err := ctx.Cache.Set(context.Background(), CacheProcessLockKey, "other")
+	lease, err := ctx.Locker.Acquire(context.Background(), ProcessLockKey, "other", time.Minute)
 	assert.NoError(t, err)
+	assert.NotNil(t, lease)
 
 	ctx.MetricsRegister = appProm.NewRegistry(types.NameServerMetrics, prometheus.NewRegistry())
 
@@ -617,16 +843,44 @@ func TestCertifierManager_Run_SuccessHasLock(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestCertifierManager_Run_SuccessSaveWithFencingToken(t *testing.T) {
+	ctx := appCtx.TestContext(nil)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	resolver := mockTypes.NewMockResolver(ctrl)
+	resolvers := types.Resolvers{types.DefaultKey: resolver}
+
+	storage := mockTypesStorageState.NewMockStorage(ctrl)
+	account, _ := acme.NewAccount("foo@bar.com")
+	account.Registration = &registration.Resource{}
+	storage.EXPECT().Load().Times(1).Return(&types.State{Account: account, Certificates: types.Certificates{}}, nil)
+	storage.EXPECT().Save(gomock.Any()).Times(1).DoAndReturn(func(state *types.State) error {
+		assert.Greater(t, state.FencingToken, int64(0))
+		return nil
+	})
+
+	ctx.MetricsRegister = appProm.NewRegistry(types.NameServerMetrics, prometheus.NewRegistry())
+
+	cm := &CertifierManager{
+		ephemeralID:  "id",
+		stateStorage: storage,
+		resolvers:    resolvers,
+	}
+	err := cm.Run(ctx)
+	assert.NoError(t, err)
+	lease, _ := ctx.Locker.Acquire(context.Background(), ProcessLockKey, "other", time.Minute)
+	assert.NotNil(t, lease, "lock must be released after run")
+}
+
 func TestCertifierManager_Run_FailedObtainLock(t *testing.T) {
 	ctx := appCtx.TestContext(nil)
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
-	cache := mockTypes.NewMockCache[string](ctrl)
-	ctx.Cache = cache
-	cache.EXPECT().Get(gomock.Any(), gomock.Any()).Times(1).Return("", errors.New("error"))
+	locker := mockTypes.NewMockLocker(ctrl)
+	ctx.Locker = locker
+	locker.EXPECT().Acquire(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Times(1).Return(nil, errors.New("error"))
 
 	storage := mockTypesStorageState.NewMockStorage(ctrl)
-	storage.EXPECT().Load().Times(1).Return(&types.State{}, nil)
 
 	ctx.MetricsRegister = appProm.NewRegistry(types.NameServerMetrics, prometheus.NewRegistry())
 
@@ -642,13 +896,11 @@ func TestCertifierManager_Run_FailedReleaseLock(t *testing.T) {
 	ctx := appCtx.TestContext(nil)
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
-	cache := mockTypes.NewMockCache[string](ctrl)
-	ctx.Cache = cache
+	locker := mockTypes.NewMockLocker(ctrl)
+	ctx.Locker = locker
 	gomock.InOrder(
-		cache.EXPECT().Get(gomock.Any(), gomock.Any()).Times(1).Return("", &store.NotFound{}),
-		cache.EXPECT().Set(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Times(1).Return(nil),
-		cache.EXPECT().Get(gomock.Any(), gomock.Any()).Times(1).Return("id", nil),
-		cache.EXPECT().Delete(gomock.Any(), gomock.Any()).Times(1).Return(errors.New("error")),
+		locker.EXPECT().Acquire(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Times(1).Return(&types.Lease{Key: ProcessLockKey, Owner: "id", Token: 1}, nil),
+		locker.EXPECT().Release(gomock.Any(), gomock.Any()).Times(1).Return(errors.New("error")),
 	)
 	storage := mockTypesStorageState.NewMockStorage(ctrl)
 	account, _ := acme.NewAccount("foo@bar.com")
@@ -819,7 +1071,9 @@ func TestCertifierManager_Start(t *testing.T) {
 	time.Sleep(ctx.Config.Interval)
 	ctx.Cancel()
 	assert.Contains(t, b.String(), "tick received")
-	assert.Contains(t, b.String(), "stop asked by app, exiting")
+	assert.Eventually(t, func() bool {
+		return strings.Contains(b.String(), "stop asked by app, exiting")
+	}, time.Second, time.Millisecond*10)
 }
 
 func TestCertifierManager_StartFailFirstTick(t *testing.T) {
@@ -913,6 +1167,18 @@ func TestCertifierManager_CleanUnusedCertificates(t *testing.T) {
 			domainsRequests: []*types.DomainRequest{{Domains: types.Domains{"example.com"}}},
 			want:            types.Certificates{{Identifier: "foo", Main: "example.com", Domains: types.Domains{"example.com"}}},
 		},
+		{
+			name: "SuccessKeepDeclaredAndRetentionNever",
+			certificates: types.Certificates{
+				{Identifier: "foo", Main: "example.com", Domains: types.Domains{"example.com"}, Declared: true},
+				{Identifier: "bar", Main: "example2.com", Domains: types.Domains{"example2.com"}, Retention: types.RetentionNever, UnusedAt: fakeNow.Add(time.Minute * -2)},
+			},
+			domainsRequests: []*types.DomainRequest{},
+			want: types.Certificates{
+				{Identifier: "foo", Main: "example.com", Domains: types.Domains{"example.com"}, Declared: true},
+				{Identifier: "bar", Main: "example2.com", Domains: types.Domains{"example2.com"}, Retention: types.RetentionNever, UnusedAt: fakeNow.Add(time.Minute * -2)},
+			},
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -944,8 +1210,9 @@ func TestCertifierManager_MarkCertificatesAsReused(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
+			ctx := appCtx.TestContext(nil)
 			cm := &CertifierManager{}
-			cm.MarkCertificatesAsReused(tt.certificates, tt.domainsRequests)
+			cm.MarkCertificatesAsReused(ctx, tt.certificates, tt.domainsRequests)
 			for _, c := range tt.certificates {
 				assert.Equal(t, time.Time{}, c.UnusedAt)
 			}
@@ -957,9 +1224,411 @@ func TestCertifierManager_MarkCertificatesAsReused_StillNotUsed(t *testing.T) {
 	now := time.Now()
 	certificates := types.Certificates{{Identifier: "foo", Main: "example.com", Domains: types.Domains{"example.com"}, UnusedAt: now}}
 	domainsRequests := []*types.DomainRequest{}
+	ctx := appCtx.TestContext(nil)
 	cm := &CertifierManager{}
-	cm.MarkCertificatesAsReused(certificates, domainsRequests)
+	cm.MarkCertificatesAsReused(ctx, certificates, domainsRequests)
 	for _, c := range certificates {
 		assert.Equal(t, now, c.UnusedAt)
 	}
 }
+
+func TestCertifierManager_GetCertificateAction(t *testing.T) {
+	now := time.Now()
+	fakeClock := clockwork.NewFakeClockAt(now)
+	cm := &CertifierManager{clock: fakeClock}
+	httpResolver := &appAcme.ResolverOffline{Id: types.DefaultKey, Type: typesAcme.TypeHTTP01}
+	dnsResolver := &appAcme.ResolverOffline{Id: "ovh", Type: "ovh"}
+	// 90 days certificate issued 65 days ago
+	issued := &types.Certificate{Domains: types.Domains{"foo.com"}, Key: []byte("key"), Certificate: []byte("cert"), NotBefore: now.Add(-65 * 24 * time.Hour), ExpirationDate: now.Add(25 * 24 * time.Hour)}
+	tests := []struct {
+		name        string
+		resolver    types.Resolver
+		resolvers   map[string]config.ResolverConfig
+		renewAt     string
+		certificate *types.Certificate
+		want        CertificateAction
+	}{
+		{name: "WildcardUnsupported", resolver: httpResolver, certificate: &types.Certificate{Domains: types.Domains{"*.foo.com"}}, want: CertificateActionWildcardUnsupported},
+		{name: "SkipMaxAttempt", resolver: httpResolver, certificate: &types.Certificate{Domains: types.Domains{"foo.com"}, ObtainFailCount: 3, ObtainFailDate: now}, want: CertificateActionSkipMaxAttempt},
+		{name: "Obtain", resolver: httpResolver, certificate: &types.Certificate{Domains: types.Domains{"foo.com"}}, want: CertificateActionObtain},
+		{name: "ObtainPendingDomains", resolver: httpResolver, certificate: &types.Certificate{Domains: issued.Domains, PendingDomains: types.Domains{"bar.com"}, Key: issued.Key, Certificate: issued.Certificate, NotBefore: issued.NotBefore, ExpirationDate: issued.ExpirationDate}, want: CertificateActionObtain},
+		{name: "WildcardTLSALPNUnsupported", resolver: &appAcme.ResolverOffline{Id: "alpn", Type: typesAcme.TypeTLSALPN01}, certificate: &types.Certificate{Domains: types.Domains{"*.foo.com"}}, want: CertificateActionWildcardUnsupported},
+		{name: "IPUnsupported", resolver: dnsResolver, certificate: &types.Certificate{Domains: types.Domains{"foo.com", "192.0.2.1"}}, want: CertificateActionIPUnsupported},
+		{name: "ObtainIP", resolver: httpResolver, certificate: &types.Certificate{Domains: types.Domains{"2001:db8::1"}}, want: CertificateActionObtain},
+		{name: "WildcardPendingUnsupported", resolver: httpResolver, certificate: &types.Certificate{Domains: types.Domains{"foo.com"}, PendingDomains: types.Domains{"*.foo.com"}}, want: CertificateActionWildcardUnsupported},
+		{name: "ObtainPendingDomainsAfterFailureBeforeRenewDate", resolver: httpResolver, certificate: &types.Certificate{Domains: issued.Domains, PendingDomains: types.Domains{"bar.com"}, Key: issued.Key, Certificate: issued.Certificate, NotBefore: issued.NotBefore, ExpirationDate: issued.ExpirationDate, ObtainFailCount: 1, ObtainFailDate: now}, want: CertificateActionObtain},
+		{name: "ObtainRemovedDomains", resolver: httpResolver, certificate: &types.Certificate{Domains: types.Domains{"foo.com", "bar.com"}, RemovedDomains: types.Domains{"bar.com"}, Key: issued.Key, Certificate: issued.Certificate, NotBefore: issued.NotBefore, ExpirationDate: issued.ExpirationDate}, want: CertificateActionObtain},
+		{name: "RenewCurrentDomainsAfterFailedExtension", resolver: httpResolver, renewAt: "2/3", certificate: &types.Certificate{Domains: issued.Domains, PendingDomains: types.Domains{"*.foo.com"}, Key: issued.Key, Certificate: issued.Certificate, NotBefore: issued.NotBefore, ExpirationDate: issued.ExpirationDate, ObtainFailCount: 1, ObtainFailDate: now}, want: CertificateActionRenew},
+		{name: "NoneWithRenewPeriod", resolver: httpResolver, certificate: issued, want: CertificateActionNone},
+		{name: "RenewWithGlobalRenewAt", resolver: httpResolver, renewAt: "2/3", certificate: issued, want: CertificateActionRenew},
+		{name: "NoneWithResolverRenewPeriod", resolver: dnsResolver, renewAt: "2/3", resolvers: map[string]config.ResolverConfig{"ovh": {RenewPeriod: 24 * time.Hour}}, certificate: issued, want: CertificateActionNone},
+		{name: "RenewWithResolverRenewAt", resolver: dnsResolver, resolvers: map[string]config.ResolverConfig{"ovh": {RenewAt: "1/2"}}, certificate: issued, want: CertificateActionRenew},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := appCtx.TestContext(nil)
+			ctx.Config.Acme.RenewAt = tt.renewAt
+			if tt.resolvers != nil {
+				ctx.Config.Acme.Resolvers = tt.resolvers
+			}
+			assert.Equal(t, tt.want, cm.GetCertificateAction(ctx, tt.resolver, tt.certificate))
+		})
+	}
+}
+
+func TestCertifierManager_ObtainCertificates_Staging(t *testing.T) {
+	fakeNow := time.Date(1970, time.January, 1, 0, 0, 59, 0, time.UTC)
+	trustedCtx := appCtx.TestContext(nil)
+	// resource covers domains of the certificate before and after its extension
+	resource := newCertificateResource(t, trustedCtx, fakeNow, "example.com", "www.example.com")
+	tests := []struct {
+		name        string
+		certificate *types.Certificate
+		mockFunc    func(resolver *mockTypes.MockResolver, stagingResolver *mockTypes.MockResolver)
+		checkFunc   func(t *testing.T, cert *types.Certificate, err error)
+	}{
+		{
+			name: "SuccessStagingThenProduction",
+			mockFunc: func(resolver *mockTypes.MockResolver, stagingResolver *mockTypes.MockResolver) {
+				resolver.EXPECT().ID().AnyTimes().Return(types.DefaultKey)
+				resolver.EXPECT().TypeChallenge().Times(1).Return(typesAcme.TypeHTTP01)
+				stagingResolver.EXPECT().ID().AnyTimes().Return(types.DefaultKey)
+				gomock.InOrder(
+					stagingResolver.EXPECT().Obtain(gomock.Any()).Times(1).Return(&certificate.Resource{}, nil),
+					resolver.EXPECT().Obtain(gomock.Any()).Times(1).Return(resource, nil),
+				)
+			},
+			checkFunc: func(t *testing.T, cert *types.Certificate, err error) {
+				assert.NoError(t, err)
+				assert.NotEmpty(t, cert.Certificate)
+			},
+		},
+		{
+			name: "FailStagingNotCountAsProductionAttempt",
+			mockFunc: func(resolver *mockTypes.MockResolver, stagingResolver *mockTypes.MockResolver) {
+				resolver.EXPECT().ID().AnyTimes().Return(types.DefaultKey)
+				resolver.EXPECT().TypeChallenge().Times(1).Return(typesAcme.TypeHTTP01)
+				stagingResolver.EXPECT().ID().AnyTimes().Return(types.DefaultKey)
+				stagingResolver.EXPECT().Obtain(gomock.Any()).Times(1).Return(nil, errors.New("acme: error: 403 :: urn:ietf:params:acme:error:unauthorized"))
+			},
+			checkFunc: func(t *testing.T, cert *types.Certificate, err error) {
+				assert.ErrorContains(t, err, "unable to validate certificate foo on staging: acme: error: 403 :: urn:ietf:params:acme:error:unauthorized")
+				assert.Empty(t, cert.Certificate)
+				assert.Equal(t, 0, cert.ObtainFailCount)
+				assert.True(t, cert.ObtainFailDate.IsZero())
+				assert.Equal(t, fakeNow, cert.StagingFailDate)
+			},
+		},
+		{
+			name:        "SkipStagingFailedBeforeDelay",
+			certificate: &types.Certificate{Identifier: "foo", Main: "example.com", Domains: types.Domains{"example.com"}, StagingFailDate: fakeNow.Add(-time.Minute)},
+			mockFunc: func(resolver *mockTypes.MockResolver, stagingResolver *mockTypes.MockResolver) {
+				resolver.EXPECT().ID().AnyTimes().Return(types.DefaultKey)
+				resolver.EXPECT().TypeChallenge().Times(1).Return(typesAcme.TypeHTTP01)
+				stagingResolver.EXPECT().Obtain(gomock.Any()).Times(0)
+				resolver.EXPECT().Obtain(gomock.Any()).Times(0)
+			},
+			checkFunc: func(t *testing.T, cert *types.Certificate, err error) {
+				assert.NoError(t, err)
+				assert.Empty(t, cert.Certificate)
+				assert.Equal(t, fakeNow.Add(-time.Minute), cert.StagingFailDate)
+			},
+		},
+		{
+			name:        "SuccessStagingRetriedAfterDelay",
+			certificate: &types.Certificate{Identifier: "foo", Main: "example.com", Domains: types.Domains{"example.com"}, StagingFailDate: fakeNow.Add(-2 * time.Hour)},
+			mockFunc: func(resolver *mockTypes.MockResolver, stagingResolver *mockTypes.MockResolver) {
+				resolver.EXPECT().ID().AnyTimes().Return(types.DefaultKey)
+				resolver.EXPECT().TypeChallenge().Times(1).Return(typesAcme.TypeHTTP01)
+				stagingResolver.EXPECT().ID().AnyTimes().Return(types.DefaultKey)
+				gomock.InOrder(
+					stagingResolver.EXPECT().Obtain(gomock.Any()).Times(1).Return(&certificate.Resource{}, nil),
+					resolver.EXPECT().Obtain(gomock.Any()).Times(1).Return(resource, nil),
+				)
+			},
+			checkFunc: func(t *testing.T, cert *types.Certificate, err error) {
+				assert.NoError(t, err)
+				assert.NotEmpty(t, cert.Certificate)
+				assert.True(t, cert.StagingFailDate.IsZero())
+			},
+		},
+		{
+			name:        "SuccessExpiredWithoutStaging",
+			certificate: &types.Certificate{Identifier: "foo", Main: "example.com", Domains: types.Domains{"example.com"}, ExpirationDate: fakeNow.Add(-time.Hour)},
+			mockFunc: func(resolver *mockTypes.MockResolver, stagingResolver *mockTypes.MockResolver) {
+				resolver.EXPECT().ID().AnyTimes().Return(types.DefaultKey)
+				resolver.EXPECT().TypeChallenge().Times(1).Return(typesAcme.TypeHTTP01)
+				stagingResolver.EXPECT().Obtain(gomock.Any()).Times(0)
+				resolver.EXPECT().Obtain(gomock.Any()).Times(1).Return(resource, nil)
+			},
+			checkFunc: func(t *testing.T, cert *types.Certificate, err error) {
+				assert.NoError(t, err)
+				assert.NotEmpty(t, cert.Certificate)
+			},
+		},
+		{
+			name: "SuccessExtendWithoutStaging",
+			certificate: &types.Certificate{
+				Identifier: "foo", Main: "example.com", Domains: types.Domains{"example.com"}, PendingDomains: types.Domains{"www.example.com"},
+				Key: resource.PrivateKey, Certificate: resource.Certificate, ExpirationDate: fakeNow.Add(time.Hour * 24 * 90),
+			},
+			mockFunc: func(resolver *mockTypes.MockResolver, stagingResolver *mockTypes.MockResolver) {
+				resolver.EXPECT().ID().AnyTimes().Return(types.DefaultKey)
+				resolver.EXPECT().TypeChallenge().Times(1).Return(typesAcme.TypeHTTP01)
+				stagingResolver.EXPECT().Obtain(gomock.Any()).Times(0)
+				resolver.EXPECT().Obtain(gomock.Any()).Times(1).Return(resource, nil)
+			},
+			checkFunc: func(t *testing.T, cert *types.Certificate, err error) {
+				assert.NoError(t, err)
+				assert.Equal(t, types.Domains{"example.com", "www.example.com"}, cert.Domains)
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := appCtx.TestContext(nil)
+			ctx.Config.Acme.Staging.Enable = true
+			ctx.Config.Acme.DelayFailed = time.Hour
+			ctx.TrustedRoots = trustedCtx.TrustedRoots
+			ctrl := gomock.NewController(t)
+			resolver := mockTypes.NewMockResolver(ctrl)
+			stagingResolver := mockTypes.NewMockResolver(ctrl)
+			tt.mockFunc(resolver, stagingResolver)
+			cm := &CertifierManager{
+				ephemeralID:      "id",
+				resolvers:        types.Resolvers{types.DefaultKey: resolver},
+				stagingResolvers: types.Resolvers{types.DefaultKey: stagingResolver},
+				clock:            clockwork.NewFakeClockAt(fakeNow),
+			}
+			cert := tt.certificate
+			if cert == nil {
+				cert = &types.Certificate{Identifier: "foo", Main: "example.com", Domains: types.Domains{"example.com"}}
+			}
+			state := &types.State{Certificates: types.Certificates{cert}}
+			err := cm.ObtainCertificates(ctx, state)
+			tt.checkFunc(t, state.Certificates[0], err.ErrorOrNil())
+		})
+	}
+}
+
+func TestCertifierManager_initStaging(t *testing.T) {
+	registeredAccount, _ := typesAcme.NewAccount("foo@bar.com")
+	registeredAccount.Registration = &registration.Resource{}
+	tests := []struct {
+		name        string
+		state       *types.State
+		mockFunc    func(resolver *mockTypes.MockResolver, storage *mockTypesStorageState.MockStorage)
+		wantErr     bool
+		errContains string
+	}{
+		{
+			name:  "SuccessNewAccount",
+			state: &types.State{},
+			mockFunc: func(resolver *mockTypes.MockResolver, storage *mockTypesStorageState.MockStorage) {
+				resolver.EXPECT().Register(gomock.Any()).Times(1).Return(&registration.Resource{}, nil)
+				storage.EXPECT().Save(gomock.Any()).Times(1).Return(nil)
+			},
+		},
+		{
+			name:  "SuccessAlreadyRegistered",
+			state: &types.State{StagingAccount: registeredAccount},
+			mockFunc: func(resolver *mockTypes.MockResolver, storage *mockTypesStorageState.MockStorage) {
+			},
+		},
+		{
+			name:  "FailRegister",
+			state: &types.State{},
+			mockFunc: func(resolver *mockTypes.MockResolver, storage *mockTypesStorageState.MockStorage) {
+				resolver.EXPECT().Register(gomock.Any()).Times(1).Return(nil, errors.New("error"))
+			},
+			wantErr:     true,
+			errContains: "error when register ACME account: error",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := appCtx.TestContext(nil)
+			ctx.Config.Acme.Email = "foo@bar.com"
+			ctrl := gomock.NewController(t)
+			resolver := mockTypes.NewMockResolver(ctrl)
+			storage := mockTypesStorageState.NewMockStorage(ctrl)
+			tt.mockFunc(resolver, storage)
+			cm := &CertifierManager{stateStorage: storage, stagingResolvers: types.Resolvers{types.DefaultKey: resolver}}
+			err := cm.initStaging(ctx, tt.state)
+			if tt.wantErr {
+				assert.ErrorContains(t, err, tt.errContains)
+				return
+			}
+			assert.NoError(t, err)
+			assert.NotNil(t, tt.state.StagingAccount)
+			assert.NotNil(t, tt.state.StagingAccount.Registration)
+		})
+	}
+}
+
+func TestCertifierManager_ObtainCertificates_StopAsked(t *testing.T) {
+	ctx := appCtx.TestContext(nil)
+	ctrl := gomock.NewController(t)
+	resolver := mockTypes.NewMockResolver(ctrl)
+	cm := &CertifierManager{
+		resolvers: types.Resolvers{types.DefaultKey: resolver},
+		clock:     clockwork.NewFakeClock(),
+		stopAsked: true,
+	}
+	state := &types.State{Certificates: types.Certificates{{Identifier: "foo", Main: "example.com", Domains: types.Domains{"example.com"}}}}
+	err := cm.ObtainCertificates(ctx, state)
+	assert.NoError(t, err.ErrorOrNil())
+	assert.Empty(t, state.Certificates[0].Certificate)
+}
+
+func TestCertifierManager_ObtainCertificates_RunStopped(t *testing.T) {
+	ctx := appCtx.TestContext(nil)
+	ctrl := gomock.NewController(t)
+	resolver := mockTypes.NewMockResolver(ctrl)
+	runCtx, cancel := context.WithCancelCause(context.Background())
+	cancel(types.ErrLockNotHeld)
+	cm := &CertifierManager{
+		resolvers: types.Resolvers{types.DefaultKey: resolver},
+		clock:     clockwork.NewFakeClock(),
+		runCtx:    runCtx,
+	}
+	state := &types.State{Certificates: types.Certificates{{Identifier: "foo", Main: "example.com", Domains: types.Domains{"example.com"}}}}
+	err := cm.ObtainCertificates(ctx, state)
+	assert.ErrorContains(t, err.ErrorOrNil(), "run stopped")
+	assert.Empty(t, state.Certificates[0].Certificate)
+}
+
+func TestCertifierManager_Run_FailedLockLost(t *testing.T) {
+	ctx := appCtx.TestContext(nil)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	resolver := mockTypes.NewMockResolver(ctrl)
+	resolvers := types.Resolvers{types.DefaultKey: resolver}
+
+	storage := mockTypesStorageState.NewMockStorage(ctrl)
+	account, _ := acme.NewAccount("foo@bar.com")
+	account.Registration = &registration.Resource{}
+	cm := &CertifierManager{
+		ephemeralID:  "id",
+		stateStorage: storage,
+		resolvers:    resolvers,
+	}
+	storage.EXPECT().Load().Times(1).DoAndReturn(func() (*types.State, error) {
+		cm.cancelRun(types.ErrLockNotHeld)
+		return &types.State{Account: account, Certificates: types.Certificates{}}, nil
+	})
+	storage.EXPECT().Save(gomock.Any()).Times(0)
+
+	ctx.MetricsRegister = appProm.NewRegistry(types.NameServerMetrics, prometheus.NewRegistry())
+
+	err := cm.Run(ctx)
+	assert.ErrorContains(t, err, "run stopped, state is not saved")
+}
+
+func TestCertifierManager_isStopAsked(t *testing.T) {
+	ctx := appCtx.TestContext(nil)
+	cm := &CertifierManager{}
+	assert.False(t, cm.isStopAsked(ctx))
+
+	ctx.Cancel()
+	assert.Eventually(t, func() bool {
+		return cm.isStopAsked(ctx)
+	}, time.Second, time.Millisecond*10)
+	assert.True(t, cm.isStopAsked(ctx))
+}
+
+func TestCertifierManager_Abandon(t *testing.T) {
+	ctx := appCtx.TestContext(nil)
+	ctrl := gomock.NewController(t)
+	locker := mockTypes.NewMockLocker(ctrl)
+	ctx.Locker = locker
+	lease := &types.Lease{Key: ProcessLockKey, Owner: "id", Token: 1}
+	locker.EXPECT().Release(gomock.Any(), lease).Times(1).Return(nil)
+
+	cm := &CertifierManager{ephemeralID: "id"}
+	assert.NoError(t, cm.Abandon(ctx))
+
+	cm.runCtx, cm.cancelRun = context.WithCancelCause(context.Background())
+	cm.setLease(lease)
+	assert.NoError(t, cm.Abandon(ctx))
+	assert.Nil(t, cm.lease)
+	assert.ErrorIs(t, cm.runStopped(), errRunAbandoned)
+	// lease is not released twice by the run in progress
+	assert.False(t, cm.setLease(nil))
+}
+
+func TestCertifierManager_Abandon_CertificateLease(t *testing.T) {
+	ctx := appCtx.TestContext(nil)
+	ctrl := gomock.NewController(t)
+	locker := mockTypes.NewMockLocker(ctrl)
+	ctx.Locker = locker
+	certificateLease := &types.Lease{Key: CertificateLockKeyPrefix + "foo", Owner: "id", Token: 2}
+	locker.EXPECT().Release(gomock.Any(), certificateLease).Times(1).Return(errors.New("error"))
+
+	cm := &CertifierManager{ephemeralID: "id"}
+	cm.setCertificateLease(certificateLease)
+	assert.ErrorContains(t, cm.Abandon(ctx), "error")
+	assert.Nil(t, cm.certificateLease)
+	assert.False(t, cm.setCertificateLease(nil))
+}
+
+func TestCertifierManager_saveState_SuccessRebase(t *testing.T) {
+	logBuffer := &bytes.Buffer{}
+	ctx := appCtx.TestContext(logBuffer)
+	ctx.MetricsRegister = appProm.NewRegistry(types.NameServerMetrics, prometheus.NewRegistry())
+	cfg := ctx.Config.State
+	cfg.Type = storageState.FsKey
+	cfg.Config = map[string]interface{}{"path": "/app/state.json"}
+	storage, err := storageState.CreateStorage(ctx, cfg)
+	assert.NoError(t, err)
+	assert.NoError(t, storage.Save(&types.State{Account: &acme.Account{}, Certificates: types.Certificates{
+		{Identifier: "foo", Domains: types.Domains{"foo.com"}},
+		{Identifier: "bar", Domains: types.Domains{"bar.com"}},
+	}}))
+
+	base, _ := storage.Load()
+	state := base.Clone()
+	state.Certificates.GetCertificate("foo").ObtainFailCount = 1
+
+	// a command imports a certificate during the run
+	latest, _ := storage.Load()
+	latest.Certificates = append(latest.Certificates, &types.Certificate{Identifier: "baz", Domains: types.Domains{"baz.com"}})
+	assert.NoError(t, storage.Save(latest))
+
+	cm := &CertifierManager{stateStorage: storage}
+	got, err := cm.saveState(ctx, base, state)
+	assert.NoError(t, err)
+	assert.Contains(t, logBuffer.String(), "state was modified during run, apply changes on last saved state")
+	saved, _ := storage.Load()
+	assert.Equal(t, saved, got)
+	assert.Len(t, saved.Certificates, 3)
+	assert.Equal(t, 1, saved.Certificates.GetCertificate("foo").ObtainFailCount)
+	assert.NotNil(t, saved.Certificates.GetCertificate("baz"))
+	assert.Equal(t, int64(3), saved.Revision)
+}
+
+func TestCertifierManager_saveState_FailConflict(t *testing.T) {
+	ctx := appCtx.TestContext(nil)
+	ctx.MetricsRegister = appProm.NewRegistry(types.NameServerMetrics, prometheus.NewRegistry())
+	ctrl := gomock.NewController(t)
+	storage := mockTypesStorageState.NewMockStorage(ctrl)
+	storage.EXPECT().Save(gomock.Any()).Times(maxStateConflictRetries + 1).Return(types.ErrStateConflict)
+	storage.EXPECT().Load().Times(maxStateConflictRetries).Return(&types.State{}, nil)
+
+	cm := &CertifierManager{stateStorage: storage}
+	got, err := cm.saveState(ctx, &types.State{}, &types.State{})
+	assert.Nil(t, got)
+	assert.ErrorIs(t, err, types.ErrStateConflict)
+}
+
+func TestCertifierManager_saveState_FailLoad(t *testing.T) {
+	ctx := appCtx.TestContext(nil)
+	ctrl := gomock.NewController(t)
+	storage := mockTypesStorageState.NewMockStorage(ctrl)
+	storage.EXPECT().Save(gomock.Any()).Times(1).Return(types.ErrStateConflict)
+	storage.EXPECT().Load().Times(1).Return(nil, errors.New("fail"))
+
+	cm := &CertifierManager{stateStorage: storage}
+	_, err := cm.saveState(ctx, &types.State{}, &types.State{})
+	assert.EqualError(t, err, "failed to load state: fail")
+}
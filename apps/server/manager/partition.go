@@ -0,0 +1,232 @@
+package manager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/alexandreh2ag/lets-go-tls/apps/server/acme"
+	appCtx "github.com/alexandreh2ag/lets-go-tls/apps/server/context"
+	"github.com/alexandreh2ag/lets-go-tls/types"
+	"github.com/hashicorp/go-multierror"
+)
+
+const (
+	CertificateLockKeyPrefix = "certificate_lock_"
+
+	partitionLockRetryInterval = time.Millisecond * 500
+)
+
+// runPartition obtains certificates by claiming a lease per certificate, so replicas share the work.
+// A certificate with a lease held by a dead replica is picked up by another one after lease expiry.
+func (cm *CertifierManager) runPartition(ctx *appCtx.ServerContext, state *types.State) error {
+	if state == nil {
+		// process lock is held by another replica, work on last saved state
+		var errLoad error
+		state, errLoad = cm.stateStorage.Load()
+		if errLoad != nil {
+			return fmt.Errorf("failed to load state: %v", errLoad)
+		}
+		cm.initMetrics(ctx, state)
+		errInit := cm.initPartitionResolvers(ctx, state)
+		if errInit != nil {
+			return errInit
+		}
+	}
+
+	merr := &multierror.Error{}
+	for _, certificate := range state.Certificates {
+		if cm.isStopAsked(ctx) {
+			ctx.Logger.Info("stop asked by app, remaining certificates will be processed on next start")
+			break
+		}
+		if errStopped := cm.runStopped(); errStopped != nil {
+			merr = multierror.Append(merr, fmt.Errorf("run stopped, remaining certificates will be processed on next run: %v", errStopped))
+			break
+		}
+		if !cm.needObtain(ctx, certificate) {
+			continue
+		}
+		err := cm.obtainPartitionedCertificate(ctx, certificate.Identifier)
+		if err != nil {
+			merr = multierror.Append(merr, err)
+		}
+	}
+	cm.reportObtainErrors(ctx, merr)
+	return nil
+}
+
+// initPartitionResolvers creates resolvers from accounts registered by the replica holding process lock.
+func (cm *CertifierManager) initPartitionResolvers(ctx *appCtx.ServerContext, state *types.State) error {
+	var err error
+	if cm.resolvers == nil {
+		if state.Account == nil || state.Account.Registration == nil {
+			return fmt.Errorf("ACME account is not registered yet")
+		}
+		cm.resolvers, err = acme.CreateResolvers(ctx, state.Account)
+		if err != nil {
+			return err
+		}
+	}
+
+	if ctx.Config.Acme.Staging.Enable && cm.stagingResolvers == nil {
+		if state.StagingAccount == nil || state.StagingAccount.Registration == nil {
+			return fmt.Errorf("ACME staging account is not registered yet")
+		}
+		cm.stagingResolvers, err = acme.CreateStagingResolvers(ctx, state.StagingAccount)
+		if err != nil {
+			return fmt.Errorf("failed to create staging resolvers: %v", err)
+		}
+	}
+	return nil
+}
+
+func (cm *CertifierManager) needObtain(ctx *appCtx.ServerContext, certificate *types.Certificate) bool {
+	resolver := cm.resolvers.FindResolver(certificate)
+	switch cm.GetCertificateAction(ctx, resolver, certificate) {
+	case CertificateActionNone, CertificateActionSkipMaxAttempt, CertificateActionSkipStagingFailed:
+		return false
+	}
+	return true
+}
+
+// obtainPartitionedCertificate obtains a certificate while holding its lease and merges the result in last saved state.
+func (cm *CertifierManager) obtainPartitionedCertificate(ctx *appCtx.ServerContext, identifier string) error {
+	lease, errLock := ctx.Locker.Acquire(context.Background(), CertificateLockKeyPrefix+identifier, cm.ephemeralID, ctx.Config.LockDuration)
+	if errLock != nil {
+		return fmt.Errorf("unable to lock certificate %s with: %v", identifier, errLock)
+	}
+	if lease == nil {
+		ctx.Logger.Debug(fmt.Sprintf("certificate %s is processed by another replica", identifier))
+		return nil
+	}
+	cm.setCertificateLease(lease)
+	certificateCtx, cancelCertificate := context.WithCancelCause(context.Background())
+	defer cancelCertificate(nil)
+	stopRenewLock := cm.renewLock(ctx, lease, cancelCertificate)
+	defer func() {
+		stopRenewLock()
+		if !cm.setCertificateLease(nil) {
+			// lock already released by Abandon
+			return
+		}
+		errRelease := ctx.Locker.Release(context.Background(), lease)
+		if errRelease != nil {
+			ctx.Logger.Error(fmt.Sprintf("unable to unlock certificate %s with: %v", identifier, errRelease))
+		}
+	}()
+
+	// another replica may have processed the certificate before lease was acquired
+	state, errLoad := cm.stateStorage.Load()
+	if errLoad != nil {
+		return fmt.Errorf("failed to load state: %v", errLoad)
+	}
+	current := state.Certificates.GetCertificate(identifier)
+	if current == nil || !cm.needObtain(ctx, current) {
+		return nil
+	}
+
+	result := types.Certificates{current}.Clone()[0]
+	errObtain := cm.ObtainCertificate(ctx, result)
+
+	if errStopped := cm.runStopped(); errStopped != nil {
+		return errors.Join(errObtain, fmt.Errorf("run stopped, result of certificate %s is dropped: %v", identifier, errStopped))
+	}
+	if errLost := context.Cause(certificateCtx); errLost != nil {
+		return errors.Join(errObtain, fmt.Errorf("lease of certificate %s lost, result is dropped: %v", identifier, errLost))
+	}
+	errRenew := ctx.Locker.Renew(context.Background(), lease, ctx.Config.LockDuration)
+	if errRenew != nil {
+		return errors.Join(errObtain, fmt.Errorf("lease of certificate %s lost, result is dropped: %v", identifier, errRenew))
+	}
+
+	errMerge := cm.updateState(ctx, func(state *types.State) {
+		stored := state.Certificates.GetCertificate(identifier)
+		if stored == nil {
+			ctx.Logger.Warn(fmt.Sprintf("certificate %s removed during order, result is dropped", identifier))
+			return
+		}
+		mergeCertificate(stored, result)
+	})
+	return errors.Join(errObtain, errMerge)
+}
+
+// updateState applies a change on last saved state while holding process lock.
+func (cm *CertifierManager) updateState(ctx *appCtx.ServerContext, apply func(state *types.State)) error {
+	lease, errLock := cm.waitLock(ctx)
+	if errLock != nil {
+		return errLock
+	}
+	cm.setLease(lease)
+	defer func() {
+		if !cm.setLease(nil) {
+			// lock already released by Abandon
+			return
+		}
+		errRelease := cm.releaseLock(ctx, lease)
+		if errRelease != nil {
+			ctx.Logger.Error(fmt.Sprintf("unable to unlock manager process with: %v", errRelease))
+		}
+	}()
+
+	var errSave error
+	// state may be modified by a command while the lock is held, change is applied again on last saved state
+	for retry := 0; retry <= maxStateConflictRetries; retry++ {
+		state, errLoad := cm.stateStorage.Load()
+		if errLoad != nil {
+			return fmt.Errorf("failed to load state: %v", errLoad)
+		}
+		state.FencingToken = lease.Token
+		apply(state)
+		ctx.GetMetricsRegister().UpdateCertificatesMetrics(state.Certificates)
+		errSave = cm.stateStorage.Save(state)
+		if !errors.Is(errSave, types.ErrStateConflict) {
+			return errSave
+		}
+	}
+	return errSave
+}
+
+// waitLock waits for process lock, the wait can not exceed lock duration.
+func (cm *CertifierManager) waitLock(ctx *appCtx.ServerContext) (*types.Lease, error) {
+	deadline := cm.clock.Now().Add(ctx.Config.LockDuration)
+	for {
+		lease, err := cm.obtainLock(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("unable to lock manager process with: %v", err)
+		}
+		if lease != nil {
+			return lease, nil
+		}
+		if cm.clock.Now().After(deadline) {
+			return nil, fmt.Errorf("unable to lock manager process before %s", deadline.Format(time.RFC3339))
+		}
+		cm.clock.Sleep(partitionLockRetryInterval)
+	}
+}
+
+// mergeCertificate updates a stored certificate with the result of an order, the most recent certificate is kept.
+func mergeCertificate(stored *types.Certificate, result *types.Certificate) {
+	if result.Certificate != nil && !result.ExpirationDate.Before(stored.ExpirationDate) {
+		stored.Domains = result.Domains
+		stored.PendingDomains = slices.DeleteFunc(stored.PendingDomains, func(domain types.Domain) bool {
+			return result.Domains.Contains(domain)
+		})
+		stored.RemovedDomains = slices.DeleteFunc(stored.RemovedDomains, func(domain types.Domain) bool {
+			return !result.Domains.Contains(domain)
+		})
+		stored.Key = result.Key
+		stored.Certificate = result.Certificate
+		stored.ExpirationDate = result.ExpirationDate
+		stored.NotBefore = result.NotBefore
+	}
+	// pending domains parked by the order are no longer pending
+	stored.PendingDomains = slices.DeleteFunc(stored.PendingDomains, result.ParkedDomains.Contains)
+	stored.ParkedDomains = result.ParkedDomains
+	stored.ParkedDate = result.ParkedDate
+	stored.ObtainFailCount = result.ObtainFailCount
+	stored.ObtainFailDate = result.ObtainFailDate
+	stored.StagingFailDate = result.StagingFailDate
+}
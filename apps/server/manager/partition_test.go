@@ -0,0 +1,233 @@
+package manager
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	appCtx "github.com/alexandreh2ag/lets-go-tls/apps/server/context"
+	mockTypes "github.com/alexandreh2ag/lets-go-tls/mocks/types"
+	mockTypesStorageState "github.com/alexandreh2ag/lets-go-tls/mocks/types/storage/state"
+	appProm "github.com/alexandreh2ag/lets-go-tls/prometheus"
+	"github.com/alexandreh2ag/lets-go-tls/types"
+	typesAcme "github.com/alexandreh2ag/lets-go-tls/types/acme"
+	legoCertificate "github.com/go-acme/lego/v4/certificate"
+	"github.com/jonboulle/clockwork"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/mock/gomock"
+)
+
+func Test_mergeCertificate(t *testing.T) {
+	now := time.Date(2025, time.January, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name   string
+		stored *types.Certificate
+		result *types.Certificate
+		want   *types.Certificate
+	}{
+		{
+			name:   "SuccessNewCertificate",
+			stored: &types.Certificate{Identifier: "foo", ObtainFailCount: 1, ObtainFailDate: now},
+			result: &types.Certificate{Identifier: "foo", Key: []byte("key"), Certificate: []byte("cert"), ExpirationDate: now, NotBefore: now},
+			want:   &types.Certificate{Identifier: "foo", Key: []byte("key"), Certificate: []byte("cert"), ExpirationDate: now, NotBefore: now},
+		},
+		{
+			name:   "SuccessExtendedCertificate",
+			stored: &types.Certificate{Identifier: "foo", Domains: types.Domains{"foo.com"}, PendingDomains: types.Domains{"bar.com", "baz.com"}},
+			result: &types.Certificate{Identifier: "foo", Domains: types.Domains{"foo.com", "bar.com"}, Key: []byte("key"), Certificate: []byte("cert"), ExpirationDate: now, NotBefore: now},
+			want:   &types.Certificate{Identifier: "foo", Domains: types.Domains{"foo.com", "bar.com"}, PendingDomains: types.Domains{"baz.com"}, Key: []byte("key"), Certificate: []byte("cert"), ExpirationDate: now, NotBefore: now},
+		},
+		{
+			name:   "SuccessReissuedWithoutRemovedDomains",
+			stored: &types.Certificate{Identifier: "foo", Domains: types.Domains{"foo.com", "bar.com"}, RemovedDomains: types.Domains{"bar.com"}},
+			result: &types.Certificate{Identifier: "foo", Domains: types.Domains{"foo.com"}, Key: []byte("key"), Certificate: []byte("cert"), ExpirationDate: now, NotBefore: now},
+			want:   &types.Certificate{Identifier: "foo", Domains: types.Domains{"foo.com"}, RemovedDomains: types.Domains{}, Key: []byte("key"), Certificate: []byte("cert"), ExpirationDate: now, NotBefore: now},
+		},
+		{
+			name:   "SuccessParkedDomains",
+			stored: &types.Certificate{Identifier: "foo", Domains: types.Domains{"foo.com"}, PendingDomains: types.Domains{"bar.com", "baz.com"}, ObtainFailCount: 2, ObtainFailDate: now},
+			result: &types.Certificate{Identifier: "foo", Domains: types.Domains{"foo.com"}, ParkedDomains: types.Domains{"bar.com"}, ParkedDate: now},
+			want:   &types.Certificate{Identifier: "foo", Domains: types.Domains{"foo.com"}, PendingDomains: types.Domains{"baz.com"}, ParkedDomains: types.Domains{"bar.com"}, ParkedDate: now},
+		},
+		{
+			name:   "SuccessKeepMostRecentCertificate",
+			stored: &types.Certificate{Identifier: "foo", Key: []byte("key"), Certificate: []byte("cert"), ExpirationDate: now},
+			result: &types.Certificate{Identifier: "foo", Key: []byte("old"), Certificate: []byte("old"), ExpirationDate: now.Add(-time.Hour)},
+			want:   &types.Certificate{Identifier: "foo", Key: []byte("key"), Certificate: []byte("cert"), ExpirationDate: now},
+		},
+		{
+			name:   "SuccessFailedOrder",
+			stored: &types.Certificate{Identifier: "foo", Key: []byte("key"), Certificate: []byte("cert"), ExpirationDate: now},
+			result: &types.Certificate{Identifier: "foo", Key: []byte("key"), Certificate: []byte("cert"), ExpirationDate: now, ObtainFailCount: 1, ObtainFailDate: now},
+			want:   &types.Certificate{Identifier: "foo", Key: []byte("key"), Certificate: []byte("cert"), ExpirationDate: now, ObtainFailCount: 1, ObtainFailDate: now},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mergeCertificate(tt.stored, tt.result)
+			assert.Equal(t, tt.want, tt.stored)
+		})
+	}
+}
+
+func TestCertifierManager_obtainPartitionedCertificate_Success(t *testing.T) {
+	ctx := appCtx.TestContext(nil)
+	ctx.MetricsRegister = appProm.NewRegistry(types.NameServerMetrics, prometheus.NewRegistry())
+	ctrl := gomock.NewController(t)
+	resolver := mockTypes.NewMockResolver(ctrl)
+	resolver.EXPECT().ID().AnyTimes().Return(types.DefaultKey)
+	resolver.EXPECT().TypeChallenge().AnyTimes().Return(typesAcme.TypeHTTP01)
+	now := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+	resource := newCertificateResource(t, ctx, now, "example.com")
+	resolver.EXPECT().Obtain(gomock.Any()).Times(1).Return(resource, nil)
+
+	storage := mockTypesStorageState.NewMockStorage(ctrl)
+	storage.EXPECT().Load().Times(2).DoAndReturn(func() (*types.State, error) {
+		return &types.State{Certificates: types.Certificates{{Identifier: "foo", Main: "example.com", Domains: types.Domains{"example.com"}}}}, nil
+	})
+	storage.EXPECT().Save(gomock.Any()).Times(1).DoAndReturn(func(state *types.State) error {
+		assert.Greater(t, state.FencingToken, int64(0))
+		assert.Equal(t, resource.PrivateKey, state.Certificates[0].Key)
+		assert.NotEmpty(t, state.Certificates[0].Certificate)
+		return nil
+	})
+
+	cm := &CertifierManager{
+		ephemeralID:  "id",
+		stateStorage: storage,
+		resolvers:    types.Resolvers{types.DefaultKey: resolver},
+		clock:        clockwork.NewFakeClockAt(now),
+	}
+	err := cm.obtainPartitionedCertificate(ctx, "foo")
+	assert.NoError(t, err)
+	lease, _ := ctx.Locker.Acquire(context.Background(), CertificateLockKeyPrefix+"foo", "other", time.Minute)
+	assert.NotNil(t, lease, "certificate lock must be released")
+}
+
+func TestCertifierManager_obtainPartitionedCertificate_FailAbandoned(t *testing.T) {
+	ctx := appCtx.TestContext(nil)
+	ctx.MetricsRegister = appProm.NewRegistry(types.NameServerMetrics, prometheus.NewRegistry())
+	ctrl := gomock.NewController(t)
+	resolver := mockTypes.NewMockResolver(ctrl)
+	resolver.EXPECT().ID().AnyTimes().Return(types.DefaultKey)
+	resolver.EXPECT().TypeChallenge().AnyTimes().Return(typesAcme.TypeHTTP01)
+	now := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+	resource := newCertificateResource(t, ctx, now, "example.com")
+
+	storage := mockTypesStorageState.NewMockStorage(ctrl)
+	storage.EXPECT().Load().Times(1).Return(&types.State{Certificates: types.Certificates{{Identifier: "foo", Main: "example.com", Domains: types.Domains{"example.com"}}}}, nil)
+	storage.EXPECT().Save(gomock.Any()).Times(0)
+
+	cm := &CertifierManager{
+		ephemeralID:  "id",
+		stateStorage: storage,
+		resolvers:    types.Resolvers{types.DefaultKey: resolver},
+		clock:        clockwork.NewFakeClockAt(now),
+	}
+	cm.runCtx, cm.cancelRun = context.WithCancelCause(context.Background())
+	resolver.EXPECT().Obtain(gomock.Any()).Times(1).DoAndReturn(func(request legoCertificate.ObtainRequest) (*legoCertificate.Resource, error) {
+		assert.NoError(t, cm.Abandon(ctx))
+		lease, _ := ctx.Locker.Acquire(context.Background(), CertificateLockKeyPrefix+"foo", "other", time.Minute)
+		assert.NotNil(t, lease, "certificate lock must be released by abandon")
+		return resource, nil
+	})
+	err := cm.obtainPartitionedCertificate(ctx, "foo")
+	assert.ErrorContains(t, err, "run stopped, result of certificate foo is dropped")
+	assert.Nil(t, cm.certificateLease)
+}
+
+func TestCertifierManager_obtainPartitionedCertificate_SuccessLeaseHeldByOtherReplica(t *testing.T) {
+	ctx := appCtx.TestContext(nil)
+	ctrl := gomock.NewController(t)
+	storage := mockTypesStorageState.NewMockStorage(ctrl)
+	_, err := ctx.Locker.Acquire(context.Background(), CertificateLockKeyPrefix+"foo", "other", time.Minute)
+	assert.NoError(t, err)
+
+	cm := &CertifierManager{ephemeralID: "id", stateStorage: storage}
+	err = cm.obtainPartitionedCertificate(ctx, "foo")
+	assert.NoError(t, err)
+}
+
+func TestCertifierManager_obtainPartitionedCertificate_SuccessAlreadyProcessed(t *testing.T) {
+	ctx := appCtx.TestContext(nil)
+	ctrl := gomock.NewController(t)
+	resolver := mockTypes.NewMockResolver(ctrl)
+	resolver.EXPECT().ID().AnyTimes().Return(types.DefaultKey)
+	resolver.EXPECT().TypeChallenge().AnyTimes().Return(typesAcme.TypeHTTP01)
+	storage := mockTypesStorageState.NewMockStorage(ctrl)
+	now := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+	storage.EXPECT().Load().Times(1).Return(&types.State{Certificates: types.Certificates{
+		{Identifier: "foo", Main: "example.com", Domains: types.Domains{"example.com"}, Key: []byte("key"), Certificate: []byte("cert"), ExpirationDate: time.Now().Add(90 * 24 * time.Hour)},
+	}}, nil)
+
+	cm := &CertifierManager{
+		ephemeralID:  "id",
+		stateStorage: storage,
+		resolvers:    types.Resolvers{types.DefaultKey: resolver},
+		clock:        clockwork.NewFakeClockAt(now),
+	}
+	err := cm.obtainPartitionedCertificate(ctx, "foo")
+	assert.NoError(t, err)
+}
+
+func TestCertifierManager_runPartition_FailAccountNotRegistered(t *testing.T) {
+	ctx := appCtx.TestContext(nil)
+	ctx.MetricsRegister = appProm.NewRegistry(types.NameServerMetrics, prometheus.NewRegistry())
+	ctrl := gomock.NewController(t)
+	storage := mockTypesStorageState.NewMockStorage(ctrl)
+	account, _ := typesAcme.NewAccount("foo@bar.com")
+	storage.EXPECT().Load().Times(1).Return(&types.State{Account: account}, nil)
+
+	cm := &CertifierManager{ephemeralID: "id", stateStorage: storage}
+	err := cm.runPartition(ctx, nil)
+	assert.ErrorContains(t, err, "ACME account is not registered yet")
+}
+
+func TestCertifierManager_Run_SuccessPartitionHasLock(t *testing.T) {
+	ctx := appCtx.TestContext(nil)
+	ctx.Config.Partition.Enable = true
+	ctx.MetricsRegister = appProm.NewRegistry(types.NameServerMetrics, prometheus.NewRegistry())
+	ctrl := gomock.NewController(t)
+	resolver := mockTypes.NewMockResolver(ctrl)
+	resolver.EXPECT().ID().AnyTimes().Return(types.DefaultKey)
+	storage := mockTypesStorageState.NewMockStorage(ctrl)
+	storage.EXPECT().Load().Times(1).Return(&types.State{Certificates: types.Certificates{}}, nil)
+	_, err := ctx.Locker.Acquire(context.Background(), ProcessLockKey, "other", time.Minute)
+	assert.NoError(t, err)
+
+	cm := &CertifierManager{
+		ephemeralID:  "id",
+		stateStorage: storage,
+		resolvers:    types.Resolvers{types.DefaultKey: resolver},
+		clock:        clockwork.NewRealClock(),
+	}
+	err = cm.Run(ctx)
+	assert.NoError(t, err)
+}
+
+func TestCertifierManager_updateState_SuccessRetryOnConflict(t *testing.T) {
+	ctx := appCtx.TestContext(nil)
+	ctx.MetricsRegister = appProm.NewRegistry(types.NameServerMetrics, prometheus.NewRegistry())
+	ctrl := gomock.NewController(t)
+	storage := mockTypesStorageState.NewMockStorage(ctrl)
+	storage.EXPECT().Load().Times(2).DoAndReturn(func() (*types.State, error) {
+		return &types.State{Certificates: types.Certificates{{Identifier: "foo"}}}, nil
+	})
+	gomock.InOrder(
+		storage.EXPECT().Save(gomock.Any()).Times(1).Return(types.ErrStateConflict),
+		storage.EXPECT().Save(gomock.Any()).Times(1).DoAndReturn(func(state *types.State) error {
+			assert.Equal(t, 1, state.Certificates[0].ObtainFailCount)
+			return nil
+		}),
+	)
+
+	cm := &CertifierManager{ephemeralID: "id", stateStorage: storage, clock: clockwork.NewRealClock()}
+	applied := 0
+	err := cm.updateState(ctx, func(state *types.State) {
+		applied++
+		state.Certificates[0].ObtainFailCount++
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, applied)
+}
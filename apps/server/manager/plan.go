@@ -0,0 +1,148 @@
+package manager
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/alexandreh2ag/lets-go-tls/apps/server/acme"
+	appCtx "github.com/alexandreh2ag/lets-go-tls/apps/server/context"
+	"github.com/alexandreh2ag/lets-go-tls/types"
+)
+
+type PlanCertificate struct {
+	Identifier string        `json:"identifier"`
+	Domains    types.Domains `json:"domains"`
+	Resolver   string        `json:"resolver,omitempty"`
+	Reason     string        `json:"reason,omitempty"`
+}
+
+// Plan describes what a run of the manager would do on the state.
+type Plan struct {
+	Created     []PlanCertificate `json:"created"`
+	Obtained    []PlanCertificate `json:"obtained"`
+	Renewed     []PlanCertificate `json:"renewed"`
+	Skipped     []PlanCertificate `json:"skipped"`
+	Unused      []PlanCertificate `json:"unused"`
+	Deleted     []PlanCertificate `json:"deleted"`
+	FetchErrors map[string]string `json:"fetch_errors,omitempty"`
+}
+
+func NewPlan() *Plan {
+	return &Plan{
+		Created:  []PlanCertificate{},
+		Obtained: []PlanCertificate{},
+		Renewed:  []PlanCertificate{},
+		Skipped:  []PlanCertificate{},
+		Unused:   []PlanCertificate{},
+		Deleted:  []PlanCertificate{},
+	}
+}
+
+// Plan runs the same decisions as Run on a copy of the state, it never contacts the CA nor saves the state.
+func (cm *CertifierManager) Plan(ctx *appCtx.ServerContext) (*Plan, error) {
+	plan := NewPlan()
+	state, errLoad := cm.stateStorage.Load()
+	if errLoad != nil {
+		return nil, fmt.Errorf("failed to load state: %v", errLoad)
+	}
+
+	if cm.resolvers == nil {
+		cm.resolvers = acme.CreateOfflineResolvers(ctx)
+	}
+
+	planState := &types.State{Certificates: state.Certificates.Clone()}
+	existing := map[string]bool{}
+	for _, certificate := range planState.Certificates {
+		existing[certificate.Identifier] = true
+	}
+
+	domainsRequests, errFetch := cm.FetchRequests(ctx)
+	if len(errFetch) > 0 {
+		plan.FetchErrors = map[string]string{}
+		for id, err := range errFetch {
+			plan.FetchErrors[id] = err.Error()
+		}
+	}
+
+	cm.MatchingRequests(ctx, planState, domainsRequests)
+	if len(errFetch) == 0 {
+		cm.PrunePendingDomains(ctx, planState.Certificates, domainsRequests)
+	}
+
+	for _, certificate := range planState.Certificates {
+		resolver := cm.resolvers.FindResolver(certificate)
+		item := PlanCertificate{Identifier: certificate.Identifier, Domains: certificate.RequestedDomains(), Resolver: resolver.ID()}
+
+		switch cm.GetCertificateAction(ctx, resolver, certificate) {
+		case CertificateActionWildcardUnsupported:
+			item.Reason = "wildcard certificate requires a DNS challenge"
+			plan.Skipped = append(plan.Skipped, item)
+		case CertificateActionIPUnsupported:
+			item.Reason = "IP address certificate requires an HTTP or TLS-ALPN challenge"
+			plan.Skipped = append(plan.Skipped, item)
+		case CertificateActionSkipMaxAttempt:
+			item.Reason = fmt.Sprintf(
+				"max obtain attempts reached, retry after %s",
+				certificate.ObtainFailDate.Add(ctx.Config.Acme.DelayFailed).Format(time.RFC3339),
+			)
+			plan.Skipped = append(plan.Skipped, item)
+		case CertificateActionSkipStagingFailed:
+			item.Reason = fmt.Sprintf(
+				"staging validation failed, retry after %s",
+				certificate.StagingFailDate.Add(ctx.Config.Acme.DelayFailed).Format(time.RFC3339),
+			)
+			plan.Skipped = append(plan.Skipped, item)
+		case CertificateActionObtain:
+			item.Reason = reissueReason(certificate)
+			if existing[certificate.Identifier] {
+				plan.Obtained = append(plan.Obtained, item)
+			} else {
+				plan.Created = append(plan.Created, item)
+			}
+		case CertificateActionRenew:
+			if certificate.NeedReissue() {
+				item.Domains = certificate.Domains
+				item.Reason = fmt.Sprintf("%s failed, renew current domains", reissueReason(certificate))
+			}
+			plan.Renewed = append(plan.Renewed, item)
+		}
+	}
+
+	// same as Run, unused certificates are only handled when all requesters have been fetched
+	if len(errFetch) == 0 {
+		cm.MarkCertificatesAsReused(ctx, planState.Certificates, domainsRequests)
+
+		// CleanUnusedCertificates modify the slice and UnusedAt, keep previous values to compare
+		certificates := slices.Clone(planState.Certificates)
+		alreadyUnused := map[string]bool{}
+		for _, certificate := range certificates {
+			alreadyUnused[certificate.Identifier] = !certificate.UnusedAt.IsZero()
+		}
+
+		kept := cm.CleanUnusedCertificates(ctx, planState.Certificates, domainsRequests)
+		for _, certificate := range certificates {
+			item := PlanCertificate{Identifier: certificate.Identifier, Domains: certificate.Domains}
+			if kept.GetCertificate(certificate.Identifier) == nil {
+				plan.Deleted = append(plan.Deleted, item)
+			} else if !alreadyUnused[certificate.Identifier] && !certificate.UnusedAt.IsZero() {
+				plan.Unused = append(plan.Unused, item)
+			}
+		}
+	}
+
+	return plan, nil
+}
+
+// reissueReason describes domains changed by the next issue of a certificate, it is empty without change.
+func reissueReason(certificate *types.Certificate) string {
+	reasons := []string{}
+	if len(certificate.PendingDomains) > 0 {
+		reasons = append(reasons, fmt.Sprintf("add domains %v", certificate.PendingDomains.ToStringSlice()))
+	}
+	if len(certificate.RemovedDomains) > 0 {
+		reasons = append(reasons, fmt.Sprintf("remove domains %v", certificate.RemovedDomains.ToStringSlice()))
+	}
+	return strings.Join(reasons, ", ")
+}
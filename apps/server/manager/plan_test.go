@@ -0,0 +1,121 @@
+package manager
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alexandreh2ag/lets-go-tls/apps/server/acme"
+	appCtx "github.com/alexandreh2ag/lets-go-tls/apps/server/context"
+	mockTypes "github.com/alexandreh2ag/lets-go-tls/mocks/types"
+	mockTypesStorageState "github.com/alexandreh2ag/lets-go-tls/mocks/types/storage/state"
+	appProm "github.com/alexandreh2ag/lets-go-tls/prometheus"
+	"github.com/alexandreh2ag/lets-go-tls/types"
+	typesAcme "github.com/alexandreh2ag/lets-go-tls/types/acme"
+	"github.com/jonboulle/clockwork"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/mock/gomock"
+)
+
+func TestCertifierManager_Plan_Success(t *testing.T) {
+	ctx := appCtx.TestContext(nil)
+	ctx.MetricsRegister = appProm.NewRegistry(types.NameServerMetrics, prometheus.NewRegistry())
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	now := time.Now()
+	fakeClock := clockwork.NewFakeClockAt(now)
+
+	state := &types.State{Certificates: types.Certificates{
+		{Identifier: "valid", Domains: types.Domains{"valid.com"}, Key: []byte("key"), Certificate: []byte("cert"), ExpirationDate: now.Add(60 * 24 * time.Hour)},
+		{Identifier: "renew", Domains: types.Domains{"renew.foo.com"}, Key: []byte("key"), Certificate: []byte("cert"), ExpirationDate: now.Add(24 * time.Hour)},
+		{Identifier: "renew-key", Domains: types.Domains{"renew-key.com"}, Key: []byte("key"), Certificate: []byte("cert"), ExpirationDate: now.Add(24 * time.Hour), KeyType: "ec256"},
+		{Identifier: "failed", Domains: types.Domains{"failed.com"}},
+		{Identifier: "skip", Domains: types.Domains{"skip.com"}, ObtainFailCount: 3, ObtainFailDate: now},
+		{Identifier: "unused", Domains: types.Domains{"unused.com"}, Key: []byte("key"), Certificate: []byte("cert"), ExpirationDate: now.Add(60 * 24 * time.Hour)},
+		{Identifier: "old", Domains: types.Domains{"old.com"}, Key: []byte("key"), Certificate: []byte("cert"), ExpirationDate: now.Add(60 * 24 * time.Hour), UnusedAt: now.Add(-30 * 24 * time.Hour)},
+	}}
+	storage := mockTypesStorageState.NewMockStorage(ctrl)
+	storage.EXPECT().Load().Times(1).Return(state, nil)
+
+	requester := mockTypes.NewMockRequester(ctrl)
+	requester.EXPECT().Fetch().Times(1).Return([]*types.DomainRequest{
+		{Domains: types.Domains{"valid.com"}},
+		{Domains: types.Domains{"renew.foo.com"}},
+		{Domains: types.Domains{"renew-key.com"}},
+		{Domains: types.Domains{"failed.com"}},
+		{Domains: types.Domains{"skip.com"}},
+		{Domains: types.Domains{"new.com"}},
+		{Domains: types.Domains{"*.wild.com"}},
+	}, nil)
+	ctx.Requesters = types.Requesters{"foo": requester}
+
+	cm := &CertifierManager{
+		stateStorage: storage,
+		clock:        fakeClock,
+		resolvers: types.Resolvers{
+			types.DefaultKey: &acme.ResolverOffline{Id: types.DefaultKey, Filters: []string{"*"}, Type: typesAcme.TypeHTTP01},
+			"ovh":            &acme.ResolverOffline{Id: "ovh", Filters: []string{"foo.com"}, Type: "ovh"},
+		},
+	}
+	got, err := cm.Plan(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, []PlanCertificate{{Identifier: "new.com-0", Domains: types.Domains{"new.com"}, Resolver: types.DefaultKey}}, got.Created)
+	assert.Equal(t, []PlanCertificate{{Identifier: "failed", Domains: types.Domains{"failed.com"}, Resolver: types.DefaultKey}}, got.Obtained)
+	assert.Equal(t, []PlanCertificate{
+		{Identifier: "renew", Domains: types.Domains{"renew.foo.com"}, Resolver: "ovh"},
+		{Identifier: "renew-key", Domains: types.Domains{"renew-key.com"}, Resolver: types.DefaultKey},
+	}, got.Renewed)
+	assert.Len(t, got.Skipped, 2)
+	assert.Equal(t, "skip", got.Skipped[0].Identifier)
+	assert.Contains(t, got.Skipped[0].Reason, "max obtain attempts reached")
+	assert.Equal(t, "wildcard.wild.com-0", got.Skipped[1].Identifier)
+	assert.Equal(t, "wildcard certificate requires a DNS challenge", got.Skipped[1].Reason)
+	assert.Equal(t, []PlanCertificate{{Identifier: "unused", Domains: types.Domains{"unused.com"}}}, got.Unused)
+	assert.Equal(t, []PlanCertificate{{Identifier: "old", Domains: types.Domains{"old.com"}}}, got.Deleted)
+	assert.Nil(t, got.FetchErrors)
+
+	// loaded state must stay untouched
+	assert.Len(t, state.Certificates, 7)
+	assert.True(t, state.Certificates.GetCertificate("unused").UnusedAt.IsZero())
+	assert.Equal(t, 3, state.Certificates.GetCertificate("skip").ObtainFailCount)
+}
+
+func TestCertifierManager_Plan_SuccessWithFetchErrors(t *testing.T) {
+	ctx := appCtx.TestContext(nil)
+	ctx.MetricsRegister = appProm.NewRegistry(types.NameServerMetrics, prometheus.NewRegistry())
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	now := time.Now()
+
+	storage := mockTypesStorageState.NewMockStorage(ctrl)
+	storage.EXPECT().Load().Times(1).Return(&types.State{Certificates: types.Certificates{
+		{Identifier: "old", Domains: types.Domains{"old.com"}, Key: []byte("key"), Certificate: []byte("cert"), ExpirationDate: now.Add(60 * 24 * time.Hour), UnusedAt: now.Add(-30 * 24 * time.Hour)},
+	}}, nil)
+
+	requester := mockTypes.NewMockRequester(ctrl)
+	requester.EXPECT().ID().Times(1).Return("foo")
+	requester.EXPECT().Fetch().Times(1).Return(nil, errors.New("error"))
+	ctx.Requesters = types.Requesters{"foo": requester}
+
+	cm := &CertifierManager{stateStorage: storage, clock: clockwork.NewFakeClockAt(now)}
+	got, err := cm.Plan(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"foo": "error"}, got.FetchErrors)
+	assert.Empty(t, got.Deleted)
+	assert.Empty(t, got.Unused)
+	assert.Contains(t, cm.resolvers, types.DefaultKey)
+}
+
+func TestCertifierManager_Plan_FailLoadState(t *testing.T) {
+	ctx := appCtx.TestContext(nil)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	storage := mockTypesStorageState.NewMockStorage(ctrl)
+	storage.EXPECT().Load().Times(1).Return(nil, errors.New("error"))
+
+	cm := &CertifierManager{stateStorage: storage, clock: clockwork.NewRealClock()}
+	got, err := cm.Plan(ctx)
+	assert.Nil(t, got)
+	assert.ErrorContains(t, err, "failed to load state: error")
+}
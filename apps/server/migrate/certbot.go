@@ -71,6 +71,7 @@ func ReadCertbotCertificates(ctx *context.ServerContext, dirPath string) (types.
 		cert.Key = keyRaw
 		cert.Domains = domains
 		cert.ExpirationDate = x509Cert.NotAfter
+		cert.NotBefore = x509Cert.NotBefore
 		cert.ObtainFailCount = 0
 		cert.ObtainFailDate = time.Time{}
 		certificates = append(certificates, cert)
@@ -159,6 +159,7 @@ EquocxGnp4hY0Tb/HQnfI2xnyyehRANCAASxmwOgZEkkz7ARTYxMP3scm7dc51qM
 		Certificate:    cert,
 		Key:            key,
 		ExpirationDate: time.Date(2035, time.April, 7, 00, 41, 15, 0, time.UTC),
+		NotBefore:      time.Date(2025, time.April, 6, 12, 40, 15, 0, time.UTC),
 	}
 	tests := []struct {
 		name       string
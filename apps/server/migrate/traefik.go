@@ -77,6 +77,7 @@ func ReadTraefikData(ctx *context.ServerContext, acmePath string) (*types.State,
 				Certificate:     certData.Certificate,
 				Key:             certData.Key,
 				ExpirationDate:  x509Cert.NotAfter,
+				NotBefore:       x509Cert.NotBefore,
 				ObtainFailCount: 0,
 				ObtainFailDate:  time.Time{},
 			}
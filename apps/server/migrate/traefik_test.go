@@ -46,6 +46,7 @@ func TestReadTraefikData(t *testing.T) {
 		Certificate:    cert,
 		Key:            key,
 		ExpirationDate: time.Date(2035, time.April, 7, 00, 41, 15, 0, time.UTC),
+		NotBefore:      time.Date(2025, time.April, 6, 12, 40, 15, 0, time.UTC),
 	}
 	certificateRaw := "\"Certificates\": [{\"Store\": \"default\",\"domain\": {\"main\": \"dev.cert.aha\", \"sans\": [\"dev2.cert.aha\"]}, \"certificate\": \"" + certBase64 + "\",\"key\": \"" + keyBase64 + "\"}]"
 	tests := []struct {
@@ -1,6 +1,38 @@
 package config
 
+import "time"
+
 type StateConfig struct {
-	Type   string                 `mapstructure:"type" validate:"required,excludesall=!@#$ "`
-	Config map[string]interface{} `mapstructure:"config"`
+	Type       string                 `mapstructure:"type" validate:"required,excludesall=!@#$ "`
+	Config     map[string]interface{} `mapstructure:"config"`
+	Encryption EncryptionConfig       `mapstructure:"encryption"`
+	History    HistoryConfig          `mapstructure:"history"`
+}
+
+// HistoryConfig defines where previous versions of state are kept, a version is saved before each change.
+// Versions are kept by the state storage unless a path is set.
+type HistoryConfig struct {
+	Enable bool          `mapstructure:"enable"`
+	Path   string        `mapstructure:"path"`
+	Max    int           `mapstructure:"max" validate:"gte=0"`
+	MaxAge time.Duration `mapstructure:"max_age" validate:"gte=0"`
+}
+
+// EncryptionConfig defines keys used to encrypt state at rest.
+// The first key encrypts, all keys decrypt so a key can be rotated by adding the new key first.
+// Keys still decrypt when encryption is disabled, so state can be read back as plaintext.
+type EncryptionConfig struct {
+	Enable bool                  `mapstructure:"enable"`
+	Keys   []EncryptionKeyConfig `mapstructure:"keys" validate:"required_if=Enable true,omitempty,unique=ID,dive"`
+}
+
+// EncryptionKeyConfig defines a key encryption key read from a file, an environment variable (base64 of 32 bytes)
+// or a command wrapping and unwrapping data keys (ex: a KMS client).
+type EncryptionKeyConfig struct {
+	ID      string   `mapstructure:"id" validate:"required,excludesall=!@#$ "`
+	File    string   `mapstructure:"file" validate:"required_without_all=Env Command,excluded_with=Env Command"`
+	Env     string   `mapstructure:"env" validate:"excluded_with=File Command"`
+	Command []string `mapstructure:"command" validate:"excluded_with=File Env"`
+	// Timeout stops the command when it does not answer in time, default is 30s.
+	Timeout time.Duration `mapstructure:"timeout" validate:"gte=0"`
 }
@@ -0,0 +1,24 @@
+package config
+
+import (
+	"crypto/x509"
+
+	"github.com/alexandreh2ag/lets-go-tls/types"
+	"github.com/spf13/afero"
+)
+
+type ValidationConfig struct {
+	CABundle string `mapstructure:"ca_bundle"`
+}
+
+// TrustedRoots returns roots used to validate certificate material, nil (system roots) when no bundle is defined.
+func (v ValidationConfig) TrustedRoots(fs afero.Fs) (*x509.CertPool, error) {
+	if v.CABundle == "" {
+		return nil, nil
+	}
+	bundle, err := afero.ReadFile(fs, v.CABundle)
+	if err != nil {
+		return nil, err
+	}
+	return types.NewCertPool(bundle)
+}
@@ -1,6 +1,7 @@
 package context
 
 import (
+	"crypto/x509"
 	"github.com/alexandreh2ag/lets-go-tls/http"
 	"github.com/alexandreh2ag/lets-go-tls/prometheus"
 	"github.com/spf13/afero"
@@ -9,6 +10,7 @@ import (
 	"log/slog"
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
 )
 
@@ -38,9 +40,13 @@ type BaseContext struct {
 	Fs         afero.Fs
 	sigs       chan os.Signal
 	done       chan bool
+	cancelOnce sync.Once
 
 	HttpClient http.Client
 
+	// TrustedRoots are used to validate certificate material, system roots are used when nil.
+	TrustedRoots *x509.CertPool
+
 	MetricsRegister prometheus.Registry
 }
 
@@ -64,8 +70,11 @@ func (c *BaseContext) GetWorkingDir() string {
 	return c.WorkingDir
 }
 
+// Cancel asks the app to stop without blocking, every receiver of Done is notified and next calls do nothing.
 func (c *BaseContext) Cancel() {
-	c.done <- true
+	c.cancelOnce.Do(func() {
+		close(c.done)
+	})
 }
 
 func (c *BaseContext) Done() <-chan bool {
@@ -88,15 +88,13 @@ func TestTestContext_WithLogBuffer(t *testing.T) {
 func TestContext_Cancel(t *testing.T) {
 	ctx := &BaseContext{}
 	ctx.done = make(chan bool)
-	running := true
-	go func() {
-		select {
-		case <-ctx.done:
-			running = false
-		}
-	}()
 	ctx.Cancel()
-	assert.Equal(t, false, running)
+	// a second call does not block nor panic
+	ctx.Cancel()
+	_, open := <-ctx.Done()
+	assert.False(t, open)
+	_, open = <-ctx.Done()
+	assert.False(t, open)
 }
 
 func TestContext_Done(t *testing.T) {
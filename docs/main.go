@@ -12,6 +12,7 @@ import (
 	"github.com/alexandreh2ag/lets-go-tls/apps/server/acme/dns/gandiv5"
 	"github.com/alexandreh2ag/lets-go-tls/apps/server/acme/dns/httpreq"
 	"github.com/alexandreh2ag/lets-go-tls/apps/server/acme/dns/ovh"
+	"github.com/alexandreh2ag/lets-go-tls/apps/server/acme/tlsalpn"
 	serverConfig "github.com/alexandreh2ag/lets-go-tls/apps/server/config"
 	serverRequester "github.com/alexandreh2ag/lets-go-tls/apps/server/requester"
 	"github.com/alexandreh2ag/lets-go-tls/config"
@@ -20,6 +21,7 @@ import (
 	"github.com/alexandreh2ag/lets-go-tls/requester"
 	"github.com/alexandreh2ag/lets-go-tls/storage/state"
 	"github.com/alexandreh2ag/lets-go-tls/types"
+	typesAcme "github.com/alexandreh2ag/lets-go-tls/types/acme"
 	"gopkg.in/yaml.v2"
 )
 
@@ -61,6 +63,8 @@ func getServerConfig() serverConfig.Config {
 	serverCfg.Acme.Email = "acme@example.com"
 	serverCfg.JWT.Key = "superSecret"
 
+	serverCfg.Lock = serverConfig.LockConfig{Type: "memory"}
+
 	serverCfg.State.Type = state.FsKey
 
 	serverCfg.State.Config = decodeToMap(state.ConfigFs{Path: "/var/lib/lets-go-tls/state.json"})
@@ -83,6 +87,16 @@ func getServerConfig() serverConfig.Config {
 		},
 	}
 
+	serverCfg.Certificates = []serverConfig.CertificateConfig{
+		{
+			Identifier: "foo",
+			Domains:    types.Domains{"foo.com", "www.foo.com"},
+			Resolver:   ovh.KeyDnsOVH,
+			KeyType:    "ec256",
+			Retention:  types.RetentionNever,
+		},
+	}
+
 	serverCfg.Acme.Resolvers = map[string]serverConfig.ResolverConfig{
 		ovh.KeyDnsOVH: {
 			Type: ovh.KeyDnsOVH,
@@ -123,6 +137,11 @@ func getServerConfig() serverConfig.Config {
 			}),
 			Filters: []string{"foo.com"},
 		},
+		typesAcme.TypeTLSALPN01: {
+			Type:    typesAcme.TypeTLSALPN01,
+			Config:  decodeToMap(tlsalpn.ConfigTLSALPN{Port: "443"}),
+			Filters: []string{"192.0.2.1"},
+		},
 	}
 
 	return serverCfg
@@ -1,12 +1,15 @@
 package http
 
 import (
+	"context"
 	"crypto/tls"
+	"errors"
 	"fmt"
 	"github.com/alexandreh2ag/lets-go-tls/config"
 	"github.com/labstack/echo/v4"
 	echoMiddleware "github.com/labstack/echo/v4/middleware"
 	buildinHttp "net/http"
+	"sync"
 )
 
 const (
@@ -43,20 +46,40 @@ func CreateTLSConfig(tlsConfig config.TLSConfig) *tls.Config {
 	}
 }
 
-func StartServerHTTP(e *echo.Echo, listen string, tlsConfig *tls.Config) {
-	server := buildinHttp.Server{
-		Addr:    listen,
-		Handler: e,
+func NewServerHTTP(e *echo.Echo, listen string, tlsConfig *tls.Config) *buildinHttp.Server {
+	return &buildinHttp.Server{
+		Addr:      listen,
+		Handler:   e,
+		TLSConfig: tlsConfig,
 	}
+}
 
+// StartServerHTTP blocks until the server is stopped, nil is returned when it is closed by ShutdownServersHTTP.
+func StartServerHTTP(server *buildinHttp.Server) error {
 	var errStart error
-	if tlsConfig != nil {
-		server.TLSConfig = tlsConfig
+	if server.TLSConfig != nil {
 		errStart = server.ListenAndServeTLS("", "")
 	} else {
 		errStart = server.ListenAndServe()
 	}
-	if errStart != nil && errStart != buildinHttp.ErrServerClosed {
-		panic(fmt.Errorf("fail to start http server with %v", errStart))
+
+	if errStart != nil && !errors.Is(errStart, buildinHttp.ErrServerClosed) {
+		return fmt.Errorf("fail to start http server with %v", errStart)
+	}
+	return nil
+}
+
+// ShutdownServersHTTP stops accepting connections and waits for in-flight requests until ctx expires.
+func ShutdownServersHTTP(ctx context.Context, servers ...*buildinHttp.Server) error {
+	errs := make([]error, len(servers))
+	wg := sync.WaitGroup{}
+	for i, server := range servers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = server.Shutdown(ctx)
+		}()
 	}
+	wg.Wait()
+	return errors.Join(errs...)
 }
@@ -1,12 +1,18 @@
 package http
 
 import (
+	"context"
 	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	buildinHttp "net/http"
+	"testing"
+	"time"
+
 	"github.com/alexandreh2ag/lets-go-tls/config"
 	"github.com/labstack/echo/v4"
 	"github.com/stretchr/testify/assert"
-	"testing"
-	"time"
 )
 
 func TestCreateEcho(t *testing.T) {
@@ -20,19 +26,27 @@ func TestGetApiPrefix(t *testing.T) {
 	assert.Equal(t, "/api/certificates", res)
 }
 
-func TestStartServerHTTP_SuccessListenHTTP(t *testing.T) {
+func TestNewServerHTTP(t *testing.T) {
 	e := echo.New()
+	tlsConfig := &tls.Config{}
+	got := NewServerHTTP(e, "127.0.0.1:0", tlsConfig)
+	assert.Equal(t, "127.0.0.1:0", got.Addr)
+	assert.Equal(t, e, got.Handler)
+	assert.Equal(t, tlsConfig, got.TLSConfig)
+}
 
-	go assert.NotPanics(t, func() {
-		StartServerHTTP(e, "127.0.0.1:0", nil)
-	})
+func TestStartServerHTTP_SuccessListenHTTP(t *testing.T) {
+	server := NewServerHTTP(echo.New(), "127.0.0.1:0", nil)
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- StartServerHTTP(server)
+	}()
 	time.Sleep(200 * time.Millisecond)
-	_ = e.Close()
+	assert.NoError(t, ShutdownServersHTTP(context.Background(), server))
+	assert.NoError(t, <-errChan)
 }
 
 func TestStartServerHTTP_SuccessListenHTTPS(t *testing.T) {
-	e := echo.New()
-
 	tlsConfig := &tls.Config{
 		GetCertificate: func(info *tls.ClientHelloInfo) (*tls.Certificate, error) {
 			cert, errLoadCert := tls.LoadX509KeyPair("./fixtures/cert.crt", "./fixtures/priv.key")
@@ -42,20 +56,75 @@ func TestStartServerHTTP_SuccessListenHTTPS(t *testing.T) {
 			return &cert, nil
 		},
 	}
-	go assert.NotPanics(t, func() {
-		StartServerHTTP(e, "127.0.0.1:0", tlsConfig)
-	})
+	server := NewServerHTTP(echo.New(), "127.0.0.1:0", tlsConfig)
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- StartServerHTTP(server)
+	}()
 	time.Sleep(200 * time.Millisecond)
-	_ = e.Close()
+	assert.NoError(t, ShutdownServersHTTP(context.Background(), server))
+	assert.NoError(t, <-errChan)
 }
 
 func TestStartServerHTTP_FailListen(t *testing.T) {
+	server := NewServerHTTP(echo.New(), "127.0.0.1:0", &tls.Config{})
+	err := StartServerHTTP(server)
+	assert.ErrorContains(t, err, "fail to start http server with")
+}
+
+func TestShutdownServersHTTP_DrainInFlightRequest(t *testing.T) {
 	e := echo.New()
+	started := make(chan bool)
+	e.GET("/slow", func(c echo.Context) error {
+		close(started)
+		time.Sleep(300 * time.Millisecond)
+		return c.String(buildinHttp.StatusOK, "done")
+	})
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	server := NewServerHTTP(e, listener.Addr().String(), nil)
+	go func() {
+		_ = server.Serve(listener)
+	}()
 
-	tlsConfig := &tls.Config{}
-	assert.Panics(t, func() {
-		StartServerHTTP(e, "127.0.0.1:0", tlsConfig)
+	respChan := make(chan string, 1)
+	go func() {
+		resp, errGet := buildinHttp.Get(fmt.Sprintf("http://%s/slow", listener.Addr().String()))
+		if errGet != nil {
+			respChan <- errGet.Error()
+			return
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		respChan <- string(body)
+	}()
+	<-started
+	assert.NoError(t, ShutdownServersHTTP(context.Background(), server))
+	assert.Equal(t, "done", <-respChan)
+}
+
+func TestShutdownServersHTTP_FailGracePeriodExpired(t *testing.T) {
+	e := echo.New()
+	started := make(chan bool)
+	e.GET("/slow", func(c echo.Context) error {
+		close(started)
+		time.Sleep(500 * time.Millisecond)
+		return c.String(buildinHttp.StatusOK, "done")
 	})
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	server := NewServerHTTP(e, listener.Addr().String(), nil)
+	go func() {
+		_ = server.Serve(listener)
+	}()
+	go func() {
+		_, _ = buildinHttp.Get(fmt.Sprintf("http://%s/slow", listener.Addr().String()))
+	}()
+	<-started
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err = ShutdownServersHTTP(ctx, server)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
 }
 
 func TestCreateTLSConfig_Success(t *testing.T) {
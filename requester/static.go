@@ -1,8 +1,6 @@
 package requester
 
 import (
-	"slices"
-
 	"github.com/alexandreh2ag/lets-go-tls/config"
 	"github.com/alexandreh2ag/lets-go-tls/context"
 	"github.com/alexandreh2ag/lets-go-tls/mapstructure"
@@ -56,12 +54,5 @@ func createStaticProvider(_ context.Context, cfg config.RequesterConfig) (types.
 		instance.domainRequests = append(instance.domainRequests, request)
 	}
 
-	instance.domainRequests = slices.DeleteFunc(instance.domainRequests, func(item *types.DomainRequest) bool {
-		if item.IsIP() {
-			return true
-		}
-		return false
-	})
-
 	return instance, nil
 }
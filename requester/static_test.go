@@ -30,6 +30,7 @@ func Test_createStaticProvider(t *testing.T) {
 	want := &static{id: "foo"}
 	want.domainRequests = []*types.DomainRequest{
 		{Domains: types.Domains{"foo.com"}, Requester: want},
+		{Domains: types.Domains{"127.0.0.1"}, Requester: want},
 		{Domains: types.Domains{"bar.com", "foo.bar.com"}, Requester: want},
 	}
 	tests := []struct {
@@ -0,0 +1,389 @@
+package cli
+
+import (
+	"crypto/tls"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/alexandreh2ag/lets-go-tls/types"
+	"github.com/spf13/afero"
+	"github.com/spf13/cobra"
+)
+
+const dateFormat = "2006-01-02 15:04:05"
+
+// CertificateInfo describes a certificate of state without its private key.
+type CertificateInfo struct {
+	Identifier      string        `json:"identifier"`
+	Main            string        `json:"main,omitempty"`
+	Domains         types.Domains `json:"domains"`
+	PendingDomains  types.Domains `json:"pending_domains,omitempty"`
+	Valid           bool          `json:"valid"`
+	ExpirationDate  time.Time     `json:"expiration_date"`
+	NotBefore       time.Time     `json:"not_before"`
+	ObtainFailCount int           `json:"obtain_fail_count"`
+	ObtainFailDate  time.Time     `json:"obtain_fail_date"`
+	Unused          bool          `json:"unused"`
+	UnusedAt        time.Time     `json:"unused_at"`
+	Declared        bool          `json:"declared"`
+	Resolver        string        `json:"resolver,omitempty"`
+	KeyType         string        `json:"key_type,omitempty"`
+	Retention       string        `json:"retention,omitempty"`
+
+	// Subject, Issuer and SerialNumber come from the leaf certificate, they are only filled by show command.
+	Subject      string `json:"subject,omitempty"`
+	Issuer       string `json:"issuer,omitempty"`
+	SerialNumber string `json:"serial_number,omitempty"`
+}
+
+func newCertificateInfo(certificate *types.Certificate) CertificateInfo {
+	return CertificateInfo{
+		Identifier:      certificate.Identifier,
+		Main:            certificate.Main,
+		Domains:         certificate.Domains,
+		PendingDomains:  certificate.PendingDomains,
+		Valid:           certificate.IsValid(),
+		ExpirationDate:  certificate.ExpirationDate,
+		NotBefore:       certificate.NotBefore,
+		ObtainFailCount: certificate.ObtainFailCount,
+		ObtainFailDate:  certificate.ObtainFailDate,
+		Unused:          !certificate.UnusedAt.IsZero(),
+		UnusedAt:        certificate.UnusedAt,
+		Declared:        certificate.Declared,
+		Resolver:        certificate.Resolver,
+		KeyType:         certificate.KeyType,
+		Retention:       certificate.Retention,
+	}
+}
+
+func formatDate(date time.Time) string {
+	if date.IsZero() {
+		return "-"
+	}
+	return date.Format(dateFormat)
+}
+
+func (o Options) getCertificate(identifier string) (*types.State, *types.Certificate, error) {
+	st, err := o.Storage().Load()
+	if err != nil {
+		return nil, nil, err
+	}
+	certificate := st.Certificates.GetCertificate(identifier)
+	if certificate == nil {
+		return nil, nil, fmt.Errorf("certificate %s does not exist", identifier)
+	}
+	return st, certificate, nil
+}
+
+func GetListCmd(opts Options) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "list",
+		Short: "List certificates of state",
+		Args:  cobra.NoArgs,
+		RunE:  GetListRunFn(opts),
+	}
+	cmd.Flags().StringP("format", "f", FormatText, "Define output format (text, json)")
+	return cmd
+}
+
+func GetListRunFn(opts Options) func(*cobra.Command, []string) error {
+	return func(cmd *cobra.Command, args []string) error {
+		format, err := getFormat(cmd)
+		if err != nil {
+			return err
+		}
+		st, err := opts.Storage().Load()
+		if err != nil {
+			return err
+		}
+
+		infos := []CertificateInfo{}
+		for _, certificate := range st.Certificates {
+			infos = append(infos, newCertificateInfo(certificate))
+		}
+		if format == FormatJson {
+			return writeJson(cmd.OutOrStdout(), infos)
+		}
+		w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+		_, _ = fmt.Fprintln(w, "IDENTIFIER\tDOMAINS\tEXPIRATION\tFAILS\tUNUSED")
+		for _, info := range infos {
+			unused := "no"
+			if info.Unused {
+				unused = "since " + formatDate(info.UnusedAt)
+			}
+			_, _ = fmt.Fprintf(
+				w,
+				"%s\t%s\t%s\t%d\t%s\n",
+				info.Identifier, strings.Join(info.Domains.ToStringSlice(), ","), formatDate(info.ExpirationDate), info.ObtainFailCount, unused,
+			)
+		}
+		return w.Flush()
+	}
+}
+
+func GetShowCmd(opts Options) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "show <identifier>",
+		Short: "Show a certificate of state, private key is never shown",
+		Args:  cobra.ExactArgs(1),
+		RunE:  GetShowRunFn(opts),
+	}
+	cmd.Flags().StringP("format", "f", FormatText, "Define output format (text, json)")
+	return cmd
+}
+
+func GetShowRunFn(opts Options) func(*cobra.Command, []string) error {
+	return func(cmd *cobra.Command, args []string) error {
+		format, err := getFormat(cmd)
+		if err != nil {
+			return err
+		}
+		_, certificate, err := opts.getCertificate(args[0])
+		if err != nil {
+			return err
+		}
+
+		info := newCertificateInfo(certificate)
+		if certificate.Certificate != nil {
+			leaf, errLeaf := types.GetX509Certificate(certificate.Certificate)
+			if errLeaf != nil {
+				return fmt.Errorf("failed to parse certificate %s: %v", certificate.Identifier, errLeaf)
+			}
+			info.Subject = leaf.Subject.String()
+			info.Issuer = leaf.Issuer.String()
+			info.SerialNumber = leaf.SerialNumber.String()
+		}
+		if format == FormatJson {
+			return writeJson(cmd.OutOrStdout(), info)
+		}
+		writeCertificateText(cmd.OutOrStdout(), info)
+		return nil
+	}
+}
+
+func writeCertificateText(out io.Writer, info CertificateInfo) {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	lines := [][2]string{
+		{"Identifier", info.Identifier},
+		{"Main", info.Main},
+		{"Domains", strings.Join(info.Domains.ToStringSlice(), ", ")},
+		{"Pending domains", strings.Join(info.PendingDomains.ToStringSlice(), ", ")},
+		{"Valid", fmt.Sprintf("%t", info.Valid)},
+		{"Subject", info.Subject},
+		{"Issuer", info.Issuer},
+		{"Serial number", info.SerialNumber},
+		{"Not before", formatDate(info.NotBefore)},
+		{"Expiration", formatDate(info.ExpirationDate)},
+		{"Obtain fails", fmt.Sprintf("%d", info.ObtainFailCount)},
+		{"Last obtain fail", formatDate(info.ObtainFailDate)},
+		{"Unused since", formatDate(info.UnusedAt)},
+		{"Declared", fmt.Sprintf("%t", info.Declared)},
+		{"Resolver", info.Resolver},
+		{"Key type", info.KeyType},
+		{"Retention", info.Retention},
+	}
+	for _, line := range lines {
+		value := line[1]
+		if value == "" {
+			value = "-"
+		}
+		_, _ = fmt.Fprintf(w, "%s:\t%s\n", line[0], value)
+	}
+	_ = w.Flush()
+}
+
+func GetExportCmd(opts Options) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "export <identifier>",
+		Short: "Write cert.pem, chain.pem, fullchain.pem and key.pem of a certificate in a directory",
+		Args:  cobra.ExactArgs(1),
+		RunE:  GetExportRunFn(opts),
+	}
+	cmd.Flags().StringP("dir", "d", "", "Directory where files are written")
+	_ = cmd.MarkFlagRequired("dir")
+	return cmd
+}
+
+func GetExportRunFn(opts Options) func(*cobra.Command, []string) error {
+	return func(cmd *cobra.Command, args []string) error {
+		dir, _ := cmd.Flags().GetString("dir")
+		_, certificate, err := opts.getCertificate(args[0])
+		if err != nil {
+			return err
+		}
+		if !certificate.IsValid() {
+			return fmt.Errorf("certificate %s has not been obtained yet", certificate.Identifier)
+		}
+		blocks := splitCertificates(certificate.Certificate)
+		if len(blocks) == 0 {
+			return fmt.Errorf("failed to decode certificate %s", certificate.Identifier)
+		}
+
+		fs := opts.Ctx.GetFS()
+		err = fs.MkdirAll(dir, 0755)
+		if err != nil {
+			return fmt.Errorf("failed to create directory %s: %v", dir, err)
+		}
+		files := []struct {
+			name    string
+			content []byte
+			perm    os.FileMode
+		}{
+			{name: "cert.pem", content: blocks[0], perm: 0644},
+			{name: "chain.pem", content: slices.Concat(blocks[1:]...), perm: 0644},
+			{name: "fullchain.pem", content: slices.Concat(blocks...), perm: 0644},
+			{name: "key.pem", content: certificate.Key, perm: 0600},
+		}
+		for _, file := range files {
+			path := filepath.Join(dir, file.name)
+			errWrite := afero.WriteFile(fs, path, file.content, file.perm)
+			if errWrite != nil {
+				return fmt.Errorf("failed to write %s: %v", path, errWrite)
+			}
+		}
+		_, _ = fmt.Fprintf(cmd.OutOrStdout(), "Certificate %s exported in %s.\n", certificate.Identifier, dir)
+		return nil
+	}
+}
+
+// splitCertificates returns each CERTIFICATE block of a PEM bundle re-encoded, leaf first.
+func splitCertificates(bundle []byte) [][]byte {
+	blocks := [][]byte{}
+	for block, rest := pem.Decode(bundle); block != nil; block, rest = pem.Decode(rest) {
+		if block.Type == "CERTIFICATE" {
+			blocks = append(blocks, pem.EncodeToMemory(block))
+		}
+	}
+	return blocks
+}
+
+func GetDeleteCmd(opts Options) *cobra.Command {
+	return &cobra.Command{
+		Use:   "delete <identifier>",
+		Short: "Delete a certificate of state",
+		Args:  cobra.ExactArgs(1),
+		RunE:  GetDeleteRunFn(opts),
+	}
+}
+
+func GetDeleteRunFn(opts Options) func(*cobra.Command, []string) error {
+	return func(cmd *cobra.Command, args []string) error {
+		st, certificate, err := opts.getCertificate(args[0])
+		if err != nil {
+			return err
+		}
+		st.Certificates = st.Certificates.Deletes(types.Certificates{certificate})
+		err = opts.Storage().Save(st)
+		if err != nil {
+			return err
+		}
+		_, _ = fmt.Fprintf(cmd.OutOrStdout(), "Certificate %s deleted.\n", certificate.Identifier)
+		return nil
+	}
+}
+
+func GetImportCmd(opts Options) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "import <identifier>",
+		Short: "Import a certificate and its key in state, domains are read from certificate",
+		Args:  cobra.ExactArgs(1),
+		RunE:  GetImportRunFn(opts),
+	}
+	cmd.Flags().String("cert", "", "Path of PEM certificate, it may contain the chain")
+	cmd.Flags().String("key", "", "Path of PEM private key")
+	cmd.Flags().String("chain", "", "Path of PEM chain, appended to certificate")
+	cmd.Flags().Bool("force", false, "Replace material of an existing certificate")
+	_ = cmd.MarkFlagRequired("cert")
+	_ = cmd.MarkFlagRequired("key")
+	return cmd
+}
+
+func GetImportRunFn(opts Options) func(*cobra.Command, []string) error {
+	return func(cmd *cobra.Command, args []string) error {
+		identifier := args[0]
+		force, _ := cmd.Flags().GetBool("force")
+		fs := opts.Ctx.GetFS()
+
+		material := map[string][]byte{}
+		for _, name := range []string{"cert", "key", "chain"} {
+			path, _ := cmd.Flags().GetString(name)
+			if path == "" {
+				continue
+			}
+			content, err := afero.ReadFile(fs, path)
+			if err != nil {
+				return fmt.Errorf("failed to read %s: %v", path, err)
+			}
+			material[name] = content
+		}
+		bundle := material["cert"]
+		if chain, ok := material["chain"]; ok {
+			bundle = slices.Concat(bundle, []byte("\n"), chain)
+		}
+
+		keyPair, err := tls.X509KeyPair(bundle, material["key"])
+		if err != nil {
+			return fmt.Errorf("failed to load certificate: %v", err)
+		}
+		leaf := keyPair.Leaf
+		domains := types.Domains{}
+		for _, name := range leaf.DNSNames {
+			domains = append(domains, types.Domain(name))
+		}
+		for _, ip := range leaf.IPAddresses {
+			domains = append(domains, types.Domain(ip.String()))
+		}
+		if len(domains) == 0 {
+			return errors.New("certificate does not contain any domain")
+		}
+		domains, err = domains.Normalize()
+		if err != nil {
+			return fmt.Errorf("certificate contains an invalid domain: %v", err)
+		}
+		// material is checked like an issued certificate before it is distributed
+		imported := &types.Certificate{Identifier: identifier, Domains: domains, Certificate: bundle, Key: material["key"]}
+		err = imported.ValidateMaterial(opts.TrustedRoots(), time.Now())
+		if err != nil {
+			return fmt.Errorf("failed to validate certificate: %v", err)
+		}
+
+		st, err := opts.Storage().Load()
+		if err != nil {
+			return err
+		}
+		certificate := st.Certificates.GetCertificate(identifier)
+		if certificate != nil && !force {
+			return fmt.Errorf("certificate %s already exists, use --force to replace it", identifier)
+		}
+		if certificate == nil {
+			certificate = &types.Certificate{Identifier: identifier}
+			st.Certificates = append(st.Certificates, certificate)
+		}
+		certificate.Main = string(domains[0])
+		certificate.Domains = domains
+		certificate.PendingDomains = nil
+		certificate.RemovedDomains = nil
+		certificate.Certificate = bundle
+		certificate.Key = material["key"]
+		certificate.ExpirationDate = leaf.NotAfter
+		certificate.NotBefore = leaf.NotBefore
+		certificate.ObtainFailCount = 0
+		certificate.ObtainFailDate = time.Time{}
+		certificate.UnusedAt = time.Time{}
+
+		err = opts.Storage().Save(st)
+		if err != nil {
+			return err
+		}
+		_, _ = fmt.Fprintf(cmd.OutOrStdout(), "Certificate %s imported for %v.\n", identifier, domains.ToStringSlice())
+		return nil
+	}
+}
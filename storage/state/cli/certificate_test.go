@@ -0,0 +1,222 @@
+package cli
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"slices"
+	"testing"
+	"time"
+
+	appCtx "github.com/alexandreh2ag/lets-go-tls/context"
+	"github.com/alexandreh2ag/lets-go-tls/internal/testutil"
+	"github.com/alexandreh2ag/lets-go-tls/types"
+	"github.com/alexandreh2ag/lets-go-tls/types/acme"
+	"github.com/alexandreh2ag/lets-go-tls/types/storage/state"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+)
+
+func saveCertificates(t *testing.T, storage state.Storage, certificates types.Certificates) {
+	current, err := storage.Load()
+	assert.NoError(t, err)
+	assert.NoError(t, storage.Save(&types.State{Account: &acme.Account{}, Certificates: certificates, Revision: current.Revision}))
+}
+
+func issueCertificate(t *testing.T, domains ...string) (*testutil.CertificateAuthority, []byte, []byte) {
+	now := time.Now()
+	ca := testutil.NewCertificateAuthority(t, now.Add(-time.Hour), now.Add(time.Hour*24*365))
+	cert, key := ca.Issue(t, domains, now.Add(-time.Hour), now.Add(time.Hour*24*90))
+	return ca, cert, key
+}
+
+func TestGetListRunFn(t *testing.T) {
+	opts, storage := prepareOptions(t, false)
+	expiration := time.Date(2026, 12, 1, 10, 0, 0, 0, time.UTC)
+	unusedAt := time.Date(2026, 10, 1, 10, 0, 0, 0, time.UTC)
+	saveCertificates(t, storage, types.Certificates{
+		{Identifier: "foo", Domains: types.Domains{"foo.com", "www.foo.com"}, ExpirationDate: expiration, ObtainFailCount: 2, Key: []byte("secret")},
+		{Identifier: "bar", Domains: types.Domains{"bar.com"}, UnusedAt: unusedAt},
+	})
+
+	out, err := execute(t, opts, "list")
+	assert.NoError(t, err)
+	assert.Equal(t, ""+
+		"IDENTIFIER  DOMAINS              EXPIRATION           FAILS  UNUSED\n"+
+		"foo         foo.com,www.foo.com  2026-12-01 10:00:00  2      no\n"+
+		"bar         bar.com              -                    0      since 2026-10-01 10:00:00\n",
+		out,
+	)
+
+	out, err = execute(t, opts, "list", "--format", "json")
+	assert.NoError(t, err)
+	assert.NotContains(t, out, "secret")
+	infos := []CertificateInfo{}
+	assert.NoError(t, json.Unmarshal([]byte(out), &infos))
+	assert.Len(t, infos, 2)
+	assert.Equal(t, 2, infos[0].ObtainFailCount)
+	assert.False(t, infos[0].Unused)
+	assert.True(t, infos[1].Unused)
+	assert.Equal(t, unusedAt, infos[1].UnusedAt)
+
+	_, err = execute(t, opts, "list", "--format", "yaml")
+	assert.EqualError(t, err, "format yaml is not supported")
+}
+
+func TestGetShowRunFn(t *testing.T) {
+	opts, storage := prepareOptions(t, false)
+	_, cert, key := issueCertificate(t, "foo.com")
+	saveCertificates(t, storage, types.Certificates{
+		{Identifier: "foo", Domains: types.Domains{"foo.com"}, Certificate: cert, Key: key, Resolver: "default"},
+	})
+
+	out, err := execute(t, opts, "show", "foo")
+	assert.NoError(t, err)
+	assert.Contains(t, out, "Domains:           foo.com\n")
+	assert.Contains(t, out, "Issuer:            CN=lets-go-tls test CA\n")
+	assert.Contains(t, out, "Resolver:          default\n")
+	assert.NotContains(t, out, "PRIVATE KEY")
+
+	out, err = execute(t, opts, "show", "foo", "--format", "json")
+	assert.NoError(t, err)
+	info := CertificateInfo{}
+	assert.NoError(t, json.Unmarshal([]byte(out), &info))
+	assert.Equal(t, "CN=foo.com", info.Subject)
+	assert.True(t, info.Valid)
+
+	_, err = execute(t, opts, "show", "missing")
+	assert.EqualError(t, err, "certificate missing does not exist")
+}
+
+func TestGetExportRunFn(t *testing.T) {
+	opts, storage := prepareOptions(t, false)
+	ca, cert, key := issueCertificate(t, "foo.com")
+	saveCertificates(t, storage, types.Certificates{
+		{Identifier: "foo", Domains: types.Domains{"foo.com"}, Certificate: slices.Concat(cert, []byte("\n"), ca.PEM), Key: key},
+		{Identifier: "pending", Domains: types.Domains{"bar.com"}},
+	})
+
+	out, err := execute(t, opts, "export", "foo", "--dir", "/export")
+	assert.NoError(t, err)
+	assert.Equal(t, "Certificate foo exported in /export.\n", out)
+	fs := opts.Ctx.GetFS()
+	for path, want := range map[string][]byte{
+		"/export/cert.pem":      cert,
+		"/export/chain.pem":     ca.PEM,
+		"/export/fullchain.pem": slices.Concat(cert, ca.PEM),
+		"/export/key.pem":       key,
+	} {
+		got, errRead := afero.ReadFile(fs, path)
+		assert.NoError(t, errRead)
+		assert.Equal(t, string(want), string(got), path)
+	}
+	info, _ := fs.Stat("/export/key.pem")
+	assert.Equal(t, "-rw-------", info.Mode().String())
+
+	_, err = execute(t, opts, "export", "pending", "--dir", "/export")
+	assert.EqualError(t, err, "certificate pending has not been obtained yet")
+	_, err = execute(t, opts, "export", "missing", "--dir", "/export")
+	assert.EqualError(t, err, "certificate missing does not exist")
+	_, err = execute(t, opts, "export", "foo")
+	assert.ErrorContains(t, err, `required flag(s) "dir" not set`)
+}
+
+func TestGetExportRunFn_FailWrite(t *testing.T) {
+	opts, storage := prepareOptions(t, false)
+	_, cert, key := issueCertificate(t, "foo.com")
+	saveCertificates(t, storage, types.Certificates{{Identifier: "foo", Domains: types.Domains{"foo.com"}, Certificate: cert, Key: key}})
+	ctx := opts.Ctx.(*appCtx.BaseContext)
+	ctx.Fs = afero.NewReadOnlyFs(ctx.Fs)
+
+	_, err := execute(t, opts, "export", "foo", "--dir", "/export")
+	assert.ErrorContains(t, err, "failed to create directory /export")
+}
+
+func TestGetDeleteRunFn(t *testing.T) {
+	opts, storage := prepareOptions(t, false)
+	saveCertificates(t, storage, types.Certificates{
+		{Identifier: "foo", Domains: types.Domains{"foo.com"}},
+		{Identifier: "bar", Domains: types.Domains{"bar.com"}},
+	})
+
+	out, err := execute(t, opts, "delete", "foo")
+	assert.NoError(t, err)
+	assert.Equal(t, "Certificate foo deleted.\n", out)
+	got, _ := storage.Load()
+	assert.Nil(t, got.Certificates.GetCertificate("foo"))
+	assert.NotNil(t, got.Certificates.GetCertificate("bar"))
+
+	_, err = execute(t, opts, "delete", "foo")
+	assert.EqualError(t, err, "certificate foo does not exist")
+}
+
+func TestGetImportRunFn(t *testing.T) {
+	opts, storage := prepareOptions(t, false)
+	ca, cert, key := issueCertificate(t, "Foo.COM", "127.0.0.1")
+	opts.TrustedRoots = func() *x509.CertPool { return ca.Pool }
+	fs := opts.Ctx.GetFS()
+	_ = afero.WriteFile(fs, "/import/cert.pem", cert, 0644)
+	_ = afero.WriteFile(fs, "/import/chain.pem", ca.PEM, 0644)
+	_ = afero.WriteFile(fs, "/import/key.pem", key, 0600)
+	saveCertificates(t, storage, types.Certificates{{Identifier: "bar", Domains: types.Domains{"bar.com"}, ObtainFailCount: 3, Resolver: "default"}})
+
+	out, err := execute(t, opts, "import", "foo", "--cert", "/import/cert.pem", "--chain", "/import/chain.pem", "--key", "/import/key.pem")
+	assert.NoError(t, err)
+	assert.Equal(t, "Certificate foo imported for [foo.com 127.0.0.1].\n", out)
+	got, _ := storage.Load()
+	imported := got.Certificates.GetCertificate("foo")
+	assert.Equal(t, types.Domains{"foo.com", "127.0.0.1"}, imported.Domains)
+	assert.Equal(t, "foo.com", imported.Main)
+	assert.Equal(t, key, imported.Key)
+	assert.Len(t, splitCertificates(imported.Certificate), 2)
+	assert.False(t, imported.ExpirationDate.IsZero())
+
+	_, err = execute(t, opts, "import", "bar", "--cert", "/import/cert.pem", "--key", "/import/key.pem")
+	assert.EqualError(t, err, "certificate bar already exists, use --force to replace it")
+
+	_, err = execute(t, opts, "import", "bar", "--cert", "/import/cert.pem", "--key", "/import/key.pem", "--force")
+	assert.NoError(t, err)
+	got, _ = storage.Load()
+	replaced := got.Certificates.GetCertificate("bar")
+	assert.Equal(t, types.Domains{"foo.com", "127.0.0.1"}, replaced.Domains)
+	assert.Equal(t, 0, replaced.ObtainFailCount)
+	assert.Equal(t, "default", replaced.Resolver)
+	assert.Len(t, got.Certificates, 2)
+}
+
+func TestGetImportRunFn_Fail(t *testing.T) {
+	opts, storage := prepareOptions(t, false)
+	ca, cert, key := issueCertificate(t, "foo.com")
+	_, otherCert, _ := issueCertificate(t, "bar.com")
+	invalidCert, invalidKey := ca.Issue(t, []string{"foo_bar.com"}, time.Now().Add(-time.Hour), time.Now().Add(time.Hour))
+	expiredCert, expiredKey := ca.Issue(t, []string{"foo.com"}, time.Now().Add(-time.Hour*2), time.Now().Add(-time.Hour))
+	fs := opts.Ctx.GetFS()
+	_ = afero.WriteFile(fs, "/import/cert.pem", cert, 0644)
+	_ = afero.WriteFile(fs, "/import/key.pem", key, 0600)
+	_ = afero.WriteFile(fs, "/import/other.pem", otherCert, 0644)
+	_ = afero.WriteFile(fs, "/import/invalid.pem", invalidCert, 0644)
+	_ = afero.WriteFile(fs, "/import/invalid.key", invalidKey, 0600)
+	_ = afero.WriteFile(fs, "/import/expired.pem", expiredCert, 0644)
+	_ = afero.WriteFile(fs, "/import/expired.key", expiredKey, 0600)
+
+	// chain is not trusted by system roots
+	_, err := execute(t, opts, "import", "foo", "--cert", "/import/cert.pem", "--key", "/import/key.pem")
+	assert.ErrorContains(t, err, "failed to validate certificate: certificate chain is not trusted")
+
+	opts.TrustedRoots = func() *x509.CertPool { return ca.Pool }
+	_, err = execute(t, opts, "import", "foo", "--cert", "/import/expired.pem", "--key", "/import/expired.key")
+	assert.ErrorContains(t, err, "failed to validate certificate: certificate is not valid at")
+
+	_, err = execute(t, opts, "import", "foo", "--cert", "/import/invalid.pem", "--key", "/import/invalid.key")
+	assert.ErrorContains(t, err, "certificate contains an invalid domain")
+	got, _ := storage.Load()
+	assert.Empty(t, got.Certificates)
+
+	_, err = execute(t, opts, "import", "foo", "--cert", "/import/missing.pem", "--key", "/import/key.pem")
+	assert.ErrorContains(t, err, "failed to read /import/missing.pem")
+
+	_, err = execute(t, opts, "import", "foo", "--cert", "/import/other.pem", "--key", "/import/key.pem")
+	assert.ErrorContains(t, err, "failed to load certificate")
+
+	_, err = execute(t, opts, "import", "foo", "--cert", "/import/cert.pem")
+	assert.ErrorContains(t, err, `required flag(s) "key" not set`)
+}
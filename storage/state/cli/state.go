@@ -0,0 +1,230 @@
+package cli
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"text/tabwriter"
+
+	"github.com/alexandreh2ag/lets-go-tls/config"
+	"github.com/alexandreh2ag/lets-go-tls/context"
+	storageState "github.com/alexandreh2ag/lets-go-tls/storage/state"
+	"github.com/alexandreh2ag/lets-go-tls/types"
+	"github.com/alexandreh2ag/lets-go-tls/types/storage/state"
+	"github.com/spf13/cobra"
+)
+
+const (
+	FormatText = "text"
+	FormatJson = "json"
+)
+
+// Options gives access to state config, storage and trusted roots, they are only available once the root command pre run is done.
+type Options struct {
+	Ctx          context.Context
+	Config       func() config.StateConfig
+	Storage      func() state.Storage
+	TrustedRoots func() *x509.CertPool
+}
+
+func (o Options) history() (*storageState.History, error) {
+	cfg := o.Config()
+	if !cfg.History.Enable {
+		return nil, errors.New("state history is not enabled")
+	}
+	return storageState.NewHistory(o.Ctx.GetFS(), o.Storage(), cfg)
+}
+
+// GetStateCmd returns commands working with the configured state storage, shared by server and agent.
+func GetStateCmd(opts Options) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "state",
+		Short: "Inspect and manage state",
+	}
+	cmd.AddCommand(
+		GetListCmd(opts),
+		GetShowCmd(opts),
+		GetExportCmd(opts),
+		GetDeleteCmd(opts),
+		GetImportCmd(opts),
+		GetHistoryCmd(opts),
+		GetDiffCmd(opts),
+		GetRestoreCmd(opts),
+		GetMigrateCmd(opts),
+	)
+	return cmd
+}
+
+func getFormat(cmd *cobra.Command) (string, error) {
+	format, _ := cmd.Flags().GetString("format")
+	if format != FormatText && format != FormatJson {
+		return "", fmt.Errorf("format %s is not supported", format)
+	}
+	return format, nil
+}
+
+func writeJson(w io.Writer, value any) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(value)
+}
+
+func GetHistoryCmd(opts Options) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "history",
+		Short: "List previous versions of state",
+		Args:  cobra.NoArgs,
+		RunE:  GetHistoryRunFn(opts),
+	}
+	cmd.Flags().StringP("format", "f", FormatText, "Define output format (text, json)")
+	return cmd
+}
+
+func GetHistoryRunFn(opts Options) func(*cobra.Command, []string) error {
+	return func(cmd *cobra.Command, args []string) error {
+		format, err := getFormat(cmd)
+		if err != nil {
+			return err
+		}
+		history, err := opts.history()
+		if err != nil {
+			return err
+		}
+		versions, err := history.List()
+		if err != nil {
+			return err
+		}
+
+		if format == FormatJson {
+			return writeJson(cmd.OutOrStdout(), versions)
+		}
+		w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+		_, _ = fmt.Fprintln(w, "VERSION\tDATE\tCERTIFICATES\tREMOVAL")
+		for _, version := range versions {
+			_, _ = fmt.Fprintf(w, "%s\t%s\t%d\t%t\n", version.ID, version.Date.Format(dateFormat), version.Certificates, version.Removal)
+		}
+		return w.Flush()
+	}
+}
+
+func GetDiffCmd(opts Options) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "diff <version> [<version>]",
+		Short: fmt.Sprintf("Compare two versions of state (second version is %s by default)", storageState.CurrentVersion),
+		Args:  cobra.RangeArgs(1, 2),
+		RunE:  GetDiffRunFn(opts),
+	}
+	cmd.Flags().StringP("format", "f", FormatText, "Define output format (text, json)")
+	return cmd
+}
+
+func GetDiffRunFn(opts Options) func(*cobra.Command, []string) error {
+	return func(cmd *cobra.Command, args []string) error {
+		format, err := getFormat(cmd)
+		if err != nil {
+			return err
+		}
+		history, err := opts.history()
+		if err != nil {
+			return err
+		}
+		if len(args) == 1 {
+			args = append(args, storageState.CurrentVersion)
+		}
+		states := []*types.State{}
+		for _, id := range args {
+			var st *types.State
+			if id == storageState.CurrentVersion {
+				st, err = opts.Storage().Load()
+			} else {
+				st, err = history.Get(id)
+			}
+			if err != nil {
+				return err
+			}
+			states = append(states, st)
+		}
+
+		diff := storageState.Diff(states[0], states[1])
+		if format == FormatJson {
+			return writeJson(cmd.OutOrStdout(), diff)
+		}
+		writeDiffText(cmd.OutOrStdout(), diff)
+		return nil
+	}
+}
+
+func writeDiffText(w io.Writer, diff storageState.StateDiff) {
+	symbols := map[string]string{storageState.DiffAdded: "+", storageState.DiffRemoved: "-", storageState.DiffChanged: "~"}
+	counts := map[string]int{}
+	if diff.AccountChanged {
+		_, _ = fmt.Fprintln(w, "~ account")
+	}
+	if diff.StagingAccountChanged {
+		_, _ = fmt.Fprintln(w, "~ staging account")
+	}
+	for _, certificate := range diff.Certificates {
+		counts[certificate.Action]++
+		line := fmt.Sprintf("%s %s %v", symbols[certificate.Action], certificate.Identifier, certificate.Domains.ToStringSlice())
+		if len(certificate.Fields) > 0 {
+			line += fmt.Sprintf(": %v", certificate.Fields)
+		}
+		_, _ = fmt.Fprintln(w, line)
+	}
+	_, _ = fmt.Fprintf(
+		w,
+		"Diff: %d added, %d removed, %d changed.\n",
+		counts[storageState.DiffAdded], counts[storageState.DiffRemoved], counts[storageState.DiffChanged],
+	)
+}
+
+func GetRestoreCmd(opts Options) *cobra.Command {
+	return &cobra.Command{
+		Use:   "restore <version>",
+		Short: "Restore a previous version of state, current state is kept in history",
+		Args:  cobra.ExactArgs(1),
+		RunE:  GetRestoreRunFn(opts),
+	}
+}
+
+func GetRestoreRunFn(opts Options) func(*cobra.Command, []string) error {
+	return func(cmd *cobra.Command, args []string) error {
+		history, err := opts.history()
+		if err != nil {
+			return err
+		}
+		err = history.Restore(opts.Storage(), args[0])
+		if err != nil {
+			return err
+		}
+		_, _ = fmt.Fprintf(cmd.OutOrStdout(), "State restored from version %s.\n", args[0])
+		return nil
+	}
+}
+
+func GetMigrateCmd(opts Options) *cobra.Command {
+	return &cobra.Command{
+		Use:   "migrate",
+		Short: "Upgrade state to the schema version of this binary, run it while server and agent are stopped",
+		Args:  cobra.NoArgs,
+		RunE:  GetMigrateRunFn(opts),
+	}
+}
+
+func GetMigrateRunFn(opts Options) func(*cobra.Command, []string) error {
+	return func(cmd *cobra.Command, args []string) error {
+		// state is migrated on load, save writes it with the current schema
+		st, err := opts.Storage().Load()
+		if err != nil {
+			return err
+		}
+		err = opts.Storage().Save(st)
+		if err != nil {
+			return err
+		}
+		_, _ = fmt.Fprintf(cmd.OutOrStdout(), "State migrated to schema version %d.\n", storageState.SchemaVersion())
+		return nil
+	}
+}
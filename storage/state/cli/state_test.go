@@ -0,0 +1,147 @@
+package cli
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/alexandreh2ag/lets-go-tls/config"
+	appCtx "github.com/alexandreh2ag/lets-go-tls/context"
+	storageState "github.com/alexandreh2ag/lets-go-tls/storage/state"
+	"github.com/alexandreh2ag/lets-go-tls/types"
+	"github.com/alexandreh2ag/lets-go-tls/types/storage/state"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+)
+
+func prepareOptions(t *testing.T, historyEnable bool) (Options, state.Storage) {
+	ctx := appCtx.TestContext(nil)
+	cfg := config.StateConfig{
+		Type:    storageState.FsKey,
+		Config:  map[string]interface{}{"path": "/app/state.json"},
+		History: config.HistoryConfig{Enable: historyEnable},
+	}
+	storage, err := storageState.CreateStorage(ctx, cfg)
+	assert.NoError(t, err)
+	opts := Options{
+		Ctx:          ctx,
+		Config:       func() config.StateConfig { return cfg },
+		Storage:      func() state.Storage { return storage },
+		TrustedRoots: func() *x509.CertPool { return nil },
+	}
+	return opts, storage
+}
+
+func execute(t *testing.T, opts Options, args ...string) (string, error) {
+	t.Helper()
+	b := bytes.NewBufferString("")
+	cmd := GetStateCmd(opts)
+	cmd.SetArgs(args)
+	cmd.SetOut(b)
+	cmd.SetErr(io.Discard)
+	err := cmd.Execute()
+	return b.String(), err
+}
+
+func saveVersions(t *testing.T, storage state.Storage) {
+	saveCertificates(t, storage, types.Certificates{
+		{Identifier: "foo", Domains: types.Domains{"foo.com"}},
+		{Identifier: "bar", Domains: types.Domains{"bar.com"}},
+	})
+	saveCertificates(t, storage, types.Certificates{
+		{Identifier: "foo", Domains: types.Domains{"foo.com"}, ObtainFailCount: 1},
+		{Identifier: "baz", Domains: types.Domains{"baz.com"}},
+	})
+}
+
+func getVersions(t *testing.T, opts Options) []storageState.Version {
+	out, err := execute(t, opts, "history", "--format", "json")
+	assert.NoError(t, err)
+	versions := []storageState.Version{}
+	assert.NoError(t, json.Unmarshal([]byte(out), &versions))
+	return versions
+}
+
+func TestGetHistoryRunFn(t *testing.T) {
+	opts, storage := prepareOptions(t, true)
+	saveVersions(t, storage)
+
+	versions := getVersions(t, opts)
+	assert.Len(t, versions, 1)
+	assert.Equal(t, 2, versions[0].Certificates)
+	assert.True(t, versions[0].Removal)
+
+	out, err := execute(t, opts, "history")
+	assert.NoError(t, err)
+	assert.Contains(t, out, "VERSION")
+	assert.Contains(t, out, "REMOVAL")
+	assert.Contains(t, out, versions[0].ID)
+}
+
+func TestGetHistoryRunFn_Fail(t *testing.T) {
+	opts, _ := prepareOptions(t, false)
+	_, err := execute(t, opts, "history")
+	assert.EqualError(t, err, "state history is not enabled")
+
+	opts, _ = prepareOptions(t, true)
+	_, err = execute(t, opts, "history", "--format", "yaml")
+	assert.EqualError(t, err, "format yaml is not supported")
+}
+
+func TestGetDiffRunFn(t *testing.T) {
+	opts, storage := prepareOptions(t, true)
+	saveVersions(t, storage)
+	versions := getVersions(t, opts)
+
+	out, err := execute(t, opts, "diff", versions[0].ID)
+	assert.NoError(t, err)
+	assert.Equal(t, "~ foo [foo.com]: [obtain_fail_count]\n- bar [bar.com]\n+ baz [baz.com]\nDiff: 1 added, 1 removed, 1 changed.\n", out)
+
+	out, err = execute(t, opts, "diff", storageState.CurrentVersion, versions[0].ID, "--format", "json")
+	assert.NoError(t, err)
+	diff := storageState.StateDiff{}
+	assert.NoError(t, json.Unmarshal([]byte(out), &diff))
+	assert.Len(t, diff.Certificates, 3)
+
+	_, err = execute(t, opts, "diff", "missing")
+	assert.ErrorIs(t, err, storageState.ErrVersionNotFound)
+}
+
+func TestGetRestoreRunFn(t *testing.T) {
+	opts, storage := prepareOptions(t, true)
+	saveVersions(t, storage)
+	versions := getVersions(t, opts)
+
+	out, err := execute(t, opts, "restore", versions[0].ID)
+	assert.NoError(t, err)
+	assert.Equal(t, "State restored from version "+versions[0].ID+".\n", out)
+	got, _ := storage.Load()
+	assert.NotNil(t, got.Certificates.GetCertificate("bar"))
+	assert.Len(t, getVersions(t, opts), 2)
+
+	_, err = execute(t, opts, "restore", "missing")
+	assert.ErrorIs(t, err, storageState.ErrVersionNotFound)
+}
+
+func TestGetMigrateRunFn(t *testing.T) {
+	opts, storage := prepareOptions(t, false)
+	fs := opts.Ctx.GetFS()
+	_ = afero.WriteFile(fs, "/app/state.json", []byte(`{"certificates":[{"identifier":"foo","domain":["foo.com"]}]}`), 0644)
+
+	out, err := execute(t, opts, "migrate")
+	assert.NoError(t, err)
+	assert.Equal(t, fmt.Sprintf("State migrated to schema version %d.\n", storageState.SchemaVersion()), out)
+	data, _ := afero.ReadFile(fs, "/app/state.json")
+	assert.Contains(t, string(data), fmt.Sprintf(`"schema_version": %d`, storageState.SchemaVersion()))
+	assert.Contains(t, string(data), `"domains": [`)
+	assert.NotContains(t, string(data), `"domain": [`)
+	got, _ := storage.Load()
+	assert.Equal(t, types.Domains{"foo.com"}, got.Certificates[0].Domains)
+
+	_ = afero.WriteFile(fs, "/app/state.json", []byte(`{"schema_version":100}`), 0644)
+	_, err = execute(t, opts, "migrate")
+	assert.ErrorIs(t, err, types.ErrUnsupportedSchemaVersion)
+}
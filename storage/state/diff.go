@@ -0,0 +1,82 @@
+package state
+
+import (
+	"encoding/json"
+	"reflect"
+	"slices"
+
+	"github.com/alexandreh2ag/lets-go-tls/types"
+)
+
+const (
+	DiffAdded   = "added"
+	DiffRemoved = "removed"
+	DiffChanged = "changed"
+)
+
+type CertificateDiff struct {
+	Identifier string        `json:"identifier"`
+	Domains    types.Domains `json:"domains"`
+	Action     string        `json:"action"`
+	// Fields are json fields changed
+	Fields []string `json:"fields,omitempty"`
+}
+
+type StateDiff struct {
+	AccountChanged        bool              `json:"account_changed"`
+	StagingAccountChanged bool              `json:"staging_account_changed"`
+	Certificates          []CertificateDiff `json:"certificates"`
+}
+
+// Diff compares certificates of two states by identifier, in order of from then to.
+func Diff(from, to *types.State) StateDiff {
+	diff := StateDiff{
+		AccountChanged:        !jsonEqual(from.Account, to.Account),
+		StagingAccountChanged: !jsonEqual(from.StagingAccount, to.StagingAccount),
+		Certificates:          []CertificateDiff{},
+	}
+	for _, certificate := range from.Certificates {
+		other := to.Certificates.GetCertificate(certificate.Identifier)
+		if other == nil {
+			diff.Certificates = append(diff.Certificates, CertificateDiff{Identifier: certificate.Identifier, Domains: certificate.Domains, Action: DiffRemoved})
+			continue
+		}
+		if fields := changedFields(certificate, other); len(fields) > 0 {
+			diff.Certificates = append(diff.Certificates, CertificateDiff{Identifier: other.Identifier, Domains: other.Domains, Action: DiffChanged, Fields: fields})
+		}
+	}
+	for _, certificate := range to.Certificates {
+		if from.Certificates.GetCertificate(certificate.Identifier) == nil {
+			diff.Certificates = append(diff.Certificates, CertificateDiff{Identifier: certificate.Identifier, Domains: certificate.Domains, Action: DiffAdded})
+		}
+	}
+	return diff
+}
+
+func jsonEqual(a, b any) bool {
+	dataA, _ := json.Marshal(a)
+	dataB, _ := json.Marshal(b)
+	return string(dataA) == string(dataB)
+}
+
+func changedFields(from, to *types.Certificate) []string {
+	fieldsFrom, fieldsTo := map[string]any{}, map[string]any{}
+	dataFrom, _ := json.Marshal(from)
+	dataTo, _ := json.Marshal(to)
+	_ = json.Unmarshal(dataFrom, &fieldsFrom)
+	_ = json.Unmarshal(dataTo, &fieldsTo)
+
+	fields := []string{}
+	for name, value := range fieldsFrom {
+		if !reflect.DeepEqual(value, fieldsTo[name]) {
+			fields = append(fields, name)
+		}
+	}
+	for name := range fieldsTo {
+		if _, ok := fieldsFrom[name]; !ok {
+			fields = append(fields, name)
+		}
+	}
+	slices.Sort(fields)
+	return fields
+}
@@ -0,0 +1,42 @@
+package state
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alexandreh2ag/lets-go-tls/types"
+	"github.com/alexandreh2ag/lets-go-tls/types/acme"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiff(t *testing.T) {
+	now := time.Date(2026, 10, 19, 10, 0, 0, 0, time.UTC)
+	from := &types.State{
+		Account: &acme.Account{Email: "dev@foo.com"},
+		Certificates: types.Certificates{
+			{Identifier: "foo", Domains: types.Domains{"foo.com"}, ExpirationDate: now},
+			{Identifier: "bar", Domains: types.Domains{"bar.com"}},
+			{Identifier: "same", Domains: types.Domains{"same.com"}},
+		},
+	}
+	to := &types.State{
+		Account:        &acme.Account{Email: "dev@foo.com"},
+		StagingAccount: &acme.Account{Email: "dev@foo.com"},
+		Certificates: types.Certificates{
+			{Identifier: "same", Domains: types.Domains{"same.com"}},
+			{Identifier: "foo", Domains: types.Domains{"foo.com"}, ExpirationDate: now.Add(time.Hour), ObtainFailCount: 1},
+			{Identifier: "baz", Domains: types.Domains{"baz.com"}},
+		},
+	}
+
+	want := StateDiff{
+		StagingAccountChanged: true,
+		Certificates: []CertificateDiff{
+			{Identifier: "foo", Domains: types.Domains{"foo.com"}, Action: DiffChanged, Fields: []string{"expiration_date", "obtain_fail_count"}},
+			{Identifier: "bar", Domains: types.Domains{"bar.com"}, Action: DiffRemoved},
+			{Identifier: "baz", Domains: types.Domains{"baz.com"}, Action: DiffAdded},
+		},
+	}
+	assert.Equal(t, want, Diff(from, to))
+	assert.Equal(t, StateDiff{Certificates: []CertificateDiff{}}, Diff(to, to))
+}
@@ -0,0 +1,275 @@
+package state
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/alexandreh2ag/lets-go-tls/config"
+	"github.com/spf13/afero"
+)
+
+const (
+	envelopeAlgorithm = "AES-256-GCM"
+	keySize           = 32
+
+	commandWrap   = "wrap"
+	commandUnwrap = "unwrap"
+
+	defaultCommandTimeout = 30 * time.Second
+)
+
+// envelope is saved instead of the plaintext state when encryption is enabled.
+// State is encrypted with a random data key, the data key is wrapped by the key encryption key KeyID.
+type envelope struct {
+	Algorithm  string `json:"algorithm"`
+	KeyID      string `json:"key_id"`
+	WrappedKey []byte `json:"wrapped_key"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// KeyWrapper wraps and unwraps data keys with a key encryption key.
+type KeyWrapper interface {
+	Wrap(dataKey []byte) ([]byte, error)
+	Unwrap(wrapped []byte) ([]byte, error)
+}
+
+// Encryption encrypts and decrypts state content, a nil Encryption reads and writes plaintext.
+type Encryption struct {
+	enable   bool
+	keyID    string
+	wrappers map[string]KeyWrapper
+}
+
+// NewEncryption returns nil when no key is defined.
+func NewEncryption(fs afero.Fs, cfg config.EncryptionConfig) (*Encryption, error) {
+	if len(cfg.Keys) == 0 {
+		return nil, nil
+	}
+	e := &Encryption{enable: cfg.Enable, keyID: cfg.Keys[0].ID, wrappers: map[string]KeyWrapper{}}
+	for _, keyCfg := range cfg.Keys {
+		wrapper, err := createKeyWrapper(fs, keyCfg)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load encryption key %s: %v", keyCfg.ID, err)
+		}
+		e.wrappers[keyCfg.ID] = wrapper
+	}
+	return e, nil
+}
+
+func createKeyWrapper(fs afero.Fs, cfg config.EncryptionKeyConfig) (KeyWrapper, error) {
+	var encoded string
+	switch {
+	case len(cfg.Command) > 0:
+		timeout := cfg.Timeout
+		if timeout == 0 {
+			timeout = defaultCommandTimeout
+		}
+		return &commandKeyWrapper{command: cfg.Command, timeout: timeout}, nil
+	case cfg.File != "":
+		content, err := afero.ReadFile(fs, cfg.File)
+		if err != nil {
+			return nil, err
+		}
+		encoded = string(content)
+	case cfg.Env != "":
+		encoded = os.Getenv(cfg.Env)
+		if encoded == "" {
+			return nil, fmt.Errorf("environment variable %s is empty", cfg.Env)
+		}
+	}
+
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, fmt.Errorf("key is not base64: %v", err)
+	}
+	if len(key) != keySize {
+		return nil, fmt.Errorf("key must be %d bytes, got %d", keySize, len(key))
+	}
+	return &aesKeyWrapper{key: key}, nil
+}
+
+// IsEnabled returns true when state must be written encrypted.
+func (e *Encryption) IsEnabled() bool {
+	return e != nil && e.enable
+}
+
+// Encrypt returns the envelope of plaintext encrypted with a new data key, plaintext is returned when encryption is disabled.
+func (e *Encryption) Encrypt(plaintext []byte) ([]byte, error) {
+	if !e.IsEnabled() {
+		return plaintext, nil
+	}
+	dataKey := make([]byte, keySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, fmt.Errorf("failed to generate data key: %v", err)
+	}
+	nonce, ciphertext, err := seal(dataKey, plaintext)
+	if err != nil {
+		return nil, err
+	}
+	wrappedKey, err := e.wrappers[e.keyID].Wrap(dataKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to wrap data key with key %s: %v", e.keyID, err)
+	}
+	return json.MarshalIndent(envelope{
+		Algorithm:  envelopeAlgorithm,
+		KeyID:      e.keyID,
+		WrappedKey: wrappedKey,
+		Nonce:      nonce,
+		Ciphertext: ciphertext,
+	}, "", "  ")
+}
+
+// Decrypt returns the plaintext of an envelope, data which is not an envelope is returned as is (unencrypted state).
+func (e *Encryption) Decrypt(data []byte) ([]byte, error) {
+	env, ok := parseEnvelope(data)
+	if !ok {
+		return data, nil
+	}
+	if e == nil {
+		return nil, errors.New("state is encrypted but no encryption key is defined")
+	}
+	wrapper, ok := e.wrappers[env.KeyID]
+	if !ok {
+		return nil, fmt.Errorf("state is encrypted with unknown key %s", env.KeyID)
+	}
+	dataKey, err := wrapper.Unwrap(env.WrappedKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap data key with key %s: %v", env.KeyID, err)
+	}
+	plaintext, err := open(dataKey, env.Nonce, env.Ciphertext)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt state with key %s: %v", env.KeyID, err)
+	}
+	return plaintext, nil
+}
+
+// Unchanged returns true when stored content already holds plaintext in the expected form:
+// encrypted with the current key when encryption is enabled, unencrypted otherwise.
+func (e *Encryption) Unchanged(stored []byte, plaintext []byte) bool {
+	if !e.Current(stored) {
+		return false
+	}
+	current, err := e.Decrypt(stored)
+	return err == nil && bytes.Equal(current, plaintext)
+}
+
+// Current returns true when stored content is in the expected form without decrypting it:
+// encrypted with the current key when encryption is enabled, unencrypted otherwise.
+func (e *Encryption) Current(stored []byte) bool {
+	env, encrypted := parseEnvelope(stored)
+	return encrypted == e.IsEnabled() && (!encrypted || env.KeyID == e.keyID)
+}
+
+func parseEnvelope(data []byte) (envelope, bool) {
+	env := envelope{}
+	if err := json.Unmarshal(data, &env); err != nil {
+		return env, false
+	}
+	return env, env.Algorithm == envelopeAlgorithm
+}
+
+func seal(key []byte, plaintext []byte) ([]byte, []byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return nil, nil, fmt.Errorf("failed to generate nonce: %v", err)
+	}
+	return nonce, gcm.Seal(nil, nonce, plaintext, nil), nil
+}
+
+func open(key []byte, nonce []byte, ciphertext []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(nonce) != gcm.NonceSize() {
+		return nil, errors.New("invalid nonce size")
+	}
+	return gcm.Open(nil, nonce, ciphertext, nil)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// aesKeyWrapper wraps data keys with AES-GCM, the nonce is prepended to the wrapped key.
+type aesKeyWrapper struct {
+	key []byte
+}
+
+func (w *aesKeyWrapper) Wrap(dataKey []byte) ([]byte, error) {
+	nonce, ciphertext, err := seal(w.key, dataKey)
+	if err != nil {
+		return nil, err
+	}
+	return append(nonce, ciphertext...), nil
+}
+
+func (w *aesKeyWrapper) Unwrap(wrapped []byte) ([]byte, error) {
+	gcm, err := newGCM(w.key)
+	if err != nil {
+		return nil, err
+	}
+	if len(wrapped) < gcm.NonceSize() {
+		return nil, errors.New("wrapped key is too short")
+	}
+	return open(w.key, wrapped[:gcm.NonceSize()], wrapped[gcm.NonceSize():])
+}
+
+// commandKeyWrapper delegates wrapping to an external command (ex: a KMS client), the command is called
+// with `wrap` or `unwrap` as last argument, it reads the base64 key on stdin and writes the base64 result on stdout.
+type commandKeyWrapper struct {
+	command []string
+	timeout time.Duration
+}
+
+func (w *commandKeyWrapper) Wrap(dataKey []byte) ([]byte, error) {
+	return w.run(commandWrap, dataKey)
+}
+
+func (w *commandKeyWrapper) Unwrap(wrapped []byte) ([]byte, error) {
+	return w.run(commandUnwrap, wrapped)
+}
+
+func (w *commandKeyWrapper) run(action string, input []byte) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), w.timeout)
+	defer cancel()
+	args := append(append([]string{}, w.command[1:]...), action)
+	cmd := exec.CommandContext(ctx, w.command[0], args...)
+	// children of a killed command may keep output open, waiting for them is bounded
+	cmd.WaitDelay = time.Second
+	cmd.Stdin = strings.NewReader(base64.StdEncoding.EncodeToString(input))
+	stderr := &bytes.Buffer{}
+	cmd.Stderr = stderr
+	output, err := cmd.Output()
+	if ctx.Err() != nil {
+		return nil, fmt.Errorf("command %s %s did not answer in %s", w.command[0], action, w.timeout)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("command %s %s failed: %v: %s", w.command[0], action, err, strings.TrimSpace(stderr.String()))
+	}
+	result, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(output)))
+	if err != nil {
+		return nil, fmt.Errorf("command %s %s output is not base64: %v", w.command[0], action, err)
+	}
+	return result, nil
+}
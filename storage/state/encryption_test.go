@@ -0,0 +1,267 @@
+package state
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/alexandreh2ag/lets-go-tls/config"
+	"github.com/go-playground/validator/v10"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	testKey1 = base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 32))
+	testKey2 = base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{2}, 32))
+)
+
+func TestNewEncryption(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	_ = afero.WriteFile(fs, "/key1", []byte(testKey1+"\n"), 0600)
+	_ = afero.WriteFile(fs, "/wrong", []byte("wrong"), 0600)
+	_ = afero.WriteFile(fs, "/short", []byte(base64.StdEncoding.EncodeToString([]byte("short"))), 0600)
+	t.Setenv("STATE_KEY", testKey2)
+
+	tests := []struct {
+		name    string
+		cfg     config.EncryptionConfig
+		wantNil bool
+		wantErr string
+	}{
+		{
+			name:    "SuccessNoKey",
+			wantNil: true,
+		},
+		{
+			name: "SuccessKeys",
+			cfg: config.EncryptionConfig{Enable: true, Keys: []config.EncryptionKeyConfig{
+				{ID: "file", File: "/key1"},
+				{ID: "env", Env: "STATE_KEY"},
+				{ID: "kms", Command: []string{"kms"}},
+				{ID: "kms-timeout", Command: []string{"kms"}, Timeout: time.Minute},
+			}},
+		},
+		{
+			name:    "FailReadFile",
+			cfg:     config.EncryptionConfig{Keys: []config.EncryptionKeyConfig{{ID: "file", File: "/missing"}}},
+			wantErr: "failed to load encryption key file: open /missing: file does not exist",
+		},
+		{
+			name:    "FailEmptyEnv",
+			cfg:     config.EncryptionConfig{Keys: []config.EncryptionKeyConfig{{ID: "env", Env: "STATE_KEY_MISSING"}}},
+			wantErr: "failed to load encryption key env: environment variable STATE_KEY_MISSING is empty",
+		},
+		{
+			name:    "FailNotBase64",
+			cfg:     config.EncryptionConfig{Keys: []config.EncryptionKeyConfig{{ID: "file", File: "/wrong"}}},
+			wantErr: "failed to load encryption key file: key is not base64",
+		},
+		{
+			name:    "FailKeySize",
+			cfg:     config.EncryptionConfig{Keys: []config.EncryptionKeyConfig{{ID: "file", File: "/short"}}},
+			wantErr: "failed to load encryption key file: key must be 32 bytes, got 5",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewEncryption(fs, tt.cfg)
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				assert.Nil(t, got)
+				return
+			}
+			assert.NoError(t, err)
+			if tt.wantNil {
+				assert.Nil(t, got)
+				return
+			}
+			assert.Equal(t, "file", got.keyID)
+			assert.Len(t, got.wrappers, 4)
+			assert.Equal(t, defaultCommandTimeout, got.wrappers["kms"].(*commandKeyWrapper).timeout)
+			assert.Equal(t, time.Minute, got.wrappers["kms-timeout"].(*commandKeyWrapper).timeout)
+		})
+	}
+}
+
+func newTestEncryption(t *testing.T, enable bool, keys ...string) *Encryption {
+	t.Helper()
+	fs := afero.NewMemMapFs()
+	cfg := config.EncryptionConfig{Enable: enable}
+	for _, key := range keys {
+		value := testKey1
+		if key == "key2" {
+			value = testKey2
+		}
+		_ = afero.WriteFile(fs, "/"+key, []byte(value), 0600)
+		cfg.Keys = append(cfg.Keys, config.EncryptionKeyConfig{ID: key, File: "/" + key})
+	}
+	e, err := NewEncryption(fs, cfg)
+	assert.NoError(t, err)
+	return e
+}
+
+func TestEncryption_EncryptDecrypt(t *testing.T) {
+	plaintext := []byte(`{"certificates":[]}`)
+	e := newTestEncryption(t, true, "key1")
+
+	data, err := e.Encrypt(plaintext)
+	assert.NoError(t, err)
+	assert.NotContains(t, string(data), "certificates")
+	env, ok := parseEnvelope(data)
+	assert.True(t, ok)
+	assert.Equal(t, "key1", env.KeyID)
+
+	got, err := e.Decrypt(data)
+	assert.NoError(t, err)
+	assert.Equal(t, plaintext, got)
+}
+
+func TestEncryption_Encrypt_Disabled(t *testing.T) {
+	plaintext := []byte(`{"certificates":[]}`)
+	var nilEncryption *Encryption
+	for _, e := range []*Encryption{nilEncryption, newTestEncryption(t, false, "key1")} {
+		got, err := e.Encrypt(plaintext)
+		assert.NoError(t, err)
+		assert.Equal(t, plaintext, got)
+	}
+}
+
+func TestEncryption_Decrypt(t *testing.T) {
+	plaintext := []byte(`{"certificates":[]}`)
+	encryptedKey1, _ := newTestEncryption(t, true, "key1").Encrypt(plaintext)
+	tampered := envelope{}
+	_ = json.Unmarshal(encryptedKey1, &tampered)
+	tampered.Ciphertext[0] ^= 0xff
+	tamperedData, _ := json.Marshal(tampered)
+
+	tests := []struct {
+		name       string
+		encryption *Encryption
+		data       []byte
+		wantErr    string
+	}{
+		{
+			name:       "SuccessPlaintext",
+			encryption: newTestEncryption(t, true, "key1"),
+			data:       plaintext,
+		},
+		{
+			name: "SuccessPlaintextWithoutEncryption",
+			data: plaintext,
+		},
+		{
+			name:       "SuccessRotatedKey",
+			encryption: newTestEncryption(t, true, "key2", "key1"),
+			data:       encryptedKey1,
+		},
+		{
+			name:       "SuccessDisabled",
+			encryption: newTestEncryption(t, false, "key1"),
+			data:       encryptedKey1,
+		},
+		{
+			name:    "FailNoKey",
+			data:    encryptedKey1,
+			wantErr: "state is encrypted but no encryption key is defined",
+		},
+		{
+			name:       "FailUnknownKey",
+			encryption: newTestEncryption(t, true, "key2"),
+			data:       encryptedKey1,
+			wantErr:    "state is encrypted with unknown key key1",
+		},
+		{
+			name:       "FailTampered",
+			encryption: newTestEncryption(t, true, "key1"),
+			data:       tamperedData,
+			wantErr:    "failed to decrypt state with key key1: cipher: message authentication failed",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.encryption.Decrypt(tt.data)
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, plaintext, got)
+		})
+	}
+}
+
+func TestEncryption_Current(t *testing.T) {
+	plaintext := []byte(`{"certificates":[]}`)
+	encryptedKey1, _ := newTestEncryption(t, true, "key1").Encrypt(plaintext)
+	assert.True(t, newTestEncryption(t, true, "key1").Current(encryptedKey1))
+	assert.False(t, newTestEncryption(t, true, "key2", "key1").Current(encryptedKey1))
+	assert.False(t, newTestEncryption(t, true, "key1").Current(plaintext))
+	assert.False(t, newTestEncryption(t, false, "key1").Current(encryptedKey1))
+	assert.True(t, newTestEncryption(t, false, "key1").Current(plaintext))
+	assert.True(t, (*Encryption)(nil).Current(plaintext))
+}
+
+func TestEncryption_Unchanged(t *testing.T) {
+	plaintext := []byte(`{"certificates":[]}`)
+	encryptedKey1, _ := newTestEncryption(t, true, "key1").Encrypt(plaintext)
+
+	assert.True(t, newTestEncryption(t, true, "key1").Unchanged(encryptedKey1, plaintext))
+	assert.False(t, newTestEncryption(t, true, "key1").Unchanged(encryptedKey1, []byte("{}")))
+	assert.False(t, newTestEncryption(t, true, "key2", "key1").Unchanged(encryptedKey1, plaintext))
+	assert.False(t, newTestEncryption(t, true, "key1").Unchanged(plaintext, plaintext))
+	assert.False(t, newTestEncryption(t, false, "key1").Unchanged(encryptedKey1, plaintext))
+	assert.True(t, newTestEncryption(t, false, "key1").Unchanged(plaintext, plaintext))
+}
+
+func TestEncryption_Command(t *testing.T) {
+	plaintext := []byte(`{"certificates":[]}`)
+	// identity key wrapper, a real command would call a KMS
+	e := &Encryption{enable: true, keyID: "kms", wrappers: map[string]KeyWrapper{"kms": &commandKeyWrapper{command: []string{"sh", "-c", "cat", "kms"}, timeout: time.Second}}}
+
+	data, err := e.Encrypt(plaintext)
+	assert.NoError(t, err)
+	got, err := e.Decrypt(data)
+	assert.NoError(t, err)
+	assert.Equal(t, plaintext, got)
+}
+
+func TestEncryption_CommandFail(t *testing.T) {
+	e := &Encryption{enable: true, keyID: "kms", wrappers: map[string]KeyWrapper{"kms": &commandKeyWrapper{command: []string{"sh", "-c", "echo denied >&2; exit 1", "kms"}, timeout: time.Second}}}
+
+	_, err := e.Encrypt([]byte("{}"))
+	assert.ErrorContains(t, err, "failed to wrap data key with key kms: command sh wrap failed: exit status 1: denied")
+
+	e.wrappers["kms"] = &commandKeyWrapper{command: []string{"sh", "-c", "echo '!'", "kms"}, timeout: time.Second}
+	_, err = e.Encrypt([]byte("{}"))
+	assert.ErrorContains(t, err, "command sh wrap output is not base64")
+
+	e.wrappers["kms"] = &commandKeyWrapper{command: []string{"sh", "-c", "sleep 5", "kms"}, timeout: time.Millisecond * 100}
+	start := time.Now()
+	_, err = e.Encrypt([]byte("{}"))
+	assert.ErrorContains(t, err, "command sh wrap did not answer in 100ms")
+	assert.Less(t, time.Since(start), time.Second*3)
+}
+
+func TestEncryptionConfig_Validate(t *testing.T) {
+	validate := validator.New()
+	assert.NoError(t, validate.Struct(config.StateConfig{Type: FsKey}))
+	assert.NoError(t, validate.Struct(config.StateConfig{Type: FsKey, Encryption: config.EncryptionConfig{
+		Enable: true,
+		Keys:   []config.EncryptionKeyConfig{{ID: "new", Env: "KEY"}, {ID: "old", File: "/key"}},
+	}}))
+
+	err := validate.Struct(config.StateConfig{Type: FsKey, Encryption: config.EncryptionConfig{Enable: true}})
+	assert.ErrorContains(t, err, "'Keys' failed on the 'required_if' tag")
+
+	err = validate.Struct(config.StateConfig{Type: FsKey, Encryption: config.EncryptionConfig{Keys: []config.EncryptionKeyConfig{{ID: "key"}}}})
+	assert.ErrorContains(t, err, "'File' failed on the 'required_without_all' tag")
+
+	err = validate.Struct(config.StateConfig{Type: FsKey, Encryption: config.EncryptionConfig{Keys: []config.EncryptionKeyConfig{{ID: "key", File: "/key", Env: "KEY"}}}})
+	assert.ErrorContains(t, err, "'File' failed on the 'excluded_with' tag")
+
+	err = validate.Struct(config.StateConfig{Type: FsKey, Encryption: config.EncryptionConfig{Keys: []config.EncryptionKeyConfig{{ID: "key", File: "/a"}, {ID: "key", File: "/b"}}}})
+	assert.ErrorContains(t, err, "'Keys' failed on the 'unique' tag")
+}
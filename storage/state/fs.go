@@ -2,15 +2,17 @@ package state
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
+	"os"
+	"path/filepath"
 
 	"github.com/alexandreh2ag/lets-go-tls/config"
 	"github.com/alexandreh2ag/lets-go-tls/context"
 	appFs "github.com/alexandreh2ag/lets-go-tls/fs"
 	"github.com/alexandreh2ag/lets-go-tls/mapstructure"
 	"github.com/alexandreh2ag/lets-go-tls/types"
-	"github.com/alexandreh2ag/lets-go-tls/types/acme"
 	"github.com/alexandreh2ag/lets-go-tls/types/storage/state"
 	"github.com/go-playground/validator/v10"
 	"github.com/spf13/afero"
@@ -24,17 +26,24 @@ func init() {
 	TypeStorageMapping[FsKey] = createFsStorage
 }
 
-var _ state.Storage = &fs{}
+var (
+	_ state.Storage       = &fs{}
+	_ state.ChangeTracker = &fs{}
+	_ state.VersionStore  = &fs{}
+)
 
 type ConfigFs struct {
 	Path string `mapstructure:"path" validate:"required"`
 }
 
+// fs saves state in a file, it is single-writer only: the revision and the fencing token are checked
+// before writing the file but not atomically, so two processes may override each other changes.
 type fs struct {
-	fs       afero.Fs
-	logger   *slog.Logger
-	checksum *appFs.Checksum
-	cfg      ConfigFs
+	fs         afero.Fs
+	logger     *slog.Logger
+	checksum   *appFs.Checksum
+	encryption *Encryption
+	cfg        ConfigFs
 }
 
 func (f fs) Type() string {
@@ -42,37 +51,99 @@ func (f fs) Type() string {
 }
 
 func (f fs) Load() (*types.State, error) {
-	s := &types.State{Account: &acme.Account{}}
 	ok, err := afero.Exists(f.fs, f.cfg.Path)
 	if err != nil {
 		return nil, fmt.Errorf("failed to check path %s: %v", f.cfg.Path, err)
 	}
 	if !ok {
-		return s, nil
+		return newState(), nil
+	}
+	data, err := afero.ReadFile(f.fs, f.cfg.Path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %v", f.cfg.Path, err)
 	}
-	data, _ := f.fs.Open(f.cfg.Path)
-	decoder := json.NewDecoder(data)
-	err = decoder.Decode(s)
+	data, err = f.encryption.Decrypt(data)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse json %s: %v", f.cfg.Path, err)
+		return nil, fmt.Errorf("failed to decrypt %s: %v", f.cfg.Path, err)
 	}
-	return s, nil
+	return DecodeState(f.cfg.Path, data)
+}
+
+// ChangeTag uses modification time and size of the file, a save always changes the revision in the file.
+func (f fs) ChangeTag() (string, error) {
+	info, err := f.fs.Stat(f.cfg.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to stat %s: %v", f.cfg.Path, err)
+	}
+	return fmt.Sprintf("%d-%d", info.ModTime().UnixNano(), info.Size()), nil
 }
 
 func (f fs) Save(state *types.State) error {
+	state.SchemaVersion = SchemaVersion()
+	current, err := f.Load()
+	if err != nil {
+		return err
+	}
+	if state.FencingToken > 0 && current.FencingToken > state.FencingToken {
+		return fmt.Errorf("failed to write in %s: %w (%d < %d)", f.cfg.Path, types.ErrStaleFencingToken, state.FencingToken, current.FencingToken)
+	}
+	err = checkRevision(f.cfg.Path, current, state)
+	if err != nil {
+		return err
+	}
+
 	data, _ := json.MarshalIndent(state, "", "  ")
 
-	if !f.checksum.MustCompareContentWithPath(data, f.cfg.Path) {
-		f.logger.Info(fmt.Sprintf("save state to %s", f.cfg.Path))
-		err := afero.WriteFile(f.fs, f.cfg.Path, data, 0660)
-		if err != nil {
-			return fmt.Errorf("failed to write in %s: %v", f.cfg.Path, err)
-		}
+	changed := !f.checksum.MustCompareContentWithPath(data, f.cfg.Path)
+	if f.encryption != nil {
+		stored, _ := afero.ReadFile(f.fs, f.cfg.Path)
+		changed = !f.encryption.Unchanged(stored, data)
+	}
+	if !changed {
+		return nil
+	}
+
+	next := *state
+	next.Revision++
+	data, _ = json.MarshalIndent(&next, "", "  ")
+	data, err = f.encryption.Encrypt(data)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt state: %v", err)
+	}
+	f.logger.Info(fmt.Sprintf("save state to %s", f.cfg.Path))
+	err = afero.WriteFile(f.fs, f.cfg.Path, data, 0660)
+	if err != nil {
+		return fmt.Errorf("failed to write in %s: %v", f.cfg.Path, err)
 	}
 
+	state.Revision = next.Revision
 	return nil
 }
 
+// versions are kept in the history directory next to the state file.
+func (f fs) versions() dirVersionStore {
+	return dirVersionStore{fs: f.fs, path: filepath.Join(filepath.Dir(f.cfg.Path), "history")}
+}
+
+func (f fs) VersionIDs() ([]string, error) {
+	return f.versions().VersionIDs()
+}
+
+func (f fs) GetVersion(id string) ([]byte, error) {
+	return f.versions().GetVersion(id)
+}
+
+func (f fs) PutVersion(id string, data []byte) error {
+	return f.versions().PutVersion(id, data)
+}
+
+func (f fs) DeleteVersion(id string) error {
+	return f.versions().DeleteVersion(id)
+}
+
 func createFsStorage(ctx context.Context, cfg config.StateConfig) (state.Storage, error) {
 	instanceConfig := ConfigFs{}
 	err := mapstructure.Decode(cfg.Config, &instanceConfig)
@@ -86,7 +157,12 @@ func createFsStorage(ctx context.Context, cfg config.StateConfig) (state.Storage
 		return nil, err
 	}
 
-	instance := &fs{fs: ctx.GetFS(), logger: ctx.GetLogger(), cfg: instanceConfig, checksum: appFs.NewChecksum(ctx.GetFS())}
+	encryption, err := NewEncryption(ctx.GetFS(), cfg.Encryption)
+	if err != nil {
+		return nil, err
+	}
+
+	instance := &fs{fs: ctx.GetFS(), logger: ctx.GetLogger(), cfg: instanceConfig, checksum: appFs.NewChecksum(ctx.GetFS()), encryption: encryption}
 
 	return instance, nil
 }
@@ -33,6 +33,7 @@ func Test_fs_Load_Success(t *testing.T) {
 
 	got, err := stateStorage.Load()
 	assert.NoError(t, err)
+	want.SchemaVersion = SchemaVersion()
 	assert.Equal(t, want, got)
 }
 
@@ -41,7 +42,7 @@ func Test_fs_Load_SuccessPathNotExist(t *testing.T) {
 	basePath := "/app"
 	stateStorage := &fs{fs: ctx.Fs, cfg: ConfigFs{Path: path.Join(basePath, "acme.json")}}
 
-	want := &types.State{Account: &acme.Account{}}
+	want := &types.State{SchemaVersion: SchemaVersion(), Account: &acme.Account{}}
 
 	got, err := stateStorage.Load()
 	assert.NoError(t, err)
@@ -69,9 +70,10 @@ func Test_fs_Save_Success(t *testing.T) {
 		Account:      &acme.Account{Email: "dev@foo.com", Registration: &registration.Resource{Body: legoAcme.Account{Status: "valid"}, URI: "https://uri.com"}, Key: []byte("privatekey")},
 		Certificates: types.Certificates{{Domains: types.Domains{"foo.com", "bar.com"}, Key: []byte("key"), Certificate: []byte("certificate")}},
 	}
-	data, _ := json.MarshalIndent(s, "", "  ")
 	err := stateStorage.Save(s)
 	assert.NoError(t, err)
+	assert.Equal(t, int64(1), s.Revision)
+	data, _ := json.MarshalIndent(s, "", "  ")
 	fileExist, _ := afero.Exists(ctx.Fs, stateStorage.cfg.Path)
 	file, _ := ctx.Fs.Open(stateStorage.cfg.Path)
 	fileData, _ := io.ReadAll(file)
@@ -79,6 +81,77 @@ func Test_fs_Save_Success(t *testing.T) {
 	assert.Equal(t, data, fileData)
 }
 
+func Test_fs_Save_SuccessWithFencingToken(t *testing.T) {
+	ctx := appCtx.TestContext(nil)
+	basePath := "/app"
+	stateStorage := &fs{fs: ctx.Fs, logger: ctx.Logger, cfg: ConfigFs{Path: path.Join(basePath, "acme.json")}, checksum: appFs.NewChecksum(ctx.Fs)}
+	_ = ctx.Fs.Mkdir(basePath, 0775)
+	_ = afero.WriteFile(ctx.Fs, stateStorage.cfg.Path, []byte(`{"certificates":[],"fencing_token":10}`), 0644)
+
+	err := stateStorage.Save(&types.State{Certificates: types.Certificates{}, FencingToken: 11})
+	assert.NoError(t, err)
+	got, _ := stateStorage.Load()
+	assert.Equal(t, int64(11), got.FencingToken)
+}
+
+func Test_fs_Save_FailStaleFencingToken(t *testing.T) {
+	ctx := appCtx.TestContext(nil)
+	basePath := "/app"
+	stateStorage := &fs{fs: ctx.Fs, logger: ctx.Logger, cfg: ConfigFs{Path: path.Join(basePath, "acme.json")}, checksum: appFs.NewChecksum(ctx.Fs)}
+	_ = ctx.Fs.Mkdir(basePath, 0775)
+	_ = afero.WriteFile(ctx.Fs, stateStorage.cfg.Path, []byte(`{"certificates":[],"fencing_token":10}`), 0644)
+
+	err := stateStorage.Save(&types.State{Certificates: types.Certificates{}, FencingToken: 9})
+	assert.ErrorIs(t, err, types.ErrStaleFencingToken)
+	assert.Contains(t, err.Error(), "failed to write in /app/acme.json: stale fencing token (9 < 10)")
+}
+
+func Test_fs_Save_FailRevisionConflict(t *testing.T) {
+	ctx := appCtx.TestContext(nil)
+	stateStorage := &fs{fs: ctx.Fs, logger: ctx.Logger, cfg: ConfigFs{Path: "/app/acme.json"}, checksum: appFs.NewChecksum(ctx.Fs)}
+	_ = afero.WriteFile(ctx.Fs, stateStorage.cfg.Path, []byte(`{"certificates":[],"revision":3}`), 0644)
+
+	err := stateStorage.Save(&types.State{Certificates: types.Certificates{{Identifier: "foo"}}, Revision: 2})
+	assert.ErrorIs(t, err, types.ErrStateConflict)
+	assert.Contains(t, err.Error(), "failed to write in /app/acme.json: state was modified concurrently (revision 2 != 3)")
+
+	s := &types.State{Certificates: types.Certificates{{Identifier: "foo"}}, Revision: 3}
+	assert.NoError(t, stateStorage.Save(s))
+	assert.Equal(t, int64(4), s.Revision)
+	// unchanged state does not increment revision
+	assert.NoError(t, stateStorage.Save(s))
+	assert.Equal(t, int64(4), s.Revision)
+}
+
+func Test_fs_Save_FailNewerSchema(t *testing.T) {
+	ctx := appCtx.TestContext(nil)
+	stateStorage := &fs{fs: ctx.Fs, logger: ctx.Logger, cfg: ConfigFs{Path: "/app/acme.json"}, checksum: appFs.NewChecksum(ctx.Fs)}
+	_ = afero.WriteFile(ctx.Fs, stateStorage.cfg.Path, []byte(`{"schema_version":100,"certificates":[]}`), 0644)
+
+	err := stateStorage.Save(&types.State{Certificates: types.Certificates{}})
+	assert.ErrorIs(t, err, types.ErrUnsupportedSchemaVersion)
+	data, _ := afero.ReadFile(ctx.Fs, stateStorage.cfg.Path)
+	assert.Equal(t, `{"schema_version":100,"certificates":[]}`, string(data))
+}
+
+func Test_fs_ChangeTag(t *testing.T) {
+	ctx := appCtx.TestContext(nil)
+	stateStorage := &fs{fs: ctx.Fs, logger: ctx.Logger, cfg: ConfigFs{Path: "/app/state.json"}, checksum: appFs.NewChecksum(ctx.Fs)}
+	tag, err := stateStorage.ChangeTag()
+	assert.NoError(t, err)
+	assert.Empty(t, tag)
+
+	assert.NoError(t, stateStorage.Save(&types.State{Account: &acme.Account{}}))
+	first, err := stateStorage.ChangeTag()
+	assert.NoError(t, err)
+	assert.NotEmpty(t, first)
+
+	assert.NoError(t, stateStorage.Save(&types.State{Account: &acme.Account{Email: "dev@foo.com"}, Revision: 1}))
+	second, err := stateStorage.ChangeTag()
+	assert.NoError(t, err)
+	assert.NotEqual(t, first, second)
+}
+
 func Test_fs_Type(t *testing.T) {
 	stateStorage := &fs{}
 	assert.Equal(t, FsKey, stateStorage.Type())
@@ -126,6 +199,16 @@ func Test_createFsStorage(t *testing.T) {
 			wantErr:     true,
 			errContains: "ConfigFs.Path' Error:Field validation for 'Path' failed on the 'required' tag",
 		},
+		{
+			name: "FailEncryptionKey",
+			cfg: config.StateConfig{
+				Type:       FsKey,
+				Config:     map[string]interface{}{"path": "/app/acme.json"},
+				Encryption: config.EncryptionConfig{Enable: true, Keys: []config.EncryptionKeyConfig{{ID: "key", File: "/missing.key"}}},
+			},
+			wantErr:     true,
+			errContains: "failed to load encryption key key",
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -142,3 +225,57 @@ func Test_createFsStorage(t *testing.T) {
 		})
 	}
 }
+
+func Test_fs_SaveLoad_SuccessEncrypted(t *testing.T) {
+	ctx := appCtx.TestContext(nil)
+	stateStorage := &fs{fs: ctx.Fs, logger: ctx.Logger, cfg: ConfigFs{Path: "/app/acme.json"}, checksum: appFs.NewChecksum(ctx.Fs), encryption: newTestEncryption(t, true, "key1")}
+	s := &types.State{
+		Account:      &acme.Account{Email: "dev@foo.com", Key: []byte("privatekey")},
+		Certificates: types.Certificates{{Domains: types.Domains{"foo.com"}, Key: []byte("key"), Certificate: []byte("certificate")}},
+	}
+
+	assert.NoError(t, stateStorage.Save(s))
+	encrypted, _ := afero.ReadFile(ctx.Fs, stateStorage.cfg.Path)
+	assert.NotContains(t, string(encrypted), "foo.com")
+
+	// unchanged state is not written again
+	assert.NoError(t, stateStorage.Save(s))
+	current, _ := afero.ReadFile(ctx.Fs, stateStorage.cfg.Path)
+	assert.Equal(t, encrypted, current)
+
+	got, err := stateStorage.Load()
+	assert.NoError(t, err)
+	assert.Equal(t, s, got)
+}
+
+func Test_fs_Save_SuccessEncryptPlaintextState(t *testing.T) {
+	ctx := appCtx.TestContext(nil)
+	stateStorage := &fs{fs: ctx.Fs, logger: ctx.Logger, cfg: ConfigFs{Path: "/app/acme.json"}, checksum: appFs.NewChecksum(ctx.Fs), encryption: newTestEncryption(t, true, "key1")}
+	plaintext, _ := json.MarshalIndent(&types.State{Certificates: types.Certificates{}}, "", "  ")
+	_ = afero.WriteFile(ctx.Fs, stateStorage.cfg.Path, plaintext, 0644)
+
+	got, err := stateStorage.Load()
+	assert.NoError(t, err)
+	assert.NoError(t, stateStorage.Save(got))
+	data, _ := afero.ReadFile(ctx.Fs, stateStorage.cfg.Path)
+	_, encrypted := parseEnvelope(data)
+	assert.True(t, encrypted)
+}
+
+func Test_fs_Load_FailDecrypt(t *testing.T) {
+	ctx := appCtx.TestContext(nil)
+	encrypted, _ := newTestEncryption(t, true, "key1").Encrypt([]byte("{}"))
+	_ = afero.WriteFile(ctx.Fs, "/app/acme.json", encrypted, 0644)
+	stateStorage := &fs{fs: ctx.Fs, cfg: ConfigFs{Path: "/app/acme.json"}, encryption: newTestEncryption(t, true, "key2")}
+
+	got, err := stateStorage.Load()
+	assert.Nil(t, got)
+	assert.EqualError(t, err, "failed to decrypt /app/acme.json: state is encrypted with unknown key key1")
+}
+
+func Test_fs_VersionStore(t *testing.T) {
+	memFs := afero.NewMemMapFs()
+	testVersionStore(t, &fs{fs: memFs, cfg: ConfigFs{Path: "/app/state.json"}})
+	ok, _ := afero.Exists(memFs, "/app/history/state-20261019T100200.000000000Z-removal.json")
+	assert.True(t, ok)
+}
@@ -0,0 +1,318 @@
+package state
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/alexandreh2ag/lets-go-tls/config"
+	"github.com/alexandreh2ag/lets-go-tls/types"
+	"github.com/alexandreh2ag/lets-go-tls/types/storage/state"
+	"github.com/spf13/afero"
+)
+
+const (
+	defaultHistoryMax = 20
+
+	historyFilePrefix = "state-"
+	historyFileSuffix = ".json"
+	// historyIDFormat sorts in chronological order
+	historyIDFormat = "20060102T150405.000000000Z"
+	// historyRemovalSuffix marks versions saved before certificates were removed
+	historyRemovalSuffix = "-removal"
+
+	// CurrentVersion designates the current state in place of a version id.
+	CurrentVersion = "current"
+)
+
+var ErrVersionNotFound = state.ErrVersionNotFound
+
+// Version is a previous state kept in history.
+type Version struct {
+	ID           string    `json:"id"`
+	Date         time.Time `json:"date"`
+	Certificates int       `json:"certificates"`
+	// Removal is set on versions saved before certificates were removed, they are not rotated by count.
+	Removal bool `json:"removal"`
+}
+
+// History keeps previous versions of state, encrypted like the state, in the state storage or in a directory.
+type History struct {
+	store      state.VersionStore
+	encryption *Encryption
+	max        int
+	maxAge     time.Duration
+	now        func() time.Time
+}
+
+func NewHistory(fs afero.Fs, storage state.Storage, cfg config.StateConfig) (*History, error) {
+	encryption, err := NewEncryption(fs, cfg.Encryption)
+	if err != nil {
+		return nil, err
+	}
+	store, err := newVersionStore(fs, storage, cfg.History)
+	if err != nil {
+		return nil, err
+	}
+	max := cfg.History.Max
+	if max == 0 {
+		max = defaultHistoryMax
+	}
+	return &History{store: store, encryption: encryption, max: max, maxAge: cfg.History.MaxAge, now: time.Now}, nil
+}
+
+// newVersionStore returns the directory of history path when set, otherwise the storage keeping state.
+func newVersionStore(fs afero.Fs, storage state.Storage, cfg config.HistoryConfig) (state.VersionStore, error) {
+	if cfg.Path != "" {
+		return dirVersionStore{fs: fs, path: cfg.Path}, nil
+	}
+	for current := storage; current != nil; {
+		if store, ok := current.(state.VersionStore); ok {
+			return store, nil
+		}
+		wrapper, ok := current.(interface{ Unwrap() state.Storage })
+		if !ok {
+			break
+		}
+		current = wrapper.Unwrap()
+	}
+	return nil, fmt.Errorf("state storage %s can not keep history, history path is required", storage.Type())
+}
+
+// Snapshot saves state as a new version and rotates versions.
+func (h *History) Snapshot(st *types.State) (string, error) {
+	return h.snapshot(st, false)
+}
+
+// snapshot saves state as a new version, versions above max are removed except removal versions,
+// versions older than max age are removed whatever their kind.
+func (h *History) snapshot(st *types.State, removal bool) (string, error) {
+	now := h.now().UTC()
+	id := now.Format(historyIDFormat)
+	if removal {
+		id += historyRemovalSuffix
+	}
+	data, _ := json.MarshalIndent(st, "", "  ")
+	data, err := h.encryption.Encrypt(data)
+	if err != nil {
+		return "", fmt.Errorf("failed to encrypt state: %v", err)
+	}
+	err = h.store.PutVersion(id, data)
+	if err != nil {
+		return "", err
+	}
+
+	ids, err := h.ids()
+	if err != nil {
+		return "", err
+	}
+	count := 0
+	for _, versionID := range ids {
+		versionRemoval := strings.HasSuffix(versionID, historyRemovalSuffix)
+		if !versionRemoval {
+			count++
+		}
+		expired := h.maxAge > 0 && now.Sub(versionDate(versionID)) > h.maxAge
+		if expired || (!versionRemoval && count > h.max) {
+			if err = h.store.DeleteVersion(versionID); err != nil {
+				return "", err
+			}
+		}
+	}
+	return id, nil
+}
+
+// ids returns version ids, newest first.
+func (h *History) ids() ([]string, error) {
+	ids, err := h.store.VersionIDs()
+	if err != nil {
+		return nil, err
+	}
+	slices.Sort(ids)
+	slices.Reverse(ids)
+	return ids, nil
+}
+
+func versionDate(id string) time.Time {
+	date, _ := time.Parse(historyIDFormat, strings.TrimSuffix(id, historyRemovalSuffix))
+	return date
+}
+
+// List returns versions, newest first.
+func (h *History) List() ([]Version, error) {
+	ids, err := h.ids()
+	if err != nil {
+		return nil, err
+	}
+	versions := []Version{}
+	for _, id := range ids {
+		st, errGet := h.Get(id)
+		if errGet != nil {
+			return nil, errGet
+		}
+		versions = append(versions, Version{
+			ID:           id,
+			Date:         versionDate(id),
+			Certificates: len(st.Certificates),
+			Removal:      strings.HasSuffix(id, historyRemovalSuffix),
+		})
+	}
+	return versions, nil
+}
+
+// Get returns the state saved in version id.
+func (h *History) Get(id string) (*types.State, error) {
+	name := "history version " + id
+	data, err := h.store.GetVersion(id)
+	if errors.Is(err, ErrVersionNotFound) {
+		return nil, fmt.Errorf("%w: %s", ErrVersionNotFound, id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	data, err = h.encryption.Decrypt(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt %s: %v", name, err)
+	}
+	return DecodeState(name, data)
+}
+
+// Restore saves the state of version id as current state, current state is kept in history so a restore can be undone.
+func (h *History) Restore(storage state.Storage, id string) error {
+	st, err := h.Get(id)
+	if err != nil {
+		return err
+	}
+	current, err := storage.Load()
+	if err != nil {
+		return err
+	}
+	// the restored state is written by the current lock owner lineage over the current revision
+	st.FencingToken = current.FencingToken
+	st.Revision = current.Revision
+	return storage.Save(st)
+}
+
+var (
+	_ state.Storage           = &historyStorage{}
+	_ state.CertificateFinder = &historyFinderStorage{}
+)
+
+// historyStorage saves the previous state in history before each save changing it.
+type historyStorage struct {
+	state.Storage
+	history *History
+}
+
+func (s historyStorage) Save(st *types.State) error {
+	st.SchemaVersion = SchemaVersion()
+	current, err := s.Storage.Load()
+	if err != nil {
+		return err
+	}
+	currentData, _ := json.Marshal(current)
+	data, _ := json.Marshal(st)
+	// an empty state (first start) is not kept, neither a state the save will refuse as modified concurrently
+	empty := len(current.Certificates) == 0 && (current.Account == nil || len(current.Account.Key) == 0)
+	if !empty && current.Revision == st.Revision && string(currentData) != string(data) {
+		// a version before certificates are removed is always kept, so a removal can be undone
+		removal := slices.ContainsFunc(current.Certificates, func(certificate *types.Certificate) bool {
+			return st.Certificates.GetCertificate(certificate.Identifier) == nil
+		})
+		if _, err = s.history.snapshot(current, removal); err != nil {
+			return fmt.Errorf("failed to save state history: %v", err)
+		}
+	}
+	return s.Storage.Save(st)
+}
+
+// Unwrap returns the storage saving state.
+func (s historyStorage) Unwrap() state.Storage {
+	return s.Storage
+}
+
+// historyFinderStorage keeps the domain index of storages implementing state.CertificateFinder.
+type historyFinderStorage struct {
+	historyStorage
+}
+
+func (s historyFinderStorage) FindCertificates(domains types.Domains) (types.Certificates, error) {
+	return s.Storage.(state.CertificateFinder).FindCertificates(domains)
+}
+
+// WithHistory returns storage saving previous versions in history.
+func WithHistory(storage state.Storage, history *History) state.Storage {
+	if _, ok := storage.(state.CertificateFinder); ok {
+		return &historyFinderStorage{historyStorage{Storage: storage, history: history}}
+	}
+	return &historyStorage{Storage: storage, history: history}
+}
+
+var _ state.VersionStore = dirVersionStore{}
+
+// dirVersionStore keeps versions as files in a directory.
+type dirVersionStore struct {
+	fs   afero.Fs
+	path string
+}
+
+func (d dirVersionStore) file(id string) string {
+	return filepath.Join(d.path, historyFilePrefix+id+historyFileSuffix)
+}
+
+func (d dirVersionStore) VersionIDs() ([]string, error) {
+	entries, err := afero.ReadDir(d.fs, d.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return []string{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read history directory %s: %v", d.path, err)
+	}
+	ids := []string{}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, historyFilePrefix) || !strings.HasSuffix(name, historyFileSuffix) {
+			continue
+		}
+		ids = append(ids, strings.TrimSuffix(strings.TrimPrefix(name, historyFilePrefix), historyFileSuffix))
+	}
+	return ids, nil
+}
+
+func (d dirVersionStore) GetVersion(id string) ([]byte, error) {
+	if strings.ContainsAny(id, `/\`) {
+		return nil, ErrVersionNotFound
+	}
+	data, err := afero.ReadFile(d.fs, d.file(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrVersionNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %v", d.file(id), err)
+	}
+	return data, nil
+}
+
+func (d dirVersionStore) PutVersion(id string, data []byte) error {
+	err := d.fs.MkdirAll(d.path, 0770)
+	if err != nil {
+		return fmt.Errorf("failed to create history directory %s: %v", d.path, err)
+	}
+	err = afero.WriteFile(d.fs, d.file(id), data, 0660)
+	if err != nil {
+		return fmt.Errorf("failed to write in %s: %v", d.file(id), err)
+	}
+	return nil
+}
+
+func (d dirVersionStore) DeleteVersion(id string) error {
+	if err := d.fs.Remove(d.file(id)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to remove %s: %v", d.file(id), err)
+	}
+	return nil
+}
@@ -0,0 +1,288 @@
+package state
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/alexandreh2ag/lets-go-tls/config"
+	appCtx "github.com/alexandreh2ag/lets-go-tls/context"
+	appFs "github.com/alexandreh2ag/lets-go-tls/fs"
+	mockTypesStorageState "github.com/alexandreh2ag/lets-go-tls/mocks/types/storage/state"
+	"github.com/alexandreh2ag/lets-go-tls/types"
+	"github.com/alexandreh2ag/lets-go-tls/types/acme"
+	"github.com/alexandreh2ag/lets-go-tls/types/storage/state"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/mock/gomock"
+)
+
+func newTestHistory(t *testing.T, fs afero.Fs, max int) *History {
+	history, err := NewHistory(fs, nil, config.StateConfig{History: config.HistoryConfig{Enable: true, Path: "/app/history", Max: max}})
+	assert.NoError(t, err)
+	date := time.Date(2026, 10, 19, 10, 0, 0, 0, time.UTC)
+	history.now = func() time.Time {
+		date = date.Add(time.Minute)
+		return date
+	}
+	return history
+}
+
+func TestNewHistory(t *testing.T) {
+	memFs := afero.NewMemMapFs()
+	history, err := NewHistory(memFs, nil, config.StateConfig{History: config.HistoryConfig{Enable: true, Path: "/app/history"}})
+	assert.NoError(t, err)
+	assert.Equal(t, defaultHistoryMax, history.max)
+	assert.Equal(t, dirVersionStore{fs: memFs, path: "/app/history"}, history.store)
+
+	// versions are kept by the state storage without path, even when wrapped
+	storage := &fs{fs: memFs, cfg: ConfigFs{Path: "/app/state.json"}}
+	history, err = NewHistory(memFs, WithSnapshot(WithHistory(storage, nil), time.Second), config.StateConfig{History: config.HistoryConfig{Enable: true}})
+	assert.NoError(t, err)
+	assert.Equal(t, storage, history.store)
+
+	ctrl := gomock.NewController(t)
+	mockStorage := mockTypesStorageState.NewMockStorage(ctrl)
+	mockStorage.EXPECT().Type().Return("mock")
+	_, err = NewHistory(memFs, mockStorage, config.StateConfig{History: config.HistoryConfig{Enable: true}})
+	assert.ErrorContains(t, err, "can not keep history, history path is required")
+
+	_, err = NewHistory(memFs, nil, config.StateConfig{
+		History:    config.HistoryConfig{Enable: true, Path: "/app/history"},
+		Encryption: config.EncryptionConfig{Keys: []config.EncryptionKeyConfig{{ID: "key", File: "/missing.key"}}},
+	})
+	assert.ErrorContains(t, err, "failed to load encryption key key")
+}
+
+func TestHistory_SnapshotListGet(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	history := newTestHistory(t, fs, 2)
+
+	versions, err := history.List()
+	assert.NoError(t, err)
+	assert.Empty(t, versions)
+
+	for i := 1; i <= 3; i++ {
+		certificates := types.Certificates{}
+		for j := 0; j < i; j++ {
+			certificates = append(certificates, &types.Certificate{Identifier: fmt.Sprintf("cert%d", j)})
+		}
+		_, err = history.Snapshot(&types.State{Certificates: certificates})
+		assert.NoError(t, err)
+	}
+	_ = afero.WriteFile(fs, "/app/history/other.json", []byte("{}"), 0644)
+
+	versions, err = history.List()
+	assert.NoError(t, err)
+	assert.Equal(t, []Version{
+		{ID: "20261019T100300.000000000Z", Date: time.Date(2026, 10, 19, 10, 3, 0, 0, time.UTC), Certificates: 3},
+		{ID: "20261019T100200.000000000Z", Date: time.Date(2026, 10, 19, 10, 2, 0, 0, time.UTC), Certificates: 2},
+	}, versions)
+
+	got, err := history.Get("20261019T100200.000000000Z")
+	assert.NoError(t, err)
+	assert.Len(t, got.Certificates, 2)
+
+	_, err = history.Get("20261019T100100.000000000Z")
+	assert.ErrorIs(t, err, ErrVersionNotFound)
+	_, err = history.Get("../state")
+	assert.ErrorIs(t, err, ErrVersionNotFound)
+}
+
+func TestHistory_Snapshot_Encrypted(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	history := newTestHistory(t, fs, 2)
+	history.encryption = newTestEncryption(t, true, "key1")
+
+	id, err := history.Snapshot(&types.State{Certificates: types.Certificates{{Identifier: "foo", Domains: types.Domains{"foo.com"}}}})
+	assert.NoError(t, err)
+	data, _ := afero.ReadFile(fs, history.store.(dirVersionStore).file(id))
+	assert.NotContains(t, string(data), "foo.com")
+
+	got, err := history.Get(id)
+	assert.NoError(t, err)
+	assert.Equal(t, types.Domains{"foo.com"}, got.Certificates[0].Domains)
+
+	history.encryption = newTestEncryption(t, true, "key2")
+	_, err = history.Get(id)
+	assert.ErrorContains(t, err, "failed to decrypt history version 20261019T100100.000000000Z")
+}
+
+func TestHistory_Get_FailParse(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	history := newTestHistory(t, fs, 2)
+	_ = afero.WriteFile(fs, history.store.(dirVersionStore).file("wrong"), []byte("[}"), 0644)
+
+	_, err := history.Get("wrong")
+	assert.ErrorContains(t, err, "failed to parse json history version wrong")
+	_, err = history.List()
+	assert.ErrorContains(t, err, "failed to parse json history version wrong")
+}
+
+func newTestHistoryStorage(t *testing.T) (afero.Fs, *History, state.Storage) {
+	ctx := appCtx.TestContext(nil)
+	history := newTestHistory(t, ctx.Fs, 5)
+	storage := &fs{fs: ctx.Fs, logger: ctx.Logger, cfg: ConfigFs{Path: "/app/state.json"}, checksum: appFs.NewChecksum(ctx.Fs)}
+	return ctx.Fs, history, WithHistory(storage, history)
+}
+
+func Test_historyStorage_Save(t *testing.T) {
+	_, history, storage := newTestHistoryStorage(t)
+	first := &types.State{Account: &acme.Account{}, Certificates: types.Certificates{{Identifier: "foo", Domains: types.Domains{"foo.com"}}}}
+	second := &types.State{Account: &acme.Account{}, Certificates: types.Certificates{}}
+
+	// empty state is not kept
+	assert.NoError(t, storage.Save(first))
+	versions, _ := history.List()
+	assert.Empty(t, versions)
+
+	// unchanged state is not kept
+	assert.NoError(t, storage.Save(first))
+	versions, _ = history.List()
+	assert.Empty(t, versions)
+
+	// state modified concurrently is not kept
+	assert.ErrorIs(t, storage.Save(second), types.ErrStateConflict)
+	versions, _ = history.List()
+	assert.Empty(t, versions)
+
+	second.Revision = first.Revision
+	assert.NoError(t, storage.Save(second))
+	versions, _ = history.List()
+	assert.Len(t, versions, 1)
+	got, _ := history.Get(versions[0].ID)
+	assert.Equal(t, first, got)
+	assert.Equal(t, FsKey, storage.Type())
+}
+
+func Test_historyStorage_Save_FailLoad(t *testing.T) {
+	fs, _, storage := newTestHistoryStorage(t)
+	_ = afero.WriteFile(fs, "/app/state.json", []byte("[}"), 0644)
+
+	err := storage.Save(&types.State{})
+	assert.ErrorContains(t, err, "failed to parse json /app/state.json")
+}
+
+func Test_historyStorage_Save_FailSnapshot(t *testing.T) {
+	fs, history, storage := newTestHistoryStorage(t)
+	_ = afero.WriteFile(fs, "/app/state.json", []byte(`{"certificates":[{"identifier":"foo"}]}`), 0644)
+	history.store = dirVersionStore{fs: afero.NewReadOnlyFs(fs), path: "/app/history"}
+
+	err := storage.Save(&types.State{})
+	assert.ErrorContains(t, err, "failed to save state history")
+}
+
+func TestHistory_Restore(t *testing.T) {
+	_, history, storage := newTestHistoryStorage(t)
+	first := &types.State{Account: &acme.Account{}, Certificates: types.Certificates{{Identifier: "foo", Domains: types.Domains{"foo.com"}}}, FencingToken: 1}
+	second := &types.State{Account: &acme.Account{}, Certificates: types.Certificates{{Identifier: "bar", Domains: types.Domains{"bar.com"}}}, FencingToken: 2}
+	assert.NoError(t, storage.Save(first))
+	second.Revision = first.Revision
+	assert.NoError(t, storage.Save(second))
+	versions, _ := history.List()
+
+	assert.NoError(t, history.Restore(storage, versions[0].ID))
+	got, _ := storage.Load()
+	assert.Equal(t, first.Certificates, got.Certificates)
+	assert.Equal(t, int64(2), got.FencingToken)
+
+	// state before restore is kept
+	versions, _ = history.List()
+	assert.Len(t, versions, 2)
+
+	assert.ErrorIs(t, history.Restore(storage, "missing"), ErrVersionNotFound)
+}
+
+func TestWithHistory(t *testing.T) {
+	history := &History{}
+	storage := WithHistory(&fs{}, history)
+	_, isFinder := storage.(state.CertificateFinder)
+	assert.False(t, isFinder)
+
+	storage = WithHistory(&sqlStorage{}, history)
+	_, isFinder = storage.(state.CertificateFinder)
+	assert.True(t, isFinder)
+}
+
+// testVersionStore checks a version store of a storage keeps, lists and removes versions.
+func testVersionStore(t *testing.T, store state.VersionStore) {
+	ids, err := store.VersionIDs()
+	assert.NoError(t, err)
+	assert.Empty(t, ids)
+	_, err = store.GetVersion("missing")
+	assert.ErrorIs(t, err, ErrVersionNotFound)
+
+	assert.NoError(t, store.PutVersion("20261019T100100.000000000Z", []byte("first")))
+	assert.NoError(t, store.PutVersion("20261019T100200.000000000Z-removal", []byte("second")))
+	ids, err = store.VersionIDs()
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"20261019T100100.000000000Z", "20261019T100200.000000000Z-removal"}, ids)
+	data, err := store.GetVersion("20261019T100200.000000000Z-removal")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("second"), data)
+
+	assert.NoError(t, store.DeleteVersion("20261019T100100.000000000Z"))
+	ids, err = store.VersionIDs()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"20261019T100200.000000000Z-removal"}, ids)
+	_, err = store.GetVersion("20261019T100100.000000000Z")
+	assert.ErrorIs(t, err, ErrVersionNotFound)
+}
+
+func Test_dirVersionStore(t *testing.T) {
+	testVersionStore(t, dirVersionStore{fs: afero.NewMemMapFs(), path: "/app/history"})
+}
+
+func TestHistory_Snapshot_KeepRemovalVersions(t *testing.T) {
+	history := newTestHistory(t, afero.NewMemMapFs(), 1)
+	st := &types.State{Certificates: types.Certificates{{Identifier: "foo"}}}
+
+	_, _ = history.Snapshot(st)
+	removalID, err := history.snapshot(st, true)
+	assert.NoError(t, err)
+	_, _ = history.Snapshot(st)
+	lastID, _ := history.Snapshot(st)
+
+	versions, err := history.List()
+	assert.NoError(t, err)
+	assert.Equal(t, []Version{
+		{ID: lastID, Date: time.Date(2026, 10, 19, 10, 4, 0, 0, time.UTC), Certificates: 1},
+		{ID: removalID, Date: time.Date(2026, 10, 19, 10, 2, 0, 0, time.UTC), Certificates: 1, Removal: true},
+	}, versions)
+}
+
+func TestHistory_Snapshot_MaxAge(t *testing.T) {
+	history := newTestHistory(t, afero.NewMemMapFs(), 10)
+	history.maxAge = time.Minute * 2
+	st := &types.State{Certificates: types.Certificates{{Identifier: "foo"}}}
+
+	_, _ = history.snapshot(st, true)
+	_, _ = history.Snapshot(st)
+	_, _ = history.Snapshot(st)
+	lastID, _ := history.Snapshot(st)
+
+	versions, err := history.List()
+	assert.NoError(t, err)
+	assert.Len(t, versions, 3)
+	assert.Equal(t, lastID, versions[0].ID)
+	assert.False(t, slices.ContainsFunc(versions, func(version Version) bool { return version.Removal }))
+}
+
+func Test_historyStorage_Save_Removal(t *testing.T) {
+	_, history, storage := newTestHistoryStorage(t)
+	first := &types.State{Account: &acme.Account{}, Certificates: types.Certificates{{Identifier: "foo"}, {Identifier: "bar"}}}
+	assert.NoError(t, storage.Save(first))
+
+	second := &types.State{Account: &acme.Account{}, Certificates: types.Certificates{{Identifier: "foo", Domains: types.Domains{"foo.com"}}, {Identifier: "bar"}}, Revision: first.Revision}
+	assert.NoError(t, storage.Save(second))
+	third := &types.State{Account: &acme.Account{}, Certificates: types.Certificates{{Identifier: "foo", Domains: types.Domains{"foo.com"}}}, Revision: second.Revision}
+	assert.NoError(t, storage.Save(third))
+
+	versions, _ := history.List()
+	assert.Len(t, versions, 2)
+	assert.True(t, versions[0].Removal)
+	assert.False(t, versions[1].Removal)
+	got, _ := history.Get(versions[0].ID)
+	assert.Len(t, got.Certificates, 2)
+}
@@ -0,0 +1,277 @@
+package state
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/alexandreh2ag/lets-go-tls/config"
+	appCtx "github.com/alexandreh2ag/lets-go-tls/context"
+	"github.com/alexandreh2ag/lets-go-tls/mapstructure"
+	"github.com/alexandreh2ag/lets-go-tls/types"
+	"github.com/alexandreh2ag/lets-go-tls/types/storage/state"
+	"github.com/go-playground/validator/v10"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	RedisKey        = "redis"
+	RedisClusterKey = "redis-cluster"
+
+	defaultRedisKeyPrefix = "lets-go-tls"
+)
+
+func init() {
+	TypeStorageMapping[RedisKey] = createRedisStorage
+	TypeStorageMapping[RedisClusterKey] = createRedisClusterStorage
+}
+
+var (
+	_ state.Storage       = &redisStorage{}
+	_ state.ChangeTracker = &redisStorage{}
+	_ state.VersionStore  = &redisStorage{}
+
+	// KEYS[1]: state key, KEYS[2]: fencing token key, KEYS[3]: revision key
+	// ARGV[1]: state, ARGV[2]: fencing token, ARGV[3]: revision the state was loaded from
+	// returns {0, new revision} when state is saved, {1, current fencing token} when it is more recent
+	// or {2, current revision} when state was modified since it was loaded
+	saveScript = redis.NewScript(`
+local current = tonumber(redis.call('GET', KEYS[2]) or '0')
+local token = tonumber(ARGV[2])
+if token > 0 and current > token then
+	return {1, current}
+end
+local revision = tonumber(redis.call('GET', KEYS[3]) or '0')
+if revision ~= tonumber(ARGV[3]) then
+	return {2, revision}
+end
+redis.call('SET', KEYS[1], ARGV[1])
+redis.call('SET', KEYS[3], revision + 1)
+if token > current then
+	redis.call('SET', KEYS[2], ARGV[2])
+end
+return {0, revision + 1}
+`)
+)
+
+type ConfigRedis struct {
+	Address   string `mapstructure:"address" validate:"required"`
+	DB        int    `mapstructure:"db"`
+	Username  string `mapstructure:"username"`
+	Password  string `mapstructure:"password"`
+	KeyPrefix string `mapstructure:"key_prefix" validate:"required"`
+}
+
+type ConfigRedisCluster struct {
+	Address   []string `mapstructure:"address" validate:"required,min=1"`
+	Username  string   `mapstructure:"username"`
+	Password  string   `mapstructure:"password"`
+	KeyPrefix string   `mapstructure:"key_prefix" validate:"required"`
+}
+
+type redisStorage struct {
+	client     redis.UniversalClient
+	logger     *slog.Logger
+	encryption *Encryption
+	typ        string
+	keyPrefix  string
+}
+
+func (r redisStorage) Type() string {
+	return r.typ
+}
+
+// keys use a hash tag so state, fencing token and revision are in the same slot with redis cluster.
+func (r redisStorage) keys() []string {
+	return []string{
+		fmt.Sprintf("{%s}:state", r.keyPrefix),
+		fmt.Sprintf("{%s}:fencing_token", r.keyPrefix),
+		fmt.Sprintf("{%s}:revision", r.keyPrefix),
+	}
+}
+
+func (r redisStorage) Load() (*types.State, error) {
+	key := r.keys()[0]
+	data, err := r.client.Get(context.Background(), key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return newState(), nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %s: %v", key, err)
+	}
+	data, err = r.encryption.Decrypt(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt %s: %v", key, err)
+	}
+	return DecodeState(key, data)
+}
+
+// ChangeTag returns the revision, it is incremented by each save.
+func (r redisStorage) ChangeTag() (string, error) {
+	key := r.keys()[2]
+	revision, err := r.client.Get(context.Background(), key).Result()
+	if errors.Is(err, redis.Nil) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to get %s: %v", key, err)
+	}
+	return revision, nil
+}
+
+// Save skips the write when the stored state is unchanged, so revision is not incremented.
+func (r redisStorage) Save(state *types.State) error {
+	state.SchemaVersion = SchemaVersion()
+	keys := r.keys()
+	stored, err := r.client.Get(context.Background(), keys[0]).Bytes()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return fmt.Errorf("failed to get %s: %v", keys[0], err)
+	}
+	data, _ := json.Marshal(state)
+	if stored != nil && r.encryption.Unchanged(stored, data) {
+		return nil
+	}
+
+	next := *state
+	next.Revision++
+	data, _ = json.Marshal(&next)
+	data, err = r.encryption.Encrypt(data)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt state: %v", err)
+	}
+
+	r.logger.Debug(fmt.Sprintf("save state to %s", keys[0]))
+	result, err := saveScript.Run(context.Background(), r.client, keys, data, state.FencingToken, state.Revision).Int64Slice()
+	if err != nil {
+		return fmt.Errorf("failed to write in %s: %v", keys[0], err)
+	}
+	switch result[0] {
+	case 1:
+		return fmt.Errorf("failed to write in %s: %w (%d < %d)", keys[0], types.ErrStaleFencingToken, state.FencingToken, result[1])
+	case 2:
+		return fmt.Errorf("failed to write in %s: %w (revision %d != %d)", keys[0], types.ErrStateConflict, state.Revision, result[1])
+	}
+	state.Revision = next.Revision
+	return nil
+}
+
+// versionsKey is the set of version ids, each version is kept in its own key of the same slot.
+func (r redisStorage) versionsKey() string {
+	return fmt.Sprintf("{%s}:history", r.keyPrefix)
+}
+
+func (r redisStorage) versionKey(id string) string {
+	return fmt.Sprintf("{%s}:history:%s", r.keyPrefix, id)
+}
+
+func (r redisStorage) VersionIDs() ([]string, error) {
+	ids, err := r.client.SMembers(context.Background(), r.versionsKey()).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %s: %v", r.versionsKey(), err)
+	}
+	return ids, nil
+}
+
+func (r redisStorage) GetVersion(id string) ([]byte, error) {
+	data, err := r.client.Get(context.Background(), r.versionKey(id)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, state.ErrVersionNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %s: %v", r.versionKey(id), err)
+	}
+	return data, nil
+}
+
+func (r redisStorage) PutVersion(id string, data []byte) error {
+	_, err := r.client.TxPipelined(context.Background(), func(pipe redis.Pipeliner) error {
+		pipe.Set(context.Background(), r.versionKey(id), data, 0)
+		pipe.SAdd(context.Background(), r.versionsKey(), id)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write in %s: %v", r.versionKey(id), err)
+	}
+	return nil
+}
+
+func (r redisStorage) DeleteVersion(id string) error {
+	_, err := r.client.TxPipelined(context.Background(), func(pipe redis.Pipeliner) error {
+		pipe.Del(context.Background(), r.versionKey(id))
+		pipe.SRem(context.Background(), r.versionsKey(), id)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to remove %s: %v", r.versionKey(id), err)
+	}
+	return nil
+}
+
+func createRedisStorage(ctx appCtx.Context, cfg config.StateConfig) (state.Storage, error) {
+	instanceConfig := ConfigRedis{KeyPrefix: defaultRedisKeyPrefix}
+	err := mapstructure.Decode(cfg.Config, &instanceConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	validate := validator.New()
+	err = validate.Struct(instanceConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	encryption, err := NewEncryption(ctx.GetFS(), cfg.Encryption)
+	if err != nil {
+		return nil, err
+	}
+
+	clientConfig := &redis.Options{
+		Addr:     instanceConfig.Address,
+		DB:       instanceConfig.DB,
+		Username: instanceConfig.Username,
+		Password: instanceConfig.Password,
+	}
+
+	return &redisStorage{
+		client:     redis.NewClient(clientConfig),
+		logger:     ctx.GetLogger(),
+		encryption: encryption,
+		typ:        RedisKey,
+		keyPrefix:  instanceConfig.KeyPrefix,
+	}, nil
+}
+
+func createRedisClusterStorage(ctx appCtx.Context, cfg config.StateConfig) (state.Storage, error) {
+	instanceConfig := ConfigRedisCluster{KeyPrefix: defaultRedisKeyPrefix}
+	err := mapstructure.Decode(cfg.Config, &instanceConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	validate := validator.New()
+	err = validate.Struct(instanceConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	encryption, err := NewEncryption(ctx.GetFS(), cfg.Encryption)
+	if err != nil {
+		return nil, err
+	}
+
+	clientConfig := &redis.ClusterOptions{
+		Addrs:    instanceConfig.Address,
+		Username: instanceConfig.Username,
+		Password: instanceConfig.Password,
+	}
+
+	return &redisStorage{
+		client:     redis.NewClusterClient(clientConfig),
+		logger:     ctx.GetLogger(),
+		encryption: encryption,
+		typ:        RedisClusterKey,
+		keyPrefix:  instanceConfig.KeyPrefix,
+	}, nil
+}
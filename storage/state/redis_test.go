@@ -0,0 +1,328 @@
+package state
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/alexandreh2ag/lets-go-tls/config"
+	appCtx "github.com/alexandreh2ag/lets-go-tls/context"
+	"github.com/alexandreh2ag/lets-go-tls/types"
+	"github.com/alexandreh2ag/lets-go-tls/types/acme"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+func createTestRedisStorage(t *testing.T) (*miniredis.Miniredis, *redisStorage) {
+	ctx := appCtx.TestContext(nil)
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	return server, &redisStorage{client: client, logger: ctx.Logger, typ: RedisKey, keyPrefix: "prefix"}
+}
+
+func Test_redisStorage_Type(t *testing.T) {
+	assert.Equal(t, RedisKey, redisStorage{typ: RedisKey}.Type())
+	assert.Equal(t, RedisClusterKey, redisStorage{typ: RedisClusterKey}.Type())
+}
+
+func Test_redisStorage_Load_Success(t *testing.T) {
+	server, stateStorage := createTestRedisStorage(t)
+	want := &types.State{
+		Account:      &acme.Account{Email: "dev@foo.com", Key: []byte("privatekey")},
+		Certificates: types.Certificates{{Domains: types.Domains{"foo.com", "bar.com"}, Key: []byte("key"), Certificate: []byte("certificate")}},
+	}
+	data, _ := json.Marshal(want)
+	_ = server.Set("{prefix}:state", string(data))
+
+	got, err := stateStorage.Load()
+	assert.NoError(t, err)
+	want.SchemaVersion = SchemaVersion()
+	assert.Equal(t, want, got)
+}
+
+func Test_redisStorage_Load_SuccessKeyNotExist(t *testing.T) {
+	_, stateStorage := createTestRedisStorage(t)
+
+	got, err := stateStorage.Load()
+	assert.NoError(t, err)
+	assert.Equal(t, &types.State{SchemaVersion: SchemaVersion(), Account: &acme.Account{}}, got)
+}
+
+func Test_redisStorage_Load_FailMarshal(t *testing.T) {
+	server, stateStorage := createTestRedisStorage(t)
+	_ = server.Set("{prefix}:state", "[}")
+
+	got, err := stateStorage.Load()
+	assert.Nil(t, got)
+	assert.ErrorContains(t, err, "failed to parse json {prefix}:state: invalid character '}' looking for beginning of value")
+}
+
+func Test_redisStorage_Load_FailGet(t *testing.T) {
+	server, stateStorage := createTestRedisStorage(t)
+	server.Close()
+
+	got, err := stateStorage.Load()
+	assert.Nil(t, got)
+	assert.ErrorContains(t, err, "failed to get {prefix}:state")
+}
+
+func Test_redisStorage_Load_FailDecrypt(t *testing.T) {
+	server, stateStorage := createTestRedisStorage(t)
+	encrypted, _ := newTestEncryption(t, true, "key1").Encrypt([]byte("{}"))
+	_ = server.Set("{prefix}:state", string(encrypted))
+	stateStorage.encryption = newTestEncryption(t, true, "key2")
+
+	got, err := stateStorage.Load()
+	assert.Nil(t, got)
+	assert.EqualError(t, err, "failed to decrypt {prefix}:state: state is encrypted with unknown key key1")
+}
+
+func Test_redisStorage_Save_Success(t *testing.T) {
+	server, stateStorage := createTestRedisStorage(t)
+	s := &types.State{
+		Account:      &acme.Account{Email: "dev@foo.com", Key: []byte("privatekey")},
+		Certificates: types.Certificates{{Domains: types.Domains{"foo.com"}, Key: []byte("key"), Certificate: []byte("certificate")}},
+	}
+
+	err := stateStorage.Save(s)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), s.Revision)
+	data, _ := json.Marshal(s)
+	got, _ := server.Get("{prefix}:state")
+	assert.Equal(t, string(data), got)
+	revision, _ := server.Get("{prefix}:revision")
+	assert.Equal(t, "1", revision)
+	assert.False(t, server.Exists("{prefix}:fencing_token"))
+}
+
+func Test_redisStorage_Save_FailRevisionConflict(t *testing.T) {
+	server, stateStorage := createTestRedisStorage(t)
+	_ = server.Set("{prefix}:revision", "3")
+
+	err := stateStorage.Save(&types.State{Certificates: types.Certificates{}, Revision: 2})
+	assert.ErrorIs(t, err, types.ErrStateConflict)
+	assert.EqualError(t, err, "failed to write in {prefix}:state: state was modified concurrently (revision 2 != 3)")
+	assert.False(t, server.Exists("{prefix}:state"))
+}
+
+func Test_redisStorage_Save_SuccessWithFencingToken(t *testing.T) {
+	server, stateStorage := createTestRedisStorage(t)
+	_ = server.Set("{prefix}:fencing_token", "10")
+
+	err := stateStorage.Save(&types.State{Certificates: types.Certificates{}, FencingToken: 11})
+	assert.NoError(t, err)
+	got, _ := stateStorage.Load()
+	assert.Equal(t, int64(11), got.FencingToken)
+	token, _ := server.Get("{prefix}:fencing_token")
+	assert.Equal(t, "11", token)
+}
+
+func Test_redisStorage_Save_FailStaleFencingToken(t *testing.T) {
+	server, stateStorage := createTestRedisStorage(t)
+	_ = server.Set("{prefix}:state", `{"certificates":[],"fencing_token":10}`)
+	_ = server.Set("{prefix}:fencing_token", "10")
+
+	err := stateStorage.Save(&types.State{Certificates: types.Certificates{}, FencingToken: 9})
+	assert.ErrorIs(t, err, types.ErrStaleFencingToken)
+	assert.ErrorContains(t, err, "failed to write in {prefix}:state: stale fencing token (9 < 10)")
+	got, _ := server.Get("{prefix}:state")
+	assert.Equal(t, `{"certificates":[],"fencing_token":10}`, got)
+}
+
+func Test_redisStorage_Save_FailWrite(t *testing.T) {
+	server, stateStorage := createTestRedisStorage(t)
+	server.Close()
+
+	err := stateStorage.Save(&types.State{Certificates: types.Certificates{}})
+	assert.ErrorContains(t, err, "failed to get {prefix}:state")
+}
+
+func Test_redisStorage_Save_SuccessUnchanged(t *testing.T) {
+	server, stateStorage := createTestRedisStorage(t)
+	s := &types.State{
+		Account:      &acme.Account{Email: "dev@foo.com", Key: []byte("privatekey")},
+		Certificates: types.Certificates{{Domains: types.Domains{"foo.com"}, Key: []byte("key")}},
+	}
+	assert.NoError(t, stateStorage.Save(s))
+
+	assert.NoError(t, stateStorage.Save(s))
+	assert.Equal(t, int64(1), s.Revision)
+	revision, _ := server.Get("{prefix}:revision")
+	assert.Equal(t, "1", revision)
+
+	loaded, err := stateStorage.Load()
+	assert.NoError(t, err)
+	assert.NoError(t, stateStorage.Save(loaded))
+	assert.Equal(t, int64(1), loaded.Revision)
+	revision, _ = server.Get("{prefix}:revision")
+	assert.Equal(t, "1", revision)
+}
+
+func Test_redisStorage_Save_SuccessUnchangedEncrypted(t *testing.T) {
+	server, stateStorage := createTestRedisStorage(t)
+	stateStorage.encryption = newTestEncryption(t, true, "key1")
+	s := &types.State{
+		Account:      &acme.Account{Email: "dev@foo.com", Key: []byte("privatekey")},
+		Certificates: types.Certificates{{Domains: types.Domains{"foo.com"}, Key: []byte("key")}},
+	}
+	assert.NoError(t, stateStorage.Save(s))
+	stored, _ := server.Get("{prefix}:state")
+
+	assert.NoError(t, stateStorage.Save(s))
+	revision, _ := server.Get("{prefix}:revision")
+	assert.Equal(t, "1", revision)
+	got, _ := server.Get("{prefix}:state")
+	assert.Equal(t, stored, got)
+}
+
+func Test_redisStorage_ChangeTag(t *testing.T) {
+	server, stateStorage := createTestRedisStorage(t)
+	tag, err := stateStorage.ChangeTag()
+	assert.NoError(t, err)
+	assert.Empty(t, tag)
+
+	assert.NoError(t, stateStorage.Save(&types.State{Account: &acme.Account{}}))
+	tag, err = stateStorage.ChangeTag()
+	assert.NoError(t, err)
+	assert.Equal(t, "1", tag)
+
+	server.Close()
+	_, err = stateStorage.ChangeTag()
+	assert.ErrorContains(t, err, "failed to get {prefix}:revision")
+}
+
+func Test_redisStorage_SaveLoad_SuccessEncrypted(t *testing.T) {
+	server, stateStorage := createTestRedisStorage(t)
+	stateStorage.encryption = newTestEncryption(t, true, "key1")
+	s := &types.State{
+		Account:      &acme.Account{Email: "dev@foo.com", Key: []byte("privatekey")},
+		Certificates: types.Certificates{{Domains: types.Domains{"foo.com"}, Key: []byte("key"), Certificate: []byte("certificate")}},
+	}
+
+	assert.NoError(t, stateStorage.Save(s))
+	encrypted, _ := server.Get("{prefix}:state")
+	assert.NotContains(t, encrypted, "foo.com")
+
+	got, err := stateStorage.Load()
+	assert.NoError(t, err)
+	assert.Equal(t, s, got)
+}
+
+func Test_createRedisStorage(t *testing.T) {
+	ctx := appCtx.TestContext(nil)
+
+	tests := []struct {
+		name        string
+		cfg         config.StateConfig
+		wantPrefix  string
+		errContains string
+	}{
+		{
+			name:       "Success",
+			cfg:        config.StateConfig{Type: RedisKey, Config: map[string]interface{}{"address": "127.0.0.1:6379"}},
+			wantPrefix: defaultRedisKeyPrefix,
+		},
+		{
+			name:       "SuccessWithConfig",
+			cfg:        config.StateConfig{Type: RedisKey, Config: map[string]interface{}{"address": "127.0.0.1:6379", "db": 1, "username": "user", "password": "pass", "key_prefix": "foo"}},
+			wantPrefix: "foo",
+		},
+		{
+			name:        "FailDecodeCfg",
+			cfg:         config.StateConfig{Type: RedisKey, Config: map[string]interface{}{"address": []string{}}},
+			errContains: "'address' expected type 'string', got unconvertible type '[]string'",
+		},
+		{
+			name:        "FailValidateCfg",
+			cfg:         config.StateConfig{Type: RedisKey, Config: map[string]interface{}{"address": ""}},
+			errContains: "Field validation for 'Address' failed on the 'required' tag",
+		},
+		{
+			name:        "FailValidateKeyPrefix",
+			cfg:         config.StateConfig{Type: RedisKey, Config: map[string]interface{}{"address": "127.0.0.1:6379", "key_prefix": ""}},
+			errContains: "Field validation for 'KeyPrefix' failed on the 'required' tag",
+		},
+		{
+			name: "FailEncryptionKey",
+			cfg: config.StateConfig{
+				Type:       RedisKey,
+				Config:     map[string]interface{}{"address": "127.0.0.1:6379"},
+				Encryption: config.EncryptionConfig{Enable: true, Keys: []config.EncryptionKeyConfig{{ID: "key", File: "/missing.key"}}},
+			},
+			errContains: "failed to load encryption key key",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := createRedisStorage(ctx, tt.cfg)
+			if tt.errContains != "" {
+				assert.Nil(t, got)
+				assert.ErrorContains(t, err, tt.errContains)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, RedisKey, got.Type())
+			assert.Equal(t, tt.wantPrefix, got.(*redisStorage).keyPrefix)
+		})
+	}
+}
+
+func Test_createRedisClusterStorage(t *testing.T) {
+	ctx := appCtx.TestContext(nil)
+
+	tests := []struct {
+		name        string
+		cfg         config.StateConfig
+		wantPrefix  string
+		errContains string
+	}{
+		{
+			name:       "Success",
+			cfg:        config.StateConfig{Type: RedisClusterKey, Config: map[string]interface{}{"address": []string{"127.0.0.1:6379"}}},
+			wantPrefix: defaultRedisKeyPrefix,
+		},
+		{
+			name:       "SuccessWithConfig",
+			cfg:        config.StateConfig{Type: RedisClusterKey, Config: map[string]interface{}{"address": []string{"127.0.0.1:6379"}, "username": "user", "password": "pass", "key_prefix": "foo"}},
+			wantPrefix: "foo",
+		},
+		{
+			name:        "FailDecodeCfg",
+			cfg:         config.StateConfig{Type: RedisClusterKey, Config: map[string]interface{}{"address": ""}},
+			errContains: "'address' source data must be an array or slice, got string",
+		},
+		{
+			name:        "FailValidateCfg",
+			cfg:         config.StateConfig{Type: RedisClusterKey, Config: map[string]interface{}{"address": []string{}}},
+			errContains: "Field validation for 'Address' failed on the 'min' tag",
+		},
+		{
+			name: "FailEncryptionKey",
+			cfg: config.StateConfig{
+				Type:       RedisClusterKey,
+				Config:     map[string]interface{}{"address": []string{"127.0.0.1:6379"}},
+				Encryption: config.EncryptionConfig{Enable: true, Keys: []config.EncryptionKeyConfig{{ID: "key", File: "/missing.key"}}},
+			},
+			errContains: "failed to load encryption key key",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := createRedisClusterStorage(ctx, tt.cfg)
+			if tt.errContains != "" {
+				assert.Nil(t, got)
+				assert.ErrorContains(t, err, tt.errContains)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, RedisClusterKey, got.Type())
+			assert.Equal(t, tt.wantPrefix, got.(*redisStorage).keyPrefix)
+		})
+	}
+}
+
+func Test_redisStorage_VersionStore(t *testing.T) {
+	server, stateStorage := createTestRedisStorage(t)
+	testVersionStore(t, stateStorage)
+	assert.True(t, server.Exists("{prefix}:history:20261019T100200.000000000Z-removal"))
+}
@@ -0,0 +1,52 @@
+package state
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/alexandreh2ag/lets-go-tls/types"
+)
+
+// checkRevision refuses to save a state which was not loaded from the current revision of location.
+func checkRevision(location string, current, st *types.State) error {
+	if current.Revision != st.Revision {
+		return fmt.Errorf("failed to write in %s: %w (revision %d != %d)", location, types.ErrStateConflict, st.Revision, current.Revision)
+	}
+	return nil
+}
+
+// Rebase applies changes made on local since base onto latest, certificate by certificate:
+// a certificate changed, added or removed in local is replaced, added or removed in latest, other certificates of latest are kept.
+// A certificate changed in local but removed from latest stays removed. Accounts are taken from local when changed.
+// The returned state has the revision of latest and the fencing token of local, so it can be saved.
+func Rebase(base, local, latest *types.State) *types.State {
+	rebased := latest.Clone()
+	rebased.FencingToken = local.FencingToken
+	if !jsonEqual(base.Account, local.Account) {
+		rebased.Account = local.Account
+	}
+	if !jsonEqual(base.StagingAccount, local.StagingAccount) {
+		rebased.StagingAccount = local.StagingAccount
+	}
+
+	for _, certificate := range base.Certificates {
+		if local.Certificates.GetCertificate(certificate.Identifier) == nil {
+			rebased.Certificates = rebased.Certificates.Deletes(types.Certificates{certificate})
+		}
+	}
+	for _, certificate := range local.Certificates {
+		previous := base.Certificates.GetCertificate(certificate.Identifier)
+		if previous != nil && jsonEqual(previous, certificate) {
+			continue
+		}
+		index := slices.IndexFunc(rebased.Certificates, func(other *types.Certificate) bool {
+			return other.Identifier == certificate.Identifier
+		})
+		if index >= 0 {
+			rebased.Certificates[index] = certificate
+		} else if previous == nil {
+			rebased.Certificates = append(rebased.Certificates, certificate)
+		}
+	}
+	return rebased
+}
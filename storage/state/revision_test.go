@@ -0,0 +1,62 @@
+package state
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alexandreh2ag/lets-go-tls/types"
+	"github.com/alexandreh2ag/lets-go-tls/types/acme"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_checkRevision(t *testing.T) {
+	assert.NoError(t, checkRevision("/app/state.json", &types.State{Revision: 2}, &types.State{Revision: 2}))
+
+	err := checkRevision("/app/state.json", &types.State{Revision: 3}, &types.State{Revision: 2})
+	assert.ErrorIs(t, err, types.ErrStateConflict)
+	assert.EqualError(t, err, "failed to write in /app/state.json: state was modified concurrently (revision 2 != 3)")
+}
+
+func TestRebase(t *testing.T) {
+	now := time.Date(2026, 10, 19, 10, 0, 0, 0, time.UTC)
+	base := &types.State{
+		Account: &acme.Account{Email: "dev@foo.com"},
+		Certificates: types.Certificates{
+			{Identifier: "renewed", Domains: types.Domains{"renewed.com"}},
+			{Identifier: "unused", Domains: types.Domains{"unused.com"}},
+			{Identifier: "untouched", Domains: types.Domains{"untouched.com"}},
+			{Identifier: "deleted", Domains: types.Domains{"deleted.com"}},
+		},
+		Revision: 1,
+	}
+	local := base.Clone()
+	local.FencingToken = 5
+	local.Certificates.GetCertificate("renewed").ExpirationDate = now
+	local.Certificates.GetCertificate("deleted").ObtainFailCount = 1
+	local.Certificates = local.Certificates.Deletes(types.Certificates{{Identifier: "unused"}})
+	local.Certificates = append(local.Certificates, &types.Certificate{Identifier: "added", Domains: types.Domains{"added.com"}})
+
+	// certificates changed by a CLI command during the run
+	latest := base.Clone()
+	latest.Revision = 2
+	latest.Account = &acme.Account{Email: "other@foo.com"}
+	latest.Certificates.GetCertificate("untouched").Resolver = "http"
+	latest.Certificates = latest.Certificates.Deletes(types.Certificates{{Identifier: "deleted"}})
+	latest.Certificates = append(latest.Certificates, &types.Certificate{Identifier: "imported", Domains: types.Domains{"imported.com"}})
+
+	want := &types.State{
+		Account: &acme.Account{Email: "other@foo.com"},
+		Certificates: types.Certificates{
+			{Identifier: "renewed", Domains: types.Domains{"renewed.com"}, ExpirationDate: now},
+			{Identifier: "untouched", Domains: types.Domains{"untouched.com"}, Resolver: "http"},
+			{Identifier: "imported", Domains: types.Domains{"imported.com"}},
+			{Identifier: "added", Domains: types.Domains{"added.com"}},
+		},
+		FencingToken: 5,
+		Revision:     2,
+	}
+	assert.Equal(t, want, Rebase(base, local, latest))
+
+	local.Account = &acme.Account{Email: "new@foo.com"}
+	assert.Equal(t, "new@foo.com", Rebase(base, local, latest).Account.Email)
+}
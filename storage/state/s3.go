@@ -0,0 +1,359 @@
+package state
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/alexandreh2ag/lets-go-tls/config"
+	appCtx "github.com/alexandreh2ag/lets-go-tls/context"
+	"github.com/alexandreh2ag/lets-go-tls/mapstructure"
+	"github.com/alexandreh2ag/lets-go-tls/types"
+	"github.com/alexandreh2ag/lets-go-tls/types/storage/state"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsHttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	awsConfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3Types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/go-playground/validator/v10"
+)
+
+const (
+	S3Key = "s3"
+
+	defaultS3Key    = "lets-go-tls/state.json"
+	defaultS3Region = "us-east-1"
+)
+
+func init() {
+	TypeStorageMapping[S3Key] = createS3Storage
+}
+
+var (
+	_ state.Storage       = &s3Storage{}
+	_ state.ChangeTracker = &s3Storage{}
+	_ state.VersionStore  = &s3Storage{}
+)
+
+type ConfigS3 struct {
+	Bucket          string `mapstructure:"bucket" validate:"required"`
+	Key             string `mapstructure:"key" validate:"required"`
+	Region          string `mapstructure:"region" validate:"required"`
+	Endpoint        string `mapstructure:"endpoint" validate:"omitempty,url"`
+	ForcePathStyle  bool   `mapstructure:"force_path_style"`
+	AccessKeyID     string `mapstructure:"access_key_id" validate:"required_with=SecretAccessKey"`
+	SecretAccessKey string `mapstructure:"secret_access_key" validate:"required_with=AccessKeyID"`
+}
+
+// s3Client is the part of the s3 client used by the storage.
+type s3Client interface {
+	GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error)
+	HeadObject(ctx context.Context, params *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error)
+	PutObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error)
+	DeleteObject(ctx context.Context, params *s3.DeleteObjectInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectOutput, error)
+	ListObjectsV2(ctx context.Context, params *s3.ListObjectsV2Input, optFns ...func(*s3.Options)) (*s3.ListObjectsV2Output, error)
+}
+
+type s3Storage struct {
+	client     s3Client
+	logger     *slog.Logger
+	encryption *Encryption
+	cfg        ConfigS3
+	last       *s3Object
+}
+
+// s3Object is the last object loaded or saved. Save of a state coming from this object is conditioned on its ETag,
+// so a write of another process since Load is refused by the storage itself.
+type s3Object struct {
+	mutex    sync.Mutex
+	known    bool
+	revision int64
+	data     []byte
+	etag     string
+}
+
+func (o *s3Object) set(revision int64, data []byte, etag string) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	// an existing object without ETag can not be used for a conditional write
+	o.known = data == nil || etag != ""
+	o.revision, o.data, o.etag = revision, data, etag
+}
+
+// get returns content and ETag of the object when revision was loaded or saved last.
+func (o *s3Object) get(revision int64) ([]byte, string, bool) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	if !o.known || o.revision != revision {
+		return nil, "", false
+	}
+	return o.data, o.etag, true
+}
+
+func (s s3Storage) Type() string {
+	return S3Key
+}
+
+func (s s3Storage) location() string {
+	return fmt.Sprintf("s3://%s/%s", s.cfg.Bucket, s.cfg.Key)
+}
+
+// get returns the stored object and its ETag, an empty ETag means the object does not exist.
+func (s s3Storage) get() ([]byte, string, error) {
+	output, err := s.client.GetObject(context.Background(), &s3.GetObjectInput{
+		Bucket: aws.String(s.cfg.Bucket),
+		Key:    aws.String(s.cfg.Key),
+	})
+	if err != nil {
+		var noSuchKey *s3Types.NoSuchKey
+		if errors.As(err, &noSuchKey) {
+			return nil, "", nil
+		}
+		return nil, "", fmt.Errorf("failed to get %s: %v", s.location(), err)
+	}
+	defer output.Body.Close()
+	data, err := io.ReadAll(output.Body)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read %s: %v", s.location(), err)
+	}
+	return data, aws.ToString(output.ETag), nil
+}
+
+func (s s3Storage) decode(data []byte) (*types.State, error) {
+	if data == nil {
+		return newState(), nil
+	}
+	data, err := s.encryption.Decrypt(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt %s: %v", s.location(), err)
+	}
+	return DecodeState(s.location(), data)
+}
+
+func (s s3Storage) Load() (*types.State, error) {
+	data, etag, err := s.get()
+	if err != nil {
+		return nil, err
+	}
+	st, err := s.decode(data)
+	if err != nil {
+		return nil, err
+	}
+	s.last.set(st.Revision, data, etag)
+	return st, nil
+}
+
+// ChangeTag returns the ETag of the object with a HEAD request.
+func (s s3Storage) ChangeTag() (string, error) {
+	output, err := s.client.HeadObject(context.Background(), &s3.HeadObjectInput{
+		Bucket: aws.String(s.cfg.Bucket),
+		Key:    aws.String(s.cfg.Key),
+	})
+	if err != nil {
+		if responseStatusCode(err) == http.StatusNotFound {
+			return "", nil
+		}
+		return "", fmt.Errorf("failed to head %s: %v", s.location(), err)
+	}
+	return aws.ToString(output.ETag), nil
+}
+
+// Save writes state only if the object was not modified since state was loaded (If-Match on its ETag, or If-None-Match
+// when it did not exist), so concurrent replicas cannot overwrite each other.
+// A state which was not loaded by this storage is checked against the object read at save.
+func (s s3Storage) Save(state *types.State) error {
+	state.SchemaVersion = SchemaVersion()
+	stored, etag, loaded := s.last.get(state.Revision)
+	if !loaded {
+		var err error
+		stored, etag, err = s.get()
+		if err != nil {
+			return err
+		}
+	}
+
+	current, err := s.decode(stored)
+	if err != nil {
+		return err
+	}
+	if state.FencingToken > 0 && current.FencingToken > state.FencingToken {
+		return fmt.Errorf("failed to write in %s: %w (%d < %d)", s.location(), types.ErrStaleFencingToken, state.FencingToken, current.FencingToken)
+	}
+	err = checkRevision(s.location(), current, state)
+	if err != nil {
+		return err
+	}
+
+	data, _ := json.MarshalIndent(state, "", "  ")
+	if stored != nil && s.encryption.Unchanged(stored, data) {
+		return nil
+	}
+
+	next := *state
+	next.Revision++
+	data, _ = json.MarshalIndent(&next, "", "  ")
+	data, err = s.encryption.Encrypt(data)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt state: %v", err)
+	}
+
+	input := &s3.PutObjectInput{
+		Bucket:      aws.String(s.cfg.Bucket),
+		Key:         aws.String(s.cfg.Key),
+		Body:        bytes.NewReader(data),
+		ContentType: aws.String("application/json"),
+		IfNoneMatch: aws.String("*"),
+	}
+	if etag != "" {
+		input.IfNoneMatch, input.IfMatch = nil, aws.String(etag)
+	}
+
+	s.logger.Info(fmt.Sprintf("save state to %s", s.location()))
+	output, err := s.client.PutObject(context.Background(), input)
+	if err != nil {
+		if status := responseStatusCode(err); status == http.StatusPreconditionFailed || status == http.StatusConflict {
+			return fmt.Errorf("failed to write in %s: %w", s.location(), types.ErrStateConflict)
+		}
+		return fmt.Errorf("failed to write in %s: %v", s.location(), err)
+	}
+
+	s.last.set(next.Revision, data, aws.ToString(output.ETag))
+	state.Revision = next.Revision
+	return nil
+}
+
+// versionsPrefix is the prefix of version objects, they are kept next to the state object.
+func (s s3Storage) versionsPrefix() string {
+	return s.cfg.Key + ".history/"
+}
+
+func (s s3Storage) versionLocation(id string) string {
+	return fmt.Sprintf("s3://%s/%s%s", s.cfg.Bucket, s.versionsPrefix(), id)
+}
+
+func (s s3Storage) VersionIDs() ([]string, error) {
+	ids := []string{}
+	paginator := s3.NewListObjectsV2Paginator(s.client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.cfg.Bucket),
+		Prefix: aws.String(s.versionsPrefix()),
+	})
+	for paginator.HasMorePages() {
+		output, err := paginator.NextPage(context.Background())
+		if err != nil {
+			return nil, fmt.Errorf("failed to list s3://%s/%s: %v", s.cfg.Bucket, s.versionsPrefix(), err)
+		}
+		for _, object := range output.Contents {
+			ids = append(ids, strings.TrimPrefix(aws.ToString(object.Key), s.versionsPrefix()))
+		}
+	}
+	return ids, nil
+}
+
+func (s s3Storage) GetVersion(id string) ([]byte, error) {
+	output, err := s.client.GetObject(context.Background(), &s3.GetObjectInput{
+		Bucket: aws.String(s.cfg.Bucket),
+		Key:    aws.String(s.versionsPrefix() + id),
+	})
+	if err != nil {
+		var noSuchKey *s3Types.NoSuchKey
+		if errors.As(err, &noSuchKey) {
+			return nil, state.ErrVersionNotFound
+		}
+		return nil, fmt.Errorf("failed to get %s: %v", s.versionLocation(id), err)
+	}
+	defer output.Body.Close()
+	data, err := io.ReadAll(output.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %v", s.versionLocation(id), err)
+	}
+	return data, nil
+}
+
+func (s s3Storage) PutVersion(id string, data []byte) error {
+	_, err := s.client.PutObject(context.Background(), &s3.PutObjectInput{
+		Bucket: aws.String(s.cfg.Bucket),
+		Key:    aws.String(s.versionsPrefix() + id),
+		Body:   bytes.NewReader(data),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write in %s: %v", s.versionLocation(id), err)
+	}
+	return nil
+}
+
+func (s s3Storage) DeleteVersion(id string) error {
+	_, err := s.client.DeleteObject(context.Background(), &s3.DeleteObjectInput{
+		Bucket: aws.String(s.cfg.Bucket),
+		Key:    aws.String(s.versionsPrefix() + id),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to remove %s: %v", s.versionLocation(id), err)
+	}
+	return nil
+}
+
+func createS3Storage(ctx appCtx.Context, cfg config.StateConfig) (state.Storage, error) {
+	instanceConfig := ConfigS3{Key: defaultS3Key, Region: defaultS3Region}
+	err := mapstructure.Decode(cfg.Config, &instanceConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	validate := validator.New()
+	err = validate.Struct(instanceConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	encryption, err := NewEncryption(ctx.GetFS(), cfg.Encryption)
+	if err != nil {
+		return nil, err
+	}
+
+	// checksums are only sent when required, S3 compatible endpoints may not support them
+	options := []func(*awsConfig.LoadOptions) error{
+		awsConfig.WithRegion(instanceConfig.Region),
+		awsConfig.WithRequestChecksumCalculation(aws.RequestChecksumCalculationWhenRequired),
+		awsConfig.WithResponseChecksumValidation(aws.ResponseChecksumValidationWhenRequired),
+	}
+	if instanceConfig.AccessKeyID != "" {
+		options = append(options, awsConfig.WithCredentialsProvider(
+			credentials.NewStaticCredentialsProvider(instanceConfig.AccessKeyID, instanceConfig.SecretAccessKey, ""),
+		))
+	}
+	cfgAws, err := awsConfig.LoadDefaultConfig(context.Background(), options...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load s3 config: %v", err)
+	}
+	client := s3.NewFromConfig(cfgAws, func(o *s3.Options) {
+		o.UsePathStyle = instanceConfig.ForcePathStyle
+		if instanceConfig.Endpoint != "" {
+			o.BaseEndpoint = aws.String(instanceConfig.Endpoint)
+		}
+	})
+
+	return &s3Storage{
+		client:     client,
+		logger:     ctx.GetLogger(),
+		encryption: encryption,
+		cfg:        instanceConfig,
+		last:       &s3Object{},
+	}, nil
+}
+
+// responseStatusCode returns the HTTP status code of an s3 error, 0 when the request got no response.
+func responseStatusCode(err error) int {
+	var responseErr *awsHttp.ResponseError
+	if errors.As(err, &responseErr) {
+		return responseErr.HTTPStatusCode()
+	}
+	return 0
+}
@@ -0,0 +1,418 @@
+package state
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/alexandreh2ag/lets-go-tls/config"
+	appCtx "github.com/alexandreh2ag/lets-go-tls/context"
+	"github.com/alexandreh2ag/lets-go-tls/types"
+	"github.com/alexandreh2ag/lets-go-tls/types/acme"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeS3 is an in-process S3 compatible server (path style) supporting conditional writes.
+type fakeS3 struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+	// beforePut is called before a put is applied, to simulate a concurrent writer
+	beforePut func()
+	fail      bool
+	gets      int
+}
+
+func etagOf(data []byte) string {
+	sum := md5.Sum(data)
+	return fmt.Sprintf("%q", hex.EncodeToString(sum[:]))
+}
+
+func writeS3Error(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	_, _ = fmt.Fprintf(w, "<Error><Code>%s</Code><Message>%s</Message></Error>", code, code)
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	key := strings.TrimPrefix(r.URL.Path, "/")
+	if f.fail {
+		writeS3Error(w, http.StatusForbidden, "AccessDenied")
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		if r.URL.Query().Get("list-type") == "2" {
+			f.list(w, key, r.URL.Query().Get("prefix"))
+			return
+		}
+		f.mu.Lock()
+		f.gets++
+		data, ok := f.objects[key]
+		f.mu.Unlock()
+		if !ok {
+			writeS3Error(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		w.Header().Set("ETag", etagOf(data))
+		_, _ = w.Write(data)
+	case http.MethodHead:
+		f.mu.Lock()
+		data, ok := f.objects[key]
+		f.mu.Unlock()
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("ETag", etagOf(data))
+	case http.MethodPut:
+		body, _ := io.ReadAll(r.Body)
+		if f.beforePut != nil {
+			f.beforePut()
+		}
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		current, exists := f.objects[key]
+		if r.Header.Get("If-None-Match") == "*" && exists {
+			writeS3Error(w, http.StatusPreconditionFailed, "PreconditionFailed")
+			return
+		}
+		if ifMatch := r.Header.Get("If-Match"); ifMatch != "" && (!exists || ifMatch != etagOf(current)) {
+			writeS3Error(w, http.StatusPreconditionFailed, "PreconditionFailed")
+			return
+		}
+		f.objects[key] = body
+		w.Header().Set("ETag", etagOf(body))
+	case http.MethodDelete:
+		f.mu.Lock()
+		delete(f.objects, key)
+		f.mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeS3Error(w, http.StatusMethodNotAllowed, "MethodNotAllowed")
+	}
+}
+
+func (f *fakeS3) list(w http.ResponseWriter, bucket string, prefix string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	w.Header().Set("Content-Type", "application/xml")
+	_, _ = fmt.Fprintf(w, "<ListBucketResult><Name>%s</Name><IsTruncated>false</IsTruncated>", bucket)
+	for key := range f.objects {
+		if name, ok := strings.CutPrefix(key, bucket+"/"); ok && strings.HasPrefix(name, prefix) {
+			_, _ = fmt.Fprintf(w, "<Contents><Key>%s</Key></Contents>", name)
+		}
+	}
+	_, _ = fmt.Fprint(w, "</ListBucketResult>")
+}
+
+func createTestS3Storage(t *testing.T) (*fakeS3, *s3Storage) {
+	ctx := appCtx.TestContext(nil)
+	fake := &fakeS3{objects: map[string][]byte{}}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	cfg := config.StateConfig{Type: S3Key, Config: map[string]interface{}{
+		"bucket":            "bucket",
+		"key":               "state.json",
+		"endpoint":          server.URL,
+		"force_path_style":  true,
+		"access_key_id":     "access",
+		"secret_access_key": "secret",
+	}}
+	storage, err := createS3Storage(ctx, cfg)
+	assert.NoError(t, err)
+	return fake, storage.(*s3Storage)
+}
+
+func Test_s3Storage_Type(t *testing.T) {
+	assert.Equal(t, S3Key, s3Storage{}.Type())
+}
+
+func Test_s3Storage_Load_Success(t *testing.T) {
+	fake, stateStorage := createTestS3Storage(t)
+	want := &types.State{
+		Account:      &acme.Account{Email: "dev@foo.com", Key: []byte("privatekey")},
+		Certificates: types.Certificates{{Domains: types.Domains{"foo.com", "bar.com"}, Key: []byte("key"), Certificate: []byte("certificate")}},
+	}
+	fake.objects["bucket/state.json"], _ = json.Marshal(want)
+
+	got, err := stateStorage.Load()
+	assert.NoError(t, err)
+	want.SchemaVersion = SchemaVersion()
+	assert.Equal(t, want, got)
+}
+
+func Test_s3Storage_Load_SuccessKeyNotExist(t *testing.T) {
+	_, stateStorage := createTestS3Storage(t)
+
+	got, err := stateStorage.Load()
+	assert.NoError(t, err)
+	assert.Equal(t, &types.State{SchemaVersion: SchemaVersion(), Account: &acme.Account{}}, got)
+}
+
+func Test_s3Storage_Load_FailMarshal(t *testing.T) {
+	fake, stateStorage := createTestS3Storage(t)
+	fake.objects["bucket/state.json"] = []byte("[}")
+
+	got, err := stateStorage.Load()
+	assert.Nil(t, got)
+	assert.ErrorContains(t, err, "failed to parse json s3://bucket/state.json: invalid character '}' looking for beginning of value")
+}
+
+func Test_s3Storage_Load_FailGet(t *testing.T) {
+	fake, stateStorage := createTestS3Storage(t)
+	fake.fail = true
+
+	got, err := stateStorage.Load()
+	assert.Nil(t, got)
+	assert.ErrorContains(t, err, "failed to get s3://bucket/state.json: operation error S3: GetObject")
+	assert.ErrorContains(t, err, "AccessDenied")
+}
+
+func Test_s3Storage_Load_FailDecrypt(t *testing.T) {
+	fake, stateStorage := createTestS3Storage(t)
+	fake.objects["bucket/state.json"], _ = newTestEncryption(t, true, "key1").Encrypt([]byte("{}"))
+	stateStorage.encryption = newTestEncryption(t, true, "key2")
+
+	got, err := stateStorage.Load()
+	assert.Nil(t, got)
+	assert.EqualError(t, err, "failed to decrypt s3://bucket/state.json: state is encrypted with unknown key key1")
+}
+
+func Test_s3Storage_Save_Success(t *testing.T) {
+	fake, stateStorage := createTestS3Storage(t)
+	s := &types.State{
+		Account:      &acme.Account{Email: "dev@foo.com", Key: []byte("privatekey")},
+		Certificates: types.Certificates{{Domains: types.Domains{"foo.com"}, Key: []byte("key"), Certificate: []byte("certificate")}},
+	}
+
+	assert.NoError(t, stateStorage.Save(s))
+	assert.Equal(t, int64(1), s.Revision)
+	data, _ := json.MarshalIndent(s, "", "  ")
+	assert.Equal(t, data, fake.objects["bucket/state.json"])
+
+	s.Certificates = append(s.Certificates, &types.Certificate{Domains: types.Domains{"bar.com"}})
+	assert.NoError(t, stateStorage.Save(s))
+	got, err := stateStorage.Load()
+	assert.NoError(t, err)
+	assert.Equal(t, s, got)
+}
+
+func Test_s3Storage_Save_SuccessUnchanged(t *testing.T) {
+	fake, stateStorage := createTestS3Storage(t)
+	s := &types.State{Certificates: types.Certificates{}}
+	assert.NoError(t, stateStorage.Save(s))
+
+	// an unchanged state is not written, so no conflict can happen
+	fake.beforePut = func() { t.Fatal("unchanged state must not be written") }
+	assert.NoError(t, stateStorage.Save(s))
+}
+
+func Test_s3Storage_Save_SuccessWithFencingToken(t *testing.T) {
+	fake, stateStorage := createTestS3Storage(t)
+	fake.objects["bucket/state.json"] = []byte(`{"certificates":[],"fencing_token":10}`)
+
+	assert.NoError(t, stateStorage.Save(&types.State{Certificates: types.Certificates{}, FencingToken: 11}))
+	got, _ := stateStorage.Load()
+	assert.Equal(t, int64(11), got.FencingToken)
+}
+
+func Test_s3Storage_Save_FailStaleFencingToken(t *testing.T) {
+	fake, stateStorage := createTestS3Storage(t)
+	fake.objects["bucket/state.json"] = []byte(`{"certificates":[],"fencing_token":10}`)
+
+	err := stateStorage.Save(&types.State{Certificates: types.Certificates{}, FencingToken: 9})
+	assert.ErrorIs(t, err, types.ErrStaleFencingToken)
+	assert.ErrorContains(t, err, "failed to write in s3://bucket/state.json: stale fencing token (9 < 10)")
+}
+
+func Test_s3Storage_Save_FailRevisionConflict(t *testing.T) {
+	fake, stateStorage := createTestS3Storage(t)
+	fake.objects["bucket/state.json"] = []byte(`{"certificates":[],"revision":3}`)
+
+	err := stateStorage.Save(&types.State{Certificates: types.Certificates{{Domains: types.Domains{"foo.com"}}}, Revision: 2})
+	assert.ErrorIs(t, err, types.ErrStateConflict)
+	assert.EqualError(t, err, "failed to write in s3://bucket/state.json: state was modified concurrently (revision 2 != 3)")
+}
+
+func Test_s3Storage_Save_FailConcurrentWrite(t *testing.T) {
+	fake, stateStorage := createTestS3Storage(t)
+	fake.objects["bucket/state.json"] = []byte(`{"certificates":[]}`)
+	fake.beforePut = func() {
+		fake.mu.Lock()
+		defer fake.mu.Unlock()
+		fake.objects["bucket/state.json"] = []byte(`{"certificates":[],"fencing_token":1}`)
+	}
+
+	err := stateStorage.Save(&types.State{Certificates: types.Certificates{{Domains: types.Domains{"foo.com"}}}})
+	assert.ErrorIs(t, err, types.ErrStateConflict)
+	assert.EqualError(t, err, "failed to write in s3://bucket/state.json: state was modified concurrently")
+	assert.Equal(t, []byte(`{"certificates":[],"fencing_token":1}`), fake.objects["bucket/state.json"])
+}
+
+func Test_s3Storage_Save_FailConcurrentCreate(t *testing.T) {
+	fake, stateStorage := createTestS3Storage(t)
+	fake.beforePut = func() {
+		fake.mu.Lock()
+		defer fake.mu.Unlock()
+		fake.objects["bucket/state.json"] = []byte(`{"certificates":[]}`)
+	}
+
+	err := stateStorage.Save(&types.State{Certificates: types.Certificates{{Domains: types.Domains{"foo.com"}}}})
+	assert.ErrorIs(t, err, types.ErrStateConflict)
+}
+
+func Test_s3Storage_Save_SuccessWithLoadedETag(t *testing.T) {
+	fake, stateStorage := createTestS3Storage(t)
+	fake.objects["bucket/state.json"] = []byte(`{"certificates":[],"revision":1}`)
+	s, err := stateStorage.Load()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, fake.gets)
+
+	s.Certificates = types.Certificates{{Domains: types.Domains{"foo.com"}}}
+	assert.NoError(t, stateStorage.Save(s))
+	s.Certificates = types.Certificates{{Domains: types.Domains{"bar.com"}}}
+	assert.NoError(t, stateStorage.Save(s))
+	assert.Equal(t, 1, fake.gets)
+	assert.Equal(t, int64(3), s.Revision)
+}
+
+func Test_s3Storage_Save_FailModifiedSinceLoad(t *testing.T) {
+	fake, stateStorage := createTestS3Storage(t)
+	fake.objects["bucket/state.json"] = []byte(`{"certificates":[],"revision":1}`)
+	s, err := stateStorage.Load()
+	assert.NoError(t, err)
+
+	// object written by another process without revision change is detected by its ETag
+	fake.objects["bucket/state.json"] = []byte(`{"certificates":[],"revision":1,"fencing_token":5}`)
+	s.Certificates = types.Certificates{{Domains: types.Domains{"foo.com"}}}
+	err = stateStorage.Save(s)
+	assert.ErrorIs(t, err, types.ErrStateConflict)
+	assert.Equal(t, []byte(`{"certificates":[],"revision":1,"fencing_token":5}`), fake.objects["bucket/state.json"])
+}
+
+func Test_s3Storage_Save_FailGet(t *testing.T) {
+	fake, stateStorage := createTestS3Storage(t)
+	fake.fail = true
+
+	err := stateStorage.Save(&types.State{Certificates: types.Certificates{}})
+	assert.ErrorContains(t, err, "failed to get s3://bucket/state.json: operation error S3: GetObject")
+	assert.ErrorContains(t, err, "AccessDenied")
+}
+
+func Test_s3Storage_ChangeTag(t *testing.T) {
+	fake, stateStorage := createTestS3Storage(t)
+	tag, err := stateStorage.ChangeTag()
+	assert.NoError(t, err)
+	assert.Empty(t, tag)
+
+	assert.NoError(t, stateStorage.Save(&types.State{Account: &acme.Account{}}))
+	tag, err = stateStorage.ChangeTag()
+	assert.NoError(t, err)
+	assert.Equal(t, etagOf(fake.objects["bucket/state.json"]), tag)
+
+	fake.fail = true
+	_, err = stateStorage.ChangeTag()
+	assert.ErrorContains(t, err, "failed to head s3://bucket/state.json")
+}
+
+func Test_s3Storage_SaveLoad_SuccessEncrypted(t *testing.T) {
+	fake, stateStorage := createTestS3Storage(t)
+	stateStorage.encryption = newTestEncryption(t, true, "key1")
+	s := &types.State{
+		Account:      &acme.Account{Email: "dev@foo.com", Key: []byte("privatekey")},
+		Certificates: types.Certificates{{Domains: types.Domains{"foo.com"}, Key: []byte("key"), Certificate: []byte("certificate")}},
+	}
+
+	assert.NoError(t, stateStorage.Save(s))
+	encrypted := fake.objects["bucket/state.json"]
+	assert.NotContains(t, string(encrypted), "foo.com")
+
+	// unchanged state is not written again
+	assert.NoError(t, stateStorage.Save(s))
+	assert.Equal(t, encrypted, fake.objects["bucket/state.json"])
+
+	got, err := stateStorage.Load()
+	assert.NoError(t, err)
+	assert.Equal(t, s, got)
+}
+
+func Test_createS3Storage(t *testing.T) {
+	ctx := appCtx.TestContext(nil)
+
+	tests := []struct {
+		name        string
+		cfg         config.StateConfig
+		want        ConfigS3
+		errContains string
+	}{
+		{
+			name: "Success",
+			cfg:  config.StateConfig{Type: S3Key, Config: map[string]interface{}{"bucket": "bucket"}},
+			want: ConfigS3{Bucket: "bucket", Key: defaultS3Key, Region: defaultS3Region},
+		},
+		{
+			name: "SuccessWithConfig",
+			cfg: config.StateConfig{Type: S3Key, Config: map[string]interface{}{
+				"bucket": "bucket", "key": "state.json", "region": "eu-west-3", "endpoint": "https://minio.local:9000",
+				"force_path_style": true, "access_key_id": "access", "secret_access_key": "secret",
+			}},
+			want: ConfigS3{Bucket: "bucket", Key: "state.json", Region: "eu-west-3", Endpoint: "https://minio.local:9000", ForcePathStyle: true, AccessKeyID: "access", SecretAccessKey: "secret"},
+		},
+		{
+			name:        "FailDecodeCfg",
+			cfg:         config.StateConfig{Type: S3Key, Config: map[string]interface{}{"bucket": []string{}}},
+			errContains: "'bucket' expected type 'string', got unconvertible type '[]string'",
+		},
+		{
+			name:        "FailValidateCfg",
+			cfg:         config.StateConfig{Type: S3Key, Config: map[string]interface{}{}},
+			errContains: "Field validation for 'Bucket' failed on the 'required' tag",
+		},
+		{
+			name:        "FailValidateEndpoint",
+			cfg:         config.StateConfig{Type: S3Key, Config: map[string]interface{}{"bucket": "bucket", "endpoint": "wrong"}},
+			errContains: "Field validation for 'Endpoint' failed on the 'url' tag",
+		},
+		{
+			name:        "FailValidateCredentials",
+			cfg:         config.StateConfig{Type: S3Key, Config: map[string]interface{}{"bucket": "bucket", "access_key_id": "access"}},
+			errContains: "Field validation for 'SecretAccessKey' failed on the 'required_with' tag",
+		},
+		{
+			name: "FailEncryptionKey",
+			cfg: config.StateConfig{
+				Type:       S3Key,
+				Config:     map[string]interface{}{"bucket": "bucket"},
+				Encryption: config.EncryptionConfig{Enable: true, Keys: []config.EncryptionKeyConfig{{ID: "key", File: "/missing.key"}}},
+			},
+			errContains: "failed to load encryption key key",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := createS3Storage(ctx, tt.cfg)
+			if tt.errContains != "" {
+				assert.Nil(t, got)
+				assert.ErrorContains(t, err, tt.errContains)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, S3Key, got.Type())
+			assert.Equal(t, tt.want, got.(*s3Storage).cfg)
+		})
+	}
+}
+
+func Test_s3Storage_VersionStore(t *testing.T) {
+	fake, stateStorage := createTestS3Storage(t)
+	testVersionStore(t, stateStorage)
+	assert.Contains(t, fake.objects, "bucket/state.json.history/20261019T100200.000000000Z-removal")
+}
@@ -0,0 +1,152 @@
+package state
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"slices"
+
+	"github.com/alexandreh2ag/lets-go-tls/types"
+	"github.com/alexandreh2ag/lets-go-tls/types/acme"
+)
+
+// SchemaMigration upgrades a state document (json object of types.State) from Version-1 to Version.
+type SchemaMigration struct {
+	Version     int
+	Description string
+	Migrate     func(document map[string]any) error
+}
+
+// schemaMigrations are applied in order on load, a migration must never be modified once released.
+var schemaMigrations = []SchemaMigration{}
+
+func init() {
+	RegisterSchemaMigration(SchemaMigration{
+		Version:     1,
+		Description: "rename certificate field domain to domains",
+		Migrate:     migrateCertificateDomains,
+	})
+	RegisterSchemaMigration(SchemaMigration{
+		Version:     2,
+		Description: "normalize certificate domains",
+		Migrate:     migrateNormalizeDomains,
+	})
+}
+
+// RegisterSchemaMigration adds a migration, versions must be registered in order without gap.
+func RegisterSchemaMigration(migration SchemaMigration) {
+	if migration.Version != len(schemaMigrations)+1 {
+		panic(fmt.Sprintf("state schema migration %d registered after version %d", migration.Version, len(schemaMigrations)))
+	}
+	schemaMigrations = append(schemaMigrations, migration)
+}
+
+// SchemaVersion returns the schema version of states saved by this build.
+func SchemaVersion() int {
+	return len(schemaMigrations)
+}
+
+// DecodeState parses a state document saved in location and migrates it to the current schema version.
+func DecodeState(location string, data []byte) (*types.State, error) {
+	document := map[string]any{}
+	// numbers are kept as is, fencing token and revision must not lose precision
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	err := decoder.Decode(&document)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse json %s: %v", location, err)
+	}
+
+	version := 0
+	if value, ok := document["schema_version"].(json.Number); ok {
+		number, _ := value.Int64()
+		version = max(int(number), 0)
+	}
+	if version > SchemaVersion() {
+		return nil, fmt.Errorf(
+			"failed to load %s: %w (%d > %d), upgrade lets-go-tls to read it",
+			location, types.ErrUnsupportedSchemaVersion, version, SchemaVersion(),
+		)
+	}
+	for _, migration := range schemaMigrations[version:] {
+		err = migration.Migrate(document)
+		if err != nil {
+			return nil, fmt.Errorf("failed to migrate %s to schema version %d: %v", location, migration.Version, err)
+		}
+	}
+	document["schema_version"] = SchemaVersion()
+
+	data, _ = json.Marshal(document)
+	st := &types.State{Account: &acme.Account{}}
+	err = json.Unmarshal(data, st)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse json %s: %v", location, err)
+	}
+	return st, nil
+}
+
+// newState returns the state loaded when a storage is empty.
+func newState() *types.State {
+	return &types.State{SchemaVersion: SchemaVersion(), Account: &acme.Account{}}
+}
+
+// migrateCertificateDomains moves domains saved in legacy field domain, Certificate.UnmarshalJSON
+// still reads this field for certificates received from an older server.
+func migrateCertificateDomains(document map[string]any) error {
+	certificates, _ := document["certificates"].([]any)
+	for _, item := range certificates {
+		certificate, ok := item.(map[string]any)
+		if !ok {
+			continue
+		}
+		legacy, hasLegacy := certificate["domain"]
+		delete(certificate, "domain")
+		if domains, hasDomains := certificate["domains"].([]any); hasLegacy && (!hasDomains || len(domains) == 0) {
+			certificate["domains"] = legacy
+		}
+	}
+	return nil
+}
+
+// migrateNormalizeDomains normalizes domains saved before requests were normalized, so they match normalized requests.
+// An invalid domain is kept as is.
+func migrateNormalizeDomains(document map[string]any) error {
+	certificates, _ := document["certificates"].([]any)
+	for _, item := range certificates {
+		certificate, ok := item.(map[string]any)
+		if !ok {
+			continue
+		}
+		if main, isString := certificate["main"].(string); isString && main != "" {
+			certificate["main"] = string(normalizeDomain(types.Domain(main)))
+		}
+		for _, field := range []string{"domains", "pending_domains", "parked_domains", "removed_domains"} {
+			domains, isList := certificate[field].([]any)
+			if !isList {
+				continue
+			}
+			normalized := []any{}
+			for _, value := range domains {
+				domain, isString := value.(string)
+				if !isString {
+					normalized = append(normalized, value)
+					continue
+				}
+				value = string(normalizeDomain(types.Domain(domain)))
+				if !slices.Contains(normalized, value) {
+					normalized = append(normalized, value)
+				}
+			}
+			certificate[field] = normalized
+		}
+	}
+	return nil
+}
+
+func normalizeDomain(domain types.Domain) types.Domain {
+	normalized, err := domain.Normalize()
+	if err != nil {
+		return domain
+	}
+	return normalized
+}
@@ -0,0 +1,98 @@
+package state
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/alexandreh2ag/lets-go-tls/types"
+	"github.com/alexandreh2ag/lets-go-tls/types/acme"
+	"github.com/stretchr/testify/assert"
+)
+
+func withSchemaMigrations(t *testing.T, migrations ...SchemaMigration) {
+	previous := schemaMigrations
+	t.Cleanup(func() { schemaMigrations = previous })
+	schemaMigrations = append([]SchemaMigration{}, previous...)
+	for _, migration := range migrations {
+		RegisterSchemaMigration(migration)
+	}
+}
+
+func TestDecodeState_SuccessMigrateLegacy(t *testing.T) {
+	data := []byte(`{"certificates":[
+		{"identifier":"foo","domain":["foo.com"]},
+		{"identifier":"bar","domains":["bar.com"],"domain":["wrong.com"]}
+	],"fencing_token":9007199254740993}`)
+
+	got, err := DecodeState("/app/state.json", data)
+	assert.NoError(t, err)
+	assert.Equal(t, &types.State{
+		SchemaVersion: SchemaVersion(),
+		Account:       &acme.Account{},
+		Certificates: types.Certificates{
+			{Identifier: "foo", Domains: types.Domains{"foo.com"}},
+			{Identifier: "bar", Domains: types.Domains{"bar.com"}},
+		},
+		FencingToken: 9007199254740993,
+	}, got)
+}
+
+func TestDecodeState_SuccessMigrateNormalizeDomains(t *testing.T) {
+	data := []byte(`{"schema_version":1,"certificates":[
+		{"identifier":"foo","main":"Foo.COM.","domains":["Foo.COM.","foo.com","Bücher.example.com"],"pending_domains":["2001:DB8:0::1"]},
+		{"identifier":"bar","domains":["foo_bar.example.com"],"parked_domains":["*.Bar.com"],"removed_domains":["www.bar.com"]}
+	]}`)
+
+	got, err := DecodeState("/app/state.json", data)
+	assert.NoError(t, err)
+	assert.Equal(t, types.Certificates{
+		{Identifier: "foo", Main: "foo.com", Domains: types.Domains{"foo.com", "xn--bcher-kva.example.com"}, PendingDomains: types.Domains{"2001:db8::1"}},
+		{Identifier: "bar", Domains: types.Domains{"foo_bar.example.com"}, ParkedDomains: types.Domains{"*.bar.com"}, RemovedDomains: types.Domains{"www.bar.com"}},
+	}, got.Certificates)
+}
+
+func TestDecodeState_SuccessMigrateFromVersion(t *testing.T) {
+	applied := []int{}
+	migrate := func(version int) func(document map[string]any) error {
+		return func(document map[string]any) error {
+			applied = append(applied, version)
+			return nil
+		}
+	}
+	withSchemaMigrations(t,
+		SchemaMigration{Version: SchemaVersion() + 1, Migrate: migrate(SchemaVersion() + 1)},
+		SchemaMigration{Version: SchemaVersion() + 2, Migrate: migrate(SchemaVersion() + 2)},
+	)
+
+	current := SchemaVersion() - 2
+	got, err := DecodeState("/app/state.json", []byte(fmt.Sprintf(`{"schema_version":%d,"certificates":[]}`, current+1)))
+	assert.NoError(t, err)
+	assert.Equal(t, []int{current + 2}, applied)
+	assert.Equal(t, current+2, got.SchemaVersion)
+}
+
+func TestDecodeState_Fail(t *testing.T) {
+	_, err := DecodeState("/app/state.json", []byte(`[}`))
+	assert.EqualError(t, err, "failed to parse json /app/state.json: invalid character '}' looking for beginning of value")
+
+	_, err = DecodeState("/app/state.json", []byte(`{"certificates":{}}`))
+	assert.ErrorContains(t, err, "failed to parse json /app/state.json: json: cannot unmarshal object")
+
+	_, err = DecodeState("/app/state.json", []byte(`{"schema_version":100}`))
+	assert.ErrorIs(t, err, types.ErrUnsupportedSchemaVersion)
+	assert.EqualError(t, err, "failed to load /app/state.json: state schema version is not supported (100 > 2), upgrade lets-go-tls to read it")
+
+	withSchemaMigrations(t, SchemaMigration{Version: SchemaVersion() + 1, Migrate: func(document map[string]any) error {
+		return errors.New("fail")
+	}})
+	_, err = DecodeState("/app/state.json", []byte(`{}`))
+	assert.EqualError(t, err, "failed to migrate /app/state.json to schema version 3: fail")
+}
+
+func TestRegisterSchemaMigration_Panic(t *testing.T) {
+	withSchemaMigrations(t)
+	assert.PanicsWithValue(t, "state schema migration 5 registered after version 2", func() {
+		RegisterSchemaMigration(SchemaMigration{Version: 5})
+	})
+}
@@ -0,0 +1,113 @@
+package state
+
+import (
+	"sync"
+	"time"
+
+	"github.com/alexandreh2ag/lets-go-tls/types"
+	"github.com/alexandreh2ag/lets-go-tls/types/storage/state"
+)
+
+var (
+	_ state.SnapshotReader    = &snapshotStorage{}
+	_ state.CertificateFinder = &snapshotFinderStorage{}
+)
+
+// snapshotStorage keeps a parsed state in memory for readers, Load and Save still use the storage.
+type snapshotStorage struct {
+	state.Storage
+	tracker  state.ChangeTracker
+	interval time.Duration
+	now      func() time.Time
+
+	mutex     sync.Mutex
+	snapshot  *types.State
+	tag       string
+	checkedAt time.Time
+}
+
+// Snapshot returns the state kept in memory. It is checked at most once per interval: the state is loaded again
+// only when the change tag of the storage changed, storages without change tag are loaded again at each check.
+func (s *snapshotStorage) Snapshot() (*types.State, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := s.now()
+	if s.snapshot != nil && now.Sub(s.checkedAt) < s.interval {
+		return s.snapshot, nil
+	}
+
+	// tag is read before load, a save in between only causes another load on next check
+	tag := ""
+	if s.tracker != nil {
+		var err error
+		tag, err = s.tracker.ChangeTag()
+		if err != nil {
+			return nil, err
+		}
+		if s.snapshot != nil && tag == s.tag {
+			s.checkedAt = now
+			return s.snapshot, nil
+		}
+	}
+
+	st, err := s.Storage.Load()
+	if err != nil {
+		return nil, err
+	}
+	s.snapshot, s.tag, s.checkedAt = st, tag, now
+	return s.snapshot, nil
+}
+
+// Save refreshes the snapshot with the saved state, its change tag is unknown so it is loaded again on next check.
+func (s *snapshotStorage) Save(st *types.State) error {
+	err := s.Storage.Save(st)
+	if err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.snapshot, s.tag, s.checkedAt = st.Clone(), "", s.now()
+	return nil
+}
+
+// Unwrap returns the storage saving state.
+func (s *snapshotStorage) Unwrap() state.Storage {
+	return s.Storage
+}
+
+// snapshotFinderStorage keeps the domain index of storages implementing state.CertificateFinder.
+type snapshotFinderStorage struct {
+	*snapshotStorage
+}
+
+func (s snapshotFinderStorage) FindCertificates(domains types.Domains) (types.Certificates, error) {
+	return s.Storage.(state.CertificateFinder).FindCertificates(domains)
+}
+
+// WithSnapshot returns storage keeping a read-only state in memory, see state.SnapshotReader.
+// Storages implementing state.CertificateFinder keep their domain index.
+func WithSnapshot(storage state.Storage, interval time.Duration) state.Storage {
+	instance := newSnapshotStorage(storage, interval)
+	if _, ok := storage.(state.CertificateFinder); ok {
+		return &snapshotFinderStorage{instance}
+	}
+	return instance
+}
+
+func newSnapshotStorage(storage state.Storage, interval time.Duration) *snapshotStorage {
+	instance := &snapshotStorage{Storage: storage, interval: interval, now: time.Now}
+	for current := storage; current != nil; {
+		if tracker, ok := current.(state.ChangeTracker); ok {
+			instance.tracker = tracker
+			break
+		}
+		wrapper, ok := current.(interface{ Unwrap() state.Storage })
+		if !ok {
+			break
+		}
+		current = wrapper.Unwrap()
+	}
+	return instance
+}
@@ -0,0 +1,148 @@
+package state
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	appCtx "github.com/alexandreh2ag/lets-go-tls/context"
+	appFs "github.com/alexandreh2ag/lets-go-tls/fs"
+	mockTypesStorageState "github.com/alexandreh2ag/lets-go-tls/mocks/types/storage/state"
+	"github.com/alexandreh2ag/lets-go-tls/types"
+	"github.com/alexandreh2ag/lets-go-tls/types/acme"
+	"github.com/alexandreh2ag/lets-go-tls/types/storage/state"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/mock/gomock"
+)
+
+type trackedStorage struct {
+	*mockTypesStorageState.MockStorage
+	*mockTypesStorageState.MockChangeTracker
+}
+
+type finderStorage struct {
+	*mockTypesStorageState.MockStorage
+	*mockTypesStorageState.MockCertificateFinder
+}
+
+func newTestSnapshotStorage(storage state.Storage) (*snapshotStorage, *time.Time) {
+	now := time.Date(2026, 10, 19, 10, 0, 0, 0, time.UTC)
+	instance := newSnapshotStorage(storage, time.Second*5)
+	instance.now = func() time.Time { return now }
+	return instance, &now
+}
+
+func Test_snapshotStorage_Snapshot_SuccessTracked(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	storage := trackedStorage{mockTypesStorageState.NewMockStorage(ctrl), mockTypesStorageState.NewMockChangeTracker(ctrl)}
+	first := &types.State{Revision: 1}
+	second := &types.State{Revision: 2}
+	gomock.InOrder(
+		storage.MockChangeTracker.EXPECT().ChangeTag().Times(1).Return("1", nil),
+		storage.MockStorage.EXPECT().Load().Times(1).Return(first, nil),
+		storage.MockChangeTracker.EXPECT().ChangeTag().Times(1).Return("1", nil),
+		storage.MockChangeTracker.EXPECT().ChangeTag().Times(1).Return("2", nil),
+		storage.MockStorage.EXPECT().Load().Times(1).Return(second, nil),
+	)
+	snapshot, now := newTestSnapshotStorage(storage)
+
+	got, err := snapshot.Snapshot()
+	assert.NoError(t, err)
+	assert.Same(t, first, got)
+
+	// served from memory until interval is reached
+	*now = now.Add(time.Second)
+	got, _ = snapshot.Snapshot()
+	assert.Same(t, first, got)
+
+	// unchanged tag keeps snapshot
+	*now = now.Add(time.Second * 5)
+	got, _ = snapshot.Snapshot()
+	assert.Same(t, first, got)
+
+	*now = now.Add(time.Second * 5)
+	got, err = snapshot.Snapshot()
+	assert.NoError(t, err)
+	assert.Same(t, second, got)
+}
+
+func Test_snapshotStorage_Snapshot_SuccessNotTracked(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	storage := mockTypesStorageState.NewMockStorage(ctrl)
+	storage.EXPECT().Load().Times(2).Return(&types.State{}, nil)
+	snapshot, now := newTestSnapshotStorage(storage)
+
+	_, _ = snapshot.Snapshot()
+	_, _ = snapshot.Snapshot()
+	*now = now.Add(time.Second * 5)
+	_, err := snapshot.Snapshot()
+	assert.NoError(t, err)
+}
+
+func Test_snapshotStorage_Snapshot_Fail(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	storage := trackedStorage{mockTypesStorageState.NewMockStorage(ctrl), mockTypesStorageState.NewMockChangeTracker(ctrl)}
+	gomock.InOrder(
+		storage.MockChangeTracker.EXPECT().ChangeTag().Times(1).Return("", errors.New("fail tag")),
+		storage.MockChangeTracker.EXPECT().ChangeTag().Times(1).Return("1", nil),
+		storage.MockStorage.EXPECT().Load().Times(1).Return(nil, errors.New("fail load")),
+	)
+	snapshot, _ := newTestSnapshotStorage(storage)
+
+	_, err := snapshot.Snapshot()
+	assert.EqualError(t, err, "fail tag")
+	_, err = snapshot.Snapshot()
+	assert.EqualError(t, err, "fail load")
+}
+
+func Test_snapshotStorage_Save(t *testing.T) {
+	ctx := appCtx.TestContext(nil)
+	history := newTestHistory(t, ctx.Fs, 5)
+	storage := &fs{fs: ctx.Fs, logger: ctx.Logger, cfg: ConfigFs{Path: "/app/state.json"}, checksum: appFs.NewChecksum(ctx.Fs)}
+	snapshot, now := newTestSnapshotStorage(WithHistory(storage, history))
+	assert.Equal(t, storage, snapshot.tracker)
+
+	st := &types.State{Account: &acme.Account{}, Certificates: types.Certificates{{Identifier: "foo", Domains: types.Domains{"foo.com"}}}}
+	assert.NoError(t, snapshot.Save(st))
+	got, err := snapshot.Snapshot()
+	assert.NoError(t, err)
+	assert.Equal(t, st, got)
+
+	// snapshot is a copy of saved state
+	st.Certificates[0].Identifier = "bar"
+	assert.Equal(t, "foo", got.Certificates[0].Identifier)
+
+	// change tag of saved state is read on next check
+	*now = now.Add(time.Second * 5)
+	got, err = snapshot.Snapshot()
+	assert.NoError(t, err)
+	assert.NotEmpty(t, snapshot.tag)
+
+	// a save of another process is loaded on next check
+	other, _ := storage.Load()
+	other.Certificates = types.Certificates{}
+	assert.NoError(t, storage.Save(other))
+	*now = now.Add(time.Second * 5)
+	got, err = snapshot.Snapshot()
+	assert.NoError(t, err)
+	assert.Equal(t, other, got)
+
+	assert.ErrorIs(t, snapshot.Save(&types.State{}), types.ErrStateConflict)
+	assert.Same(t, got, snapshot.snapshot)
+}
+
+func TestWithSnapshot_Finder(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	storage := mockTypesStorageState.NewMockStorage(ctrl)
+	_, isFinder := WithSnapshot(storage, time.Second).(state.CertificateFinder)
+	assert.False(t, isFinder)
+
+	finder := finderStorage{mockTypesStorageState.NewMockStorage(ctrl), mockTypesStorageState.NewMockCertificateFinder(ctrl)}
+	finder.MockCertificateFinder.EXPECT().FindCertificates(types.Domains{"foo.com"}).Times(1).Return(types.Certificates{{Identifier: "foo"}}, nil)
+	snapshot := WithSnapshot(WithHistory(finder, nil), time.Second)
+	_, isReader := snapshot.(state.SnapshotReader)
+	assert.True(t, isReader)
+	got, err := snapshot.(state.CertificateFinder).FindCertificates(types.Domains{"foo.com"})
+	assert.NoError(t, err)
+	assert.Equal(t, types.Certificates{{Identifier: "foo"}}, got)
+}
@@ -0,0 +1,707 @@
+package state
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/alexandreh2ag/lets-go-tls/config"
+	appCtx "github.com/alexandreh2ag/lets-go-tls/context"
+	"github.com/alexandreh2ag/lets-go-tls/mapstructure"
+	"github.com/alexandreh2ag/lets-go-tls/types"
+	"github.com/alexandreh2ag/lets-go-tls/types/acme"
+	"github.com/alexandreh2ag/lets-go-tls/types/storage/state"
+	"github.com/go-playground/validator/v10"
+	_ "github.com/jackc/pgx/v5/stdlib"
+	"github.com/spf13/afero"
+	_ "modernc.org/sqlite"
+)
+
+const (
+	SQLKey = "sql"
+
+	SQLDriverSqlite   = "sqlite"
+	SQLDriverPostgres = "postgres"
+
+	sqlAccountKey        = "account"
+	sqlStagingAccountKey = "staging_account"
+	sqlFencingTokenKey   = "fencing_token"
+	sqlRevisionKey       = "revision"
+	sqlSchemaVersionKey  = "schema_version"
+
+	// sqlLoadRetries is the number of times rows are read again when a save changed them during a load.
+	sqlLoadRetries = 3
+)
+
+func init() {
+	TypeStorageMapping[SQLKey] = createSQLStorage
+}
+
+var (
+	_ state.Storage           = &sqlStorage{}
+	_ state.CertificateFinder = &sqlStorage{}
+	_ state.ChangeTracker     = &sqlStorage{}
+	_ state.VersionStore      = &sqlStorage{}
+
+	sqlDrivers = map[string]string{
+		SQLDriverSqlite:   "sqlite",
+		SQLDriverPostgres: "pgx",
+	}
+
+	// sqlMigrations are applied in order at start, a migration must never be modified once released.
+	sqlMigrations = [][]string{
+		{
+			`CREATE TABLE IF NOT EXISTS state_meta (name TEXT PRIMARY KEY, value TEXT NOT NULL)`,
+			`INSERT INTO state_meta (name, value) VALUES ('fencing_token', '0') ON CONFLICT (name) DO NOTHING`,
+			`CREATE TABLE IF NOT EXISTS state_accounts (name TEXT PRIMARY KEY, data TEXT NOT NULL)`,
+			`CREATE TABLE IF NOT EXISTS state_certificates (identifier TEXT PRIMARY KEY, position INTEGER NOT NULL, data TEXT NOT NULL)`,
+			`CREATE TABLE IF NOT EXISTS state_certificate_domains (identifier TEXT NOT NULL, domain TEXT NOT NULL, PRIMARY KEY (identifier, domain))`,
+			`CREATE INDEX IF NOT EXISTS state_certificate_domains_domain ON state_certificate_domains (domain)`,
+		},
+		{
+			`INSERT INTO state_meta (name, value) VALUES ('revision', '0') ON CONFLICT (name) DO NOTHING`,
+		},
+		{
+			`INSERT INTO state_meta (name, value) VALUES ('schema_version', '0') ON CONFLICT (name) DO NOTHING`,
+		},
+		{
+			`ALTER TABLE state_accounts ADD COLUMN data_hash TEXT NOT NULL DEFAULT ''`,
+			`ALTER TABLE state_certificates ADD COLUMN data_hash TEXT NOT NULL DEFAULT ''`,
+		},
+		{
+			`CREATE TABLE IF NOT EXISTS state_history (id TEXT PRIMARY KEY, data TEXT NOT NULL)`,
+		},
+	}
+)
+
+type ConfigSQL struct {
+	Driver string `mapstructure:"driver" validate:"required,oneof=sqlite postgres"`
+	DSN    string `mapstructure:"dsn" validate:"required"`
+	// ImportFromFs is the path of a fs state imported when the database is empty.
+	ImportFromFs string `mapstructure:"import_from_fs"`
+}
+
+// sqlStorage saves the account and each certificate as a row, certificate domains are indexed to find
+// certificates without loading the whole state. A save only writes changed rows in a transaction.
+type sqlStorage struct {
+	db         *sql.DB
+	logger     *slog.Logger
+	encryption *Encryption
+	cfg        ConfigSQL
+}
+
+type sqlCertificateRow struct {
+	position int
+	data     []byte
+	hash     string
+}
+
+func (s sqlStorage) Type() string {
+	return SQLKey
+}
+
+// rebind replaces ? placeholders by $n placeholders with postgres.
+func (s sqlStorage) rebind(query string) string {
+	if s.cfg.Driver != SQLDriverPostgres {
+		return query
+	}
+	builder := strings.Builder{}
+	n := 0
+	for _, char := range query {
+		if char == '?' {
+			n++
+			builder.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		builder.WriteRune(char)
+	}
+	return builder.String()
+}
+
+func (s sqlStorage) migrate() error {
+	_, err := s.db.Exec(`CREATE TABLE IF NOT EXISTS state_schema_migrations (version INTEGER PRIMARY KEY)`)
+	if err != nil {
+		return fmt.Errorf("failed to create migrations table: %v", err)
+	}
+	for i, statements := range sqlMigrations {
+		version := i + 1
+		applied := 0
+		err = s.db.QueryRow(s.rebind(`SELECT COUNT(*) FROM state_schema_migrations WHERE version = ?`), version).Scan(&applied)
+		if err != nil {
+			return fmt.Errorf("failed to check migration %d: %v", version, err)
+		}
+		if applied > 0 {
+			continue
+		}
+		err = s.transaction(func(tx *sql.Tx) error {
+			for _, statement := range statements {
+				if _, errExec := tx.Exec(statement); errExec != nil {
+					return errExec
+				}
+			}
+			_, errExec := tx.Exec(s.rebind(`INSERT INTO state_schema_migrations (version) VALUES (?) ON CONFLICT (version) DO NOTHING`), version)
+			return errExec
+		})
+		if err != nil {
+			return fmt.Errorf("failed to apply migration %d: %v", version, err)
+		}
+		s.logger.Info(fmt.Sprintf("state sql migration %d applied", version))
+	}
+	return nil
+}
+
+func (s sqlStorage) transaction(fn func(tx *sql.Tx) error) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	err = fn(tx)
+	if err != nil {
+		return errors.Join(err, tx.Rollback())
+	}
+	return tx.Commit()
+}
+
+// decrypt returns the json document of a row.
+func (s sqlStorage) decrypt(name string, data []byte) (json.RawMessage, error) {
+	data, err := s.encryption.Decrypt(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt %s: %v", name, err)
+	}
+	if !json.Valid(data) {
+		return nil, fmt.Errorf("failed to parse json %s", name)
+	}
+	return data, nil
+}
+
+// decode migrates rows assembled in a state document like other storages.
+func (s sqlStorage) decode(document map[string]any) (*types.State, error) {
+	schemaVersion, err := s.loadMeta(s.db, sqlSchemaVersionKey, "")
+	if err != nil {
+		return nil, err
+	}
+	document["schema_version"] = schemaVersion
+	data, _ := json.Marshal(document)
+	return DecodeState("sql state", data)
+}
+
+func (s sqlStorage) queryCertificates(querier sqlQuerier, query string, args ...any) ([]json.RawMessage, error) {
+	rows, err := querier.Query(s.rebind(query), args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to select certificates: %v", err)
+	}
+	defer rows.Close()
+	certificates := []json.RawMessage{}
+	for rows.Next() {
+		var identifier string
+		var data []byte
+		if err = rows.Scan(&identifier, &data); err != nil {
+			return nil, fmt.Errorf("failed to read certificate: %v", err)
+		}
+		certificate, errDecrypt := s.decrypt("certificate "+identifier, data)
+		if errDecrypt != nil {
+			return nil, errDecrypt
+		}
+		certificates = append(certificates, certificate)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to select certificates: %v", err)
+	}
+	return certificates, nil
+}
+
+func (s sqlStorage) loadAccount(querier sqlQuerier, name string) (json.RawMessage, error) {
+	var data []byte
+	err := querier.QueryRow(s.rebind(`SELECT data FROM state_accounts WHERE name = ?`), name).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to select %s: %v", name, err)
+	}
+	return s.decrypt(name, data)
+}
+
+// sqlQuerier is implemented by sql.DB and sql.Tx.
+type sqlQuerier interface {
+	QueryRow(query string, args ...any) *sql.Row
+	Query(query string, args ...any) (*sql.Rows, error)
+}
+
+func (s sqlStorage) loadMeta(querier sqlQuerier, name string, lock string) (int64, error) {
+	var value string
+	err := querier.QueryRow(s.rebind(`SELECT value FROM state_meta WHERE name = ?`+lock), name).Scan(&value)
+	if err != nil {
+		return 0, fmt.Errorf("failed to select %s: %v", name, err)
+	}
+	number, _ := strconv.ParseInt(value, 10, 64)
+	return number, nil
+}
+
+func (s sqlStorage) Load() (*types.State, error) {
+	return s.load(s.db)
+}
+
+// load reads rows outside a transaction: the revision is read before and after the rows, rows are read again when
+// a save changed them in the meantime, so a state is never loaded with the revision of another save.
+func (s sqlStorage) load(querier sqlQuerier) (*types.State, error) {
+	for retry := 0; retry <= sqlLoadRetries; retry++ {
+		revision, err := s.loadMeta(querier, sqlRevisionKey, "")
+		if err != nil {
+			return nil, err
+		}
+		st, err := s.loadRows(querier)
+		if err != nil {
+			return nil, err
+		}
+		if st.Revision, err = s.loadMeta(querier, sqlRevisionKey, ""); err != nil {
+			return nil, err
+		}
+		if st.Revision == revision {
+			return st, nil
+		}
+		s.logger.Debug(fmt.Sprintf("sql state saved during load (revision %d to %d), load it again", revision, st.Revision))
+	}
+	return nil, fmt.Errorf("failed to load sql state: %w (saved during each load)", types.ErrStateConflict)
+}
+
+func (s sqlStorage) loadRows(querier sqlQuerier) (*types.State, error) {
+	// account names are the json fields of types.State
+	document := map[string]any{}
+	for _, name := range []string{sqlAccountKey, sqlStagingAccountKey} {
+		account, err := s.loadAccount(querier, name)
+		if err != nil {
+			return nil, err
+		}
+		if account != nil {
+			document[name] = account
+		}
+	}
+	certificates, err := s.queryCertificates(querier, `SELECT identifier, data FROM state_certificates ORDER BY position`)
+	if err != nil {
+		return nil, err
+	}
+	document["certificates"] = certificates
+
+	st, err := s.decode(document)
+	if err != nil {
+		return nil, err
+	}
+	if st.FencingToken, err = s.loadMeta(querier, sqlFencingTokenKey, ""); err != nil {
+		return nil, err
+	}
+	return st, nil
+}
+
+// ChangeTag returns the revision, it is incremented by each save changing rows.
+func (s sqlStorage) ChangeTag() (string, error) {
+	revision, err := s.loadMeta(s.db, sqlRevisionKey, "")
+	if err != nil {
+		return "", err
+	}
+	return strconv.FormatInt(revision, 10), nil
+}
+
+// FindCertificates uses the domain index: certificates covering one of domains, by itself or by its wildcard, are returned
+// so a request can be served by a certificate per domain.
+func (s sqlStorage) FindCertificates(domains types.Domains) (types.Certificates, error) {
+	if len(domains) == 0 {
+		return types.Certificates{}, nil
+	}
+	candidates := []any{}
+	for _, domain := range domains {
+		// private suffixes only remove wildcard coverage, certificates found with public suffixes are matched again by caller
+		for _, candidate := range []string{indexDomain(domain), indexDomain(domain.FormatSubdomainToWildcard(nil))} {
+			if !slices.Contains(candidates, any(candidate)) {
+				candidates = append(candidates, candidate)
+			}
+		}
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(candidates)), ", ")
+	certificates, err := s.queryCertificates(
+		s.db,
+		`SELECT identifier, data FROM state_certificates WHERE identifier IN (SELECT identifier FROM state_certificate_domains WHERE domain IN (`+placeholders+`)) ORDER BY position`,
+		candidates...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	st, err := s.decode(map[string]any{"certificates": certificates})
+	if err != nil {
+		return nil, err
+	}
+	return st.Certificates, nil
+}
+
+func (s sqlStorage) Save(st *types.State) error {
+	st.SchemaVersion = SchemaVersion()
+	changed := false
+	err := s.transaction(func(tx *sql.Tx) error {
+		lock := ""
+		if s.cfg.Driver == SQLDriverPostgres {
+			lock = " FOR UPDATE"
+		}
+		current := &types.State{}
+		var schemaVersion int64
+		var err error
+		meta := map[string]*int64{sqlFencingTokenKey: &current.FencingToken, sqlRevisionKey: &current.Revision, sqlSchemaVersionKey: &schemaVersion}
+		for name, value := range meta {
+			if *value, err = s.loadMeta(tx, name, lock); err != nil {
+				return err
+			}
+		}
+		if schemaVersion > int64(SchemaVersion()) {
+			return fmt.Errorf("failed to write in sql state: %w (%d > %d)", types.ErrUnsupportedSchemaVersion, schemaVersion, SchemaVersion())
+		}
+		if st.FencingToken > 0 && current.FencingToken > st.FencingToken {
+			return fmt.Errorf("failed to write in sql state: %w (%d < %d)", types.ErrStaleFencingToken, st.FencingToken, current.FencingToken)
+		}
+		if err = checkRevision("sql state", current, st); err != nil {
+			return err
+		}
+		if st.FencingToken > current.FencingToken {
+			changed = true
+			if err = s.saveMeta(tx, sqlFencingTokenKey, st.FencingToken); err != nil {
+				return err
+			}
+		}
+
+		for name, account := range map[string]*acme.Account{sqlAccountKey: st.Account, sqlStagingAccountKey: st.StagingAccount} {
+			accountChanged, errAccount := s.saveAccount(tx, name, account)
+			if errAccount != nil {
+				return errAccount
+			}
+			changed = changed || accountChanged
+		}
+
+		certificatesChanged, err := s.saveCertificates(tx, st.Certificates)
+		if err != nil {
+			return err
+		}
+		changed = changed || certificatesChanged
+		if schemaVersion != int64(SchemaVersion()) {
+			changed = true
+			if err = s.saveMeta(tx, sqlSchemaVersionKey, int64(SchemaVersion())); err != nil {
+				return err
+			}
+		}
+		if !changed {
+			return nil
+		}
+		return s.saveMeta(tx, sqlRevisionKey, current.Revision+1)
+	})
+	if err != nil {
+		return err
+	}
+	if changed {
+		st.Revision++
+		s.logger.Info(fmt.Sprintf("save state to sql %s", s.cfg.Driver))
+	}
+	return nil
+}
+
+func (s sqlStorage) saveMeta(tx *sql.Tx, name string, value int64) error {
+	_, err := tx.Exec(s.rebind(`UPDATE state_meta SET value = ? WHERE name = ?`), strconv.FormatInt(value, 10), name)
+	if err != nil {
+		return fmt.Errorf("failed to update %s: %v", name, err)
+	}
+	return nil
+}
+
+func (s sqlStorage) saveAccount(tx *sql.Tx, name string, account *acme.Account) (bool, error) {
+	var stored []byte
+	var storedHash string
+	err := tx.QueryRow(s.rebind(`SELECT data, data_hash FROM state_accounts WHERE name = ?`), name).Scan(&stored, &storedHash)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return false, fmt.Errorf("failed to select %s: %v", name, err)
+	}
+	exists := err == nil
+
+	if account == nil {
+		if !exists {
+			return false, nil
+		}
+		_, err = tx.Exec(s.rebind(`DELETE FROM state_accounts WHERE name = ?`), name)
+		if err != nil {
+			return false, fmt.Errorf("failed to delete %s: %v", name, err)
+		}
+		return true, nil
+	}
+
+	data, _ := json.Marshal(account)
+	hash := sqlDataHash(data)
+	if exists && s.unchanged(stored, storedHash, data) {
+		if storedHash == "" {
+			_, err = tx.Exec(s.rebind(`UPDATE state_accounts SET data_hash = ? WHERE name = ?`), hash, name)
+			if err != nil {
+				return false, fmt.Errorf("failed to write %s: %v", name, err)
+			}
+		}
+		return false, nil
+	}
+	data, err = s.encryption.Encrypt(data)
+	if err != nil {
+		return false, fmt.Errorf("failed to encrypt %s: %v", name, err)
+	}
+	_, err = tx.Exec(
+		s.rebind(`INSERT INTO state_accounts (name, data, data_hash) VALUES (?, ?, ?) ON CONFLICT (name) DO UPDATE SET data = excluded.data, data_hash = excluded.data_hash`),
+		name, string(data), hash,
+	)
+	if err != nil {
+		return false, fmt.Errorf("failed to write %s: %v", name, err)
+	}
+	return true, nil
+}
+
+func (s sqlStorage) saveCertificates(tx *sql.Tx, certificates types.Certificates) (bool, error) {
+	stored := map[string]sqlCertificateRow{}
+	rows, err := tx.Query(`SELECT identifier, position, data, data_hash FROM state_certificates`)
+	if err != nil {
+		return false, fmt.Errorf("failed to select certificates: %v", err)
+	}
+	for rows.Next() {
+		var identifier string
+		row := sqlCertificateRow{}
+		if err = rows.Scan(&identifier, &row.position, &row.data, &row.hash); err != nil {
+			_ = rows.Close()
+			return false, fmt.Errorf("failed to read certificate: %v", err)
+		}
+		stored[identifier] = row
+	}
+	_ = rows.Close()
+	if err = rows.Err(); err != nil {
+		return false, fmt.Errorf("failed to select certificates: %v", err)
+	}
+
+	changed := false
+	for position, certificate := range certificates {
+		if certificate.Identifier == "" {
+			return false, fmt.Errorf("failed to write certificate %v: identifier is empty", certificate.Domains)
+		}
+		row, exists := stored[certificate.Identifier]
+		delete(stored, certificate.Identifier)
+		data, _ := json.Marshal(certificate)
+		hash := sqlDataHash(data)
+		if exists && row.position == position && s.unchanged(row.data, row.hash, data) {
+			if row.hash == "" {
+				_, err = tx.Exec(s.rebind(`UPDATE state_certificates SET data_hash = ? WHERE identifier = ?`), hash, certificate.Identifier)
+				if err != nil {
+					return false, fmt.Errorf("failed to write certificate %s: %v", certificate.Identifier, err)
+				}
+			}
+			continue
+		}
+		changed = true
+		data, err = s.encryption.Encrypt(data)
+		if err != nil {
+			return false, fmt.Errorf("failed to encrypt certificate %s: %v", certificate.Identifier, err)
+		}
+		_, err = tx.Exec(
+			s.rebind(`INSERT INTO state_certificates (identifier, position, data, data_hash) VALUES (?, ?, ?, ?) ON CONFLICT (identifier) DO UPDATE SET position = excluded.position, data = excluded.data, data_hash = excluded.data_hash`),
+			certificate.Identifier, position, string(data), hash,
+		)
+		if err != nil {
+			return false, fmt.Errorf("failed to write certificate %s: %v", certificate.Identifier, err)
+		}
+		_, err = tx.Exec(s.rebind(`DELETE FROM state_certificate_domains WHERE identifier = ?`), certificate.Identifier)
+		if err != nil {
+			return false, fmt.Errorf("failed to write certificate %s domains: %v", certificate.Identifier, err)
+		}
+		domains := []string{}
+		for _, domain := range certificate.Domains {
+			if index := indexDomain(domain); !slices.Contains(domains, index) {
+				domains = append(domains, index)
+			}
+		}
+		for _, domain := range domains {
+			_, err = tx.Exec(s.rebind(`INSERT INTO state_certificate_domains (identifier, domain) VALUES (?, ?)`), certificate.Identifier, domain)
+			if err != nil {
+				return false, fmt.Errorf("failed to write certificate %s domains: %v", certificate.Identifier, err)
+			}
+		}
+	}
+
+	for identifier := range stored {
+		changed = true
+		for _, query := range []string{`DELETE FROM state_certificate_domains WHERE identifier = ?`, `DELETE FROM state_certificates WHERE identifier = ?`} {
+			if _, err = tx.Exec(s.rebind(query), identifier); err != nil {
+				return false, fmt.Errorf("failed to delete certificate %s: %v", identifier, err)
+			}
+		}
+	}
+	return changed, nil
+}
+
+// unchanged compares a stored row with plaintext data using the hash of its plaintext, so encrypted rows are not
+// decrypted at each save. Rows saved without hash are decrypted once, then their hash is written.
+func (s sqlStorage) unchanged(stored []byte, storedHash string, data []byte) bool {
+	if storedHash == "" {
+		return s.encryption.Unchanged(stored, data)
+	}
+	return storedHash == sqlDataHash(data) && s.encryption.Current(stored)
+}
+
+// sqlDataHash returns the SHA-256 of a row plaintext.
+func sqlDataHash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// VersionIDs returns identifiers of versions saved in state_history table.
+func (s sqlStorage) VersionIDs() ([]string, error) {
+	rows, err := s.db.Query(`SELECT id FROM state_history`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to select history: %v", err)
+	}
+	defer rows.Close()
+	ids := []string{}
+	for rows.Next() {
+		var id string
+		if err = rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to read history: %v", err)
+		}
+		ids = append(ids, id)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to select history: %v", err)
+	}
+	return ids, nil
+}
+
+// GetVersion returns data of a version saved in state_history table.
+func (s sqlStorage) GetVersion(id string) ([]byte, error) {
+	var data []byte
+	err := s.db.QueryRow(s.rebind(`SELECT data FROM state_history WHERE id = ?`), id).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, state.ErrVersionNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to select history version %s: %v", id, err)
+	}
+	return data, nil
+}
+
+// PutVersion writes data of a version in state_history table.
+func (s sqlStorage) PutVersion(id string, data []byte) error {
+	_, err := s.db.Exec(
+		s.rebind(`INSERT INTO state_history (id, data) VALUES (?, ?) ON CONFLICT (id) DO UPDATE SET data = excluded.data`),
+		id, string(data),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to write history version %s: %v", id, err)
+	}
+	return nil
+}
+
+// DeleteVersion removes a version from state_history table.
+func (s sqlStorage) DeleteVersion(id string) error {
+	_, err := s.db.Exec(s.rebind(`DELETE FROM state_history WHERE id = ?`), id)
+	if err != nil {
+		return fmt.Errorf("failed to delete history version %s: %v", id, err)
+	}
+	return nil
+}
+
+// importFs saves the state of a fs storage when the database is empty.
+func (s sqlStorage) importFs(fsStorage *fs) error {
+	exists, err := afero.Exists(fsStorage.fs, fsStorage.cfg.Path)
+	if err != nil || !exists {
+		return err
+	}
+	count := 0
+	err = s.db.QueryRow(`SELECT (SELECT COUNT(*) FROM state_accounts) + (SELECT COUNT(*) FROM state_certificates)`).Scan(&count)
+	if err != nil {
+		return fmt.Errorf("failed to count state rows: %v", err)
+	}
+	if count > 0 {
+		return nil
+	}
+	st, err := fsStorage.Load()
+	if err != nil {
+		return err
+	}
+	current, err := s.Load()
+	if err != nil {
+		return err
+	}
+	st.Revision = current.Revision
+	s.logger.Info(fmt.Sprintf("import state from %s", fsStorage.cfg.Path))
+	return s.Save(st)
+}
+
+// indexDomain returns the form of a domain saved in the domain index.
+func indexDomain(domain types.Domain) string {
+	if ip := net.ParseIP(string(domain)); ip != nil {
+		return ip.String()
+	}
+	return strings.ToLower(string(domain))
+}
+
+func createSQLStorage(ctx appCtx.Context, cfg config.StateConfig) (state.Storage, error) {
+	instanceConfig := ConfigSQL{}
+	err := mapstructure.Decode(cfg.Config, &instanceConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	validate := validator.New()
+	err = validate.Struct(instanceConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	encryption, err := NewEncryption(ctx.GetFS(), cfg.Encryption)
+	if err != nil {
+		return nil, err
+	}
+
+	dsn := instanceConfig.DSN
+	if instanceConfig.Driver == SQLDriverSqlite {
+		dsn = sqliteDSN(dsn)
+	}
+	db, err := sql.Open(sqlDrivers[instanceConfig.Driver], dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open sql state: %v", err)
+	}
+	if instanceConfig.Driver == SQLDriverSqlite {
+		// sqlite allows a single writer, transactions are serialized on one connection
+		db.SetMaxOpenConns(1)
+	}
+
+	instance := &sqlStorage{db: db, logger: ctx.GetLogger(), encryption: encryption, cfg: instanceConfig}
+	err = instance.migrate()
+	if err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+
+	if instanceConfig.ImportFromFs != "" {
+		err = instance.importFs(&fs{fs: ctx.GetFS(), cfg: ConfigFs{Path: instanceConfig.ImportFromFs}, encryption: encryption})
+		if err != nil {
+			_ = db.Close()
+			return nil, fmt.Errorf("failed to import state from %s: %v", instanceConfig.ImportFromFs, err)
+		}
+	}
+
+	return instance, nil
+}
+
+// sqliteDSN waits for a lock held by another process instead of failing at once, unless dsn defines a busy timeout.
+func sqliteDSN(dsn string) string {
+	if strings.Contains(dsn, "busy_timeout") {
+		return dsn
+	}
+	separator := "?"
+	if strings.Contains(dsn, "?") {
+		separator = "&"
+	}
+	return dsn + separator + "_pragma=busy_timeout(5000)"
+}
@@ -0,0 +1,417 @@
+package state
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"path/filepath"
+	"testing"
+
+	"github.com/alexandreh2ag/lets-go-tls/config"
+	appCtx "github.com/alexandreh2ag/lets-go-tls/context"
+	"github.com/alexandreh2ag/lets-go-tls/types"
+	"github.com/alexandreh2ag/lets-go-tls/types/acme"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+)
+
+func createTestSQLStorage(t *testing.T) (*bytes.Buffer, *sqlStorage) {
+	logBuffer := &bytes.Buffer{}
+	ctx := appCtx.TestContext(logBuffer)
+	cfg := config.StateConfig{Type: SQLKey, Config: map[string]interface{}{
+		"driver": SQLDriverSqlite,
+		"dsn":    filepath.Join(t.TempDir(), "state.db"),
+	}}
+	storage, err := createSQLStorage(ctx, cfg)
+	assert.NoError(t, err)
+	t.Cleanup(func() { _ = storage.(*sqlStorage).db.Close() })
+	return logBuffer, storage.(*sqlStorage)
+}
+
+func testSQLState() *types.State {
+	return &types.State{
+		Account: &acme.Account{Email: "dev@foo.com", Key: []byte("privatekey")},
+		Certificates: types.Certificates{
+			{Identifier: "foo", Main: "foo.com", Domains: types.Domains{"foo.com", "www.foo.com"}, Key: []byte("key"), Certificate: []byte("certificate")},
+			{Identifier: "wildcard", Main: "*.bar.com", Domains: types.Domains{"*.bar.com"}, Key: []byte("key"), Certificate: []byte("certificate")},
+			{Identifier: "ip", Main: "2001:db8::1", Domains: types.Domains{"2001:db8::1"}, Key: []byte("key"), Certificate: []byte("certificate")},
+		},
+	}
+}
+
+func Test_sqlStorage_Type(t *testing.T) {
+	assert.Equal(t, SQLKey, sqlStorage{}.Type())
+}
+
+func Test_sqliteDSN(t *testing.T) {
+	assert.Equal(t, "/app/state.db?_pragma=busy_timeout(5000)", sqliteDSN("/app/state.db"))
+	assert.Equal(t, "file:/app/state.db?mode=rwc&_pragma=busy_timeout(5000)", sqliteDSN("file:/app/state.db?mode=rwc"))
+	assert.Equal(t, "/app/state.db?_pragma=busy_timeout(1000)", sqliteDSN("/app/state.db?_pragma=busy_timeout(1000)"))
+}
+
+func Test_sqlStorage_rebind(t *testing.T) {
+	query := `SELECT data FROM state_accounts WHERE name = ? AND data = ?`
+	assert.Equal(t, query, sqlStorage{cfg: ConfigSQL{Driver: SQLDriverSqlite}}.rebind(query))
+	assert.Equal(t, `SELECT data FROM state_accounts WHERE name = $1 AND data = $2`, sqlStorage{cfg: ConfigSQL{Driver: SQLDriverPostgres}}.rebind(query))
+}
+
+func Test_sqlStorage_Load_SuccessEmpty(t *testing.T) {
+	_, stateStorage := createTestSQLStorage(t)
+
+	got, err := stateStorage.Load()
+	assert.NoError(t, err)
+	assert.Equal(t, &types.State{SchemaVersion: SchemaVersion(), Account: &acme.Account{}, Certificates: types.Certificates{}}, got)
+}
+
+func Test_sqlStorage_SaveLoad_Success(t *testing.T) {
+	_, stateStorage := createTestSQLStorage(t)
+	s := testSQLState()
+	s.StagingAccount = &acme.Account{Email: "staging@foo.com"}
+	s.FencingToken = 3
+
+	assert.NoError(t, stateStorage.Save(s))
+	got, err := stateStorage.Load()
+	assert.NoError(t, err)
+	assert.Equal(t, s, got)
+
+	// update, reorder and delete certificates, remove staging account
+	s.Certificates = types.Certificates{s.Certificates[2], s.Certificates[0]}
+	s.Certificates[1].Domains = types.Domains{"foo.com"}
+	s.StagingAccount = nil
+	assert.NoError(t, stateStorage.Save(s))
+	got, err = stateStorage.Load()
+	assert.NoError(t, err)
+	assert.Equal(t, s, got)
+
+	count := 0
+	_ = stateStorage.db.QueryRow(`SELECT COUNT(*) FROM state_certificate_domains`).Scan(&count)
+	assert.Equal(t, 2, count)
+}
+
+// interleavedQuerier runs save before the certificate query of the first loads.
+type interleavedQuerier struct {
+	sqlQuerier
+	saves int
+	save  func()
+}
+
+func (q *interleavedQuerier) Query(query string, args ...any) (*sql.Rows, error) {
+	if q.saves > 0 {
+		q.saves--
+		q.save()
+	}
+	return q.sqlQuerier.Query(query, args...)
+}
+
+func Test_sqlStorage_Load_SuccessSavedDuringLoad(t *testing.T) {
+	_, stateStorage := createTestSQLStorage(t)
+	s := testSQLState()
+	assert.NoError(t, stateStorage.Save(s))
+
+	other := testSQLState()
+	other.Revision = s.Revision
+	other.Certificates = append(other.Certificates, &types.Certificate{Identifier: "other", Main: "other.com", Domains: types.Domains{"other.com"}})
+	querier := &interleavedQuerier{sqlQuerier: stateStorage.db, saves: 1, save: func() {
+		assert.NoError(t, stateStorage.Save(other))
+	}}
+
+	got, err := stateStorage.load(querier)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), got.Revision)
+	assert.Len(t, got.Certificates, 4)
+
+	// changes of the other save are not overridden by a save of the loaded state
+	got.Certificates[0].Domains = types.Domains{"foo.com"}
+	assert.NoError(t, stateStorage.Save(got))
+	loaded, err := stateStorage.Load()
+	assert.NoError(t, err)
+	assert.Equal(t, "other", loaded.Certificates[3].Identifier)
+}
+
+func Test_sqlStorage_Load_FailSavedDuringEachLoad(t *testing.T) {
+	_, stateStorage := createTestSQLStorage(t)
+	s := testSQLState()
+	assert.NoError(t, stateStorage.Save(s))
+
+	querier := &interleavedQuerier{sqlQuerier: stateStorage.db, saves: sqlLoadRetries + 1, save: func() {
+		s.FencingToken++
+		assert.NoError(t, stateStorage.Save(s))
+	}}
+
+	_, err := stateStorage.load(querier)
+	assert.ErrorIs(t, err, types.ErrStateConflict)
+}
+
+func Test_sqlStorage_Save_SuccessUnchanged(t *testing.T) {
+	logBuffer, stateStorage := createTestSQLStorage(t)
+	s := testSQLState()
+	assert.NoError(t, stateStorage.Save(s))
+	assert.Contains(t, logBuffer.String(), "save state to sql sqlite")
+	logBuffer.Reset()
+
+	assert.NoError(t, stateStorage.Save(s))
+	assert.NotContains(t, logBuffer.String(), "save state to sql")
+	assert.Equal(t, int64(1), s.Revision)
+}
+
+type countUnwrapKeyWrapper struct {
+	KeyWrapper
+	unwrapped int
+}
+
+func (w *countUnwrapKeyWrapper) Unwrap(wrapped []byte) ([]byte, error) {
+	w.unwrapped++
+	return w.KeyWrapper.Unwrap(wrapped)
+}
+
+func Test_sqlStorage_Save_SuccessUnchangedEncryptedWithoutDecrypt(t *testing.T) {
+	logBuffer, stateStorage := createTestSQLStorage(t)
+	stateStorage.encryption = newTestEncryption(t, true, "key1")
+	wrapper := &countUnwrapKeyWrapper{KeyWrapper: stateStorage.encryption.wrappers["key1"]}
+	stateStorage.encryption.wrappers["key1"] = wrapper
+	s := testSQLState()
+	assert.NoError(t, stateStorage.Save(s))
+	logBuffer.Reset()
+
+	assert.NoError(t, stateStorage.Save(s))
+	assert.NotContains(t, logBuffer.String(), "save state to sql")
+	assert.Equal(t, 0, wrapper.unwrapped)
+
+	// rows saved without hash are decrypted once
+	_, err := stateStorage.db.Exec(`UPDATE state_certificates SET data_hash = ''`)
+	assert.NoError(t, err)
+	assert.NoError(t, stateStorage.Save(s))
+	assert.Equal(t, 3, wrapper.unwrapped)
+	assert.NoError(t, stateStorage.Save(s))
+	assert.Equal(t, 3, wrapper.unwrapped)
+	assert.Equal(t, int64(1), s.Revision)
+}
+
+func Test_sqlStorage_Save_FailStaleFencingToken(t *testing.T) {
+	_, stateStorage := createTestSQLStorage(t)
+	assert.NoError(t, stateStorage.Save(&types.State{Certificates: types.Certificates{}, FencingToken: 10}))
+
+	err := stateStorage.Save(&types.State{Certificates: types.Certificates{{Identifier: "foo", Domains: types.Domains{"foo.com"}}}, FencingToken: 9})
+	assert.ErrorIs(t, err, types.ErrStaleFencingToken)
+	assert.EqualError(t, err, "failed to write in sql state: stale fencing token (9 < 10)")
+
+	got, _ := stateStorage.Load()
+	assert.Empty(t, got.Certificates)
+	assert.Equal(t, int64(10), got.FencingToken)
+}
+
+func Test_sqlStorage_Save_FailRevisionConflict(t *testing.T) {
+	_, stateStorage := createTestSQLStorage(t)
+	s := testSQLState()
+	assert.NoError(t, stateStorage.Save(s))
+	assert.Equal(t, int64(1), s.Revision)
+
+	err := stateStorage.Save(&types.State{Certificates: types.Certificates{}})
+	assert.ErrorIs(t, err, types.ErrStateConflict)
+	assert.EqualError(t, err, "failed to write in sql state: state was modified concurrently (revision 0 != 1)")
+
+	got, _ := stateStorage.Load()
+	assert.Len(t, got.Certificates, 3)
+	assert.Equal(t, int64(1), got.Revision)
+}
+
+func Test_sqlStorage_Load_SuccessMigrateSchema(t *testing.T) {
+	_, stateStorage := createTestSQLStorage(t)
+	_, _ = stateStorage.db.Exec(`INSERT INTO state_certificates (identifier, position, data) VALUES ('foo', 0, '{"identifier":"foo","domain":["foo.com"]}')`)
+
+	got, err := stateStorage.Load()
+	assert.NoError(t, err)
+	assert.Equal(t, SchemaVersion(), got.SchemaVersion)
+	assert.Equal(t, types.Domains{"foo.com"}, got.Certificates[0].Domains)
+
+	// migrated rows are written on save
+	assert.NoError(t, stateStorage.Save(got))
+	schemaVersion, _ := stateStorage.loadMeta(stateStorage.db, sqlSchemaVersionKey, "")
+	assert.Equal(t, int64(SchemaVersion()), schemaVersion)
+	found, err := stateStorage.FindCertificates(types.Domains{"foo.com"})
+	assert.NoError(t, err)
+	assert.Len(t, found, 1)
+}
+
+func Test_sqlStorage_Save_FailNewerSchema(t *testing.T) {
+	_, stateStorage := createTestSQLStorage(t)
+	_, _ = stateStorage.db.Exec(`UPDATE state_meta SET value = '100' WHERE name = 'schema_version'`)
+
+	_, err := stateStorage.Load()
+	assert.ErrorIs(t, err, types.ErrUnsupportedSchemaVersion)
+
+	err = stateStorage.Save(&types.State{Certificates: types.Certificates{}})
+	assert.ErrorIs(t, err, types.ErrUnsupportedSchemaVersion)
+	assert.EqualError(t, err, "failed to write in sql state: state schema version is not supported (100 > 2)")
+}
+
+func Test_sqlStorage_Save_FailEmptyIdentifier(t *testing.T) {
+	_, stateStorage := createTestSQLStorage(t)
+
+	err := stateStorage.Save(&types.State{Certificates: types.Certificates{{Domains: types.Domains{"foo.com"}}}})
+	assert.EqualError(t, err, "failed to write certificate [foo.com]: identifier is empty")
+}
+
+func Test_sqlStorage_FindCertificates(t *testing.T) {
+	_, stateStorage := createTestSQLStorage(t)
+	s := testSQLState()
+	assert.NoError(t, stateStorage.Save(s))
+
+	tests := []struct {
+		name    string
+		domains types.Domains
+		want    types.Certificates
+	}{
+		{name: "SuccessExact", domains: types.Domains{"www.foo.com", "foo.com"}, want: types.Certificates{s.Certificates[0]}},
+		{name: "SuccessWildcard", domains: types.Domains{"a.bar.com"}, want: types.Certificates{s.Certificates[1]}},
+		{name: "SuccessIP", domains: types.Domains{"2001:DB8:0::1"}, want: types.Certificates{s.Certificates[2]}},
+		{name: "SuccessPerDomain", domains: types.Domains{"baz.com", "a.bar.com", "foo.com"}, want: types.Certificates{s.Certificates[0], s.Certificates[1]}},
+		{name: "SuccessNotFound", domains: types.Domains{"baz.com"}, want: types.Certificates{}},
+		{name: "SuccessEmpty", domains: types.Domains{}, want: types.Certificates{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := stateStorage.FindCertificates(tt.domains)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_sqlStorage_ChangeTag(t *testing.T) {
+	_, stateStorage := createTestSQLStorage(t)
+	tag, err := stateStorage.ChangeTag()
+	assert.NoError(t, err)
+	assert.Equal(t, "0", tag)
+
+	assert.NoError(t, stateStorage.Save(testSQLState()))
+	tag, err = stateStorage.ChangeTag()
+	assert.NoError(t, err)
+	assert.Equal(t, "1", tag)
+
+	_ = stateStorage.db.Close()
+	_, err = stateStorage.ChangeTag()
+	assert.ErrorContains(t, err, "failed to select revision")
+}
+
+func Test_sqlStorage_SaveLoad_SuccessEncrypted(t *testing.T) {
+	_, stateStorage := createTestSQLStorage(t)
+	stateStorage.encryption = newTestEncryption(t, true, "key1")
+	s := testSQLState()
+
+	assert.NoError(t, stateStorage.Save(s))
+	var data string
+	_ = stateStorage.db.QueryRow(`SELECT data FROM state_certificates WHERE identifier = 'foo'`).Scan(&data)
+	assert.NotContains(t, data, "certificate")
+
+	got, err := stateStorage.Load()
+	assert.NoError(t, err)
+	assert.Equal(t, s, got)
+
+	stateStorage.encryption = newTestEncryption(t, true, "key2")
+	_, err = stateStorage.Load()
+	assert.EqualError(t, err, "failed to decrypt account: state is encrypted with unknown key key1")
+}
+
+func Test_sqlStorage_migrate_Idempotent(t *testing.T) {
+	_, stateStorage := createTestSQLStorage(t)
+	assert.NoError(t, stateStorage.Save(testSQLState()))
+
+	assert.NoError(t, stateStorage.migrate())
+	count := 0
+	_ = stateStorage.db.QueryRow(`SELECT COUNT(*) FROM state_schema_migrations`).Scan(&count)
+	assert.Equal(t, len(sqlMigrations), count)
+	got, _ := stateStorage.Load()
+	assert.Len(t, got.Certificates, 3)
+}
+
+func Test_createSQLStorage_ImportFromFs(t *testing.T) {
+	logBuffer := &bytes.Buffer{}
+	ctx := appCtx.TestContext(logBuffer)
+	s := testSQLState()
+	data, _ := json.Marshal(s)
+	_ = afero.WriteFile(ctx.Fs, "/app/state.json", data, 0644)
+	dsn := filepath.Join(t.TempDir(), "state.db")
+	cfg := config.StateConfig{Type: SQLKey, Config: map[string]interface{}{"driver": SQLDriverSqlite, "dsn": dsn, "import_from_fs": "/app/state.json"}}
+
+	storage, err := createSQLStorage(ctx, cfg)
+	assert.NoError(t, err)
+	got, err := storage.Load()
+	assert.NoError(t, err)
+	s.SchemaVersion = SchemaVersion()
+	s.Revision = 1
+	assert.Equal(t, s, got)
+	assert.Contains(t, logBuffer.String(), "import state from /app/state.json")
+	_ = storage.(*sqlStorage).db.Close()
+
+	// database is not empty, fs state is not imported again
+	_ = afero.WriteFile(ctx.Fs, "/app/state.json", []byte(`{"certificates":[]}`), 0644)
+	storage, err = createSQLStorage(ctx, cfg)
+	assert.NoError(t, err)
+	got, _ = storage.Load()
+	assert.Len(t, got.Certificates, 3)
+	_ = storage.(*sqlStorage).db.Close()
+}
+
+func Test_createSQLStorage(t *testing.T) {
+	ctx := appCtx.TestContext(nil)
+	_ = afero.WriteFile(ctx.Fs, "/app/wrong.json", []byte("[}"), 0644)
+
+	tests := []struct {
+		name        string
+		cfg         map[string]interface{}
+		errContains string
+	}{
+		{
+			name: "Success",
+			cfg:  map[string]interface{}{"driver": SQLDriverSqlite, "dsn": filepath.Join(t.TempDir(), "state.db")},
+		},
+		{
+			name: "SuccessImportPathNotExist",
+			cfg:  map[string]interface{}{"driver": SQLDriverSqlite, "dsn": filepath.Join(t.TempDir(), "state.db"), "import_from_fs": "/app/missing.json"},
+		},
+		{
+			name:        "FailDecodeCfg",
+			cfg:         map[string]interface{}{"driver": []string{}},
+			errContains: "'driver' expected type 'string', got unconvertible type '[]string'",
+		},
+		{
+			name:        "FailValidateDriver",
+			cfg:         map[string]interface{}{"driver": "mysql", "dsn": "dsn"},
+			errContains: "Field validation for 'Driver' failed on the 'oneof' tag",
+		},
+		{
+			name:        "FailValidateDSN",
+			cfg:         map[string]interface{}{"driver": SQLDriverSqlite},
+			errContains: "Field validation for 'DSN' failed on the 'required' tag",
+		},
+		{
+			name:        "FailMigrate",
+			cfg:         map[string]interface{}{"driver": SQLDriverSqlite, "dsn": filepath.Join(t.TempDir(), "missing", "state.db")},
+			errContains: "failed to create migrations table",
+		},
+		{
+			name:        "FailImport",
+			cfg:         map[string]interface{}{"driver": SQLDriverSqlite, "dsn": filepath.Join(t.TempDir(), "state.db"), "import_from_fs": "/app/wrong.json"},
+			errContains: "failed to import state from /app/wrong.json: failed to parse json /app/wrong.json",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := createSQLStorage(ctx, config.StateConfig{Type: SQLKey, Config: tt.cfg})
+			if tt.errContains != "" {
+				assert.Nil(t, got)
+				assert.ErrorContains(t, err, tt.errContains)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, SQLKey, got.Type())
+			_ = got.(*sqlStorage).db.Close()
+		})
+	}
+}
+
+func Test_sqlStorage_VersionStore(t *testing.T) {
+	_, stateStorage := createTestSQLStorage(t)
+	testVersionStore(t, stateStorage)
+}
@@ -12,8 +12,17 @@ var TypeStorageMapping = map[string]CreateStorageFn{}
 type CreateStorageFn func(ctx context.Context, cfg config.StateConfig) (state.Storage, error)
 
 func CreateStorage(ctx context.Context, cfg config.StateConfig) (state.Storage, error) {
-	if fn, ok := TypeStorageMapping[cfg.Type]; ok {
-		return fn(ctx, cfg)
+	fn, ok := TypeStorageMapping[cfg.Type]
+	if !ok {
+		return nil, fmt.Errorf("config state storage type '%s' does not exist", cfg.Type)
 	}
-	return nil, fmt.Errorf("config state storage type '%s' does not exist", cfg.Type)
+	storage, err := fn(ctx, cfg)
+	if err != nil || !cfg.History.Enable {
+		return storage, err
+	}
+	history, err := NewHistory(ctx.GetFS(), storage, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return WithHistory(storage, history), nil
 }
@@ -25,3 +25,16 @@ func TestCreateStorage_Fail(t *testing.T) {
 	assert.Error(t, err)
 	assert.Contains(t, err.Error(), "config state storage type 'wrong' does not exist")
 }
+
+func TestCreateStorage_SuccessWithHistory(t *testing.T) {
+	ctx := context.TestContext(nil)
+	cfg := config.StateConfig{
+		Type:    FsKey,
+		Config:  map[string]interface{}{"path": "/app/acme.json"},
+		History: config.HistoryConfig{Enable: true, Path: "/app/history"},
+	}
+	got, err := CreateStorage(ctx, cfg)
+	assert.NoError(t, err)
+	assert.IsType(t, &historyStorage{}, got)
+	assert.Equal(t, FsKey, got.Type())
+}
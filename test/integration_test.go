@@ -57,6 +57,12 @@ func TestIntegration_Full(t *testing.T) {
 
 	_, apiURL, acmeHTTPClient := testutil.SetupFakeAPI(t)
 
+	caBundlePath := "/app/ca.pem"
+	ca := testutil.NewCertificateAuthority(t, time.Now().Add(-time.Hour), time.Now().Add(time.Hour*24*365))
+	certPem, keyPem := ca.Issue(t, []string{"example.com"}, time.Now().Add(-time.Hour), time.Now().Add(time.Hour*744))
+	errWriteCABundle := afero.WriteFile(fs, caBundlePath, ca.PEM, 0644)
+	assert.NoError(t, errWriteCABundle)
+
 	ctxSrv := srvCtx.TestContext(nil)
 	ctxSrv.Fs = fs
 	ctxSrv.Config.Acme.HTTPClient = acmeHTTPClient
@@ -73,6 +79,10 @@ func TestIntegration_Full(t *testing.T) {
 	cfgSrv.Interval = 1 * time.Second
 	cfgSrv.LockDuration = 5 * time.Second
 	cfgSrv.UnusedRetentionDuration = 5 * time.Minute
+	cfgSrv.ShutdownGracePeriod = 5 * time.Second
+	cfgSrv.StateRefreshInterval = 1 * time.Second
+	cfgSrv.Grouping = srvConfig.GroupingPerRequest
+	cfgSrv.Validation.CABundle = caBundlePath
 	cfgSrv.State.Type = "fs"
 	cfgSrv.State.Config = map[string]interface{}{"path": srvStatePath}
 	cfgSrv.Requesters = []config.RequesterConfig{
@@ -93,8 +103,8 @@ func TestIntegration_Full(t *testing.T) {
 				Main:           "example.com",
 				Domains:        types.Domains{"example.com"},
 				ExpirationDate: time.Now().Add(time.Hour * 744), // 1 month
-				Key:            []byte("key"),
-				Certificate:    []byte("certificate"),
+				Key:            keyPem,
+				Certificate:    certPem,
 			},
 		},
 	}
@@ -119,6 +129,8 @@ func TestIntegration_Full(t *testing.T) {
 	cfgAgent := &agentConfig.Config{}
 	cfgAgent.HTTP.Listen = agentAddress
 	cfgAgent.Interval = 1 * time.Second
+	cfgAgent.ShutdownGracePeriod = 5 * time.Second
+	cfgAgent.Validation.CABundle = caBundlePath
 	cfgAgent.State.Type = "fs"
 	cfgAgent.State.Config = map[string]interface{}{"path": agentStatePath}
 	cfgAgent.Manager.Address = fmt.Sprintf("http://%s", srvAddress)
@@ -186,11 +198,11 @@ func TestIntegration_Full(t *testing.T) {
 
 	assert.Eventually(t, func() bool {
 		certContent, certErr := afero.ReadFile(fs, filepath.Join(agentStoragePath, "example.com-0.crt"))
-		if certErr != nil || string(certContent) != "certificate" {
+		if certErr != nil || string(certContent) != string(certPem) {
 			return false
 		}
 		keyContent, keyErr := afero.ReadFile(fs, filepath.Join(agentStoragePath, "example.com-0.key"))
-		return keyErr == nil && string(keyContent) == "key"
+		return keyErr == nil && string(keyContent) == string(keyPem)
 	}, 10*time.Second, 100*time.Millisecond, "certificates were not synced in time")
 
 	ctxSrv.Signal() <- syscall.SIGINT
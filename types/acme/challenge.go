@@ -3,8 +3,9 @@ package acme
 import "github.com/go-acme/lego/v4/challenge"
 
 const (
-	TypeDNS01  = "dns-01"
-	TypeHTTP01 = "http-01"
+	TypeDNS01     = "dns-01"
+	TypeHTTP01    = "http-01"
+	TypeTLSALPN01 = "tls-alpn-01"
 )
 
 type Challenges = map[string]Challenge
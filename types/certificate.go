@@ -1,23 +1,42 @@
 package types
 
 import (
+	"crypto/tls"
 	"crypto/x509"
 	"encoding/json"
 	"encoding/pem"
+	"errors"
 	"fmt"
+	"net"
 	"slices"
+	"strings"
 	"time"
 )
 
+// MaxDomainsPerCertificate is the max number of SANs accepted by Let's Encrypt in a certificate.
+const MaxDomainsPerCertificate = 100
+
+// RetentionNever prevents a certificate to be deleted when it is unused.
+const RetentionNever = "never"
+
 type Certificates []*Certificate
 
-func (c Certificates) Match(domains Domains, onlyValid bool) *Certificate {
-	for _, certificate := range c {
-		if onlyValid && !certificate.IsValid() {
-			continue
-		}
-		if certificate.Match(domains) {
-			return certificate
+// Match returns the first certificate covering domains, declared certificates are matched in preference
+// then a certificate with exactly these domains. Wildcards cover subdomains according to suffixes.
+func (c Certificates) Match(domains Domains, onlyValid bool, suffixes Suffixes) *Certificate {
+	preferences := []func(certificate *Certificate) bool{
+		func(certificate *Certificate) bool { return certificate.Declared },
+		func(certificate *Certificate) bool { return certificate.Domains.Equal(domains) },
+		func(certificate *Certificate) bool { return true },
+	}
+	for _, preferred := range preferences {
+		for _, certificate := range c {
+			if !preferred(certificate) || (onlyValid && !certificate.IsValid()) {
+				continue
+			}
+			if certificate.Match(domains, suffixes) {
+				return certificate
+			}
 		}
 	}
 	return nil
@@ -43,31 +62,64 @@ func (c Certificates) GetCertificate(identifier string) *Certificate {
 	return nil
 }
 
-func (c Certificates) UsedCertificates(domainsRequests []*DomainRequest) Certificates {
+// MatchAll returns certificates serving domains: the certificate matched for all domains or, when none covers them
+// together, the certificate matched for each domain. With perDomain, each domain is always matched alone.
+// It returns nil when a domain is not covered.
+func (c Certificates) MatchAll(domains Domains, onlyValid bool, perDomain bool, suffixes Suffixes) Certificates {
+	if !perDomain {
+		if certificate := c.Match(domains, onlyValid, suffixes); certificate != nil {
+			return Certificates{certificate}
+		}
+	}
+	matched := Certificates{}
+	for _, domain := range domains {
+		certificate := c.Match(Domains{domain}, onlyValid, suffixes)
+		if certificate == nil {
+			return nil
+		}
+		if !slices.Contains(matched, certificate) {
+			matched = append(matched, certificate)
+		}
+	}
+	return matched
+}
+
+// usedBy returns certificates serving domainsRequests (see MatchAll), without perDomain a certificate
+// covering all domains of a request is also used.
+func (c Certificates) usedBy(domainsRequests []*DomainRequest, perDomain bool, suffixes Suffixes) map[*Certificate]bool {
+	used := map[*Certificate]bool{}
+	for _, request := range domainsRequests {
+		for _, certificate := range c.MatchAll(request.Domains, false, perDomain, suffixes) {
+			used[certificate] = true
+		}
+		if perDomain {
+			continue
+		}
+		for _, certificate := range c {
+			if certificate.Match(request.Domains, suffixes) {
+				used[certificate] = true
+			}
+		}
+	}
+	return used
+}
+
+func (c Certificates) UsedCertificates(domainsRequests []*DomainRequest, perDomain bool, suffixes Suffixes) Certificates {
+	used := c.usedBy(domainsRequests, perDomain, suffixes)
 	usedCertificates := Certificates{}
 	for _, cert := range c {
-		for _, request := range domainsRequests {
-			if cert.Match(request.Domains) {
-				usedCertificates = append(usedCertificates, cert)
-				break
-			}
+		if used[cert] {
+			usedCertificates = append(usedCertificates, cert)
 		}
 	}
 	return usedCertificates
 }
 
-func (c Certificates) UnusedCertificates(domainsRequests []*DomainRequest) Certificates {
+func (c Certificates) UnusedCertificates(domainsRequests []*DomainRequest, perDomain bool, suffixes Suffixes) Certificates {
+	used := c.usedBy(domainsRequests, perDomain, suffixes)
 	unusedCertificates := Certificates{}
 	for _, cert := range c {
-		found := false
-		for _, request := range domainsRequests {
-			if cert.Match(request.Domains) {
-				found = true
-				break
-			}
-		}
-
-		if !found {
+		if !used[cert] {
 			unusedCertificates = append(unusedCertificates, cert)
 		}
 	}
@@ -83,18 +135,49 @@ func (c Certificates) Deletes(removeCertificates Certificates) Certificates {
 	})
 }
 
+// Clone returns a copy of certificates, changes on the copy do not affect the original certificates.
+func (c Certificates) Clone() Certificates {
+	certificates := Certificates{}
+	for _, certificate := range c {
+		clone := *certificate
+		clone.Domains = slices.Clone(certificate.Domains)
+		clone.PendingDomains = slices.Clone(certificate.PendingDomains)
+		clone.ParkedDomains = slices.Clone(certificate.ParkedDomains)
+		clone.RemovedDomains = slices.Clone(certificate.RemovedDomains)
+		certificates = append(certificates, &clone)
+	}
+	return certificates
+}
+
 type Certificate struct {
 	Identifier     string    `json:"identifier,omitempty"`
 	Main           string    `json:"main,omitempty"`
 	Domains        Domains   `json:"domains,omitempty"`
+	PendingDomains Domains   `json:"pending_domains,omitempty"`
 	Certificate    []byte    `json:"certificate,omitempty"`
 	Key            []byte    `json:"key,omitempty"`
 	ExpirationDate time.Time `json:"expiration_date,omitempty"`
+	NotBefore      time.Time `json:"not_before,omitempty"`
 
 	ObtainFailCount int       `json:"obtain_fail_count,omitempty"`
 	ObtainFailDate  time.Time `json:"obtain_fail_date,omitempty"`
+	// StagingFailDate is the date of the last failed validation on staging CA, it is retried after delay_failed.
+	StagingFailDate time.Time `json:"staging_fail_date,omitempty"`
+
+	// ParkedDomains are pending domains which reached max obtain attempts, they are pending again after delay_failed from ParkedDate.
+	ParkedDomains Domains   `json:"parked_domains,omitempty"`
+	ParkedDate    time.Time `json:"parked_date,omitempty"`
+
+	// RemovedDomains are still covered by the current certificate but no longer requested, they are dropped on next issue.
+	RemovedDomains Domains `json:"removed_domains,omitempty"`
 
 	UnusedAt time.Time `json:"unused_at,omitempty"`
+
+	// Declared is true when the certificate is defined in server config, Resolver and KeyType come from this definition.
+	Declared  bool   `json:"declared,omitempty"`
+	Resolver  string `json:"resolver,omitempty"`
+	KeyType   string `json:"key_type,omitempty"`
+	Retention string `json:"retention,omitempty"`
 }
 
 func (c *Certificate) UnmarshalJSON(data []byte) error {
@@ -117,6 +200,22 @@ func (c *Certificate) UnmarshalJSON(data []byte) error {
 	return nil
 }
 
+// RequestedDomains returns domains of the certificate with pending domains and without removed domains,
+// which are the domains of next issue.
+func (c *Certificate) RequestedDomains() Domains {
+	return slices.DeleteFunc(slices.Concat(c.Domains, c.PendingDomains), c.RemovedDomains.Contains)
+}
+
+// NeedReissue returns true when domains of next issue differ from domains of the current certificate.
+func (c *Certificate) NeedReissue() bool {
+	return len(c.PendingDomains) > 0 || len(c.RemovedDomains) > 0
+}
+
+// IsIssued returns true when the certificate has already been issued once, even if its material is missing.
+func (c *Certificate) IsIssued() bool {
+	return c.Certificate != nil || !c.ExpirationDate.IsZero()
+}
+
 func (c *Certificate) IsValid() bool {
 	if c.Key == nil || c.Certificate == nil {
 		return false
@@ -124,6 +223,79 @@ func (c *Certificate) IsValid() bool {
 	return true
 }
 
+// ValidateMaterial checks certificate material before it is stored or distributed: key matches the certificate,
+// chain builds to a trusted root, certificate is valid at now and its SANs cover Domains.
+// System roots are used when roots is nil.
+func (c *Certificate) ValidateMaterial(roots *x509.CertPool, now time.Time) error {
+	if !c.IsValid() {
+		return errors.New("certificate or key is missing")
+	}
+	if _, err := tls.X509KeyPair(c.Certificate, c.Key); err != nil {
+		return fmt.Errorf("key does not match certificate: %v", err)
+	}
+
+	chain, errChain := parseCertificateChain(c.Certificate)
+	if errChain != nil {
+		return errChain
+	}
+	leaf := chain[0]
+	if now.Before(leaf.NotBefore) || now.After(leaf.NotAfter) {
+		return fmt.Errorf(
+			"certificate is not valid at %s (not before %s, not after %s)",
+			now.Format(time.RFC3339),
+			leaf.NotBefore.Format(time.RFC3339),
+			leaf.NotAfter.Format(time.RFC3339),
+		)
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, cert := range chain[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, errVerify := leaf.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		CurrentTime:   now,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	if errVerify != nil {
+		return fmt.Errorf("certificate chain is not trusted: %v", errVerify)
+	}
+
+	for _, domain := range c.Domains {
+		covered := slices.ContainsFunc(leaf.DNSNames, func(name string) bool {
+			return strings.EqualFold(name, string(domain))
+		})
+		if domain.IsIP() {
+			covered = slices.ContainsFunc(leaf.IPAddresses, func(ip net.IP) bool {
+				return ip.Equal(net.ParseIP(string(domain)))
+			})
+		}
+		if !covered {
+			return fmt.Errorf("certificate does not cover domain %s", domain)
+		}
+	}
+	return nil
+}
+
+// RenewDate returns the date from which the certificate must be renewed.
+// When renewAt is a fraction of the lifetime (ex: 2/3), it is used if NotBefore is known, otherwise the fixed renewPeriod before expiration is used.
+func (c *Certificate) RenewDate(renewAt float64, renewPeriod time.Duration) time.Time {
+	if renewAt > 0 {
+		notBefore := c.NotBefore
+		if notBefore.IsZero() && c.Certificate != nil {
+			if cert, err := GetX509Certificate(c.Certificate); err == nil {
+				notBefore = cert.NotBefore
+			}
+		}
+		if !notBefore.IsZero() && c.ExpirationDate.After(notBefore) {
+			lifetime := c.ExpirationDate.Sub(notBefore)
+			return notBefore.Add(time.Duration(float64(lifetime) * renewAt))
+		}
+	}
+	return c.ExpirationDate.Add(-renewPeriod)
+}
+
 func (c *Certificate) GetKeyFilename() string {
 	return GetKeyFilename(c.Identifier)
 }
@@ -142,10 +314,11 @@ func (c *Certificate) GetPemContent() []byte {
 	return append(content, []byte("\n")...)
 }
 
-func (c *Certificate) Match(domains Domains) bool {
+// Match returns true when the certificate covers domains, wildcards cover subdomains according to suffixes.
+func (c *Certificate) Match(domains Domains, suffixes Suffixes) bool {
 	if len(c.Domains) > 0 && len(domains) > 0 {
 		for _, domain := range domains {
-			if !slices.Contains(c.Domains, domain) && !slices.Contains(c.Domains, domain.FormatSubdomainToWildcard()) {
+			if !slices.ContainsFunc(c.Domains, func(other Domain) bool { return domain.CoveredBy(other, suffixes) }) {
 				return false
 			}
 		}
@@ -166,6 +339,36 @@ func GetPemFilename(identifier string) string {
 	return fmt.Sprintf("%s.%s", identifier, "pem")
 }
 
+// NewCertPool returns system roots with roots of bundle (PEM) added.
+func NewCertPool(bundle []byte) (*x509.CertPool, error) {
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(bundle) {
+		return nil, fmt.Errorf("failed to find any certificate in bundle")
+	}
+	return pool, nil
+}
+
+func parseCertificateChain(data []byte) ([]*x509.Certificate, error) {
+	chain := []*x509.Certificate{}
+	for block, rest := pem.Decode(data); block != nil; block, rest = pem.Decode(rest) {
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse certificate chain: %v", err)
+		}
+		chain = append(chain, cert)
+	}
+	if len(chain) == 0 {
+		return nil, fmt.Errorf("failed to decode cert")
+	}
+	return chain, nil
+}
+
 func GetX509Certificate(cert []byte) (*x509.Certificate, error) {
 	certBlock, _ := pem.Decode(cert)
 	if certBlock == nil {
@@ -4,7 +4,9 @@ import (
 	"encoding/json"
 	"fmt"
 	"testing"
+	"time"
 
+	"github.com/alexandreh2ag/lets-go-tls/internal/testutil"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -14,6 +16,7 @@ func TestCertificate_Match(t *testing.T) {
 		name               string
 		certificateDomains Domains
 		domains            Domains
+		suffixes           Suffixes
 		want               bool
 	}{
 		{
@@ -88,13 +91,44 @@ func TestCertificate_Match(t *testing.T) {
 			domains:            Domains{"foo.example.us", "example.com", "foo.foo.example.us"},
 			want:               false,
 		},
+		{
+			name:               "NoMatchWildcardPublicSuffix",
+			certificateDomains: Domains{"*.co.uk"},
+			domains:            Domains{"example.co.uk"},
+			want:               false,
+		},
+		{
+			name:               "NoMatchWildcardPrivateSuffix",
+			certificateDomains: Domains{"*.customers.example.com"},
+			domains:            Domains{"foo.customers.example.com"},
+			suffixes:           Suffixes{"customers.example.com"},
+			want:               false,
+		},
+		{
+			name:               "MatchWildcardMultiTldDot",
+			certificateDomains: Domains{"*.example.co.uk"},
+			domains:            Domains{"foo.example.co.uk"},
+			want:               true,
+		},
+		{
+			name:               "MatchIPv6",
+			certificateDomains: Domains{"192.0.2.1", "2001:db8::1"},
+			domains:            Domains{"2001:DB8:0::1"},
+			want:               true,
+		},
+		{
+			name:               "NoMatchIP",
+			certificateDomains: Domains{"192.0.2.1"},
+			domains:            Domains{"192.0.2.2"},
+			want:               false,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			c := Certificate{
 				Domains: tt.certificateDomains,
 			}
-			assert.Equalf(t, tt.want, c.Match(tt.domains), "Match(%v)", tt.domains)
+			assert.Equalf(t, tt.want, c.Match(tt.domains, tt.suffixes), "Match(%v)", tt.domains)
 		})
 	}
 }
@@ -162,6 +196,9 @@ func TestCertificates_CheckIdentifierUnique(t *testing.T) {
 func TestCertificates_Match(t *testing.T) {
 	cert := &Certificate{Domains: Domains{Domain("example.com")}, Certificate: []byte("certificate"), Key: []byte("key")}
 	certNotValid := &Certificate{Domains: Domains{Domain("example-not-valid.com")}}
+	certLarge := &Certificate{Domains: Domains{Domain("foo.com"), Domain("bar.com")}}
+	certExact := &Certificate{Domains: Domains{Domain("bar.com")}}
+	certDeclared := &Certificate{Domains: Domains{Domain("example.com"), Domain("foo.example.com")}, Certificate: []byte("certificate"), Key: []byte("key"), Declared: true}
 	tests := []struct {
 		name      string
 		c         Certificates
@@ -183,6 +220,20 @@ func TestCertificates_Match(t *testing.T) {
 			onlyValid: false,
 			want:      nil,
 		},
+		{
+			name:      "MatchExactInPreference",
+			c:         Certificates{certDeclared, certLarge, certExact},
+			request:   &DomainRequest{Domains: Domains{Domain("bar.com")}},
+			onlyValid: false,
+			want:      certExact,
+		},
+		{
+			name:      "MatchDeclaredInPreference",
+			c:         Certificates{cert, certDeclared},
+			request:   &DomainRequest{Domains: Domains{Domain("example.com")}},
+			onlyValid: true,
+			want:      certDeclared,
+		},
 		{
 			name:      "NotMatchWithNotValid",
 			c:         Certificates{cert, certNotValid},
@@ -193,7 +244,7 @@ func TestCertificates_Match(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			assert.Equalf(t, tt.want, tt.c.Match(tt.request.Domains, tt.onlyValid), "Match(%v)", tt.request)
+			assert.Equalf(t, tt.want, tt.c.Match(tt.request.Domains, tt.onlyValid, nil), "Match(%v)", tt.request)
 		})
 	}
 }
@@ -227,6 +278,43 @@ func TestCertificates_GetCertificate(t *testing.T) {
 	}
 }
 
+func TestCertificate_RequestedDomains(t *testing.T) {
+	cert := &Certificate{Domains: Domains{"foo.com", "bar.com"}, PendingDomains: Domains{"baz.com"}, RemovedDomains: Domains{"bar.com"}}
+	assert.Equal(t, Domains{"foo.com", "baz.com"}, cert.RequestedDomains())
+	assert.Equal(t, Domains{"foo.com", "bar.com"}, cert.Domains)
+	assert.True(t, cert.NeedReissue())
+	assert.False(t, (&Certificate{Domains: Domains{"foo.com"}}).NeedReissue())
+}
+
+func TestCertificate_IsIssued(t *testing.T) {
+	tests := []struct {
+		name        string
+		certificate Certificate
+		want        bool
+	}{
+		{
+			name:        "NeverIssued",
+			certificate: Certificate{Identifier: "example.com-0", Domains: Domains{"example.com"}},
+			want:        false,
+		},
+		{
+			name:        "Issued",
+			certificate: Certificate{Identifier: "example.com-0", Domains: Domains{"example.com"}, Certificate: []byte("certificate")},
+			want:        true,
+		},
+		{
+			name:        "IssuedWithoutMaterial",
+			certificate: Certificate{Identifier: "example.com-0", Domains: Domains{"example.com"}, ExpirationDate: time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)},
+			want:        true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equalf(t, tt.want, tt.certificate.IsIssued(), "IsIssued()")
+		})
+	}
+}
+
 func TestCertificate_IsValid(t *testing.T) {
 
 	tests := []struct {
@@ -287,7 +375,7 @@ func TestCertificates_UsedCertificates(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			assert.Equalf(t, tt.want, certificates.UsedCertificates(tt.domainsRequests), "UsedCertificates(%v)", tt.domainsRequests)
+			assert.Equalf(t, tt.want, certificates.UsedCertificates(tt.domainsRequests, false, nil), "UsedCertificates(%v)", tt.domainsRequests)
 		})
 	}
 }
@@ -320,11 +408,51 @@ func TestCertificates_UnusedCertificates(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			assert.Equalf(t, tt.want, certificates.UnusedCertificates(tt.domainsRequests), "UnusedCertificates(%v)", tt.domainsRequests)
+			assert.Equalf(t, tt.want, certificates.UnusedCertificates(tt.domainsRequests, false, nil), "UnusedCertificates(%v)", tt.domainsRequests)
+		})
+	}
+}
+
+func TestCertificates_MatchAll(t *testing.T) {
+	both := &Certificate{Identifier: "both", Domains: Domains{"example.com", "www.example.com"}, Key: []byte("key"), Certificate: []byte("certificate")}
+	apex := &Certificate{Identifier: "apex", Domains: Domains{"example.com"}, Key: []byte("key"), Certificate: []byte("certificate")}
+	www := &Certificate{Identifier: "www", Domains: Domains{"www.example.com"}, Key: []byte("key"), Certificate: []byte("certificate")}
+	api := &Certificate{Identifier: "api", Domains: Domains{"api.example.com"}}
+
+	tests := []struct {
+		name         string
+		certificates Certificates
+		domains      Domains
+		perDomain    bool
+		want         Certificates
+	}{
+		{name: "SingleCertificate", certificates: Certificates{apex, www, both}, domains: Domains{"example.com", "www.example.com"}, want: Certificates{both}},
+		{name: "CertificatePerDomain", certificates: Certificates{apex, www}, domains: Domains{"example.com", "www.example.com"}, want: Certificates{apex, www}},
+		{name: "PerDomainPreferExactCertificate", certificates: Certificates{both, apex, www}, domains: Domains{"example.com", "www.example.com"}, perDomain: true, want: Certificates{apex, www}},
+		{name: "PerDomainSameCertificate", certificates: Certificates{both}, domains: Domains{"example.com", "www.example.com"}, perDomain: true, want: Certificates{both}},
+		{name: "DomainNotCovered", certificates: Certificates{apex}, domains: Domains{"example.com", "www.example.com"}},
+		{name: "DomainNotValid", certificates: Certificates{apex, api}, domains: Domains{"example.com", "api.example.com"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.certificates.MatchAll(tt.domains, true, tt.perDomain, nil))
 		})
 	}
 }
 
+func TestCertificates_UnusedCertificates_PerDomain(t *testing.T) {
+	both := &Certificate{Identifier: "both", Domains: Domains{"example.com", "www.example.com"}}
+	apex := &Certificate{Identifier: "apex", Domains: Domains{"example.com"}}
+	www := &Certificate{Identifier: "www", Domains: Domains{"www.example.com"}}
+	certificates := Certificates{both, apex, www}
+	domainsRequests := []*DomainRequest{{Domains: Domains{"example.com", "www.example.com"}}}
+
+	assert.Equal(t, Certificates{apex, www}, certificates.UnusedCertificates(domainsRequests, false, nil))
+	assert.Equal(t, Certificates{both}, certificates.UnusedCertificates(domainsRequests, true, nil))
+	assert.Equal(t, Certificates{apex, www}, certificates.UsedCertificates(domainsRequests, true, nil))
+	assert.Equal(t, Certificates{}, Certificates{apex, www}.UnusedCertificates(domainsRequests, false, nil))
+}
+
 func TestCertificates_Deletes(t *testing.T) {
 	cert1 := &Certificate{Identifier: "example.com-0", Domains: Domains{Domain("example.com")}}
 	cert2 := &Certificate{Identifier: "example2.com-0", Domains: Domains{Domain("example2.com")}}
@@ -335,6 +463,85 @@ func TestCertificates_Deletes(t *testing.T) {
 	assert.Equal(t, want, got)
 }
 
+func TestCertificates_Clone(t *testing.T) {
+	certificates := Certificates{{Identifier: "foo", Domains: Domains{"example.com"}, ParkedDomains: Domains{"foo.com"}, RemovedDomains: Domains{"baz.com"}}}
+	got := certificates.Clone()
+	assert.Equal(t, certificates, got)
+
+	got[0].Identifier = "bar"
+	got[0].Domains[0] = "example.org"
+	got[0].ParkedDomains[0] = "bar.com"
+	got[0].RemovedDomains[0] = "bar.com"
+	assert.Equal(t, "foo", certificates[0].Identifier)
+	assert.Equal(t, Domain("example.com"), certificates[0].Domains[0])
+	assert.Equal(t, Domain("foo.com"), certificates[0].ParkedDomains[0])
+	assert.Equal(t, Domain("baz.com"), certificates[0].RemovedDomains[0])
+}
+
+func TestCertificate_RenewDate(t *testing.T) {
+	certPEM := []byte(`
+-----BEGIN CERTIFICATE-----
+MIIB1zCCAUCgAwIBAgIBATANBgkqhkiG9w0BAQsFADAWMRQwEgYDVQQKEwtFeGVt
+cGxlIE9yZzAeFw0yNTA2MjIxMTU1MDFaFw0yNjA2MjIxMTU1MDFaMBYxFDASBgNV
+BAoTC0V4ZW1wbGUgT3JnMIGfMA0GCSqGSIb3DQEBAQUAA4GNADCBiQKBgQC+xqcl
+8nmNTvPEiWpdJyWpsJSr8DTb6xPFnb1+I+ACzFS6Qyv7pT8RSTPuy/2DC4cSeqsV
+HiaBSNxQxoWODwgE41/dVx0p0G8US2Ds7M5TCGm3zHnz5oABagjcaI2ZT8YEveNL
+5w0Xstj6RmoytkGhEosDV04CZyhDWwzQB7+dkwIDAQABozUwMzAOBgNVHQ8BAf8E
+BAMCBaAwEwYDVR0lBAwwCgYIKwYBBQUHAwEwDAYDVR0TAQH/BAIwADANBgkqhkiG
+9w0BAQsFAAOBgQBnOYaKCp3VfgZD925AydTQ5PuUeo4LK5pE8AKLfOrgHvTzui1/
+34zByxGjWHv7p4rX2txg9EpN5BuAIvddIn3eMb802+FqjznbGVaMQ1iaftGlJnir
+B0JJs8CQfk8HQCPK5pdaZrsc+1cWqPDuUSpuDTi06NGbPD7xYGIwcKoEAg==
+-----END CERTIFICATE-----
+`)
+	notBefore := time.Date(2025, time.January, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name        string
+		certificate *Certificate
+		renewAt     float64
+		renewPeriod time.Duration
+		want        time.Time
+	}{
+		{
+			name:        "FixedPeriod",
+			certificate: &Certificate{NotBefore: notBefore, ExpirationDate: notBefore.Add(90 * 24 * time.Hour)},
+			renewPeriod: 10 * 24 * time.Hour,
+			want:        notBefore.Add(80 * 24 * time.Hour),
+		},
+		{
+			name:        "LifetimeFraction",
+			certificate: &Certificate{NotBefore: notBefore, ExpirationDate: notBefore.Add(90 * 24 * time.Hour)},
+			renewAt:     2.0 / 3.0,
+			renewPeriod: 10 * 24 * time.Hour,
+			want:        notBefore.Add(60 * 24 * time.Hour),
+		},
+		{
+			name:        "LifetimeFractionShortLived",
+			certificate: &Certificate{NotBefore: notBefore, ExpirationDate: notBefore.Add(6 * 24 * time.Hour)},
+			renewAt:     2.0 / 3.0,
+			renewPeriod: 10 * 24 * time.Hour,
+			want:        notBefore.Add(4 * 24 * time.Hour),
+		},
+		{
+			name:        "LifetimeFractionNotBeforeFromCertificate",
+			certificate: &Certificate{Certificate: certPEM, ExpirationDate: time.Date(2026, time.June, 22, 11, 55, 1, 0, time.UTC)},
+			renewAt:     0.5,
+			want:        time.Date(2025, time.December, 21, 23, 55, 1, 0, time.UTC),
+		},
+		{
+			name:        "LifetimeFractionFallbackFixedPeriod",
+			certificate: &Certificate{Certificate: []byte("wrong"), ExpirationDate: notBefore.Add(90 * 24 * time.Hour)},
+			renewAt:     2.0 / 3.0,
+			renewPeriod: 10 * 24 * time.Hour,
+			want:        notBefore.Add(80 * 24 * time.Hour),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.True(t, tt.want.Equal(tt.certificate.RenewDate(tt.renewAt, tt.renewPeriod)), tt.certificate.RenewDate(tt.renewAt, tt.renewPeriod))
+		})
+	}
+}
+
 func TestGetCertificateFilename(t *testing.T) {
 	identifier := "foo"
 	assert.Equal(t, "foo.crt", GetCertificateFilename(identifier))
@@ -466,3 +673,87 @@ func TestCertificate_UnmarshalJSON(t *testing.T) {
 		})
 	}
 }
+
+func TestCertificate_ValidateMaterial(t *testing.T) {
+	now := time.Date(2025, time.January, 1, 0, 0, 0, 0, time.UTC)
+	ca := testutil.NewCertificateAuthority(t, now.Add(-time.Hour), now.Add(time.Hour*24*365))
+	otherCA := testutil.NewCertificateAuthority(t, now.Add(-time.Hour), now.Add(time.Hour*24*365))
+	cert, key := ca.Issue(t, []string{"example.com", "www.example.com"}, now.Add(-time.Hour), now.Add(time.Hour*24*90))
+	_, otherKey := ca.Issue(t, []string{"example.com"}, now.Add(-time.Hour), now.Add(time.Hour*24*90))
+	expiredCert, expiredKey := ca.Issue(t, []string{"example.com"}, now.Add(-time.Hour*24*90), now.Add(-time.Hour))
+	untrustedCert, untrustedKey := otherCA.Issue(t, []string{"example.com"}, now.Add(-time.Hour), now.Add(time.Hour*24*90))
+	ipCert, ipKey := ca.Issue(t, []string{"192.0.2.1", "2001:db8::1"}, now.Add(-time.Hour), now.Add(time.Hour*24*90))
+
+	tests := []struct {
+		name        string
+		certificate *Certificate
+		wantErr     string
+	}{
+		{
+			name:        "Success",
+			certificate: &Certificate{Domains: Domains{"example.com", "WWW.example.com"}, Certificate: cert, Key: key},
+		},
+		{
+			name:        "SuccessChainWithRoot",
+			certificate: &Certificate{Domains: Domains{"example.com"}, Certificate: append(append([]byte{}, cert...), ca.PEM...), Key: key},
+		},
+		{
+			name:        "FailMissingMaterial",
+			certificate: &Certificate{Domains: Domains{"example.com"}, Certificate: cert},
+			wantErr:     "certificate or key is missing",
+		},
+		{
+			name:        "FailKeyMismatch",
+			certificate: &Certificate{Domains: Domains{"example.com"}, Certificate: cert, Key: otherKey},
+			wantErr:     "key does not match certificate",
+		},
+		{
+			name:        "FailExpired",
+			certificate: &Certificate{Domains: Domains{"example.com"}, Certificate: expiredCert, Key: expiredKey},
+			wantErr:     "certificate is not valid at 2025-01-01T00:00:00Z",
+		},
+		{
+			name:        "FailUntrustedChain",
+			certificate: &Certificate{Domains: Domains{"example.com"}, Certificate: untrustedCert, Key: untrustedKey},
+			wantErr:     "certificate chain is not trusted",
+		},
+		{
+			name:        "SuccessIP",
+			certificate: &Certificate{Domains: Domains{"192.0.2.1", "2001:DB8:0::1"}, Certificate: ipCert, Key: ipKey},
+		},
+		{
+			name:        "FailDomainNotCovered",
+			certificate: &Certificate{Domains: Domains{"example.com", "foo.example.com"}, Certificate: cert, Key: key},
+			wantErr:     "certificate does not cover domain foo.example.com",
+		},
+		{
+			name:        "FailIPNotCovered",
+			certificate: &Certificate{Domains: Domains{"192.0.2.1", "192.0.2.2"}, Certificate: ipCert, Key: ipKey},
+			wantErr:     "certificate does not cover domain 192.0.2.2",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.certificate.ValidateMaterial(ca.Pool, now)
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.ErrorContains(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestNewCertPool(t *testing.T) {
+	now := time.Now()
+	ca := testutil.NewCertificateAuthority(t, now.Add(-time.Hour), now.Add(time.Hour))
+	cert, key := ca.Issue(t, []string{"example.com"}, now.Add(-time.Hour), now.Add(time.Hour))
+
+	pool, err := NewCertPool(ca.PEM)
+	assert.NoError(t, err)
+	certificate := &Certificate{Domains: Domains{"example.com"}, Certificate: cert, Key: key}
+	assert.NoError(t, certificate.ValidateMaterial(pool, now))
+
+	_, err = NewCertPool([]byte("wrong"))
+	assert.ErrorContains(t, err, "failed to find any certificate in bundle")
+}
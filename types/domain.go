@@ -2,21 +2,108 @@ package types
 
 import (
 	"cmp"
+	"fmt"
 	"net"
 	"slices"
 	"strings"
+
+	"golang.org/x/net/idna"
+)
+
+// hostnameProfile lowercases and converts Unicode names to punycode, it rejects names a CA would refuse (invalid runes, label length).
+var hostnameProfile = idna.New(
+	idna.MapForLookup(),
+	idna.BidiRule(),
+	idna.StrictDomainName(true),
+	idna.VerifyDNSLength(true),
+	idna.Transitional(false),
 )
 
 type Domain string
 
-func (d Domain) FormatSubdomainToWildcard() Domain {
-	split := strings.Split(string(d), ".")
-	if len(split) >= 3 {
-		split[0] = "*"
-		return Domain(strings.Join(split, "."))
+// Normalize returns the lowercase ASCII form of the domain (ex: Bücher.Example.COM is xn--bcher-kva.example.com),
+// IP addresses are returned in canonical form. An error is returned when the domain is not a valid hostname.
+func (d Domain) Normalize() (Domain, error) {
+	name := strings.TrimSuffix(strings.TrimSpace(string(d)), ".")
+	if ip := net.ParseIP(name); ip != nil {
+		return Domain(ip.String()), nil
+	}
+
+	prefix := ""
+	if strings.HasPrefix(name, "*.") {
+		prefix, name = "*.", strings.TrimPrefix(name, "*.")
+	}
+	ascii, err := hostnameProfile.ToASCII(name)
+	if err != nil {
+		return d, fmt.Errorf("invalid hostname %s: %v", d, err)
+	}
+	return Domain(prefix + ascii), nil
+}
+
+// FormatSubdomainToWildcard returns the wildcard covering the domain (ex: *.example.com for foo.example.com),
+// the domain itself is returned when its parent is a public suffix (ex: example.co.uk) because no wildcard can cover it.
+func (d Domain) FormatSubdomainToWildcard(suffixes Suffixes) Domain {
+	if d.IsIP() || d.IsWildcard() {
+		return d
+	}
+	_, parent, found := strings.Cut(string(d), ".")
+	if !found || Domain(parent).IsPublicSuffix(suffixes) {
+		return d
+	}
+	return Domain("*." + parent)
+}
+
+// RegisteredDomain returns the domain registered under a public suffix (ex: example.co.uk for *.www.example.co.uk),
+// the domain itself is returned when it is a public suffix.
+func (d Domain) RegisteredDomain(suffixes Suffixes) Domain {
+	if d.IsIP() {
+		return d
 	}
+	name := strings.TrimPrefix(string(d), "*.")
+	suffix := suffixes.PublicSuffix(name)
+	if name == suffix {
+		return Domain(name)
+	}
+	labels := strings.Split(strings.TrimSuffix(name, "."+suffix), ".")
+	return Domain(labels[len(labels)-1] + "." + suffix)
+}
+
+// IsPublicSuffix returns true when the domain is a public suffix (ex: co.uk) or one of private suffixes.
+func (d Domain) IsPublicSuffix(suffixes Suffixes) bool {
+	if d.IsIP() {
+		return false
+	}
+	name := strings.TrimPrefix(string(d), "*.")
+	return suffixes.PublicSuffix(name) == name
+}
+
+// CoveredBy returns true when the domain is other or is covered by the wildcard other.
+func (d Domain) CoveredBy(other Domain, suffixes Suffixes) bool {
+	return d.Equal(other) || (other.IsWildcard() && !d.IsWildcard() && d.FormatSubdomainToWildcard(suffixes) == other)
+}
+
+// InZone returns true when the domain (or the base of a wildcard) is zone or one of its subdomains.
+func (d Domain) InZone(zone string) bool {
+	if d.IsIP() {
+		return d.Equal(Domain(zone))
+	}
+	zone = strings.Trim(strings.ToLower(zone), ".")
+	name := strings.TrimPrefix(string(d), "*.")
+	return name == zone || strings.HasSuffix(name, "."+zone)
+}
+
+// IsIP returns true when the domain is an IPv4 or IPv6 address identifier (RFC 8738).
+func (d Domain) IsIP() bool {
+	return net.ParseIP(string(d)) != nil
+}
 
-	return d
+// Equal compares domains, IP addresses are compared by value (ex: 2001:DB8::1 and 2001:db8:0::1).
+func (d Domain) Equal(other Domain) bool {
+	if d == other {
+		return true
+	}
+	ip := net.ParseIP(string(d))
+	return ip != nil && ip.Equal(net.ParseIP(string(other)))
 }
 
 func (d Domain) IsWildcard() bool {
@@ -39,6 +126,43 @@ func (ds Domains) Sort() {
 	})
 }
 
+// Contains returns true when domain is in the list, IP addresses are compared by value.
+func (ds Domains) Contains(domain Domain) bool {
+	return slices.ContainsFunc(ds, domain.Equal)
+}
+
+// Equal returns true when both lists contain the same domains in any order.
+func (ds Domains) Equal(other Domains) bool {
+	if len(ds) != len(other) {
+		return false
+	}
+	for _, d := range ds {
+		if !other.Contains(d) {
+			return false
+		}
+	}
+	return true
+}
+
+// Normalize returns normalized domains without duplicates, the first invalid domain is reported.
+func (ds Domains) Normalize() (Domains, error) {
+	normalized := Domains{}
+	for _, domain := range ds {
+		d, err := domain.Normalize()
+		if err != nil {
+			return ds, err
+		}
+		if !slices.Contains(normalized, d) {
+			normalized = append(normalized, d)
+		}
+	}
+	return normalized, nil
+}
+
+func (ds Domains) ContainsIP() bool {
+	return slices.ContainsFunc(ds, Domain.IsIP)
+}
+
 func (ds Domains) ContainsWildcard() bool {
 	for _, d := range ds {
 		if d.IsWildcard() {
@@ -51,16 +175,25 @@ func (ds Domains) ContainsWildcard() bool {
 type DomainRequest struct {
 	Domains   Domains   `json:"domains"`
 	Requester Requester `json:"-"`
+	// Reason explains why a request is not found, it is only set in server responses.
+	Reason string `json:"reason,omitempty"`
 }
 
-func (dr DomainRequest) IsIP() bool {
-	for _, domain := range dr.Domains {
-		ip := net.ParseIP(string(domain))
-		if ip != nil {
-			return true
-		}
+// Normalize normalizes domains of the request, they are left unchanged when one is invalid.
+func (dr *DomainRequest) Normalize() error {
+	if len(dr.Domains) == 0 {
+		return fmt.Errorf("request has no domain")
 	}
-	return false
+	domains, err := dr.Domains.Normalize()
+	if err != nil {
+		return err
+	}
+	dr.Domains = domains
+	return nil
+}
+
+func (dr DomainRequest) IsIP() bool {
+	return dr.Domains.ContainsIP()
 }
 
 func SortDomainsRequests(domainsRequests []*DomainRequest) {
@@ -2,6 +2,7 @@ package types
 
 import (
 	"github.com/stretchr/testify/assert"
+	"strings"
 	"testing"
 )
 
@@ -19,7 +20,7 @@ func TestDomain_FormatSubdomainToWildcard(t *testing.T) {
 		{
 			name: "RootDomainMultiTldDot",
 			d:    Domain("example.co.uk"),
-			want: Domain("*.co.uk"),
+			want: Domain("example.co.uk"),
 		},
 		{
 			name: "Subdomain",
@@ -31,10 +32,30 @@ func TestDomain_FormatSubdomainToWildcard(t *testing.T) {
 			d:    Domain("foo.example.co.uk"),
 			want: Domain("*.example.co.uk"),
 		},
+		{
+			name: "SubSubdomain",
+			d:    Domain("foo.bar.example.com"),
+			want: Domain("*.bar.example.com"),
+		},
+		{
+			name: "RootDomainPrivateSuffix",
+			d:    Domain("foo.github.io"),
+			want: Domain("foo.github.io"),
+		},
+		{
+			name: "Wildcard",
+			d:    Domain("*.example.com"),
+			want: Domain("*.example.com"),
+		},
+		{
+			name: "TLD",
+			d:    Domain("com"),
+			want: Domain("com"),
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			assert.Equalf(t, tt.want, tt.d.FormatSubdomainToWildcard(), "FormatSubdomainToWildcard()")
+			assert.Equalf(t, tt.want, tt.d.FormatSubdomainToWildcard(nil), "FormatSubdomainToWildcard()")
 		})
 	}
 }
@@ -213,3 +234,147 @@ func TestDomainRequest_IsIP(t *testing.T) {
 		})
 	}
 }
+
+func TestDomain_RegisteredDomain(t *testing.T) {
+	tests := []struct {
+		domain Domain
+		want   Domain
+	}{
+		{domain: "example.com", want: "example.com"},
+		{domain: "www.example.com", want: "example.com"},
+		{domain: "*.shop.example.com", want: "example.com"},
+		{domain: "www.example.co.uk", want: "example.co.uk"},
+		{domain: "com", want: "com"},
+		{domain: "192.0.2.1", want: "192.0.2.1"},
+		{domain: "foo.github.io", want: "foo.github.io"},
+		{domain: "co.uk", want: "co.uk"},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.domain), func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.domain.RegisteredDomain(nil))
+		})
+	}
+}
+
+func TestDomains_Equal(t *testing.T) {
+	assert.True(t, Domains{"a.com", "b.com"}.Equal(Domains{"b.com", "a.com"}))
+	assert.False(t, Domains{"a.com", "b.com"}.Equal(Domains{"a.com"}))
+	assert.False(t, Domains{"a.com", "b.com"}.Equal(Domains{"a.com", "c.com"}))
+}
+
+func TestDomain_IsIP(t *testing.T) {
+	assert.True(t, Domain("192.0.2.1").IsIP())
+	assert.True(t, Domain("2001:db8::1").IsIP())
+	assert.False(t, Domain("example.com").IsIP())
+	assert.False(t, Domain("[2001:db8::1]").IsIP())
+}
+
+func TestDomain_Equal(t *testing.T) {
+	assert.True(t, Domain("example.com").Equal("example.com"))
+	assert.True(t, Domain("2001:DB8::1").Equal("2001:db8:0:0::1"))
+	assert.True(t, Domain("::ffff:192.0.2.1").Equal("192.0.2.1"))
+	assert.False(t, Domain("2001:db8::1").Equal("2001:db8::2"))
+	assert.False(t, Domain("example.com").Equal("foo.com"))
+}
+
+func TestDomains_Contains(t *testing.T) {
+	domains := Domains{"example.com", "2001:db8::1"}
+	assert.True(t, domains.Contains("example.com"))
+	assert.True(t, domains.Contains("2001:db8:0::1"))
+	assert.False(t, domains.Contains("192.0.2.1"))
+}
+
+func TestDomains_ContainsIP(t *testing.T) {
+	assert.True(t, Domains{"example.com", "192.0.2.1"}.ContainsIP())
+	assert.False(t, Domains{"example.com"}.ContainsIP())
+}
+
+func TestDomain_Normalize(t *testing.T) {
+	tests := []struct {
+		domain  Domain
+		want    Domain
+		wantErr string
+	}{
+		{domain: "example.com", want: "example.com"},
+		{domain: "Example.COM", want: "example.com"},
+		{domain: " www.example.com. ", want: "www.example.com"},
+		{domain: "*.Example.com", want: "*.example.com"},
+		{domain: "Bücher.example.com", want: "xn--bcher-kva.example.com"},
+		{domain: "*.bücher.example", want: "*.xn--bcher-kva.example"},
+		{domain: "xn--bcher-kva.example.com", want: "xn--bcher-kva.example.com"},
+		{domain: "2001:DB8:0::1", want: "2001:db8::1"},
+		{domain: "192.0.2.1", want: "192.0.2.1"},
+		{domain: "", wantErr: "invalid hostname"},
+		{domain: "foo_bar.example.com", wantErr: "invalid hostname foo_bar.example.com"},
+		{domain: "foo..com", wantErr: "invalid hostname foo..com"},
+		{domain: "foo.*.example.com", wantErr: "invalid hostname foo.*.example.com"},
+		{domain: Domain(strings.Repeat("a", 64) + ".com"), wantErr: "invalid hostname"},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.domain), func(t *testing.T) {
+			got, err := tt.domain.Normalize()
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				assert.Equal(t, tt.domain, got)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestDomainRequest_Normalize(t *testing.T) {
+	dr := &DomainRequest{Domains: Domains{"Example.com", "example.com", "WWW.example.com"}}
+	assert.NoError(t, dr.Normalize())
+	assert.Equal(t, Domains{"example.com", "www.example.com"}, dr.Domains)
+
+	dr = &DomainRequest{Domains: Domains{"Example.com", "foo_bar.example.com"}}
+	assert.ErrorContains(t, dr.Normalize(), "invalid hostname foo_bar.example.com")
+	assert.Equal(t, Domains{"Example.com", "foo_bar.example.com"}, dr.Domains)
+
+	dr = &DomainRequest{}
+	assert.EqualError(t, dr.Normalize(), "request has no domain")
+}
+
+func TestDomain_IsPublicSuffix(t *testing.T) {
+	assert.True(t, Domain("com").IsPublicSuffix(nil))
+	assert.True(t, Domain("co.uk").IsPublicSuffix(nil))
+	assert.True(t, Domain("*.co.uk").IsPublicSuffix(nil))
+	assert.False(t, Domain("example.co.uk").IsPublicSuffix(nil))
+	assert.False(t, Domain("192.0.2.1").IsPublicSuffix(nil))
+}
+
+func TestDomain_CoveredBy(t *testing.T) {
+	assert.True(t, Domain("example.com").CoveredBy("example.com", nil))
+	assert.True(t, Domain("foo.example.com").CoveredBy("*.example.com", nil))
+	assert.True(t, Domain("*.example.com").CoveredBy("*.example.com", nil))
+	assert.False(t, Domain("foo.bar.example.com").CoveredBy("*.example.com", nil))
+	assert.False(t, Domain("example.com").CoveredBy("*.example.com", nil))
+	assert.False(t, Domain("example.co.uk").CoveredBy("*.co.uk", nil))
+	assert.False(t, Domain("*.foo.example.com").CoveredBy("*.example.com", nil))
+}
+
+func TestDomain_InZone(t *testing.T) {
+	assert.True(t, Domain("example.com").InZone("example.com"))
+	assert.True(t, Domain("foo.example.com").InZone("Example.com."))
+	assert.True(t, Domain("*.sub.example.com").InZone("sub.example.com"))
+	assert.False(t, Domain("example.com").InZone("sub.example.com"))
+	assert.False(t, Domain("notexample.com").InZone("example.com"))
+	assert.True(t, Domain("2001:db8::1").InZone("2001:DB8:0::1"))
+	assert.False(t, Domain("192.0.2.10").InZone("192.0.2.1"))
+}
+
+func TestSuffixes_PublicSuffix(t *testing.T) {
+	suffixes := Suffixes{"Customers.Example.com."}
+
+	assert.Equal(t, "com", Suffixes(nil).PublicSuffix("www.example.com"))
+	assert.Equal(t, "co.uk", suffixes.PublicSuffix("www.example.co.uk"))
+	assert.Equal(t, "customers.example.com", suffixes.PublicSuffix("foo.customers.example.com"))
+	assert.True(t, Domain("customers.example.com").IsPublicSuffix(suffixes))
+	assert.Equal(t, Domain("foo.customers.example.com"), Domain("www.foo.customers.example.com").RegisteredDomain(suffixes))
+	assert.Equal(t, Domain("foo.customers.example.com"), Domain("foo.customers.example.com").FormatSubdomainToWildcard(suffixes))
+	assert.Equal(t, Domain("*.example.com"), Domain("www.example.com").FormatSubdomainToWildcard(suffixes))
+	assert.False(t, Domain("foo.customers.example.com").CoveredBy("*.customers.example.com", suffixes))
+	assert.True(t, Domain("foo.customers.example.com").CoveredBy("*.customers.example.com", nil))
+}
@@ -0,0 +1,25 @@
+package types
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var ErrLockNotHeld = errors.New("lock is not held by owner")
+
+// Lease is a lock held by an owner. Token is a fencing token, it is strictly increasing for each acquisition of a key.
+type Lease struct {
+	Key   string
+	Owner string
+	Token int64
+}
+
+type Locker interface {
+	// Acquire try to take the lock atomically, a nil lease is returned when the lock is held by another owner.
+	Acquire(ctx context.Context, key, owner string, ttl time.Duration) (*Lease, error)
+	// Renew extends the lease, ErrLockNotHeld is returned when the lease is lost.
+	Renew(ctx context.Context, lease *Lease, ttl time.Duration) error
+	// Release removes the lock only if it is still held by the lease owner.
+	Release(ctx context.Context, lease *Lease) error
+}
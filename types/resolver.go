@@ -12,6 +12,10 @@ const (
 type Resolvers map[string]Resolver
 
 func (r Resolvers) FindResolver(certificate *Certificate) Resolver {
+	// resolver of a declared certificate takes precedence over filters
+	if resolver, ok := r[certificate.Resolver]; ok && certificate.Resolver != "" {
+		return resolver
+	}
 	defaultResolver := r[DefaultKey]
 
 	for _, resolver := range r {
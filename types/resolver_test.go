@@ -56,3 +56,12 @@ func TestResolvers_FindResolver_SuccessDefault(t *testing.T) {
 	got := resolvers.FindResolver(cert)
 	assert.Equal(t, defaultResolver, got)
 }
+
+func TestResolvers_FindResolver_SuccessDeclared(t *testing.T) {
+	defaultResolver := &dummyResolver{id: DefaultKey}
+	resolver := &dummyResolver{id: "foo", match: false}
+	resolvers := Resolvers{"foo": resolver, DefaultKey: defaultResolver}
+	cert := &Certificate{Domains: Domains{"example.com"}, Resolver: "foo"}
+	got := resolvers.FindResolver(cert)
+	assert.Equal(t, resolver, got)
+}
@@ -1,10 +1,41 @@
 package types
 
 import (
+	"encoding/json"
+	"errors"
+
 	"github.com/alexandreh2ag/lets-go-tls/types/acme"
 )
 
+var (
+	ErrStaleFencingToken = errors.New("stale fencing token")
+	ErrStateConflict     = errors.New("state was modified concurrently")
+	// ErrUnsupportedSchemaVersion is returned when a state was saved by a more recent version with a newer schema.
+	ErrUnsupportedSchemaVersion = errors.New("state schema version is not supported")
+)
+
 type State struct {
+	// SchemaVersion is the version of the schema used to save state, an older state is migrated on load.
+	SchemaVersion int `json:"schema_version,omitempty"`
+
 	Account      *acme.Account `json:"account,omitempty"`
 	Certificates Certificates  `json:"certificates"`
+
+	// StagingAccount is used to validate new certificates on staging CA before production when staging is enabled.
+	StagingAccount *acme.Account `json:"staging_account,omitempty"`
+
+	// FencingToken is the token of the lock lease used by the last writer, a storage refuses a save with an older token.
+	FencingToken int64 `json:"fencing_token,omitempty"`
+
+	// Revision is incremented by the storage on each save changing the state,
+	// a storage refuses with ErrStateConflict a save of a state loaded from another revision.
+	Revision int64 `json:"revision,omitempty"`
+}
+
+// Clone returns a deep copy of state.
+func (s *State) Clone() *State {
+	clone := &State{}
+	data, _ := json.Marshal(s)
+	_ = json.Unmarshal(data, clone)
+	return clone
 }
@@ -0,0 +1,26 @@
+package types
+
+import (
+	"testing"
+
+	"github.com/alexandreh2ag/lets-go-tls/types/acme"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestState_Clone(t *testing.T) {
+	state := &State{
+		Account:      &acme.Account{Email: "dev@foo.com", Key: []byte("key")},
+		Certificates: Certificates{{Identifier: "foo", Domains: Domains{"foo.com"}, Key: []byte("key")}},
+		FencingToken: 2,
+		Revision:     3,
+	}
+	clone := state.Clone()
+	assert.Equal(t, state, clone)
+
+	clone.Account.Email = "other@foo.com"
+	clone.Certificates[0].Domains[0] = "bar.com"
+	clone.Certificates[0].Key[0] = 'K'
+	assert.Equal(t, "dev@foo.com", state.Account.Email)
+	assert.Equal(t, Domains{"foo.com"}, state.Certificates[0].Domains)
+	assert.Equal(t, []byte("key"), state.Certificates[0].Key)
+}
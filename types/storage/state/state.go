@@ -1,11 +1,44 @@
 package state
 
 import (
+	"errors"
+
 	"github.com/alexandreh2ag/lets-go-tls/types"
 )
 
+var ErrVersionNotFound = errors.New("version not found")
+
 type Storage interface {
 	Type() string
 	Load() (*types.State, error)
 	Save(state *types.State) error
 }
+
+// CertificateFinder is implemented by storages able to find certificates by domain without loading the whole state.
+type CertificateFinder interface {
+	// FindCertificates returns, in state order, the certificates which may cover domains.
+	FindCertificates(domains types.Domains) (types.Certificates, error)
+}
+
+// ChangeTracker is implemented by storages able to tell if state changed without loading the whole state.
+type ChangeTracker interface {
+	// ChangeTag returns a value which changes each time state is saved, it is empty when state does not exist.
+	ChangeTag() (string, error)
+}
+
+// SnapshotReader is implemented by storages keeping a parsed state in memory.
+type SnapshotReader interface {
+	// Snapshot returns the last known state, it is shared and must not be modified.
+	Snapshot() (*types.State, error)
+}
+
+// VersionStore is implemented by storages able to keep previous versions of state next to the state.
+// Versions are stored as given, they are already encrypted.
+type VersionStore interface {
+	// VersionIDs returns ids of stored versions in any order.
+	VersionIDs() ([]string, error)
+	// GetVersion returns data of version id or ErrVersionNotFound.
+	GetVersion(id string) ([]byte, error)
+	PutVersion(id string, data []byte) error
+	DeleteVersion(id string) error
+}
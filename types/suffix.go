@@ -0,0 +1,23 @@
+package types
+
+import (
+	"strings"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+// Suffixes are private suffixes handled like public suffixes in addition to the public suffix list
+// (ex: a zone where each subdomain belongs to a different team), nil uses the public suffix list alone.
+type Suffixes []string
+
+// PublicSuffix returns the public suffix of name, the longest matching private suffix takes precedence over the public suffix list.
+func (s Suffixes) PublicSuffix(name string) string {
+	suffix, _ := publicsuffix.PublicSuffix(name)
+	for _, private := range s {
+		private = strings.Trim(strings.ToLower(private), ".")
+		if len(private) > len(suffix) && (name == private || strings.HasSuffix(name, "."+private)) {
+			suffix = private
+		}
+	}
+	return suffix
+}